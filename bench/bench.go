@@ -0,0 +1,27 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bench reads and writes Go benchmarks results files.
+//
+// Deprecated: The tools in this repository now share an internal
+// copy of this package. This package forwards to it for existing
+// users; new code should use golang.org/x/perf/benchfmt.
+package bench
+
+import "github.com/aclements/go-misc/internal/benchfmt"
+
+type (
+	Benchmark   = benchfmt.Benchmark
+	Config      = benchfmt.Config
+	ValueParser = benchfmt.ValueParser
+)
+
+var DefaultValueParsers = benchfmt.DefaultValueParsers
+
+var (
+	Parse       = benchfmt.Parse
+	ParseValues = benchfmt.ParseValues
+	Print       = benchfmt.Print
+	Fprint      = benchfmt.Fprint
+)
@@ -0,0 +1,41 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// cgroupDir is the open cgroup directory, shared by all runs.
+var cgroupDir *os.File
+
+// cgroup2Magic is the file system type of cgroup v2 directories.
+const cgroup2Magic = 0x63677270
+
+// setCgroup arranges for cmd to start in cgroup v2 directory dir.
+func setCgroup(cmd *exec.Cmd, dir string) error {
+	if cgroupDir == nil {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(dir, &st); err != nil {
+			return &os.PathError{Op: "statfs", Path: dir, Err: err}
+		}
+		if st.Type != cgroup2Magic {
+			return fmt.Errorf("%s is not a cgroup v2 directory", dir)
+		}
+		f, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		cgroupDir = f
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		UseCgroupFD: true,
+		CgroupFD:    int(cgroupDir.Fd()),
+	}
+	return nil
+}
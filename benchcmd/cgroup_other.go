@@ -0,0 +1,17 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+func setCgroup(cmd *exec.Cmd, dir string) error {
+	return fmt.Errorf("-cgroup is not supported on %s", runtime.GOOS)
+}
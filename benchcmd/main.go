@@ -3,6 +3,21 @@
 // license that can be found in the LICENSE file.
 
 // Command benchcmd times a shell command using Go benchmark format.
+//
+// benchcmd runs cmd -n times and prints a benchmark result line for
+// each run with its wall-clock, user, and system time and its peak
+// resident set size. For example,
+//
+//     benchcmd -n 10 -warmup 1 Build go build std
+//
+// prints ten "BenchmarkBuild" lines. The output can be used with
+// benchstat and benchplot. To benchmark a command across commits,
+// give benchmany a -buildcmd that writes a script that runs benchcmd.
+//
+// With -cgroup, each run is started in the given cgroup v2
+// directory, which must already exist and be writable, so it can be
+// given dedicated CPUs or memory limits. This is only supported on
+// Linux.
 package main
 
 import (
@@ -16,10 +31,12 @@ import (
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [-n iters] benchname cmd...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] benchname cmd...\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	n := flag.Int("n", 5, "iterations")
+	warmup := flag.Int("warmup", 0, "run cmd `N` times before timing it")
+	cgroup := flag.String("cgroup", "", "run cmd in cgroup v2 `directory`")
 	flag.Parse()
 	if flag.NArg() < 2 {
 		flag.Usage()
@@ -28,9 +45,16 @@ func main() {
 	benchname := flag.Arg(0)
 	args := flag.Args()[1:]
 
+	for i := 0; i < *warmup; i++ {
+		if err := command(args, *cgroup).Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "warmup: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	for i := 0; i < *n; i++ {
+		cmd := command(args, *cgroup)
 		fmt.Printf("Benchmark%s\t", benchname)
-		cmd := exec.Command(args[0], args[1:]...)
 		before := time.Now()
 		if err := cmd.Run(); err != nil {
 			fmt.Println(err)
@@ -45,3 +69,15 @@ func main() {
 		fmt.Printf("\n")
 	}
 }
+
+// command returns the command to run args, in cgroup if it's not "".
+func command(args []string, cgroup string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	if cgroup != "" {
+		if err := setCgroup(cmd, cgroup); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+	return cmd
+}
@@ -16,12 +16,17 @@
 // <commit or range>... can be either a list of individual commits or
 // a revision range. For the spelling of a revision range, see
 // "SPECIFYING RANGES" in gitrevisions(7). For exact details, see the
-// --no-walk option to git-rev-list(1).
+// --no-walk option to git-rev-list(1). With -first-parent, ranges
+// only include the first parent of merge commits.
 //
 // Benchmany will check out each revision in git-dir. The current
 // directory may or may not be in the same git repository as git-dir.
+// If git-dir is a bare repository, such as a mirror, benchmany checks
+// out revisions in a worktree of it in the "worktree" subdirectory of
+// the -d directory.
 // If git-dir refers to a Go installation, benchmany will run
-// make.bash at each revision; otherwise, it assumes go test can
+// make.bash (make.bat on Windows) at each revision; otherwise, it
+// assumes go test can
 // rebuild the necessary dependencies. Benchmany also supports using
 // gover (https://godoc.org/github.com/aclements/go-misc/gover) to
 // save and reuse Go build trees. This is useful for saving time
@@ -43,6 +48,29 @@
 // between the pair of commits with the biggest difference in the
 // metric. This is like "git bisect", but for performance.
 //
+// With -cpuprofile or -memprofile, benchmany saves a profile of each
+// run in the "profiles" subdirectory of the -d directory. This
+// requires Go testing framework benchmarks. profmerge
+// (https://godoc.org/github.com/aclements/go-misc/profmerge) merges
+// these profiles by commit and summarizes them over time.
+//
+// With -build-metrics, benchmany also records how long it takes to
+// build each commit's toolchain and benchmark binary and the size of
+// the benchmark binary. With -build-pkgs, it also builds each of the
+// given packages (for example, "cmd/go") from scratch with each
+// commit's toolchain and records its build time and binary size.
+// These are recorded in bench.log with the commit's first run as
+// benchmarks named "Build:toolchain", "Build:benchmark", and
+// "Build:<package>", with slashes in package paths replaced by "_".
+// The metrics are the wall and CPU time of the build in "ns/build"
+// and "ns/build-cpu", and the size of the binary and its executable
+// code in "B/exe" and "B/text". benchplot plots these alongside the
+// benchmarks' own metrics.
+//
+// Benchmany reads common settings, such as the repository to run git
+// in, from the go-misc configuration file. See
+// https://godoc.org/github.com/aclements/go-misc/internal/config.
+//
 // Benchmany is safe to interrupt. If it is restarted, it will parse
 // the benchmark log files to recover its state.
 package main
@@ -52,10 +80,15 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+
+	"github.com/aclements/go-misc/internal/config"
+	"github.com/aclements/go-misc/internal/gitutil"
 )
 
 var gitDir string
+var repo *gitutil.Repo
 var dryRun bool
 
 // maxFails is the maximum number of benchmark run failures to
@@ -63,31 +96,70 @@ var dryRun bool
 // commit. Build failures always disqualify a commit.
 const maxFails = 5
 
+var cfg = config.NewFlags(flag.CommandLine, "benchmany", map[string]string{
+	"repo":      "C",
+	"gover-dir": "gover-dir",
+})
+
 func main() {
 	flag.Parse()
+	if err := cfg.Apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 	doRun()
 }
 
-// git runs git subcommand subcmd and returns its stdout. If git
-// fails, it prints the failure and exits.
-func git(subcmd string, args ...string) string {
-	gitargs := []string{}
-	if gitDir != "" {
-		gitargs = append(gitargs, "-C", gitDir)
+// openRepo opens the repository in gitDir and sets repo and gitDir
+// to the working tree to check out revisions in. If the repository
+// is bare, this is a worktree in the -d directory.
+func openRepo() {
+	dir := gitDir
+	if dir == "" {
+		dir = "."
 	}
-	gitargs = append(gitargs, subcmd)
-	gitargs = append(gitargs, args...)
-	cmd := exec.Command("git", gitargs...)
+	r, err := gitutil.Open(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if r.Bare() {
+		wt, err := filepath.Abs(filepath.Join(run.binDir, "worktree"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if exists(wt) {
+			r, err = gitutil.Open(wt)
+		} else if dryRun {
+			// Keep using the bare repository, which is
+			// enough to list commits.
+			dryPrint(r.Command("worktree", "add", "--detach", wt, "HEAD"))
+		} else {
+			r, err = r.AddWorktree(wt, "HEAD")
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	// Always run git from the top level of the git tree. Some
+	// commands, like git clean, care about this.
+	repo, gitDir = r, r.Dir()
+}
+
+// git runs git subcommand subcmd in the working tree and returns its
+// stdout. If git fails, it prints the failure and exits.
+func git(subcmd string, args ...string) string {
+	cmd := repo.Command(append([]string{subcmd}, args...)...)
 	cmd.Stderr = os.Stderr
 	if dryRun {
 		dryPrint(cmd)
-		if !(subcmd == "rev-parse" || subcmd == "rev-list" || subcmd == "show") {
-			return ""
-		}
+		return ""
 	}
 	out, err := cmd.Output()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "git %s failed: %s\n", shellEscapeList(gitargs), err)
+		fmt.Fprintf(os.Stderr, "git %s failed: %s\n", shellEscapeList(cmd.Args[1:]), err)
 		os.Exit(1)
 	}
 	return string(out)
@@ -146,12 +218,3 @@ func indent(s string) string {
 	}
 	return "    " + strings.Replace(s, "\n", "\n    ", -1) + "\n"
 }
-
-// lines splits s in to lines. It omits a final blank line, if any.
-func lines(s string) []string {
-	l := strings.Split(s, "\n")
-	if len(l) > 0 && l[len(l)-1] == "" {
-		l = l[:len(l)-1]
-	}
-	return l
-}
@@ -15,8 +15,11 @@ import (
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"time"
+
+	"github.com/aclements/go-misc/internal/gitutil"
 )
 
 type commitInfo struct {
@@ -26,6 +29,10 @@ type commitInfo struct {
 	logPath      string
 	count, fails int
 	buildFailed  bool
+
+	// buildLog holds the build metrics of this commit, in benchmark
+	// format, to record with the next successful run.
+	buildLog string
 }
 
 // getCommits returns the commit info for all of the revisions in the
@@ -34,34 +41,24 @@ type commitInfo struct {
 // chronological order, most recent commit first (the same as
 // git-rev-list(1)).
 func getCommits(revRange []string, logPath string) []*commitInfo {
-	// Get commit sequence.
-	args := append(append([]string{"--no-walk"}, revRange...), "--")
-	hashes := lines(git("rev-list", args...))
-	commits := make([]*commitInfo, len(hashes))
+	// Get commit sequence and dates.
+	gcommits, err := repo.Log(gitutil.LogOptions{NoWalk: true, FirstParent: run.firstParent}, revRange...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	commits := make([]*commitInfo, len(gcommits))
 	commitMap := make(map[string]*commitInfo)
-	for i, hash := range hashes {
+	for i, gc := range gcommits {
 		commits[i] = &commitInfo{
-			hash:    hash,
-			logPath: logPath,
+			hash:       gc.Hash,
+			commitDate: gc.CommitDate,
+			logPath:    logPath,
 		}
-		commitMap[hash] = commits[i]
-	}
-
-	// Get commit dates.
-	//
-	// TODO: This can produce a huge command line.
-	args = append([]string{"-s", "--format=format:%cI"}, hashes...)
-	dates := lines(git("show", args...))
-	for i := range commits {
-		d, err := time.Parse(time.RFC3339, dates[i])
-		if err != nil {
-			log.Fatalf("cannot parse commit date: %v", err)
-		}
-		commits[i].commitDate = d
+		commitMap[gc.Hash] = commits[i]
 	}
 
 	// Get gover-cached builds. It's okay if this fails.
-	if fis, err := ioutil.ReadDir(goverDir()); err == nil {
+	if fis, err := ioutil.ReadDir(run.goverDir); err == nil {
 		for _, fi := range fis {
 			if ci := commitMap[fi.Name()]; ci != nil && fi.IsDir() {
 				ci.gover = true
@@ -83,14 +80,18 @@ func getCommits(revRange []string, logPath string) []*commitInfo {
 	return commits
 }
 
-// goverDir returns the directory containing gover-cached builds.
-func goverDir() string {
+// defaultGoverDir returns the default directory containing
+// gover-cached builds.
+func defaultGoverDir() string {
 	cache := os.Getenv("XDG_CACHE_HOME")
+	if cache == "" && runtime.GOOS == "windows" {
+		cache = os.Getenv("LocalAppData")
+	}
 	if cache == "" {
 		home := os.Getenv("HOME")
 		if home == "" {
 			u, err := user.Current()
-			if err != nil {
+			if err == nil {
 				home = u.HomeDir
 			}
 		}
@@ -133,7 +134,19 @@ func parseLog(commitMap map[string]*commitInfo, r io.Reader) {
 // binPath returns the file name of the binary for this commit.
 func (c *commitInfo) binPath() string {
 	// TODO: This assumes the short commit hash is unique.
-	return fmt.Sprintf("bench.%s", c.hash[:7])
+	name := fmt.Sprintf("bench.%s", c.hash[:7])
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// profilePath returns the path of the profile of the given kind
+// ("cpu" or "mem") of the next run of commit c. This is named
+// <hash>.<run>.<kind>.prof in the "profiles" directory under
+// run.binDir, which is what profmerge expects.
+func (c *commitInfo) profilePath(kind string) string {
+	return filepath.Join(run.binDir, "profiles", fmt.Sprintf("%s.%d.%s.prof", c.hash, c.count, kind))
 }
 
 // failed returns whether commit c has failed and should not be run
@@ -171,8 +184,9 @@ func (c *commitInfo) logRun(out string) {
 	var log bytes.Buffer
 	fmt.Fprintf(&log, "commit: %s\n", c.hash)
 	fmt.Fprintf(&log, "commit-time: %s\n", c.commitDate.UTC().Format(time.RFC3339))
-	fmt.Fprintf(&log, "\n%s\n", cleanLog(out))
+	fmt.Fprintf(&log, "\n%s\n", cleanLog(c.buildLog+out))
 	c.writeLog(log.String())
+	c.buildLog = ""
 	c.count++
 }
 
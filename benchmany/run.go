@@ -17,10 +17,12 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"time"
 
-	"github.com/aclements/go-misc/bench"
+	"github.com/aclements/go-misc/internal/benchfmt"
+	"github.com/aclements/go-misc/internal/benchproc"
 	"github.com/aclements/go-moremath/stats"
 )
 
@@ -37,9 +39,17 @@ var run struct {
 	buildCmd   string
 	iterations int
 	saveTree   bool
+	goverDir   string
 	timeout    time.Duration
 	clean      bool
 	cleanFlags string
+	cpuProfile bool
+	memProfile bool
+
+	buildMetrics bool
+	buildPkgs    string
+
+	firstParent bool
 
 	logPath string
 	binDir  string
@@ -74,10 +84,16 @@ func init() {
 	f.StringVar(&run.logPath, "o", "", "write benchmark results to `file` (default \"bench.log\" in -d directory)")
 	f.StringVar(&run.binDir, "d", ".", "write binaries to `directory`")
 	f.BoolVar(&run.saveTree, "save-tree", false, "save Go trees using gover and run benchmarks under saved trees")
+	f.StringVar(&run.goverDir, "gover-dir", defaultGoverDir(), "use gover's saved Go trees in `directory`")
 	f.DurationVar(&run.timeout, "timeout", 30*time.Minute, "time out a run after `duration`")
 	f.BoolVar(&dryRun, "dry-run", false, "print commands but do not run them")
 	f.BoolVar(&run.clean, "clean", false, "run \"git clean -f\" after every checkout")
 	f.StringVar(&run.cleanFlags, "cleanflags", "", "add `flags` to git clean command")
+	f.BoolVar(&run.cpuProfile, "cpuprofile", false, "save a CPU profile of each run in \"profiles\" in the -d directory")
+	f.BoolVar(&run.memProfile, "memprofile", false, "save a heap profile of each run in \"profiles\" in the -d directory")
+	f.BoolVar(&run.buildMetrics, "build-metrics", false, "record the time to build the toolchain and benchmark and the benchmark's binary size")
+	f.StringVar(&run.buildPkgs, "build-pkgs", "", "record the time to build and binary size of each of the space-separated `packages`")
+	f.BoolVar(&run.firstParent, "first-parent", false, "follow only the first parent of merge commits in revision ranges")
 }
 
 func doRun() {
@@ -104,6 +120,7 @@ func doRun() {
 		run.logPath = filepath.Join(run.binDir, "bench.log")
 	}
 
+	openRepo()
 	commits := getCommits(flag.Args(), run.logPath)
 
 	// Write header block to log.
@@ -115,10 +132,6 @@ func doRun() {
 		commits[0].writeLog(header.String())
 	}
 
-	// Always run git from the top level of the git tree. Some
-	// commands, like git clean, care about this.
-	gitDir = trimNL(git("rev-parse", "--show-toplevel"))
-
 	status := NewStatusReporter()
 	defer status.Stop()
 
@@ -150,9 +163,15 @@ func writeHeader(w io.Writer) {
 	}
 	fmt.Fprintf(w, "goarch: %s\n", strings.TrimSpace(string(goarch)))
 
-	kernel, err := exec.Command("uname", "-sr").Output()
+	unameCmd := exec.Command("uname", "-sr")
+	if runtime.GOOS == "windows" {
+		// Windows doesn't have uname, but ver prints the
+		// equivalent.
+		unameCmd = exec.Command("cmd", "/c", "ver")
+	}
+	kernel, err := unameCmd.Output()
 	if err != nil {
-		log.Fatalf("error running uname -sr: %s", err)
+		log.Fatalf("error running %s: %s", strings.Join(unameCmd.Args, " "), err)
 	}
 	fmt.Fprintf(w, "uname-sr: %s\n", strings.TrimSpace(string(kernel)))
 
@@ -338,35 +357,36 @@ func pickCommitMetric(commits []*commitInfo) *commitInfo {
 		log.Fatal("opening benchmark log: ", err)
 	}
 	defer logf.Close()
-	bs, err := bench.Parse(logf)
+	bs, err := benchfmt.Parse(logf)
 	if err != nil {
 		log.Fatal("parsing benchmark log for metrics: ", err)
 	}
-	results := make(map[string]map[string][]float64)
-	for _, b := range bs {
-		var hash string
-		if commitConfig, ok := b.Config["commit"]; !ok {
-			continue
-		} else {
-			hash = commitConfig.RawValue
-		}
-		result, ok := b.Result[run.metric]
-		if !ok {
-			continue
-		}
-
-		if results[hash] == nil {
-			results[hash] = make(map[string][]float64)
-		}
-		results[hash][b.Name] = append(results[hash][b.Name], result)
-	}
+	// Compare tidied units so the metric can be given at any
+	// scale (e.g., "sec/op" for "ns/op").
+	metric := benchproc.ParseUnit(run.metric).Tidy
+	byCommit := benchproc.MustParseProjection("commit")
+	byName := benchproc.MustParseProjection(benchproc.NameKey)
 	geomeans := make(map[string]float64)
-	for hash, benches := range results {
+	commitKeys, commitGroups := byCommit.Group(bs)
+	for _, ck := range commitKeys {
 		var means []float64
-		for _, results := range benches {
-			means = append(means, stats.Mean(results))
+		nameKeys, nameGroups := byName.Group(commitGroups[ck])
+		for _, nk := range nameKeys {
+			var results []float64
+			for _, b := range nameGroups[nk] {
+				for unit, val := range b.Result {
+					if unit, val := benchproc.Tidy(unit, val); unit == metric {
+						results = append(results, val)
+					}
+				}
+			}
+			if len(results) > 0 {
+				means = append(means, stats.Mean(results))
+			}
+		}
+		if len(means) > 0 {
+			geomeans[ck.Get("commit")] = stats.GeoMean(means)
 		}
-		geomeans[hash] = stats.GeoMean(means)
 	}
 
 	// Find the pair of commits with the biggest difference in the
@@ -416,17 +436,23 @@ func runBenchmark(commit *commitInfo, status *StatusReporter) {
 			git("clean", args...)
 		}
 
+		var builds []*benchfmt.Benchmark
 		var buildCmd []string
 		if commit.gover {
-			buildCmd = []string{"gover", "with", commit.hash}
+			buildCmd = goverCmd("with", commit.hash)
 		} else {
 			// If this is the Go toolchain, do a full
 			// make.bash. Otherwise, we assume that go
 			// test -c will build the necessary
 			// dependencies.
-			if exists(filepath.Join(gitDir, "src", "make.bash")) {
-				cmd := exec.Command("./make.bash")
+			makeScript := "make.bash"
+			if runtime.GOOS == "windows" {
+				makeScript = "make.bat"
+			}
+			if exists(filepath.Join(gitDir, "src", makeScript)) {
+				cmd := exec.Command(filepath.Join(gitDir, "src", makeScript))
 				cmd.Dir = filepath.Join(gitDir, "src")
+				start := time.Now()
 				if dryRun {
 					dryPrint(cmd)
 				} else if out, err := combinedOutputTimeout(cmd); err != nil {
@@ -434,6 +460,8 @@ func runBenchmark(commit *commitInfo, status *StatusReporter) {
 					fmt.Fprintf(os.Stderr, "failed to build toolchain at %s:\n%s", commit.hash, detail)
 					commit.logFailed(true, detail)
 					return
+				} else if run.buildMetrics {
+					builds = append(builds, buildBenchmark("toolchain", cmd, time.Since(start)))
 				}
 				if run.saveTree && doGoverSave() == nil {
 					commit.gover = true
@@ -448,6 +476,7 @@ func runBenchmark(commit *commitInfo, status *StatusReporter) {
 		buildCmd = append(buildCmd, strings.Fields(run.buildCmd)...)
 		buildCmd = append(buildCmd, "-o", binPath)
 		cmd := exec.Command(buildCmd[0], buildCmd[1:]...)
+		start := time.Now()
 		if dryRun {
 			dryPrint(cmd)
 		} else if out, err := combinedOutputTimeout(cmd); err != nil {
@@ -455,6 +484,21 @@ func runBenchmark(commit *commitInfo, status *StatusReporter) {
 			fmt.Fprintf(os.Stderr, "failed to build tests at %s:\n%s", commit.hash, detail)
 			commit.logFailed(true, detail)
 			return
+		} else if run.buildMetrics {
+			b := buildBenchmark("benchmark", cmd, time.Since(start))
+			addBinarySize(b, binPath)
+			builds = append(builds, b)
+		}
+
+		// Build the target packages while this commit is
+		// checked out.
+		builds = append(builds, buildPkgs(commit)...)
+		if len(builds) > 0 {
+			var buf bytes.Buffer
+			if err := benchfmt.Fprint(&buf, builds); err != nil {
+				log.Fatal(err)
+			}
+			commit.buildLog = buf.String()
 		}
 	}
 
@@ -465,8 +509,19 @@ func runBenchmark(commit *commitInfo, status *StatusReporter) {
 		binPath = "./" + binPath
 	}
 	args := append([]string{binPath}, strings.Fields(run.benchFlags)...)
+	if run.cpuProfile {
+		args = append(args, "-test.cpuprofile", commit.profilePath("cpu"))
+	}
+	if run.memProfile {
+		args = append(args, "-test.memprofile", commit.profilePath("mem"))
+	}
+	if (run.cpuProfile || run.memProfile) && !dryRun {
+		if err := os.MkdirAll(filepath.Join(run.binDir, "profiles"), 0777); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if run.saveTree {
-		args = append([]string{"gover", "with", commit.hash}, args...)
+		args = append(goverCmd("with", commit.hash), args...)
 	}
 	cmd := exec.Command(args[0], args[1:]...)
 	if dryRun {
@@ -484,8 +539,71 @@ func runBenchmark(commit *commitInfo, status *StatusReporter) {
 	}
 }
 
+// buildPkgs builds each package in run.buildPkgs using commit's
+// toolchain and returns the build time and binary size of each.
+// Packages that fail to build are reported, but don't disqualify
+// commit.
+func buildPkgs(commit *commitInfo) []*benchfmt.Benchmark {
+	var bs []*benchfmt.Benchmark
+	binPath := filepath.Join(run.binDir, "build.tmp")
+	if runtime.GOOS == "windows" {
+		binPath += ".exe"
+	}
+	for _, pkg := range strings.Fields(run.buildPkgs) {
+		var args []string
+		if commit.gover {
+			args = goverCmd("with", commit.hash)
+		}
+		// Rebuild everything so the build cache doesn't
+		// hide the cost of compiling dependencies.
+		args = append(args, "go", "build", "-a", "-o", binPath, pkg)
+		cmd := exec.Command(args[0], args[1:]...)
+		start := time.Now()
+		if dryRun {
+			dryPrint(cmd)
+			continue
+		}
+		out, err := combinedOutputTimeout(cmd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to build %s at %s:\n%s", pkg, commit.hash, indent(string(out))+indent(err.Error()))
+			continue
+		}
+		name := strings.NewReplacer(" ", "_", "/", "_").Replace(strings.TrimPrefix(pkg, "./"))
+		b := buildBenchmark(name, cmd, time.Since(start))
+		addBinarySize(b, binPath)
+		os.Remove(binPath)
+		bs = append(bs, b)
+	}
+	return bs
+}
+
+// buildBenchmark returns a benchmark result named "Build:<target>"
+// recording the wall time and CPU time of cmd, which has finished
+// building target. The units are "ns/build" and "ns/build-cpu" so
+// they aren't mixed up with the benchmarks' own ns/op.
+func buildBenchmark(target string, cmd *exec.Cmd, wall time.Duration) *benchfmt.Benchmark {
+	res := map[string]float64{"ns/build": float64(wall.Nanoseconds())}
+	if ps := cmd.ProcessState; ps != nil {
+		res["ns/build-cpu"] = float64((ps.UserTime() + ps.SystemTime()).Nanoseconds())
+	}
+	return &benchfmt.Benchmark{Name: "Build:" + target, Iterations: 1, Result: res}
+}
+
+// addBinarySize adds the size metrics of binary path to b.
+func addBinarySize(b *benchfmt.Benchmark, path string) {
+	size, err := binarySize(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get binary size: %s\n", err)
+		return
+	}
+	for unit, val := range size {
+		b.Result[unit] = val
+	}
+}
+
 func doGoverSave() error {
-	cmd := exec.Command("gover", "save")
+	args := goverCmd("save")
+	cmd := exec.Command(args[0], args[1:]...)
 	cmd.Dir = gitDir
 	if dryRun {
 		dryPrint(cmd)
@@ -499,6 +617,12 @@ func doGoverSave() error {
 	}
 }
 
+// goverCmd returns the command line to run gover subcommand subcmd
+// with args on the saved trees in run.goverDir.
+func goverCmd(subcmd string, args ...string) []string {
+	return append([]string{"gover", "-dir", run.goverDir, subcmd}, args...)
+}
+
 // runStatus updates the status message for commit.
 func runStatus(sr *StatusReporter, commit *commitInfo, status string) {
 	sr.Message(fmt.Sprintf("commit %s, iteration %d/%d: %s...", commit.hash[:7], commit.count+1, run.iterations, status))
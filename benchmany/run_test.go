@@ -13,7 +13,7 @@ import (
 	"path/filepath"
 	"testing"
 
-	"github.com/aclements/go-misc/bench"
+	"github.com/aclements/go-misc/internal/benchfmt"
 )
 
 func TestPickSpread(t *testing.T) {
@@ -98,6 +98,8 @@ func TestMain(m *testing.M) {
 			t.Fatal("Getwd: ", err)
 		}
 		os.Args = []string{os.Args[0], "-n", fmt.Sprintf("%d", iters), "HEAD~3..HEAD"}
+		// Don't pick up the user's configuration.
+		os.Setenv("GOMISC_CONFIG", filepath.Join(repo, "no-config.toml"))
 		os.Chdir(repo)
 		defer func() {
 			os.Args = oldArgs
@@ -111,7 +113,7 @@ func TestMain(m *testing.M) {
 			t.Fatal("opening bench.log: ", err)
 		}
 		defer f.Close()
-		bs, err := bench.Parse(f)
+		bs, err := benchfmt.Parse(f)
 		if err != nil {
 			t.Fatal("malformed benchmark log: ", err)
 		}
@@ -123,7 +125,7 @@ func TestMain(m *testing.M) {
 			if uname, ok := b.Config["uname-sr"]; !ok {
 				t.Errorf("missing uname-sr config")
 			} else {
-				t.Logf("uname-sr: %s", uname)
+				t.Logf("uname-sr: %s", uname.RawValue)
 			}
 		}
 		for _, rev := range revs {
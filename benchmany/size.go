@@ -0,0 +1,67 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"os"
+)
+
+// binarySize returns the size metrics of the executable at path: its
+// total size in "B/exe" and, if it's an ELF, Mach-O, or PE file, the
+// size of its executable code in "B/text".
+func binarySize(path string) (map[string]float64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]float64{"B/exe": float64(fi.Size())}
+	if text, ok := textSize(path); ok {
+		res["B/text"] = float64(text)
+	}
+	return res, nil
+}
+
+// peSectionCode is the IMAGE_SCN_CNT_CODE section characteristic,
+// which marks sections containing executable code.
+const peSectionCode = 0x20
+
+// textSize returns the total size of the executable sections of the
+// binary at path.
+func textSize(path string) (uint64, bool) {
+	if f, err := elf.Open(path); err == nil {
+		defer f.Close()
+		var size uint64
+		for _, s := range f.Sections {
+			if s.Flags&elf.SHF_EXECINSTR != 0 {
+				size += s.Size
+			}
+		}
+		return size, true
+	}
+	if f, err := macho.Open(path); err == nil {
+		defer f.Close()
+		var size uint64
+		for _, s := range f.Sections {
+			if s.Seg == "__TEXT" && s.Name == "__text" {
+				size += s.Size
+			}
+		}
+		return size, true
+	}
+	if f, err := pe.Open(path); err == nil {
+		defer f.Close()
+		var size uint64
+		for _, s := range f.Sections {
+			if s.Characteristics&peSectionCode != 0 {
+				size += uint64(s.VirtualSize)
+			}
+		}
+		return size, true
+	}
+	return 0, false
+}
@@ -6,11 +6,11 @@ package main
 
 import (
 	"log"
-	"os"
-	"os/exec"
 	"regexp"
 	"strings"
 	"time"
+
+	"github.com/aclements/go-misc/internal/gitutil"
 )
 
 type CommitInfo struct {
@@ -21,36 +21,19 @@ type CommitInfo struct {
 }
 
 func Commits(repo string, revs ...string) (commits []CommitInfo) {
-	args := []string{"-C", repo, "log", "-s",
-		"--format=format:%H %aI %cI %P\n%s\n"}
-	if len(revs) == 0 {
-		args = append(args, "--all")
-	} else {
-		args = append(append(args, "--"), revs...)
+	r, err := gitutil.Open(repo)
+	if err != nil {
+		log.Fatal(err)
 	}
-	cmd := exec.Command("git", args...)
-	cmd.Stderr = os.Stderr
-	out, err := cmd.Output()
+	gcommits, err := r.Log(gitutil.LogOptions{}, revs...)
 	if err != nil {
-		log.Fatal("git show failed: ", err)
+		log.Fatal(err)
 	}
-	for _, line := range strings.Split(string(out), "\n\n") {
-		parts := strings.Split(line, "\n")
-		subject := parts[1]
-		parts = strings.Split(parts[0], " ")
-
-		adate, err := time.Parse(time.RFC3339, parts[1])
-		if err != nil {
-			log.Fatal("cannot parse author date: ", err)
-		}
-		cdate, err := time.Parse(time.RFC3339, parts[2])
-		if err != nil {
-			log.Fatal("cannot parse commit date: ", err)
-		}
-
+	children := gitutil.Children(gcommits)
+	for _, c := range gcommits {
 		commits = append(commits, CommitInfo{
-			parts[0], subject, "", adate, cdate,
-			parts[3:], nil,
+			c.Hash, c.Subject, "", c.AuthorDate, c.CommitDate,
+			c.Parents, children[c.Hash],
 		})
 	}
 
@@ -60,15 +43,6 @@ func Commits(repo string, revs ...string) (commits []CommitInfo) {
 		hashset[commits[i].Hash] = &commits[i]
 	}
 
-	// Compute children hashes.
-	for h, ci := range hashset {
-		for _, parent := range ci.Parents {
-			if ci2, ok := hashset[parent]; ok {
-				ci2.Children = append(ci2.Children, h)
-			}
-		}
-	}
-
 	// Compute branch names.
 	var branchRe = regexp.MustCompile(`^\[[^] ]+\] `)
 	var branchOf func(ci *CommitInfo) string
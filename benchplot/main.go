@@ -9,36 +9,45 @@
 // the full commit hash of the revision that gave that result.
 // benchplot will cross-reference these hashes against the specified
 // Git repository and plot each metric over time for each benchmark.
+// Benchmarks need not report the same metrics; for example, the build
+// time and binary size results recorded by benchmany -build-metrics
+// are plotted alongside the benchmarks' runtime metrics.
+//
+// The default for -C can be set by "repo" in the go-misc
+// configuration file. See
+// https://godoc.org/github.com/aclements/go-misc/internal/config.
 //
 // [1] https://github.com/golang/proposal/blob/master/design/14313-benchmark-format.md
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"runtime"
 	"runtime/pprof"
 	"strings"
 
 	"github.com/aclements/go-gg/gg"
 	"github.com/aclements/go-gg/table"
-	"github.com/aclements/go-misc/bench"
+	"github.com/aclements/go-misc/internal/benchfmt"
+	"github.com/aclements/go-misc/internal/config"
+	"github.com/aclements/go-misc/internal/gitutil"
 )
 
 func main() {
 	log.SetPrefix("benchplot: ")
 	log.SetFlags(0)
 
-	defaultGitDir, _ := exec.Command("git", "rev-parse", "--show-toplevel").Output()
-	defaultGitDir = bytes.TrimRight(defaultGitDir, "\n")
+	defaultGitDir := ""
+	if r, err := gitutil.Open("."); err == nil {
+		defaultGitDir = r.Dir()
+	}
 	var (
 		flagCPUProfile = flag.String("cpuprofile", "", "write CPU profile to `file`")
 		flagMemProfile = flag.String("memprofile", "", "write heap profile to `file`")
-		flagGitDir     = flag.String("C", string(defaultGitDir), "run git in `dir`")
+		flagGitDir     = flag.String("C", defaultGitDir, "run git in `dir`")
 		flagOut        = flag.String("o", "", "write output to `file` (default: stdout)")
 		flagTable      = flag.Bool("table", false, "output a table instead of a plot")
 	)
@@ -46,7 +55,11 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [inputs...]\n", os.Args[0])
 		flag.PrintDefaults()
 	}
+	cfg := config.NewFlags(flag.CommandLine, "benchplot", map[string]string{"repo": "C"})
 	flag.Parse()
+	if err := cfg.Apply(); err != nil {
+		log.Fatal(err)
+	}
 
 	if *flagCPUProfile != "" {
 		f, err := os.Create(*flagCPUProfile)
@@ -74,7 +87,7 @@ func main() {
 	if len(paths) == 0 {
 		paths = []string{"-"}
 	}
-	var benchmarks []*bench.Benchmark
+	var benchmarks []*benchfmt.Benchmark
 	for _, path := range paths {
 		func() {
 			f := os.Stdin
@@ -87,14 +100,14 @@ func main() {
 				defer f.Close()
 			}
 
-			bs, err := bench.Parse(f)
+			bs, err := benchfmt.Parse(f)
 			if err != nil {
 				log.Fatal(err)
 			}
 			benchmarks = append(benchmarks, bs...)
 		}()
 	}
-	bench.ParseValues(benchmarks, nil)
+	benchfmt.ParseValues(benchmarks, nil)
 
 	// Prepare gg tables.
 	var tab table.Grouping
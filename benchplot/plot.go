@@ -43,6 +43,11 @@ func plot(t table.Grouping, configCols, resultCols []string) (*gg.Plot, int, int
 	plot.SetData(table.Unpivot(plot.Data(), "metric", "result", resultCols...))
 	y := "result"
 
+	// Drop metrics a benchmark doesn't have. Not every benchmark
+	// reports every metric; for example, benchmany's build
+	// results only have build time and binary size metrics.
+	plot.SetData(removeNaNs(plot.Data(), y))
+
 	// Normalize to earliest commit on master. It's important to
 	// do this before the geomean if there are commits missing.
 	// Unfortunately, that also means we have to *temporarily*
@@ -12,10 +12,11 @@ import (
 	"time"
 
 	"github.com/aclements/go-gg/table"
-	"github.com/aclements/go-misc/bench"
+	"github.com/aclements/go-misc/internal/benchfmt"
+	"github.com/aclements/go-misc/internal/benchproc"
 )
 
-func benchmarksToTable(bs []*bench.Benchmark) (t *table.Table, configCols, resultCols []string) {
+func benchmarksToTable(bs []*benchfmt.Benchmark) (t *table.Table, configCols, resultCols []string) {
 	// Gather name, config, and result columns.
 	nan := math.NaN()
 	names := make([]string, len(bs))
@@ -72,14 +73,26 @@ func benchmarksToTable(bs []*bench.Benchmark) (t *table.Table, configCols, resul
 	sort.Strings(keys)
 	for _, key := range keys {
 		nicekey := strings.Replace(key, "-", " ", -1)
-		if nicekey == "ns/op" {
-			// TODO: Use the unit parser from benchstat.
-			nicekey = "time/op"
+		if unit := benchproc.ParseUnit(key); unit.Class == benchproc.UnitTime {
+			// Plot times as durations, so they get
+			// time-based axis labels. Durations can't
+			// represent missing results, such as the
+			// runtime metrics of benchmany's build
+			// results, so those stay in seconds.
+			nicekey = "time" + strings.TrimPrefix(unit.Tidy, "sec")
 			durations := make([]time.Duration, len(results[key]))
+			secs := make([]float64, len(results[key]))
+			missing := false
 			for i, x := range results[key] {
-				durations[i] = time.Duration(x)
+				durations[i] = time.Duration(x * unit.Factor * 1e9)
+				secs[i] = x * unit.Factor
+				missing = missing || math.IsNaN(x)
+			}
+			if missing {
+				tab.Add(nicekey, secs)
+			} else {
+				tab.Add(nicekey, durations)
 			}
-			tab.Add(nicekey, durations)
 		} else {
 			tab.Add(nicekey, results[key])
 		}
@@ -0,0 +1,182 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command benchserve continuously benchmarks a git repository.
+//
+// benchserve watches a branch of a git repository and, whenever new
+// commits land, runs benchmany on them. It serves a dashboard over
+// HTTP with the latest results and a benchplot plot of each
+// benchmark over time, and alerts on regressions.
+//
+// benchserve runs benchmany in the current directory, which should
+// contain the benchmarks to run. benchmany checks out each commit it
+// benchmarks, so the repository should be a clone dedicated to
+// benchserve. For example,
+//
+//     git clone https://go.googlesource.com/go ~/benchserve/go
+//     cd ~/benchserve/go/test/bench/go1
+//     benchserve -C ~/benchserve/go -d ~/benchserve/bench -since go1.12
+//
+// benchmany and benchplot must be in $PATH. benchmany's binaries and
+// results are kept in the -d directory; the results log,
+// bench.log, is the only state benchserve keeps, so it's safe to
+// restart.
+//
+// A regression is a change between consecutive benchmarked commits
+// in the mean of a benchmark metric by more than -threshold in the
+// worse direction (for example, higher ns/op or lower MB/s) that is
+// statistically significant by a Mann-Whitney U-test at level -alpha.
+// New regressions are delivered as JSON to -alert-url and
+// -alert-cmd. Regressions already in the log when benchserve starts
+// are shown on the dashboard but not alerted.
+//
+// The defaults for -C and -alert-url can be set by "repo" and
+// "alert-url" in the go-misc configuration file. See
+// https://godoc.org/github.com/aclements/go-misc/internal/config.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/aclements/go-misc/internal/config"
+	"github.com/aclements/go-misc/internal/gitutil"
+)
+
+var (
+	flagGitDir     = flag.String("C", "", "benchmark the git repository in `dir`")
+	flagBranch     = flag.String("branch", "master", "benchmark new commits to `branch`")
+	flagSince      = flag.String("since", "", "benchmark commits after `revision` (default the branch's parent at startup)")
+	flagDir        = flag.String("d", ".", "keep benchmark binaries and results in `directory`")
+	flagHTTP       = flag.String("http", ":8080", "serve the dashboard on `address`")
+	flagPoll       = flag.Duration("poll", 10*time.Minute, "check for new commits every `interval`")
+	flagFetch      = flag.Bool("fetch", true, "run git fetch before checking for new commits")
+	flagN          = flag.Int("n", 5, "run each benchmark `N` times")
+	flagBenchFlags = flag.String("benchflags", "", "pass `flags` to benchmany -benchflags")
+	flagBuildCmd   = flag.String("buildcmd", "", "pass `cmd` to benchmany -buildcmd")
+	flagThreshold  = flag.Float64("threshold", 0.05, "report regressions of more than `fraction` of the old mean")
+	flagAlpha      = flag.Float64("alpha", 0.05, "report regressions significant at `level`")
+	flagAlertURL   = flag.String("alert-url", "", "POST regression alerts as JSON to `url`")
+	flagAlertCmd   = flag.String("alert-cmd", "", "run shell `command` with each regression alert as JSON on stdin")
+)
+
+// repo is the repository given by -C.
+var repo *gitutil.Repo
+
+func main() {
+	log.SetPrefix("benchserve: ")
+	log.SetFlags(log.LstdFlags)
+
+	cfg := config.NewFlags(flag.CommandLine, "benchserve", map[string]string{
+		"repo":      "C",
+		"alert-url": "alert-url",
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := cfg.Apply(); err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := *flagGitDir
+	if dir == "" {
+		dir = "."
+	}
+	var err error
+	repo, err = gitutil.Open(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	since := *flagSince
+	if since == "" {
+		since = branchRef() + "^"
+	}
+	since, err = repo.Resolve(since)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := &server{
+		since:   since,
+		logPath: filepath.Join(*flagDir, "bench.log"),
+		alerted: make(map[regressionKey]bool),
+	}
+	// Establish a baseline without alerting.
+	if err := s.update(true); err != nil {
+		log.Print(err)
+	}
+	go func() {
+		log.Fatal(http.ListenAndServe(*flagHTTP, s.handler()))
+	}()
+	log.Printf("serving dashboard on %s", *flagHTTP)
+
+	for round := 0; ; round++ {
+		if round > 0 {
+			time.Sleep(*flagPoll)
+		}
+		if err := s.benchmark(); err != nil {
+			log.Print(err)
+		}
+		if err := s.update(false); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// benchmark fetches new commits and benchmarks any commits since
+// s.since that haven't been benchmarked. benchmany resumes from its
+// log, so this only runs new commits.
+func (s *server) benchmark() error {
+	if *flagFetch {
+		if _, err := repo.Run("fetch", "-q"); err != nil {
+			return err
+		}
+	}
+	head, err := repo.Resolve(branchRef())
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-d", *flagDir, "-n", fmt.Sprint(*flagN)}
+	if *flagGitDir != "" {
+		args = append(args, "-C", *flagGitDir)
+	}
+	if *flagBenchFlags != "" {
+		args = append(args, "-benchflags", *flagBenchFlags)
+	}
+	if *flagBuildCmd != "" {
+		args = append(args, "-buildcmd", *flagBuildCmd)
+	}
+	args = append(args, s.since+".."+head)
+	cmd := exec.Command("benchmany", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("benchmany: %v", err)
+	}
+	return nil
+}
+
+// branchRef returns the ref to poll for new commits. With -fetch,
+// this is the remote-tracking branch, since fetch doesn't update
+// local branches.
+func branchRef() string {
+	if *flagFetch {
+		return "origin/" + *flagBranch
+	}
+	return *flagBranch
+}
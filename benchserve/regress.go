@@ -0,0 +1,196 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/aclements/go-misc/internal/benchfmt"
+	"github.com/aclements/go-misc/internal/benchproc"
+	"github.com/aclements/go-moremath/stats"
+)
+
+// A Regression is a significant worsening of a benchmark metric
+// between two consecutive benchmarked commits. Regressions are
+// delivered as JSON to -alert-url and -alert-cmd.
+type Regression struct {
+	// Benchmark is the benchmark name and Unit is the metric's
+	// unit, as written in the benchmark log.
+	Benchmark, Unit string
+
+	// Commit is the commit that regressed and Prev is the last
+	// benchmarked commit before it.
+	Commit, Prev string
+
+	// Old and New are the metric's means at Prev and Commit.
+	Old, New float64
+
+	// Change is the fractional change in the mean, (New-Old)/Old.
+	Change float64
+
+	// P is the p-value of the Mann-Whitney U-test between the
+	// samples at Prev and Commit.
+	P float64
+}
+
+// regressionKey identifies a regression so it's alerted only once.
+type regressionKey struct {
+	benchmark, unit, commit string
+}
+
+func (r *Regression) key() regressionKey {
+	return regressionKey{r.Benchmark, r.Unit, r.Commit}
+}
+
+func (r *Regression) String() string {
+	return fmt.Sprintf("%s %s: %s -> %s (%s, p=%.3f) at %.10s", r.Benchmark, r.Unit, r.Format(r.Old), r.Format(r.New), r.FormatChange(), r.P, r.Commit)
+}
+
+// Format formats a value of r's metric.
+func (r *Regression) Format(v float64) string {
+	return benchproc.ParseUnit(r.Unit).Format(v)
+}
+
+// FormatChange formats r.Change as a percentage.
+func (r *Regression) FormatChange() string {
+	return fmt.Sprintf("%+.1f%%", r.Change*100)
+}
+
+// A series is the samples of one benchmark metric at each commit.
+type series struct {
+	name, unit string
+	samples    map[string][]float64 // by commit hash
+}
+
+// collectSeries returns the series of every benchmark metric in bs,
+// sorted by name and unit.
+func collectSeries(bs []*benchfmt.Benchmark) []*series {
+	byName := benchproc.MustParseProjection(benchproc.NameKey)
+	byCommit := benchproc.MustParseProjection("commit")
+	var out []*series
+	names, nameGroups := byName.Group(bs)
+	for _, nk := range names {
+		byUnit := make(map[string]*series)
+		commits, commitGroups := byCommit.Group(nameGroups[nk])
+		for _, ck := range commits {
+			commit := ck.Get("commit")
+			for _, b := range commitGroups[ck] {
+				for unit, val := range b.Result {
+					s := byUnit[unit]
+					if s == nil {
+						s = &series{nk.Get(benchproc.NameKey), unit, make(map[string][]float64)}
+						byUnit[unit] = s
+						out = append(out, s)
+					}
+					s.samples[commit] = append(s.samples[commit], val)
+				}
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].name != out[j].name {
+			return out[i].name < out[j].name
+		}
+		return out[i].unit < out[j].unit
+	})
+	return out
+}
+
+// regressions returns the regressions in s between consecutive
+// commits in commits that have samples. commits must be ordered
+// oldest first.
+func (s *series) regressions(commits []string) []*Regression {
+	// Throughput units are better when higher.
+	sign := 1.0
+	if strings.HasSuffix(benchproc.ParseUnit(s.unit).Tidy, "/s") {
+		sign = -1
+	}
+
+	var out []*Regression
+	prev := ""
+	for _, commit := range commits {
+		xs := s.samples[commit]
+		if len(xs) == 0 {
+			continue
+		}
+		if prev != "" {
+			old, new := stats.Mean(s.samples[prev]), stats.Mean(xs)
+			change := (new - old) / old
+			if old != 0 && sign*change > *flagThreshold {
+				res, err := stats.MannWhitneyUTest(s.samples[prev], xs, stats.LocationDiffers)
+				if err == nil && res.P < *flagAlpha {
+					out = append(out, &Regression{
+						Benchmark: s.name, Unit: s.unit,
+						Commit: commit, Prev: prev,
+						Old: old, New: new, Change: change,
+						P: res.P,
+					})
+				}
+			}
+		}
+		prev = commit
+	}
+	return out
+}
+
+// latest returns the mean of s at the last commit in commits with
+// samples and its fractional change from the commit before it, or
+// NaN if there are no samples or no earlier commit.
+func (s *series) latest(commits []string) (mean, change float64) {
+	mean, change = math.NaN(), math.NaN()
+	for i := len(commits) - 1; i >= 0; i-- {
+		xs := s.samples[commits[i]]
+		if len(xs) == 0 {
+			continue
+		}
+		if math.IsNaN(mean) {
+			mean = stats.Mean(xs)
+			continue
+		}
+		change = (mean - stats.Mean(xs)) / stats.Mean(xs)
+		break
+	}
+	return
+}
+
+// sendAlert logs r and delivers it to -alert-url and -alert-cmd.
+func sendAlert(r *Regression) error {
+	fmt.Fprintf(os.Stderr, "regression: %s\n", r)
+
+	js, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if *flagAlertURL != "" {
+		resp, err := http.Post(*flagAlertURL, "application/json", bytes.NewReader(js))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s: %s", *flagAlertURL, resp.Status)
+		}
+	}
+
+	if *flagAlertCmd != "" {
+		cmd := exec.Command("sh", "-c", *flagAlertCmd)
+		cmd.Stdin = bytes.NewReader(js)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %v", *flagAlertCmd, err)
+		}
+	}
+	return nil
+}
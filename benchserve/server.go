@@ -0,0 +1,281 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aclements/go-misc/internal/benchfmt"
+	"github.com/aclements/go-misc/internal/benchproc"
+	"github.com/aclements/go-misc/internal/gitutil"
+)
+
+type server struct {
+	// since is the commit after which to benchmark.
+	since string
+
+	// logPath is benchmany's results log.
+	logPath string
+
+	// alerted is the set of regressions already alerted. It's
+	// only accessed by the main loop.
+	alerted map[regressionKey]bool
+
+	mu    sync.Mutex
+	state *state
+}
+
+// state is a snapshot of the analyzed results, served by the
+// dashboard.
+type state struct {
+	Updated time.Time
+
+	// Head is the newest commit.
+	Head string
+
+	// Rows is the latest results of each benchmark metric.
+	Rows []*row
+
+	// Regressions is every regression, newest first.
+	Regressions []*Regression
+
+	bs []*benchfmt.Benchmark
+}
+
+type row struct {
+	Benchmark, Unit string
+
+	// Latest is the formatted mean at the newest commit with
+	// results and Change is its change from the previous commit.
+	Latest, Change string
+
+	// Regressed indicates that the newest commit regressed.
+	Regressed bool
+}
+
+// update re-reads and analyzes the results log and, unless quiet,
+// alerts on new regressions.
+func (s *server) update(quiet bool) error {
+	f, err := os.Open(s.logPath)
+	if os.IsNotExist(err) {
+		// Nothing has been benchmarked yet.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	bs, err := benchfmt.Parse(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", s.logPath, err)
+	}
+
+	gcommits, err := repo.Log(gitutil.LogOptions{FirstParent: true}, s.since+".."+branchRef())
+	if err != nil {
+		return err
+	}
+	// commits is oldest first.
+	commits := make([]string, len(gcommits))
+	for i, c := range gcommits {
+		commits[len(gcommits)-1-i] = c.Hash
+	}
+
+	st := &state{Updated: time.Now(), bs: bs}
+	if len(commits) > 0 {
+		st.Head = commits[len(commits)-1]
+	}
+	for _, ser := range collectSeries(bs) {
+		regs := ser.regressions(commits)
+		st.Regressions = append(st.Regressions, regs...)
+
+		r := &row{Benchmark: ser.name, Unit: ser.unit}
+		mean, change := ser.latest(commits)
+		if !math.IsNaN(mean) {
+			r.Latest = benchproc.ParseUnit(ser.unit).Format(mean)
+		}
+		if !math.IsNaN(change) {
+			r.Change = fmt.Sprintf("%+.1f%%", change*100)
+		}
+		if len(regs) > 0 {
+			last := regs[len(regs)-1].Commit
+			for i := len(commits) - 1; i >= 0; i-- {
+				if len(ser.samples[commits[i]]) > 0 {
+					r.Regressed = commits[i] == last
+					break
+				}
+			}
+		}
+		st.Rows = append(st.Rows, r)
+	}
+
+	// Order regressions newest first.
+	order := make(map[string]int, len(commits))
+	for i, c := range commits {
+		order[c] = i
+	}
+	sort.SliceStable(st.Regressions, func(i, j int) bool {
+		return order[st.Regressions[i].Commit] > order[st.Regressions[j].Commit]
+	})
+
+	s.mu.Lock()
+	s.state = st
+	s.mu.Unlock()
+
+	for _, r := range st.Regressions {
+		if s.alerted[r.key()] {
+			continue
+		}
+		s.alerted[r.key()] = true
+		if quiet {
+			continue
+		}
+		if err := sendAlert(r); err != nil {
+			log.Printf("sending alert for %s %s: %v", r.Benchmark, r.Unit, err)
+		}
+	}
+	return nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveIndex)
+	mux.HandleFunc("/plot.svg", s.servePlot)
+	mux.HandleFunc("/bench.log", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, s.logPath)
+	})
+	return mux
+}
+
+func (s *server) snapshot() *state {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return &state{}
+	}
+	return s.state
+}
+
+func (s *server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	var buf bytes.Buffer
+	if err := indexTemplate.Execute(&buf, s.snapshot()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// servePlot serves a benchplot plot of the benchmark named by the
+// "name" query parameter.
+func (s *server) servePlot(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	var bs []*benchfmt.Benchmark
+	for _, b := range s.snapshot().bs {
+		if b.Name == name {
+			bs = append(bs, b)
+		}
+	}
+	if len(bs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	// benchplot reads results from a file.
+	tmp, err := ioutil.TempFile("", "benchserve")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	err = benchfmt.Fprint(tmp, bs)
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var args []string
+	if *flagGitDir != "" {
+		args = append(args, "-C", *flagGitDir)
+	}
+	args = append(args, tmp.Name())
+	cmd := exec.Command("benchplot", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	svg, err := cmd.Output()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("benchplot: %v\n%s", err, stderr.Bytes()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>benchserve</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 1em; text-align: left; }
+td.num { text-align: right; }
+.regressed { color: #c00; font-weight: bold; }
+.hash { font-family: monospace; }
+</style>
+</head>
+<body>
+{{if .Head}}
+<p>Results through <span class="hash">{{printf "%.10s" .Head}}</span>, updated {{.Updated.Format "2006-01-02 15:04:05"}}. <a href="/bench.log">Raw results</a>.</p>
+{{else}}
+<p>No results yet.</p>
+{{end}}
+
+{{with .Regressions}}
+<h2>Regressions</h2>
+<table>
+<tr><th>Commit</th><th>Benchmark</th><th>Old</th><th>New</th><th>Change</th><th>p</th></tr>
+{{range .}}
+<tr>
+<td class="hash">{{printf "%.10s" .Commit}}</td>
+<td><a href="/plot.svg?name={{.Benchmark}}">{{.Benchmark}}</a> {{.Unit}}</td>
+<td class="num">{{.Format .Old}}</td><td class="num">{{.Format .New}}</td>
+<td class="num">{{.FormatChange}}</td><td class="num">{{printf "%.3f" .P}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+
+{{with .Rows}}
+<h2>Benchmarks</h2>
+<table>
+<tr><th>Benchmark</th><th>Unit</th><th>Latest</th><th>Change</th></tr>
+{{range .}}
+<tr{{if .Regressed}} class="regressed"{{end}}>
+<td><a href="/plot.svg?name={{.Benchmark}}">{{.Benchmark}}</a></td>
+<td>{{.Unit}}</td><td class="num">{{.Latest}}</td><td class="num">{{.Change}}</td>
+</tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,293 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command benchsplit splits a Go benchmark results file [1] into a
+// file for each value of one or more configuration keys.
+//
+// For example, to split a benchmany log into a file per commit,
+//
+//     benchsplit -key commit -o by-commit bench.log
+//
+// writes by-commit/<hash>.log for each commit in bench.log. With
+// several keys, such as -key goarch,commit, the output files are
+// named by the values of all of the keys, separated by commas.
+//
+// Each output file includes the configuration lines in effect for
+// its results, so it can be processed on its own by benchstat,
+// benchplot, and other tools. A key can be set by a configuration
+// line or in a benchmark name, such as BenchmarkX/commit:abcdef.
+// Results that don't have all of the keys are dropped, as are lines
+// that are neither configuration nor results, such as test output.
+//
+// benchsplit reads the input files in order, or standard input if
+// there are none, and appends to existing output files, so it can be
+// run more than once to collect results into the same files.
+//
+// [1] https://github.com/golang/proposal/blob/master/design/14313-benchmark-format.md
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aclements/go-misc/internal/benchfmt"
+)
+
+var (
+	flagKey = flag.String("key", "", "split by comma-separated configuration `keys`")
+	flagOut = flag.String("o", ".", "write output files to `directory`")
+)
+
+func main() {
+	log.SetPrefix("benchsplit: ")
+	log.SetFlags(0)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] -key keys [inputs...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *flagKey == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	keys := strings.Split(*flagKey, ",")
+
+	if err := os.MkdirAll(*flagOut, 0777); err != nil {
+		log.Fatal(err)
+	}
+
+	s := newSplitter(*flagOut, keys)
+	if flag.NArg() == 0 {
+		s.split(os.Stdin)
+	} else {
+		for _, path := range flag.Args() {
+			f, err := os.Open(path)
+			if err != nil {
+				s.close()
+				log.Fatal(err)
+			}
+			s.split(f)
+			f.Close()
+		}
+	}
+	s.close()
+
+	if s.missing > 0 {
+		log.Printf("dropped %d results without %s", s.missing, strings.Join(keys, " and "))
+	}
+}
+
+// A splitter writes each result line to the output file for its
+// values of keys.
+type splitter struct {
+	dir  string
+	keys []string
+
+	// config is the block configuration in effect. configLines
+	// is the line that set each key in config, and configOrder
+	// is the keys of config in the order they first appeared.
+	config      map[string]*benchfmt.Config
+	configLines map[string]string
+	configOrder []string
+
+	// outs is the output for each file name, including outputs
+	// whose files are closed. open is the outputs with open
+	// files, most recently used first.
+	outs map[string]*output
+	open *list.List
+
+	// missing is the number of results that didn't have all of
+	// keys.
+	missing int
+}
+
+// maxOpen is the maximum number of output files to keep open at
+// once. Splitting a long log by commit can produce thousands of
+// outputs, so less recently used outputs are closed and reopened in
+// append mode when needed.
+const maxOpen = 128
+
+// An output is one output file.
+type output struct {
+	// f and w write to the file if it's open. elem is this
+	// output's element in splitter.open.
+	f    *os.File
+	w    *bufio.Writer
+	elem *list.Element
+
+	// value is the values of the keys that named this file.
+	value string
+
+	// config is the block configuration last written to this
+	// file, as the lines that set each key.
+	config map[string]string
+}
+
+func newSplitter(dir string, keys []string) *splitter {
+	return &splitter{
+		dir:         dir,
+		keys:        keys,
+		config:      make(map[string]*benchfmt.Config),
+		configLines: make(map[string]string),
+		outs:        make(map[string]*output),
+		open:        list.New(),
+	}
+}
+
+// split splits the results in r. Block configuration carries over
+// from earlier calls, as if the inputs were concatenated.
+func (s *splitter) split(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if k, v, ok := benchfmt.ParseConfigLine(line); ok {
+			if s.config[k] == nil {
+				s.configOrder = append(s.configOrder, k)
+			}
+			s.config[k] = &benchfmt.Config{RawValue: v, InBlock: true}
+			s.configLines[k] = line
+			continue
+		}
+
+		b := benchfmt.ParseBenchmarkLine(line, s.config)
+		if b == nil {
+			continue
+		}
+		var vals []string
+		for _, k := range s.keys {
+			c := b.Config[k]
+			if c == nil {
+				break
+			}
+			vals = append(vals, c.RawValue)
+		}
+		if len(vals) < len(s.keys) {
+			s.missing++
+			continue
+		}
+		s.write(strings.Join(vals, ","), line)
+	}
+	if err := scanner.Err(); err != nil {
+		s.close()
+		log.Fatal(err)
+	}
+}
+
+// write writes result line to the output for value, preceded by any
+// configuration lines that changed since the last result written to
+// that output.
+func (s *splitter) write(value, line string) {
+	out := s.output(value)
+	first := true
+	for _, k := range s.configOrder {
+		cl := s.configLines[k]
+		if out.config[k] == cl {
+			continue
+		}
+		if first && len(out.config) > 0 {
+			// Separate configuration blocks.
+			fmt.Fprintln(out.w)
+		}
+		first = false
+		fmt.Fprintln(out.w, cl)
+		out.config[k] = cl
+	}
+	fmt.Fprintln(out.w, line)
+}
+
+// output returns the output for value, creating or reopening it if
+// necessary.
+func (s *splitter) output(value string) *output {
+	name := fileName(value)
+	out := s.outs[name]
+	if out != nil && out.value != value {
+		s.close()
+		log.Fatalf("values %q and %q both write to %s", out.value, value, name)
+	}
+	if out != nil && out.f != nil {
+		s.open.MoveToFront(out.elem)
+		return out
+	}
+
+	if s.open.Len() >= maxOpen {
+		lru := s.open.Remove(s.open.Back()).(*output)
+		if err := lru.closeFile(); err != nil {
+			s.close()
+			log.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(s.dir, name)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		s.close()
+		log.Fatal(err)
+	}
+	reopen := out != nil
+	if !reopen {
+		out = &output{value: value, config: make(map[string]string)}
+		s.outs[name] = out
+	}
+	out.f, out.w = f, bufio.NewWriter(f)
+	out.elem = s.open.PushFront(out)
+	// If out was closed, out.config still records the
+	// configuration in the file, so it picks up where it left
+	// off.
+	if st, err := f.Stat(); err == nil && st.Size() > 0 && !reopen {
+		// Appending to an earlier run's output. Separate
+		// the new configuration from the old results.
+		fmt.Fprintln(out.w)
+	}
+	return out
+}
+
+// closeFile flushes and closes out's file. out can be reopened by
+// splitter.output.
+func (out *output) closeFile() error {
+	err := out.w.Flush()
+	if err1 := out.f.Close(); err == nil {
+		err = err1
+	}
+	out.f, out.w, out.elem = nil, nil, nil
+	return err
+}
+
+// close flushes and closes all of the outputs.
+func (s *splitter) close() {
+	for e := s.open.Front(); e != nil; e = e.Next() {
+		if err := e.Value.(*output).closeFile(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	s.open.Init()
+	s.outs = nil
+}
+
+// fileName returns the output file name for value. Characters that
+// have special meaning in paths are replaced with "_".
+func fileName(value string) string {
+	if value == "" {
+		value = "_"
+	}
+	value = strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		case strings.ContainsRune("+,-.=@", r):
+			return r
+		}
+		return '_'
+	}, value)
+	return value + ".log"
+}
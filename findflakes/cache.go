@@ -0,0 +1,143 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aclements/go-misc/internal/loganal"
+)
+
+// failureCacheVersion must be incremented whenever the format of the
+// cache changes or loganal changes in a way that affects extracted
+// failures. Caches with a different version are discarded.
+const failureCacheVersion = 5
+
+// failureCache is a persistent cache of the failures extracted from
+// each build log. Logs are never modified once fetched, so entries
+// are keyed by log path and validated against the log's size and
+// modification time.
+type failureCache struct {
+	path string
+
+	mu    sync.Mutex
+	logs  map[string]*cachedLog
+	dirty bool
+}
+
+// cachedLog records the failures extracted from a single log.
+type cachedLog struct {
+	Size     int64
+	ModTime  time.Time
+	Failures []*loganal.Failure
+}
+
+// cacheFile is the on-disk representation of a failureCache.
+type cacheFile struct {
+	Version int
+	Logs    map[string]*cachedLog
+}
+
+// openFailureCache loads the failure cache from path. If path is "",
+// the returned cache is empty and will not be saved. If path does
+// not exist or cannot be decoded, the returned cache is empty.
+func openFailureCache(path string) *failureCache {
+	c := &failureCache{path: path, logs: make(map[string]*cachedLog)}
+	if path == "" {
+		return c
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c
+	} else if err != nil {
+		log.Printf("opening failure cache: %v", err)
+		return c
+	}
+	defer f.Close()
+
+	var cf cacheFile
+	if err := gob.NewDecoder(f).Decode(&cf); err != nil {
+		log.Printf("%s: discarding corrupt failure cache: %v", path, err)
+		return c
+	}
+	if cf.Version != failureCacheVersion || cf.Logs == nil {
+		return c
+	}
+	c.logs = cf.Logs
+	return c
+}
+
+// Get returns the cached failures for build's log. ok is false if
+// build's log is not in the cache or has changed since it was cached.
+func (c *failureCache) Get(build *Build) (failures []*loganal.Failure, ok bool) {
+	path := build.LogPath()
+	c.mu.Lock()
+	ent := c.logs[path]
+	c.mu.Unlock()
+	if ent == nil {
+		return nil, false
+	}
+	st, err := os.Stat(path)
+	if err != nil || st.Size() != ent.Size || !st.ModTime().Equal(ent.ModTime) {
+		return nil, false
+	}
+	return ent.Failures, true
+}
+
+// Put records the failures extracted from build's log.
+func (c *failureCache) Put(build *Build, failures []*loganal.Failure) {
+	path := build.LogPath()
+	st, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logs[path] = &cachedLog{st.Size(), st.ModTime(), failures}
+	c.dirty = true
+}
+
+// Save writes the cache back to disk if it has changed.
+func (c *failureCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+
+	if err := xdgCreateDir(filepath.Dir(c.path)); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it into place so a
+	// concurrent or interrupted run never sees a partial cache.
+	f, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	cf := cacheFile{Version: failureCacheVersion, Logs: c.logs}
+	if err := gob.NewEncoder(f).Encode(&cf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	c.dirty = false
+	return nil
+}
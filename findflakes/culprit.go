@@ -0,0 +1,94 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "math"
+
+// CulpritRange is a range of commits that probably introduced a
+// failure.
+type CulpritRange struct {
+	// First and Last are the times of the oldest and newest
+	// commits in this range.
+	First, Last int
+
+	// P is the total probability that the culprit is in this
+	// range.
+	P float64
+
+	// Culprits gives the probability of each commit in the range,
+	// from newest (Last) to oldest (First).
+	Culprits []Culprit
+}
+
+// CulpritRange returns the smallest range of commits ending at the
+// first observed failure in the latest flaky region whose cumulative
+// probability of having introduced the failure is at least cumProb.
+// At most limit commits are considered.
+//
+// Unlike FlakeRegion.Culprits, which assumes every commit before the
+// first failure was tested equally, this uses the pass/fail history of
+// the builders that observed this failure. A commit at which those
+// builders passed is evidence that the failure had not started yet,
+// while a commit those builders never completed provides no evidence
+// either way.
+func (fc *failureClass) CulpritRange(cumProb float64, limit int) *CulpritRange {
+	reg := fc.Latest
+
+	// Find the builders that observed this failure.
+	builders := make(map[string]bool)
+	for _, f := range fc.Failures {
+		if f.T >= reg.First && f.T <= reg.Last && f.Build != nil {
+			builders[f.Build.Builder] = true
+		}
+	}
+
+	// FailureProbability is per commit across all of these
+	// builders. Assuming each builder fails independently, a
+	// single passing build is evidence 1/len(builders) as strong
+	// as a passing commit.
+	pass := 1 - reg.FailureProbability
+	passWeight := func(t int) float64 {
+		if len(builders) == 0 {
+			return 1
+		}
+		ok := 0
+		for _, build := range fc.Revs[t].Builds {
+			if builders[build.Builder] && build.Status == BuildOK {
+				ok++
+			}
+		}
+		return float64(ok) / float64(len(builders))
+	}
+
+	// Compute the likelihood that the failure started at each
+	// time t. If it started at t, then every commit from t up to
+	// (but not including) First ran with the failure present but
+	// didn't fail. Because of this, the likelihood is
+	// non-increasing as t moves back in time, so the smallest
+	// range covering cumProb always ends at First.
+	culprits := []Culprit{}
+	total, l := 0.0, 1.0
+	for t := reg.First; t >= 0 && t > reg.First-limit; t-- {
+		if t < reg.First {
+			l *= math.Pow(pass, passWeight(t))
+		}
+		culprits = append(culprits, Culprit{P: l, T: t})
+		total += l
+	}
+	if total == 0 {
+		return nil
+	}
+
+	cr := &CulpritRange{Last: reg.First}
+	for i := range culprits {
+		culprits[i].P /= total
+		if cr.P < cumProb {
+			cr.P += culprits[i].P
+			cr.First = culprits[i].T
+			cr.Culprits = culprits[:i+1]
+		}
+	}
+	return cr
+}
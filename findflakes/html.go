@@ -109,15 +109,18 @@ a.hash {
           {{end}}
           {{end}}
           <tr><th>Last observed</th><td>{{template "observation" (index $failuresByT .Last)}}</td></tr>
+          {{with ($class.CulpritRange 0.9 10)}}
           <tr><th>Likely culprits</th>
 	    <td style="padding:0px">
 	      <table>
-		{{range (.Culprits 0.9 10)}}
+		<caption>{{pct .P}} chance in {{template "revLink" (index $class.Revs .First)}}..{{template "revLink" (index $class.Revs .Last)}}</caption>
+		{{range .Culprits}}
 		<tr><td class="pct">{{pct .P}}</td><td>{{template "revSubject" (index $class.Revs .T)}}</td></tr>
 		{{end}}
 	      </table>
 	    </td>
           </tr>
+          {{end}}
           {{end}}{{/* numCommits == 1*/}}
           {{end}}{{/* with .Latest */}}
           {{with (slice .Test.All 1 (len .Test.All))}}
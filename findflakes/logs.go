@@ -65,6 +65,10 @@ func (b *Build) ReadLog() ([]byte, error) {
 	return ioutil.ReadFile(b.LogPath())
 }
 
+func (b *Build) OpenLog() (*os.File, error) {
+	return os.Open(b.LogPath())
+}
+
 // LoadRevisions loads all saved build revisions from revDir, which
 // must be the "rev" directory written by fetchlogs. The returned
 // revisions are ordered from oldest to newest.
@@ -6,6 +6,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
@@ -16,6 +17,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/aclements/go-misc/internal/config"
 	"github.com/aclements/go-misc/internal/loganal"
 )
 
@@ -24,17 +26,30 @@ var (
 	flagBranch = flag.String("branch", "master", "analyze commits to `branch`")
 	flagHTML   = flag.Bool("html", false, "print an HTML report")
 	flagLimit  = flag.Int("limit", 0, "process only most recent `N` revisions")
+	flagCache  = flag.String("cache", defaultCachePath(), "cache extracted failures in `file`; empty to disable")
+
+	flagSubtests = flag.Bool("subtests", false, "classify failures by subtest instead of by top-level test")
 
 	// TODO: Is this really just a separate mode? Should we have
 	// subcommands?
 	flagGrep  = flag.String("grep", "", "show analysis for logs matching `regexp`")
 	flagPaths = flag.Bool("paths", false, "read dir-relative paths of logs with failures from stdin (useful with greplogs -l)")
+
+	flagMonitor        = flag.Duration("monitor", 0, "re-run the analysis every `interval` and alert on changes")
+	flagFetchCmd       = flag.String("fetch-cmd", "fetchlogs", "in monitor mode, run shell `command` to fetch new logs before each analysis")
+	flagAlertThreshold = flag.Float64("alert-threshold", 0.5, "in monitor mode, alert when the chance a failure is still happening crosses `p`")
+	flagAlertURL       = flag.String("alert-url", "", "in monitor mode, POST alerts as JSON to `url`")
+	flagAlertCmd       = flag.String("alert-cmd", "", "in monitor mode, run shell `command` with each alert as JSON on stdin")
 )
 
 func defaultRevDir() string {
 	return filepath.Join(xdgCacheDir(), "fetchlogs", "rev")
 }
 
+func defaultCachePath() string {
+	return filepath.Join(xdgCacheDir(), "findflakes", "failures.gob")
+}
+
 // TODO: Tool you can point at a failure log to annotate each failure
 // in the log with links to past instances of that failure. This just
 // uses log analysis.
@@ -87,7 +102,15 @@ func defaultRevDir() string {
 func main() {
 	var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 
+	cfg := config.NewFlags(flag.CommandLine, "findflakes", map[string]string{
+		"logs":      "dir",
+		"alert-url": "alert-url",
+		"fetch-cmd": "fetch-cmd",
+	})
 	flag.Parse()
+	if err := cfg.Apply(); err != nil {
+		log.Fatal(err)
+	}
 	if flag.NArg() > 0 {
 		flag.Usage()
 		os.Exit(2)
@@ -102,25 +125,14 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	allRevs, err := LoadRevisions(*flagRevDir)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Filter to revisions on this branch.
-	revs := []*Revision{}
-	for _, rev := range allRevs {
-		if rev.Branch == *flagBranch {
-			revs = append(revs, rev)
-		}
-	}
-	if len(revs) == 0 {
-		log.Fatal("no revisions found")
+	if *flagMonitor > 0 {
+		monitor(*flagMonitor)
+		return
 	}
 
-	// Limit to most recent N revisions.
-	if *flagLimit > 0 && len(revs) > *flagLimit {
-		revs = revs[len(revs)-*flagLimit:]
+	revs, err := loadBranchRevisions()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	if *flagGrep != "" {
@@ -153,13 +165,65 @@ func main() {
 		return
 	}
 
+	cache := openFailureCache(*flagCache)
+	classes := findFailureClasses(revs, cache)
+	if err := cache.Save(); err != nil {
+		log.Printf("saving failure cache: %v", err)
+	}
+
+	if *flagHTML {
+		printHTMLReport(os.Stdout, classes)
+	} else {
+		printTextReport(os.Stdout, classes)
+	}
+}
+
+// loadBranchRevisions loads the revisions on the branch being
+// analyzed, limited to the most recent -limit revisions.
+func loadBranchRevisions() ([]*Revision, error) {
+	allRevs, err := LoadRevisions(*flagRevDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter to revisions on this branch.
+	revs := []*Revision{}
+	for _, rev := range allRevs {
+		if rev.Branch == *flagBranch {
+			revs = append(revs, rev)
+		}
+	}
+	if len(revs) == 0 {
+		return nil, fmt.Errorf("no revisions found")
+	}
+
+	// Limit to most recent N revisions.
+	if *flagLimit > 0 && len(revs) > *flagLimit {
+		revs = revs[len(revs)-*flagLimit:]
+	}
+
+	return revs, nil
+}
+
+// findFailureClasses extracts and classifies the failures in revs
+// and returns the failure classes that are likely to still be
+// happening, sorted from most to least likely.
+func findFailureClasses(revs []*Revision, cache *failureCache) []*failureClass {
 	// Extract failures from logs.
-	failures := extractFailures(revs)
+	failures := extractFailures(revs, cache)
 
 	// Classify failures.
 	lfailures := make([]*loganal.Failure, len(failures))
 	for i, f := range failures {
 		lfailures[i] = f.Failure
+		if !*flagSubtests && strings.Contains(f.Test, "/") {
+			// Classify subtest failures with their
+			// top-level test. Copy the failure since it
+			// may be shared with the cache.
+			lf := *f.Failure
+			lf.Test = lf.TestAtDepth(1)
+			lfailures[i] = &lf
+		}
 	}
 	failureClasses := loganal.Classify(lfailures)
 
@@ -190,14 +254,10 @@ func main() {
 	// happening.
 	sort.Sort(sort.Reverse(currentSorter(classes)))
 
-	if *flagHTML {
-		printHTMLReport(os.Stdout, classes)
-	} else {
-		printTextReport(os.Stdout, classes)
-	}
+	return classes
 }
 
-func processFailureLogs(revs []*Revision, process func(build *Build, data []byte) []*failure) []*failure {
+func processFailureLogs(revs []*Revision, process func(build *Build) []*failure) []*failure {
 	// Create log processing tasks.
 	type Task struct {
 		t     int
@@ -229,12 +289,7 @@ func processFailureLogs(revs []*Revision, process func(build *Build, data []byte
 			for i := range todo {
 				task := tasks[i]
 
-				data, err := task.build.ReadLog()
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				failures := process(task.build, data)
+				failures := process(task.build)
 
 				// Fill build-related fields.
 				for _, failure := range failures {
@@ -258,13 +313,32 @@ func processFailureLogs(revs []*Revision, process func(build *Build, data []byte
 	return failures
 }
 
-func extractFailures(revs []*Revision) []*failure {
-	return processFailureLogs(revs, func(build *Build, data []byte) []*failure {
-		// TODO: OS/Arch
-		lfailures, err := loganal.Extract(string(data), "", "")
-		if err != nil {
-			log.Printf("%s: %v\n", build.LogPath(), err)
-			return nil
+// extractFailures extracts the failures from the failed builds in
+// revs. Logs that are already in cache are not re-parsed, and newly
+// parsed logs are added to cache.
+func extractFailures(revs []*Revision, cache *failureCache) []*failure {
+	return processFailureLogs(revs, func(build *Build) []*failure {
+		lfailures, ok := cache.Get(build)
+		if !ok {
+			// Skip unreadable logs rather than exiting, since
+			// this runs in the monitor loop.
+			f, err := build.OpenLog()
+			if err != nil {
+				log.Print(err)
+				return nil
+			}
+
+			// Some logs are hundreds of megabytes, so stream
+			// them rather than reading them into memory.
+			//
+			// TODO: OS/Arch
+			lfailures, err = loganal.ExtractReader(f, "", "")
+			f.Close()
+			if err != nil {
+				log.Printf("%s: %v\n", build.LogPath(), err)
+				return nil
+			}
+			cache.Put(build, lfailures)
 		}
 		if len(lfailures) == 0 {
 			return nil
@@ -295,7 +369,12 @@ func extractFailures(revs []*Revision) []*failure {
 }
 
 func grepFailures(revs []*Revision, re *regexp.Regexp) []*failure {
-	return processFailureLogs(revs, func(build *Build, data []byte) []*failure {
+	return processFailureLogs(revs, func(build *Build) []*failure {
+		data, err := build.ReadLog()
+		if err != nil {
+			log.Print(err)
+			return nil
+		}
 		if !re.Match(data) {
 			return nil
 		}
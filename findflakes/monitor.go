@@ -0,0 +1,182 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/aclements/go-misc/internal/loganal"
+)
+
+// An Alert reports a change in a failure class observed by monitor
+// mode. Alerts are delivered as JSON to -alert-url and -alert-cmd.
+type Alert struct {
+	// Kind is "new" if this failure class has never been seen
+	// before or "threshold" if the probability that this failure
+	// is still happening rose above -alert-threshold.
+	Kind string
+
+	// Class is the failure class, formatted as a string.
+	Class string
+
+	// Current is the probability that this failure is still
+	// happening.
+	Current float64
+
+	// FailureProbability is the estimated failure probability in
+	// the latest flaky region.
+	FailureProbability float64
+
+	// FirstObserved and LastObserved are the first and last
+	// revisions at which this failure was observed in the latest
+	// flaky region.
+	FirstObserved, LastObserved string
+
+	// Report is the text report for this failure class.
+	Report string
+}
+
+// monitorState tracks failure classes across monitor rounds.
+type monitorState struct {
+	threshold float64
+
+	// seen is the set of all failure classes ever reported.
+	seen map[loganal.Failure]bool
+
+	// current maps from failure class to the Current probability
+	// in the previous round.
+	current map[loganal.Failure]float64
+}
+
+// update records the failure classes from a new round and returns
+// the alerts triggered by the changes since the previous round. If
+// quiet is true, it only records the classes without alerting.
+func (m *monitorState) update(classes []*failureClass, quiet bool) []*Alert {
+	var alerts []*Alert
+	current := make(map[loganal.Failure]float64, len(classes))
+	for _, fc := range classes {
+		current[fc.Class] = fc.Current
+
+		kind := ""
+		if !m.seen[fc.Class] {
+			kind = "new"
+		} else if m.current[fc.Class] < m.threshold && fc.Current >= m.threshold {
+			kind = "threshold"
+		}
+		m.seen[fc.Class] = true
+		if kind == "" || quiet {
+			continue
+		}
+
+		var report bytes.Buffer
+		printTextFlakeReport(&report, fc)
+		alerts = append(alerts, &Alert{
+			Kind:               kind,
+			Class:              fc.Class.String(),
+			Current:            fc.Current,
+			FailureProbability: fc.Latest.FailureProbability,
+			FirstObserved:      fc.Revs[fc.Latest.First].OneLine(),
+			LastObserved:       fc.Revs[fc.Latest.Last].OneLine(),
+			Report:             report.String(),
+		})
+	}
+	m.current = current
+	return alerts
+}
+
+// monitor periodically fetches new logs, re-runs the analysis, and
+// fires alerts for new failure classes and failure classes that
+// crossed the alert threshold. The first round establishes a baseline
+// and does not fire any alerts. monitor never returns.
+func monitor(interval time.Duration) {
+	m := &monitorState{
+		threshold: *flagAlertThreshold,
+		seen:      make(map[loganal.Failure]bool),
+	}
+	cache := openFailureCache(*flagCache)
+
+	for round := 0; ; round++ {
+		if round > 0 {
+			time.Sleep(interval)
+		}
+
+		if *flagFetchCmd != "" {
+			if err := runShell(*flagFetchCmd, nil); err != nil {
+				log.Printf("fetching logs: %v", err)
+			}
+		}
+
+		revs, err := loadBranchRevisions()
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		classes := findFailureClasses(revs, cache)
+		if err := cache.Save(); err != nil {
+			log.Printf("saving failure cache: %v", err)
+		}
+
+		alerts := m.update(classes, round == 0)
+		if round == 0 {
+			log.Printf("monitoring %d failure classes", len(classes))
+		}
+		for _, a := range alerts {
+			if err := sendAlert(a); err != nil {
+				log.Printf("sending alert for %s: %v", a.Class, err)
+			}
+		}
+	}
+}
+
+// sendAlert prints a to stdout and delivers it to -alert-url and
+// -alert-cmd.
+func sendAlert(a *Alert) error {
+	fmt.Printf("%s (%s)\n%s\n", a.Class, a.Kind, a.Report)
+
+	js, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	if *flagAlertURL != "" {
+		resp, err := http.Post(*flagAlertURL, "application/json", bytes.NewReader(js))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("%s: %s", *flagAlertURL, resp.Status)
+		}
+	}
+
+	if *flagAlertCmd != "" {
+		if err := runShell(*flagAlertCmd, js); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runShell runs command using the shell with stdin as its standard
+// input.
+func runShell(command string, stdin []byte) error {
+	cmd := exec.Command("sh", "-c", command)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", command, err)
+	}
+	return nil
+}
@@ -44,9 +44,11 @@ func printTextFlakeReport(w io.Writer, fc *failureClass) {
 	} else {
 		fmt.Fprintf(w, "%s chance failure is still happening\n", pct(fc.Current))
 		fmt.Fprintf(w, "%s failure probability (%d of %d commits)\n", pct(fc.Latest.FailureProbability), fc.Latest.Failures, fc.Latest.Last-fc.Latest.First+1)
-		fmt.Fprintf(w, "Likely culprits:\n")
-		for _, c := range fc.Latest.Culprits(0.9, 10) {
-			fmt.Fprintf(w, "  %3d%% %s\n", round(100*c.P), fc.Revs[c.T].OneLine())
+		if cr := fc.CulpritRange(0.9, 10); cr != nil {
+			fmt.Fprintf(w, "Likely culprits (%s chance in %s..%s):\n", pct(cr.P), fc.Revs[cr.First].Revision[:7], fc.Revs[cr.Last].Revision[:7])
+			for _, c := range cr.Culprits {
+				fmt.Fprintf(w, "  %3d%% %s\n", round(100*c.P), fc.Revs[c.T].OneLine())
+			}
 		}
 	}
 
@@ -0,0 +1,274 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command gcbench runs GC stress workloads and reports GC pause and
+// utilization metrics in Go benchmark format [1].
+//
+// gcbench has the following workloads:
+//
+// PointerDense: a live heap of -heap MB of small objects that point
+// to each other at random, which the mutator continuously rewires by
+// replacing objects. This stresses marking. PointerDense runs with
+// GOGC=25 so that it completes GCs even in short runs.
+//
+// AllocRate: allocation of short-lived -size byte objects as fast as
+// possible with almost no live heap. This stresses sweeping and the
+// GC trigger.
+//
+// LargeStacks: -goroutines goroutines blocked -depth frames deep, with
+// pointers in each frame, while the mutator allocates garbage. This
+// stresses stack scanning.
+//
+// Assist: a mutator that builds up -heap MB of pointer-dense live
+// data as fast as possible, drops it, and starts over. Because the
+// heap grows as fast as the GC can mark it, this stresses mark
+// assists.
+//
+// Each workload runs on -procs goroutines for -benchtime and reports
+// ns/op, the 50th and 99th percentile and maximum GC pause, the
+// number of GCs, and the mutator utilization. The mutator utilization
+// is the fraction of CPU time not spent on GC according to the
+// /cpu/classes metrics of runtime/metrics. The runtime only updates
+// these at the end of each GC cycle, so this covers the run up to the
+// end of its last GC. A run that completes no GCs is an error. For
+// finer metrics, such as the minimum mutator utilization, use -trace
+// and analyze the traces with tracestat.
+//
+// gcbench requires the /cpu/classes metrics, which were added in Go
+// 1.20. To evaluate GC changes across a range of Go commits, run
+// benchmany in the gcbench directory:
+//
+//     benchmany -C $GOROOT -buildcmd "go build" \
+//         -benchflags "-benchtime 5s -count 1" go1.20..master
+//
+// [1] https://github.com/golang/proposal/blob/master/design/14313-benchmark-format.md
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"runtime/trace"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aclements/go-misc/internal/benchfmt"
+)
+
+var (
+	flagBench      = flag.String("bench", ".", "run workloads matching `regexp`")
+	flagBenchtime  = flag.Duration("benchtime", time.Second, "run each workload for `duration`")
+	flagCount      = flag.Int("count", 1, "run each workload `n` times")
+	flagProcs      = flag.Int("procs", runtime.GOMAXPROCS(0), "run each workload on `n` goroutines")
+	flagHeap       = flag.Int("heap", 64, "live heap `MB` for PointerDense and Assist")
+	flagSize       = flag.Int("size", 64, "object size in `bytes` for AllocRate")
+	flagGoroutines = flag.Int("goroutines", 1000, "number of deep goroutines for LargeStacks")
+	flagDepth      = flag.Int("depth", 1000, "stack `depth` of each goroutine for LargeStacks")
+	flagTrace      = flag.String("trace", "", "write an execution trace of each run to `directory`")
+)
+
+func main() {
+	log.SetPrefix("gcbench: ")
+	log.SetFlags(0)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 || *flagProcs < 1 || *flagCount < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	re, err := regexp.Compile(*flagBench)
+	if err != nil {
+		log.Fatalf("bad -bench: %v", err)
+	}
+	if *flagTrace != "" {
+		if err := os.MkdirAll(*flagTrace, 0777); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var bs []*benchfmt.Benchmark
+	for _, w := range workloads() {
+		if !re.MatchString(w.name) {
+			continue
+		}
+		for i := 0; i < *flagCount; i++ {
+			b, err := runWorkload(w, i)
+			if err != nil {
+				log.Fatal(err)
+			}
+			bs = append(bs, b)
+		}
+	}
+	if err := benchfmt.Print(bs); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// A workload is a parameterized GC stress workload.
+type workload struct {
+	name string
+
+	// config is the parameters of this workload, which are
+	// reported as part of the benchmark name.
+	config map[string]string
+
+	// setup prepares the workload and returns the function to
+	// run on each of -procs goroutines and a function to release
+	// the workload's resources. op runs the workload until stop
+	// is closed and returns the number of operations it did. Each
+	// call to op is passed a different id from 0 to -procs-1.
+	setup func() (op func(id int, stop <-chan struct{}) int, cleanup func())
+}
+
+// runWorkload runs the i'th run of w and returns its results.
+func runWorkload(w *workload, i int) (*benchfmt.Benchmark, error) {
+	op, cleanup := w.setup()
+	defer cleanup()
+
+	// Start from a clean heap so earlier runs don't affect this
+	// one.
+	runtime.GC()
+	debug.FreeOSMemory()
+
+	if *flagTrace != "" {
+		f, err := os.Create(filepath.Join(*flagTrace, fmt.Sprintf("%s.%d.trace", w.name, i)))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := trace.Start(f); err != nil {
+			return nil, err
+		}
+		defer trace.Stop()
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	cpuBefore, err := readCPUStats()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	stop := make(chan struct{})
+	ops := make([]int, *flagProcs)
+	var wg sync.WaitGroup
+	for id := range ops {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			ops[id] = op(id, stop)
+		}(id)
+	}
+	time.Sleep(*flagBenchtime)
+	close(stop)
+	wg.Wait()
+
+	end := time.Now()
+	runtime.ReadMemStats(&after)
+	cpuAfter, err := readCPUStats()
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, n := range ops {
+		total += n
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%s did no operations in %s", w.name, *flagBenchtime)
+	}
+	if after.NumGC == before.NumGC {
+		return nil, fmt.Errorf("%s completed no GCs in %s; increase -benchtime", w.name, *flagBenchtime)
+	}
+
+	b := &benchfmt.Benchmark{
+		Name:       w.name,
+		Iterations: total,
+		Config: map[string]*benchfmt.Config{
+			"gomaxprocs": {RawValue: fmt.Sprint(runtime.GOMAXPROCS(0)), InBlock: true},
+		},
+		Result: gcMetrics(&before, &after, cpuBefore, cpuAfter),
+	}
+	for k, v := range w.config {
+		b.Config[k] = &benchfmt.Config{RawValue: v}
+	}
+	b.Result["ns/op"] = float64(end.Sub(start).Nanoseconds()) / float64(total)
+	return b, nil
+}
+
+// cpuStats is a sample of the runtime's cumulative CPU time metrics,
+// in CPU seconds.
+type cpuStats struct {
+	gc, total float64
+}
+
+// readCPUStats reads the runtime's CPU time metrics. The runtime only
+// updates these at the end of each GC cycle.
+func readCPUStats() (cpuStats, error) {
+	samples := []metrics.Sample{
+		{Name: "/cpu/classes/gc/total:cpu-seconds"},
+		{Name: "/cpu/classes/total:cpu-seconds"},
+	}
+	metrics.Read(samples)
+	for _, s := range samples {
+		if s.Value.Kind() != metrics.KindFloat64 {
+			return cpuStats{}, fmt.Errorf("runtime does not support metric %s", s.Name)
+		}
+	}
+	return cpuStats{samples[0].Value.Float64(), samples[1].Value.Float64()}, nil
+}
+
+// gcMetrics returns the GC metrics between MemStats before and after
+// and CPU stats cpuBefore and cpuAfter.
+func gcMetrics(before, after *runtime.MemStats, cpuBefore, cpuAfter cpuStats) map[string]float64 {
+	m := make(map[string]float64)
+	gcs := after.NumGC - before.NumGC
+	m["GCs"] = float64(gcs)
+
+	// PauseNs is a circular buffer of the most recent pauses, so
+	// this only has the last len(PauseNs) pauses of a long run.
+	var pauses []float64
+	for n := after.NumGC; n > before.NumGC && len(pauses) < len(after.PauseNs); n-- {
+		pauses = append(pauses, float64(after.PauseNs[(n+uint32(len(after.PauseNs))-1)%uint32(len(after.PauseNs))]))
+	}
+	if len(pauses) > 0 {
+		sort.Float64s(pauses)
+		m["ns/p50-GC-pause"] = quantile(pauses, 0.5)
+		m["ns/p99-GC-pause"] = quantile(pauses, 0.99)
+		m["ns/max-GC-pause"] = pauses[len(pauses)-1]
+	}
+
+	// cpuBefore was read just after the GC at the start of the
+	// run, so this covers the run up to the end of its last GC.
+	if total := cpuAfter.total - cpuBefore.total; total > 0 {
+		util := 1 - (cpuAfter.gc-cpuBefore.gc)/total
+		m["mutator-util"] = math.Max(0, math.Min(1, util))
+	}
+	return m
+}
+
+// quantile returns the q'th quantile of sorted xs, rounded to the
+// nearest nanosecond.
+func quantile(xs []float64, q float64) float64 {
+	pos := q * float64(len(xs)-1)
+	i := int(pos)
+	if i+1 >= len(xs) {
+		return xs[len(xs)-1]
+	}
+	frac := pos - float64(i)
+	return math.Round(xs[i]*(1-frac) + xs[i+1]*frac)
+}
@@ -0,0 +1,200 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"unsafe"
+)
+
+// workloads returns the workloads with their parameters from flags.
+func workloads() []*workload {
+	heap := fmt.Sprintf("%dMB", *flagHeap)
+	return []*workload{
+		{"PointerDense", map[string]string{"heap": heap, "gogc": fmt.Sprint(pointerDenseGOGC)}, setupPointerDense},
+		{"AllocRate", map[string]string{"size": fmt.Sprintf("%dB", *flagSize)}, setupAllocRate},
+		{"LargeStacks", map[string]string{"goroutines": fmt.Sprint(*flagGoroutines), "depth": fmt.Sprint(*flagDepth)}, setupLargeStacks},
+		{"Assist", map[string]string{"heap": heap}, setupAssist},
+	}
+}
+
+// A node is a small, pointer-dense heap object.
+type node struct {
+	p [4]*node
+}
+
+// heapNodes returns the number of nodes in -heap MB.
+func heapNodes() int {
+	return *flagHeap << 20 / int(unsafe.Sizeof(node{}))
+}
+
+// pointerDenseGOGC is the GOGC setting for PointerDense. Marking a
+// large pointer-dense heap is slow, so with the default GOGC of 100,
+// PointerDense may not complete a GC in a short run. A low GOGC starts
+// GCs sooner, so the GC is marking most of the time.
+const pointerDenseGOGC = 25
+
+func setupPointerDense() (func(int, <-chan struct{}) int, func()) {
+	// Build a random graph of nodes. Each goroutine rewires its
+	// own part of the graph, so they don't race.
+	nodes := make([]*node, heapNodes())
+	for i := range nodes {
+		nodes[i] = new(node)
+	}
+	for _, n := range nodes {
+		for j := range n.p {
+			n.p[j] = nodes[rand.Intn(len(nodes))]
+		}
+	}
+	part := len(nodes) / *flagProcs
+
+	op := func(id int, stop <-chan struct{}) int {
+		mine := nodes[id*part : (id+1)*part]
+		if len(mine) == 0 {
+			return 0
+		}
+		// Replace nodes in runs of consecutive nodes. This
+		// allocates about twice as fast as replacing nodes
+		// at random, which is limited by cache misses, so the
+		// heap turns over quickly.
+		run := 100
+		if run > len(mine) {
+			run = len(mine)
+		}
+		r := rand.New(rand.NewSource(int64(id)))
+		ops := 0
+		for {
+			select {
+			case <-stop:
+				return ops
+			default:
+			}
+			for i := 0; i < 1000; i += run {
+				// Replace each node with a new node
+				// with the same edges but one, so the
+				// old node becomes garbage. Other
+				// nodes may still point to the old
+				// node, so garbage is only collected
+				// gradually.
+				start := r.Intn(len(mine) - run + 1)
+				for k := start; k < start+run; k++ {
+					n := new(node)
+					n.p = mine[k].p
+					n.p[k%len(n.p)] = mine[r.Intn(len(mine))]
+					mine[k] = n
+				}
+				ops += run
+			}
+		}
+	}
+	gogc := debug.SetGCPercent(pointerDenseGOGC)
+	cleanup := func() {
+		nodes = nil
+		debug.SetGCPercent(gogc)
+	}
+	return op, cleanup
+}
+
+func setupAllocRate() (func(int, <-chan struct{}) int, func()) {
+	size := *flagSize
+	op := func(id int, stop <-chan struct{}) int {
+		// Keep a few recent objects live so the compiler
+		// can't stack allocate them.
+		var recent [16][]byte
+		ops := 0
+		for {
+			select {
+			case <-stop:
+				return ops
+			default:
+			}
+			for i := 0; i < 1000; i++ {
+				recent[i%len(recent)] = make([]byte, size)
+			}
+			ops += 1000
+		}
+	}
+	return op, func() {}
+}
+
+func setupLargeStacks() (func(int, <-chan struct{}) int, func()) {
+	release := make(chan struct{})
+	target := new(node)
+	var wg sync.WaitGroup
+	for i := 0; i < *flagGoroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deepStack(*flagDepth, target, release)
+		}()
+	}
+
+	op := func(id int, stop <-chan struct{}) int {
+		var recent [16]*node
+		ops := 0
+		for {
+			select {
+			case <-stop:
+				return ops
+			default:
+			}
+			for i := 0; i < 1000; i++ {
+				recent[i%len(recent)] = new(node)
+			}
+			ops += 1000
+		}
+	}
+	cleanup := func() {
+		close(release)
+		wg.Wait()
+	}
+	return op, cleanup
+}
+
+// deepStack recurses depth frames, each of which has pointers to p
+// to scan, and then blocks until release is closed.
+//
+//go:noinline
+func deepStack(depth int, p *node, release <-chan struct{}) *node {
+	ptrs := [4]*node{p, p, p, p}
+	if depth <= 1 {
+		<-release
+	} else {
+		deepStack(depth-1, p, release)
+	}
+	// Use ptrs after the call so they're live during it.
+	return ptrs[depth%len(ptrs)]
+}
+
+func setupAssist() (func(int, <-chan struct{}) int, func()) {
+	limit := heapNodes() / *flagProcs
+	op := func(id int, stop <-chan struct{}) int {
+		var live []*node
+		ops := 0
+		for {
+			select {
+			case <-stop:
+				return ops
+			default:
+			}
+			for i := 0; i < 1000; i++ {
+				n := new(node)
+				if len(live) > 0 {
+					n.p[0] = live[len(live)-1]
+					n.p[1] = live[len(live)/2]
+				}
+				live = append(live, n)
+			}
+			ops += 1000
+			if len(live) >= limit {
+				live = nil
+			}
+		}
+	}
+	return op, func() {}
+}
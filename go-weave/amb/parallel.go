@@ -0,0 +1,442 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// StrategyParallel explores the ambiguous value space in depth-first
+// order like StrategyDFS, but divides the space among several worker
+// processes.
+//
+// Each execution of the application runs in a single process, so
+// applications can keep their state in global variables. Workers are
+// started by re-executing the current program with the same arguments
+// and an environment variable that tells Scheduler.Run to act as a
+// worker. The program must therefore reach the same Scheduler.Run
+// call deterministically. In a worker, Scheduler.Run does not return;
+// the process exits once there is no more work.
+//
+// The coordinator initially gives the whole space to one worker. When
+// a worker is idle, the coordinator steals work from a busy worker by
+// asking it to give away the unexplored sibling subtree closest to the
+// root of its own subtree.
+//
+// Workers don't share any state, so visited-state pruning (see
+// weave.Scheduler.StateHash) only prunes states that the same worker
+// has already visited. Workers can't be goroutines in one process
+// that share a visited set because the weave primitives and most
+// models keep their scheduler in global variables.
+//
+// StrategyParallel is only supported on systems where os/exec can
+// pass extra file descriptors to child processes.
+type StrategyParallel struct {
+	// MaxDepth specifies the maximum depth of the tree. If this
+	// is 0, it defaults to DefaultMaxDepth.
+	MaxDepth int
+
+	// Workers is the number of worker processes. If this is 0, it
+	// defaults to runtime.NumCPU().
+	Workers int
+}
+
+// Paths are always explored by a Scheduler in a worker process, so
+// the methods of StrategyParallel itself are never called.
+
+func (s *StrategyParallel) Reset() {}
+
+func (s *StrategyParallel) Amb(n int) (int, bool) {
+	panic("StrategyParallel.Amb called outside a worker")
+}
+
+func (s *StrategyParallel) Next() bool {
+	panic("StrategyParallel.Next called outside a worker")
+}
+
+// parallelEnv is the environment variable that identifies a worker
+// process. Its value is "run/worker", where run is the index of the
+// Scheduler.Run call the worker serves.
+const parallelEnv = "AMB_PARALLEL_WORKER"
+
+// runIndex counts calls to Scheduler.Run with a StrategyParallel.
+var runIndex int
+
+// runParallel runs root under StrategyParallel, either as the
+// coordinator or as a worker.
+func (s *Scheduler) runParallel(p *StrategyParallel, root func()) {
+	run := runIndex
+	runIndex++
+
+	if env := os.Getenv(parallelEnv); env != "" {
+		var wrun, worker int
+		if _, err := fmt.Sscanf(env, "%d/%d", &wrun, &worker); err != nil {
+			log.Fatalf("bad %s: %q", parallelEnv, env)
+		}
+		if run < wrun {
+			// The coordinator is at a later Run call.
+			return
+		}
+		s.parallelWorker(p, worker, root)
+		os.Exit(0)
+	}
+	s.parallelCoordinator(p, run)
+}
+
+// subtree is the Strategy used by workers. It explores the subtree
+// under a fixed prefix in depth-first order.
+type subtree struct {
+	maxDepth int
+	// base is the length of the fixed prefix.
+	base int
+	// widths and path are as in StrategyDFS. limit[i] is the
+	// upper bound on path[i] still assigned to this worker.
+	widths, path, limit []int
+	step                int
+}
+
+func newSubtree(maxDepth int, prefix []Decision) *subtree {
+	t := &subtree{maxDepth: maxDepth, base: len(prefix)}
+	for _, d := range prefix {
+		t.widths = append(t.widths, d.Width)
+		t.path = append(t.path, d.Choice)
+		t.limit = append(t.limit, d.Choice+1)
+	}
+	return t
+}
+
+func (t *subtree) Reset() {
+	t.step = 0
+}
+
+func (t *subtree) Amb(n int) (int, bool) {
+	if t.step < len(t.path) {
+		if n != t.widths[t.step] {
+			panic(&ErrNondeterminism{fmt.Sprintf("Amb(%d) during replay, but previous call was Amb(%d)", n, t.widths[t.step])})
+		}
+		res := t.path[t.step]
+		t.step++
+		return res, true
+	}
+
+	if len(t.path) == t.maxDepth {
+		return 0, false
+	}
+
+	t.widths = append(t.widths, n)
+	t.path = append(t.path, 0)
+	t.limit = append(t.limit, n)
+	t.step++
+	return 0, true
+}
+
+func (t *subtree) Next() bool {
+	t.step = 0
+	for len(t.path) > t.base {
+		i := len(t.path) - 1
+		t.path[i]++
+		if t.path[i] < t.limit[i] {
+			return true
+		}
+		t.path, t.widths, t.limit = t.path[:i], t.widths[:i], t.limit[:i]
+	}
+	return false
+}
+
+// split gives away the unexplored subtree closest to the root and
+// returns its prefix, or nil if there is nothing to give away. It must
+// be called between paths.
+func (t *subtree) split() []Decision {
+	for i := t.base; i < len(t.path); i++ {
+		if t.path[i]+1 < t.limit[i] {
+			t.limit[i]--
+			var prefix []Decision
+			for j := 0; j < i; j++ {
+				prefix = append(prefix, Decision{t.path[j], t.widths[j]})
+			}
+			return append(prefix, Decision{t.limit[i], t.widths[i]})
+		}
+	}
+	return nil
+}
+
+func formatPrefix(prefix []Decision) string {
+	var parts []string
+	for _, d := range prefix {
+		parts = append(parts, fmt.Sprintf("%d/%d", d.Choice, d.Width))
+	}
+	return strings.Join(parts, " ")
+}
+
+func parsePrefix(fields []string) ([]Decision, error) {
+	var prefix []Decision
+	for _, f := range fields {
+		var d Decision
+		if _, err := fmt.Sscanf(f, "%d/%d", &d.Choice, &d.Width); err != nil {
+			return nil, fmt.Errorf("bad decision %q", f)
+		}
+		prefix = append(prefix, d)
+	}
+	return prefix, nil
+}
+
+// The coordinator and workers communicate with line-oriented messages.
+// The coordinator sends:
+//
+//     explore <prefix>  explore the subtree under prefix
+//     split             give away part of the current subtree
+//
+// Workers send:
+//
+//     count <n>         n more paths have been explored
+//     done              the subtree is done; the worker is idle
+//     work <prefix>     reply to split with a subtree to explore
+//     nowork            reply to split with nothing to give away
+//
+// The coordinator closes the worker's input when there is no more
+// work.
+
+// parallelWorker runs root as worker number worker.
+func (s *Scheduler) parallelWorker(p *StrategyParallel, worker int, root func()) {
+	in, out := os.NewFile(3, "coordinator-in"), os.NewFile(4, "coordinator-out")
+	if s.TraceFile != "" {
+		s.TraceFile = fmt.Sprintf("%s.%d", s.TraceFile, worker)
+	}
+	maxDepth := p.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+
+	msgs := make(chan []string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			msgs <- strings.Fields(scanner.Text())
+		}
+		close(msgs)
+	}()
+
+	w := bufio.NewWriter(out)
+	send := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, format+"\n", args...)
+		if err := w.Flush(); err != nil {
+			log.Fatalf("worker %d: %v", worker, err)
+		}
+	}
+
+	// handle handles a message other than explore while exploring t.
+	handle := func(t *subtree, msg []string) {
+		if msg[0] != "split" {
+			log.Fatalf("worker %d: unexpected message %q", worker, msg)
+		}
+		var prefix []Decision
+		if t != nil {
+			prefix = t.split()
+		}
+		if prefix == nil {
+			send("nowork")
+		} else {
+			send("work %s", formatPrefix(prefix))
+		}
+	}
+
+	for msg := range msgs {
+		if msg[0] != "explore" {
+			handle(nil, msg)
+			continue
+		}
+		prefix, err := parsePrefix(msg[1:])
+		if err != nil {
+			log.Fatalf("worker %d: %v", worker, err)
+		}
+		t := newSubtree(maxDepth, prefix)
+		s.Strategy = t
+		n := 0
+		last := time.Now()
+		for {
+			s.run1(root)
+			n++
+			if !t.Next() {
+				break
+			}
+			if now := time.Now(); now.Sub(last) > 100*time.Millisecond {
+				send("count %d", n)
+				n, last = 0, now
+			}
+		poll:
+			for {
+				select {
+				case msg, ok := <-msgs:
+					if !ok {
+						return
+					}
+					handle(t, msg)
+				default:
+					break poll
+				}
+			}
+		}
+		send("count %d", n)
+		send("done")
+	}
+}
+
+type workerProc struct {
+	id   int
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+	busy bool
+	// splitting indicates a split request is outstanding.
+	splitting bool
+}
+
+type workerMsg struct {
+	w   *workerProc
+	msg []string
+	err error
+}
+
+// parallelCoordinator starts workers for Run call run and distributes
+// the space among them.
+func (s *Scheduler) parallelCoordinator(p *StrategyParallel, run int) {
+	n := p.Workers
+	if n == 0 {
+		n = runtime.NumCPU()
+	}
+
+	msgs := make(chan workerMsg)
+	var workers []*workerProc
+	for i := 0; i < n; i++ {
+		wp, err := startWorker(run, i, msgs)
+		if err != nil {
+			log.Fatalf("starting worker: %v", err)
+		}
+		workers = append(workers, wp)
+	}
+
+	queue := [][]Decision{nil}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		// Hand out queued work to idle workers.
+		idle := 0
+		for _, wp := range workers {
+			if !wp.busy && len(queue) > 0 {
+				fmt.Fprintf(wp.in, "explore %s\n", formatPrefix(queue[0]))
+				queue = queue[1:]
+				wp.busy = true
+			}
+			if !wp.busy {
+				idle++
+			}
+		}
+		if idle == len(workers) && len(queue) == 0 {
+			break
+		}
+
+		select {
+		case m := <-msgs:
+			if m.err != nil {
+				log.Fatalf("worker %d: %v", m.w.id, m.err)
+			}
+			switch m.msg[0] {
+			case "count":
+				c, _ := strconv.ParseInt(m.msg[1], 10, 64)
+				atomic.AddInt64(&count, c)
+			case "done":
+				m.w.busy = false
+			case "work":
+				m.w.splitting = false
+				prefix, err := parsePrefix(m.msg[1:])
+				if err != nil {
+					log.Fatalf("worker %d: %v", m.w.id, err)
+				}
+				queue = append(queue, prefix)
+			case "nowork":
+				m.w.splitting = false
+			default:
+				log.Fatalf("worker %d: unexpected message %q", m.w.id, m.msg)
+			}
+		case <-ticker.C:
+			// Steal work for idle workers.
+			if idle == 0 || len(queue) > 0 {
+				break
+			}
+			for _, wp := range workers {
+				if idle == 0 {
+					break
+				}
+				if wp.busy && !wp.splitting {
+					fmt.Fprintf(wp.in, "split\n")
+					wp.splitting = true
+					idle--
+				}
+			}
+		}
+	}
+
+	for _, wp := range workers {
+		wp.in.Close()
+	}
+	for range workers {
+		// Drain messages until every worker exits.
+		for m := range msgs {
+			if m.err == io.EOF {
+				break
+			}
+		}
+	}
+	for _, wp := range workers {
+		if err := wp.cmd.Wait(); err != nil {
+			log.Fatalf("worker %d: %v", wp.id, err)
+		}
+	}
+}
+
+func startWorker(run, id int, msgs chan<- workerMsg) (*workerProc, error) {
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d/%d", parallelEnv, run, id))
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{inR, outW}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	inR.Close()
+	outW.Close()
+
+	wp := &workerProc{id: id, cmd: cmd, in: inW}
+	go func() {
+		scanner := bufio.NewScanner(outR)
+		for scanner.Scan() {
+			if msg := strings.Fields(scanner.Text()); len(msg) > 0 {
+				msgs <- workerMsg{w: wp, msg: msg}
+			}
+		}
+		err := scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		msgs <- workerMsg{w: wp, err: err}
+	}()
+	return wp, nil
+}
@@ -28,32 +28,27 @@ func startProgress() {
 	progress.stop = make(chan struct{})
 	progress.done = make(chan struct{})
 
-	go func() {
-		// Redirect process stdout and stderr.
-		//
-		// Alternatively, we could dup our pipes over stdout
-		// and stderr, but then we're in the way of any
-		// runtime debug output.
-		origStdout, origStderr := os.Stdout, os.Stderr
-		newStdoutR, newStdoutW, err := os.Pipe()
-		if err != nil {
-			log.Fatalf("failed to create stdout self-pipe: %v", err)
-		}
-		newStderrR, newStderrW, err := os.Pipe()
-		if err != nil {
-			log.Fatalf("failed to create stderr self-pipe: %v", err)
-		}
-
-		defer func() {
-			os.Stdout, os.Stderr = origStdout, origStderr
-			// Stop the feeder. It will close the write sides.
-			newStdoutR.Close()
-			newStderrR.Close()
-		}()
-		os.Stdout, os.Stderr = newStdoutW, newStderrW
-		go pipeFeeder(newStdoutR, origStdout, origStdout)
-		go pipeFeeder(newStderrR, origStderr, origStderr)
+	// Redirect process stdout and stderr.
+	//
+	// Alternatively, we could dup our pipes over stdout
+	// and stderr, but then we're in the way of any
+	// runtime debug output.
+	origStdout, origStderr := os.Stdout, os.Stderr
+	newStdoutR, newStdoutW, err := os.Pipe()
+	if err != nil {
+		log.Fatalf("failed to create stdout self-pipe: %v", err)
+	}
+	newStderrR, newStderrW, err := os.Pipe()
+	if err != nil {
+		log.Fatalf("failed to create stderr self-pipe: %v", err)
+	}
+	os.Stdout, os.Stderr = newStdoutW, newStderrW
+	var feeders sync.WaitGroup
+	feeders.Add(2)
+	go pipeFeeder(newStdoutR, origStdout, origStderr, &feeders)
+	go pipeFeeder(newStderrR, origStderr, origStderr, &feeders)
 
+	go func() {
 		report := func(final bool) {
 			progress.printLock.Lock()
 			fmt.Fprintf(origStderr, "%s%d done", resetLine, atomic.LoadInt64(&count))
@@ -70,16 +65,27 @@ func startProgress() {
 			select {
 			case <-ticker.C:
 			case <-progress.stop:
-				report(true)
 				break loop
 			}
 		}
 		ticker.Stop()
+
+		// Restore stdout and stderr and let the feeders drain
+		// anything still in the pipes before the final report.
+		os.Stdout, os.Stderr = origStdout, origStderr
+		newStdoutW.Close()
+		newStderrW.Close()
+		feeders.Wait()
+		report(true)
 		close(progress.done)
 	}()
 }
 
-func pipeFeeder(r, w, pstream *os.File) {
+// pipeFeeder copies r to w until r reaches EOF, pausing progress
+// reporting to pstream while a line is being printed.
+func pipeFeeder(r, w, pstream *os.File, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer r.Close()
 	var buf [256]byte
 	bol := true
 	for {
@@ -104,7 +110,9 @@ func pipeFeeder(r, w, pstream *os.File) {
 			bol = true
 		}
 	}
-	w.Close()
+	if !bol {
+		progress.printLock.Unlock()
+	}
 }
 
 func stopProgress() {
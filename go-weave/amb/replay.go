@@ -0,0 +1,113 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A Decision records the result of a single call to Amb.
+type Decision struct {
+	// Choice is the value returned by Amb.
+	Choice int
+	// Width is the argument to Amb.
+	Width int
+}
+
+const traceHeader = "amb trace v1"
+
+// WriteTrace writes path to w in the format read by ReadTrace.
+//
+// The format is a header line followed by one line per decision of
+// the form "choice/width".
+func WriteTrace(w io.Writer, path []Decision) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, traceHeader)
+	for _, d := range path {
+		fmt.Fprintf(bw, "%d/%d\n", d.Choice, d.Width)
+	}
+	return bw.Flush()
+}
+
+// ReadTrace reads a path written by WriteTrace.
+func ReadTrace(r io.Reader) ([]Decision, error) {
+	var path []Decision
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || scanner.Text() != traceHeader {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("not an amb trace")
+	}
+	for lineno := 2; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var d Decision
+		if _, err := fmt.Sscanf(line, "%d/%d", &d.Choice, &d.Width); err != nil || d.Choice < 0 || d.Choice >= d.Width {
+			return nil, fmt.Errorf("line %d: bad decision %q", lineno, line)
+		}
+		path = append(path, d)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// StrategyReplay executes a single, previously recorded path. It is
+// typically constructed from a trace file written by
+// Scheduler.TraceFile to reproduce a failure.
+//
+// When a Scheduler runs a StrategyReplay, it doesn't print progress
+// or recover panics, so a failure crashes the program with a full
+// stack trace at the point of failure, where it can be examined with
+// a debugger.
+type StrategyReplay struct {
+	// Path is the sequence of decisions to replay.
+	Path []Decision
+
+	step int
+}
+
+// ReadTraceFile returns a StrategyReplay that replays the trace in
+// file name.
+func ReadTraceFile(name string) (*StrategyReplay, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	path, err := ReadTrace(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return &StrategyReplay{Path: path}, nil
+}
+
+func (s *StrategyReplay) Reset() {
+	s.step = 0
+}
+
+func (s *StrategyReplay) Amb(n int) (int, bool) {
+	if s.step == len(s.Path) {
+		return 0, false
+	}
+	d := s.Path[s.step]
+	if n != d.Width {
+		panic(&ErrNondeterminism{fmt.Sprintf("Amb(%d) during replay, but recorded call was Amb(%d)", n, d.Width)})
+	}
+	s.step++
+	return d.Choice, true
+}
+
+func (s *StrategyReplay) Next() bool {
+	return false
+}
@@ -53,7 +53,17 @@ type Scheduler struct {
 	// space.
 	Strategy Strategy
 
+	// TraceFile, if non-empty, is the file to write the decision
+	// sequence of the first failing path to. This can be replayed
+	// using ReadTraceFile.
+	TraceFile string
+
 	active bool
+
+	// path is the sequence of decisions on the current path.
+	path []Decision
+	// traced indicates a failure has been written to TraceFile.
+	traced bool
 }
 
 var curStrategy Strategy
@@ -68,9 +78,19 @@ func (s *Scheduler) Run(root func()) {
 	s.active = true
 	defer func() { s.active = false }()
 
+	_, replay := s.Strategy.(*StrategyReplay)
+	p, parallel := s.Strategy.(*StrategyParallel)
 	count = 0
-	startProgress()
-	defer stopProgress()
+	if !replay && !(parallel && os.Getenv(parallelEnv) != "") {
+		startProgress()
+		defer stopProgress()
+	}
+	s.traced = false
+
+	if parallel {
+		s.runParallel(p, root)
+		return
+	}
 
 	s.Strategy.Reset()
 	for {
@@ -84,11 +104,20 @@ func (s *Scheduler) Run(root func()) {
 }
 
 func (s *Scheduler) run1(root func()) {
+	s.path = s.path[:0]
+	if _, ok := s.Strategy.(*StrategyReplay); ok {
+		// Let failures crash so they can be debugged.
+		root()
+		return
+	}
 	defer func() {
 		err := recover()
 		if err != nil {
-			// TODO: Report path.
 			fmt.Println("failure:", err)
+			if s.TraceFile != "" && !s.traced {
+				s.traced = true
+				s.writeTrace()
+			}
 			var buf []byte
 			for i := 1 << 10; i < 1<<20; i *= 2 {
 				buf = make([]byte, i)
@@ -115,9 +144,26 @@ func (s *Scheduler) Amb(n int) int {
 	if !ok {
 		panic(PathTerminated)
 	}
+	s.path = append(s.path, Decision{x, n})
 	return x
 }
 
+// writeTrace writes the current path to s.TraceFile.
+func (s *Scheduler) writeTrace() {
+	f, err := os.Create(s.TraceFile)
+	if err == nil {
+		err = WriteTrace(f, s.path)
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+	}
+	if err != nil {
+		fmt.Println("failed to write trace:", err)
+		return
+	}
+	fmt.Printf("trace written to %s\n", s.TraceFile)
+}
+
 // PathTerminated is panicked by Scheduler.Amb to indicate that Run
 // should continue to the next path.
 var PathTerminated = errors.New("path terminated")
@@ -9,6 +9,7 @@ type AtomicInt32 struct {
 }
 
 func (a *AtomicInt32) Add(delta int32) (new int32) {
+	globalSched.Access(a, true)
 	a.v += delta
 	new = a.v
 	globalSched.Sched()
@@ -16,6 +17,7 @@ func (a *AtomicInt32) Add(delta int32) (new int32) {
 }
 
 func (a *AtomicInt32) CompareAndSwap(old, new int32) (swapped bool) {
+	globalSched.Access(a, true)
 	swapped = a.v == old
 	if swapped {
 		a.v = new
@@ -25,17 +27,20 @@ func (a *AtomicInt32) CompareAndSwap(old, new int32) (swapped bool) {
 }
 
 func (a *AtomicInt32) Load() int32 {
+	globalSched.Access(a, false)
 	v := a.v
 	globalSched.Sched()
 	return v
 }
 
 func (a *AtomicInt32) Store(val int32) {
+	globalSched.Access(a, true)
 	a.v = val
 	globalSched.Sched()
 }
 
 func (a *AtomicInt32) Swap(new int32) (old int32) {
+	globalSched.Access(a, true)
 	old, a.v = a.v, new
 	globalSched.Sched()
 	return
@@ -0,0 +1,286 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package weave
+
+// Chan is a channel with the semantics of a Go channel. Like Go
+// channels, Chans must be created with NewChan.
+type Chan struct {
+	cap    int
+	buf    []interface{}
+	closed bool
+
+	// recvq and sendq are the threads blocked receiving from and
+	// sending to this channel, in FIFO order. Waiters whose
+	// select has already fired are removed lazily.
+	recvq, sendq []*chanWaiter
+}
+
+// chanWaiter is a thread blocked on one case of a select.
+type chanWaiter struct {
+	sel *selectWait
+	cas int
+	// val is the value to send or the received value.
+	val interface{}
+	ok  bool
+}
+
+// selectWait is the state of a blocked select, shared by all of its
+// cases.
+type selectWait struct {
+	thr *thread
+	// fired indicates that case cas completed.
+	fired bool
+	cas   int
+	// closed indicates that cas was a send on a channel that
+	// was closed.
+	closed bool
+}
+
+// NewChan returns a new channel with buffer capacity cap.
+func NewChan(cap int) *Chan {
+	return &Chan{cap: cap}
+}
+
+// Send sends v on c.
+func (c *Chan) Send(v interface{}) {
+	Select(SelectCase{Dir: SelectSend, Chan: c, Send: v})
+}
+
+// Recv receives a value from c. ok is false if c is closed and
+// empty.
+func (c *Chan) Recv() (v interface{}, ok bool) {
+	_, v, ok = Select(SelectCase{Dir: SelectRecv, Chan: c})
+	return
+}
+
+// Len returns the number of buffered values in c.
+func (c *Chan) Len() int {
+	globalSched.Access(c, false)
+	return len(c.buf)
+}
+
+// Close closes c.
+func (c *Chan) Close() {
+	globalSched.Access(c, true)
+	if c.closed {
+		panic("close of closed channel")
+	}
+	c.closed = true
+	for _, w := range c.recvq {
+		if !w.sel.fired {
+			w.val, w.ok = nil, false
+			w.fire()
+		}
+	}
+	for _, w := range c.sendq {
+		if !w.sel.fired {
+			w.sel.closed = true
+			w.fire()
+		}
+	}
+	c.recvq, c.sendq = nil, nil
+	globalSched.Sched()
+}
+
+// SelectDir is the direction of a select case.
+type SelectDir int
+
+const (
+	_ SelectDir = iota
+	SelectSend
+	SelectRecv
+	SelectDefault
+)
+
+// SelectCase is a case of a select statement. It's analogous to
+// reflect.SelectCase.
+type SelectCase struct {
+	Dir SelectDir
+	// Chan is the channel to send on or receive from. If it's
+	// nil, the case is never ready, like a nil Go channel.
+	Chan *Chan
+	// Send is the value to send for a SelectSend case.
+	Send interface{}
+}
+
+// Select executes a select statement. Like a Go select statement, if
+// multiple cases are ready, it chooses one of them ambiguously. If no
+// cases are ready, it runs the SelectDefault case if there is one, or
+// otherwise blocks until a case is ready. It returns the index of the
+// chosen case and, if it is a SelectRecv case, the received value and
+// whether the value was sent (rather than received from a closed
+// channel).
+func Select(cases ...SelectCase) (chosen int, recv interface{}, recvOK bool) {
+	for _, cas := range cases {
+		if cas.Chan != nil {
+			globalSched.Access(cas.Chan, true)
+		}
+	}
+
+	// Collect the ready cases.
+	var ready []int
+	dflt := -1
+	for i, cas := range cases {
+		switch cas.Dir {
+		case SelectDefault:
+			if dflt >= 0 {
+				panic("multiple defaults in select")
+			}
+			dflt = i
+		case SelectSend:
+			if cas.Chan != nil && cas.Chan.canSend() {
+				ready = append(ready, i)
+			}
+		case SelectRecv:
+			if cas.Chan != nil && cas.Chan.canRecv() {
+				ready = append(ready, i)
+			}
+		default:
+			panic("bad select case direction")
+		}
+	}
+
+	if len(ready) > 0 {
+		chosen = ready[0]
+		if len(ready) > 1 {
+			chosen = ready[globalSched.Amb(len(ready))]
+		}
+		cas := cases[chosen]
+		if cas.Dir == SelectSend {
+			cas.Chan.send(cas.Send)
+		} else {
+			recv, recvOK = cas.Chan.recv()
+		}
+		globalSched.Sched()
+		return
+	}
+	if dflt >= 0 {
+		globalSched.Sched()
+		return dflt, nil, false
+	}
+
+	// Block on all of the cases.
+	this := globalSched.curThread
+	sel := &selectWait{thr: this}
+	waiters := make([]*chanWaiter, len(cases))
+	for i, cas := range cases {
+		if cas.Chan == nil {
+			continue
+		}
+		w := &chanWaiter{sel: sel, cas: i, val: cas.Send}
+		waiters[i] = w
+		if cas.Dir == SelectSend {
+			cas.Chan.sendq = append(cas.Chan.sendq, w)
+		} else {
+			cas.Chan.recvq = append(cas.Chan.recvq, w)
+		}
+	}
+	what := "select"
+	if len(cases) == 1 && cases[0].Dir == SelectSend {
+		what = "chan send"
+	} else if len(cases) == 1 {
+		what = "chan receive"
+	}
+	this.block(what, func() {
+		for _, cas := range cases {
+			if cas.Chan != nil {
+				cas.Chan.reset()
+			}
+		}
+	})
+	for _, cas := range cases {
+		if cas.Chan != nil {
+			globalSched.Access(cas.Chan, true)
+		}
+	}
+
+	chosen = sel.cas
+	if sel.closed {
+		panic("send on closed channel")
+	}
+	if cases[chosen].Dir == SelectRecv {
+		recv, recvOK = waiters[chosen].val, waiters[chosen].ok
+	}
+	return
+}
+
+// fire completes w's select and wakes its thread.
+func (w *chanWaiter) fire() {
+	w.sel.fired = true
+	w.sel.cas = w.cas
+	w.sel.thr.unblock()
+}
+
+// dequeue removes and returns the first waiter in q whose select has
+// not fired, or nil.
+func dequeue(q *[]*chanWaiter) *chanWaiter {
+	for len(*q) > 0 {
+		w := (*q)[0]
+		*q = (*q)[1:]
+		if !w.sel.fired {
+			return w
+		}
+	}
+	return nil
+}
+
+// peek reports whether q has a waiter whose select has not fired.
+func peek(q []*chanWaiter) bool {
+	for _, w := range q {
+		if !w.sel.fired {
+			return true
+		}
+	}
+	return false
+}
+
+// canSend reports whether a send on c can proceed without blocking.
+// A send on a closed channel proceeds (and panics).
+func (c *Chan) canSend() bool {
+	return c.closed || len(c.buf) < c.cap || peek(c.recvq)
+}
+
+// canRecv reports whether a receive from c can proceed without
+// blocking.
+func (c *Chan) canRecv() bool {
+	return c.closed || len(c.buf) > 0 || peek(c.sendq)
+}
+
+func (c *Chan) send(v interface{}) {
+	if c.closed {
+		panic("send on closed channel")
+	}
+	if w := dequeue(&c.recvq); w != nil {
+		// Hand the value directly to a blocked receiver.
+		w.val, w.ok = v, true
+		w.fire()
+		return
+	}
+	c.buf = append(c.buf, v)
+}
+
+func (c *Chan) recv() (interface{}, bool) {
+	if len(c.buf) > 0 {
+		v := c.buf[0]
+		c.buf = c.buf[1:]
+		// Move a blocked sender's value into the buffer.
+		if w := dequeue(&c.sendq); w != nil {
+			c.buf = append(c.buf, w.val)
+			w.fire()
+		}
+		return v, true
+	}
+	if w := dequeue(&c.sendq); w != nil {
+		// Take the value directly from a blocked sender.
+		w.fire()
+		return w.val, true
+	}
+	// c is closed.
+	return nil, false
+}
+
+func (c *Chan) reset() {
+	*c = Chan{cap: c.cap}
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package weave
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"os"
+
+	"github.com/aclements/go-misc/go-weave/amb"
+)
+
+// Diagram layout, in pixels.
+const (
+	diagLaneWidth = 240
+	diagRowHeight = 22
+	diagTop       = 40
+	diagMargin    = 20
+)
+
+// laneState is the state of a thread's lane in the diagram.
+type laneState uint8
+
+const (
+	laneNone laneState = iota // Not created yet or exited
+	laneRunnable
+	laneRunning
+	laneBlocked
+)
+
+// writeDiagram writes the diagram of the current execution, which
+// failed with err, to s.DiagramFile, if this is the first failure.
+func (s *Scheduler) writeDiagram(err interface{}) {
+	if s.DiagramFile == "" || s.diagrammed || err == amb.PathTerminated {
+		return
+	}
+	s.diagrammed = true
+
+	f, ferr := os.Create(s.DiagramFile)
+	if ferr == nil {
+		ferr = writeDiagram(f, err, s.trace)
+		if ferr1 := f.Close(); ferr == nil {
+			ferr = ferr1
+		}
+	}
+	if ferr != nil {
+		fmt.Println("failed to write diagram:", ferr)
+		return
+	}
+	fmt.Printf("diagram written to %s\n", s.DiagramFile)
+}
+
+// writeDiagram writes an HTML page to w containing an SVG sequence
+// diagram of trace, with one lane per thread and one row per entry.
+func writeDiagram(w io.Writer, err interface{}, trace []traceEntry) error {
+	nlanes := 1
+	for _, ent := range trace {
+		for _, tid := range []int{ent.tid, ent.other} {
+			if tid >= nlanes {
+				nlanes = tid + 1
+			}
+		}
+	}
+	laneX := func(tid int) int {
+		return diagMargin + diagLaneWidth*tid + diagLaneWidth/4
+	}
+	width := 2*diagMargin + diagLaneWidth*nlanes
+	height := diagTop + diagRowHeight*len(trace) + diagMargin
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>weave schedule</title>
+<style>
+body { font-family: sans-serif; }
+pre.err { color: #c00; }
+svg text { font-family: monospace; font-size: 12px; }
+</style>
+</head>
+<body>
+<pre class="err">%s</pre>
+<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">
+<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto"><path d="M0,0 L10,5 L0,10 z"/></marker></defs>
+`, html.EscapeString(fmt.Sprint(err)), width, height)
+
+	for tid := 0; tid < nlanes; tid++ {
+		fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\" font-weight=\"bold\">T%d</text>\n", laneX(tid), diagTop-16, tid)
+	}
+
+	lanes := make([]laneState, nlanes)
+	lanes[0] = laneRunnable
+	running := -1
+	for row, ent := range trace {
+		// Update the lane states.
+		switch ent.kind {
+		case traceRun:
+			if running >= 0 && lanes[running] == laneRunning {
+				lanes[running] = laneRunnable
+			}
+			running = ent.tid
+			lanes[ent.tid] = laneRunning
+		case traceBlock:
+			lanes[ent.tid] = laneBlocked
+		case traceUnblock, traceGo:
+			lanes[ent.other] = laneRunnable
+		case traceExit:
+			lanes[ent.tid] = laneNone
+		}
+
+		// Draw the lanes.
+		y0 := diagTop + diagRowHeight*row
+		y1, ymid := y0+diagRowHeight, y0+diagRowHeight/2
+		for tid, state := range lanes {
+			style := ""
+			switch state {
+			case laneRunnable:
+				style = `stroke="#bbb" stroke-width="2"`
+			case laneRunning:
+				style = `stroke="#4a7" stroke-width="8"`
+			case laneBlocked:
+				style = `stroke="#d66" stroke-width="2" stroke-dasharray="4,3"`
+			default:
+				continue
+			}
+			fmt.Fprintf(bw, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" %s/>\n", laneX(tid), y0, laneX(tid), y1, style)
+		}
+
+		// Draw the entry.
+		label, color := "", "#000"
+		switch ent.kind {
+		case traceMsg:
+			label = ent.msg
+		case traceBlock:
+			label, color = ent.msg, "#c33"
+		case traceUnblock, traceGo:
+			if ent.kind == traceUnblock {
+				label = fmt.Sprintf("wake T%d", ent.other)
+			} else {
+				label = fmt.Sprintf("go T%d", ent.other)
+			}
+			fmt.Fprintf(bw, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#000\" marker-end=\"url(#arrow)\"/>\n", laneX(ent.tid), ymid, laneX(ent.other), ymid)
+		case traceExit:
+			label = "exit"
+			fmt.Fprintf(bw, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#000\" stroke-width=\"2\"/>\n", laneX(ent.tid)-6, ymid, laneX(ent.tid)+6, ymid)
+		case traceFail:
+			if ent.tid < 0 {
+				// The whole execution failed.
+				fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" fill=\"#c00\" font-weight=\"bold\">%s</text>\n", diagMargin, ymid+4, html.EscapeString(ent.msg))
+				continue
+			}
+			label, color = "FAIL: "+ent.msg, "#c00"
+			fmt.Fprintf(bw, "<circle cx=\"%d\" cy=\"%d\" r=\"6\" fill=\"#c00\"/>\n", laneX(ent.tid), ymid)
+		}
+		if label == "" {
+			continue
+		}
+		if ent.kind == traceMsg || ent.kind == traceBlock {
+			fmt.Fprintf(bw, "<circle cx=\"%d\" cy=\"%d\" r=\"3\" fill=\"%s\"/>\n", laneX(ent.tid), ymid, color)
+		}
+		// Keep labels from running into the next lane, but
+		// show the full text on hover.
+		short := label
+		if n := (diagLaneWidth*3/4 - 10) / 7; len(short) > n {
+			short = short[:n-3] + "..."
+		}
+		fmt.Fprintf(bw, "<text x=\"%d\" y=\"%d\" fill=\"%s\"><title>%s</title>%s</text>\n", laneX(ent.tid)+8, ymid-3, color, html.EscapeString(label), html.EscapeString(short))
+	}
+
+	fmt.Fprintf(bw, "</svg>\n</body>\n</html>\n")
+	return bw.Flush()
+}
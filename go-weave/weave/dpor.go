@@ -0,0 +1,402 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package weave
+
+import (
+	"fmt"
+
+	"github.com/aclements/go-misc/go-weave/amb"
+)
+
+// StrategyDPOR explores thread interleavings using dynamic
+// partial-order reduction with sleep sets (Flanagan and Godefroid,
+// "Dynamic partial-order reduction for model checking software",
+// POPL 2005). Like amb.StrategyDFS, it is deterministic and explores
+// the whole space, but it only explores one interleaving of steps that
+// don't interact.
+//
+// A step is everything a thread does between two scheduling points.
+// Two steps are dependent if they access the same object and at least
+// one of them writes it. The primitives in this package record their
+// own accesses. Models must record every other access to shared state
+// using Scheduler.Access. A step that records no accesses is assumed
+// to be independent of every other step, so a missing Access call
+// causes the exploration to miss interleavings.
+//
+// StrategyDPOR can only be used with a weave.Scheduler. Calls to
+// Scheduler.Amb are explored exhaustively.
+type StrategyDPOR struct {
+	// MaxDepth specifies the maximum depth of the tree. If this
+	// is 0, it defaults to amb.DefaultMaxDepth.
+	MaxDepth int
+
+	path []*dporNode
+	step int
+
+	// The following are reset for each execution.
+
+	// sched is the list of runnable thread IDs if the next call
+	// to Amb is a scheduling decision, or nil.
+	sched []int
+	// trace is the sequence of steps executed so far.
+	trace []*dporStep
+	// objs assigns each object accessed in this execution a
+	// number in the order it was first accessed.
+	objs map[interface{}]int
+	// curNode is the index in path of the scheduling decision that
+	// started the current step.
+	curNode int
+	// data indicates the current step called Amb.
+	data bool
+	// branchSleep is the sleep set of the current step, before
+	// removing steps that are dependent on it.
+	branchSleep map[int]*dporStep
+	// sleep is the sleep set for the next scheduling decision.
+	sleep map[int]*dporStep
+	// sleepBlocked indicates that the current execution was
+	// terminated because every runnable thread was asleep.
+	sleepBlocked bool
+}
+
+// dporNode is a decision in the exploration tree. It is either a
+// scheduling decision or a call to Amb from the model.
+type dporNode struct {
+	width, choice int
+
+	// The remaining fields are only used for scheduling
+	// decisions.
+	sched bool
+
+	// ids is the IDs of the threads corresponding to each
+	// choice. These are the enabled threads.
+	ids []int
+
+	// backtrack is the set of thread IDs that must be explored
+	// from this node.
+	backtrack map[int]bool
+
+	// explored is the list of thread IDs that have been explored
+	// from this node, in order. The last is the current choice.
+	explored []int
+
+	// sleep is the sleep set at this node. Threads in the sleep
+	// set need not be explored from this node because exploring
+	// them is equivalent to an interleaving that has already
+	// been explored.
+	sleep map[int]*dporStep
+
+	// steps records the step each explored thread took from this
+	// node.
+	steps map[int]*dporStep
+
+	// nobjs is the number of objects accessed before this node.
+	// Since every execution through this node replays the same
+	// prefix, objects numbered below nobjs are the same objects
+	// in every such execution.
+	nobjs int
+}
+
+// dporStep is a step executed by a thread.
+type dporStep struct {
+	tid int
+	// node is the index of the scheduling decision that started
+	// this step.
+	node int
+	// acc maps from the number of each object accessed by this
+	// step to whether it was written.
+	acc map[int]bool
+	// spawned is the IDs of threads created by this step.
+	spawned []int
+	// det indicates that this step didn't call Amb, so it always
+	// does the same thing from its node.
+	det bool
+}
+
+func (d *StrategyDPOR) maxDepth() int {
+	if d.MaxDepth == 0 {
+		return amb.DefaultMaxDepth
+	}
+	return d.MaxDepth
+}
+
+func (d *StrategyDPOR) Reset() {
+	d.path = nil
+	d.step = 0
+	d.resetExecution()
+}
+
+func (d *StrategyDPOR) resetExecution() {
+	d.sched = nil
+	d.trace = nil
+	d.objs = make(map[interface{}]int)
+	d.curNode = -1
+	d.data = false
+	d.branchSleep = nil
+	d.sleep = nil
+	d.sleepBlocked = false
+}
+
+func (d *StrategyDPOR) beginSched(ids []int, prev int) {
+	d.sched = ids
+}
+
+func (d *StrategyDPOR) Amb(n int) (int, bool) {
+	sched := d.sched
+	d.sched = nil
+	if sched == nil {
+		d.data = true
+	}
+
+	if d.step < len(d.path) {
+		// We're in replay mode.
+		node := d.path[d.step]
+		if n != node.width || node.sched != (sched != nil) {
+			panic(&amb.ErrNondeterminism{Detail: fmt.Sprintf("Amb(%d) during replay, but previous call was Amb(%d)", n, node.width)})
+		}
+		d.step++
+		if node.sched {
+			d.enter(d.step-1, node)
+		}
+		return node.choice, true
+	}
+
+	if len(d.path) == d.maxDepth() {
+		return 0, false
+	}
+
+	// We're in exploration mode.
+	node := &dporNode{width: n}
+	if sched != nil {
+		node.sched = true
+		node.ids = sched
+		node.backtrack = make(map[int]bool)
+		node.sleep = d.sleep
+		node.steps = make(map[int]*dporStep)
+		node.nobjs = len(d.objs)
+		node.choice = -1
+		for i, id := range sched {
+			if node.sleep[id] == nil {
+				node.choice = i
+				break
+			}
+		}
+		if node.choice == -1 {
+			// Every thread is asleep, so every
+			// continuation of this execution is
+			// equivalent to one already explored.
+			d.sleepBlocked = true
+			return 0, false
+		}
+		id := sched[node.choice]
+		node.backtrack[id] = true
+		node.explored = []int{id}
+	}
+	d.path = append(d.path, node)
+	d.step++
+	if node.sched {
+		d.enter(len(d.path)-1, node)
+	}
+	return node.choice, true
+}
+
+// enter starts a step at scheduling decision node, which is path[i].
+func (d *StrategyDPOR) enter(i int, node *dporNode) {
+	d.curNode = i
+	d.data = false
+
+	// The step's sleep set includes the node's sleep set and the
+	// steps of siblings that have already been explored.
+	d.branchSleep = make(map[int]*dporStep)
+	for id, st := range node.sleep {
+		d.branchSleep[id] = st
+	}
+	for _, id := range node.explored[:len(node.explored)-1] {
+		if st := node.steps[id]; st != nil && st.det {
+			d.branchSleep[id] = st
+		}
+	}
+}
+
+// endStep records the step of thread tid that started at the last
+// scheduling decision. acc is the set of objects it accessed and
+// spawned is the set of threads it created.
+func (d *StrategyDPOR) endStep(tid int, acc map[interface{}]bool, spawned []int) {
+	if d.curNode < 0 {
+		return
+	}
+	st := &dporStep{tid, d.curNode, make(map[int]bool), spawned, !d.data}
+	for obj, write := range acc {
+		n, ok := d.objs[obj]
+		if !ok {
+			n = len(d.objs)
+			d.objs[obj] = n
+		}
+		st.acc[n] = write
+	}
+	d.trace = append(d.trace, st)
+	node := d.path[d.curNode]
+	if node.steps[tid] == nil {
+		node.steps[tid] = st
+	}
+
+	// Threads stay asleep until a dependent step executes.
+	d.sleep = make(map[int]*dporStep)
+	for id, st2 := range d.branchSleep {
+		if id != tid && !d.sleepDependent(st2, st) {
+			d.sleep[id] = st2
+		}
+	}
+}
+
+// dependent reports whether steps a and b may not commute.
+func (a *dporStep) dependent(b *dporStep) bool {
+	if len(a.acc) > len(b.acc) {
+		a, b = b, a
+	}
+	for obj, aw := range a.acc {
+		if bw, ok := b.acc[obj]; ok && (aw || bw) {
+			return true
+		}
+	}
+	return false
+}
+
+// sleepDependent reports whether sleeping step z, which was executed
+// in an earlier execution, may not commute with step st of the
+// current execution.
+//
+// Models generally allocate their state in each execution, so only
+// objects accessed before z's node can be identified across
+// executions. If both steps access objects first accessed after z's
+// node, they may be the same object, so they're assumed dependent.
+func (d *StrategyDPOR) sleepDependent(z, st *dporStep) bool {
+	nobjs := d.path[z.node].nobjs
+	zNew, stNew := false, false
+	for obj, zw := range z.acc {
+		if obj >= nobjs {
+			zNew = true
+		} else if sw, ok := st.acc[obj]; ok && (zw || sw) {
+			return true
+		}
+	}
+	for obj := range st.acc {
+		if obj >= nobjs {
+			stNew = true
+		}
+	}
+	return zNew && stNew
+}
+
+// addBacktracks finds races in the current trace and adds backtrack
+// points to explore the other order of each race.
+//
+// Steps i and j race if they are from different threads, are
+// dependent, and i does not happen before j. Happens-before is the
+// transitive closure of program order, thread creation, and the order
+// of dependent steps, and is tracked with vector clocks.
+func (d *StrategyDPOR) addBacktracks() {
+	type vclock map[int]int
+	join := func(a, b vclock) {
+		for k, v := range b {
+			if v > a[k] {
+				a[k] = v
+			}
+		}
+	}
+	threadVC := make(map[int]vclock)
+	counts := make(map[int]int)
+	clocks := make([]vclock, len(d.trace))
+	for j, sj := range d.trace {
+		t := sj.tid
+		vc := threadVC[t]
+
+		// Find the latest step that races with j and add a
+		// backtrack point before it to run t instead.
+		for i := j - 1; i >= 0; i-- {
+			si := d.trace[i]
+			if si.tid == t || !si.dependent(sj) {
+				continue
+			}
+			if clocks[i][si.tid] <= vc[si.tid] {
+				// i happens before j.
+				continue
+			}
+			node := d.path[si.node]
+			enabled := false
+			for _, id := range node.ids {
+				if id == t {
+					enabled = true
+				}
+			}
+			if enabled {
+				node.backtrack[t] = true
+			} else {
+				// We don't know which thread leads
+				// to t, so try them all.
+				for _, id := range node.ids {
+					node.backtrack[id] = true
+				}
+			}
+			break
+		}
+
+		// Compute j's clock.
+		nvc := make(vclock)
+		join(nvc, vc)
+		for i := 0; i < j; i++ {
+			if d.trace[i].tid != t && d.trace[i].dependent(sj) {
+				join(nvc, clocks[i])
+			}
+		}
+		counts[t]++
+		nvc[t] = counts[t]
+		clocks[j] = nvc
+		threadVC[t] = nvc
+		for _, c := range sj.spawned {
+			cvc := make(vclock)
+			join(cvc, nvc)
+			threadVC[c] = cvc
+		}
+	}
+}
+
+func (d *StrategyDPOR) Next() bool {
+	d.addBacktracks()
+	d.step = 0
+	d.resetExecution()
+
+	// Find the deepest decision with choices left to explore.
+	for len(d.path) > 0 {
+		node := d.path[len(d.path)-1]
+		if !node.sched {
+			node.choice++
+			if node.choice < node.width {
+				return true
+			}
+		} else if i, ok := node.nextChoice(); ok {
+			node.choice = i
+			node.explored = append(node.explored, node.ids[i])
+			return true
+		}
+		d.path = d.path[:len(d.path)-1]
+	}
+	// We're out of paths.
+	return false
+}
+
+// nextChoice returns the next thread to explore from scheduling
+// decision node.
+func (node *dporNode) nextChoice() (int, bool) {
+	explored := make(map[int]bool)
+	for _, id := range node.explored {
+		explored[id] = true
+	}
+	for i, id := range node.ids {
+		if node.backtrack[id] && !explored[id] && node.sleep[id] == nil {
+			return i, true
+		}
+	}
+	return 0, false
+}
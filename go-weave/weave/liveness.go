@@ -0,0 +1,144 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package weave
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Liveness configures livelock and starvation detection.
+//
+// Since executions are finite, a livelock is approximated as a window
+// of Bound consecutive scheduling steps in which the model makes no
+// progress. Under a fairness assumption, only windows in which the
+// schedule was fair are reported, so unfair schedules that merely
+// starve the thread that would make progress are not reported.
+// Fairness is judged over the second half of the window, so threads
+// created during the window are accounted for.
+type Liveness struct {
+	// Progress reports whether the model has made progress. It
+	// is called after every scheduling step. For example, it may
+	// report whether some thread completed an operation since
+	// the last call.
+	Progress func() bool
+
+	// Bound is the number of consecutive steps without progress
+	// that are reported as a livelock.
+	Bound int
+
+	// Fairness is the fairness assumption a window must satisfy
+	// to be reported.
+	Fairness Fairness
+}
+
+// Fairness is an assumption about the scheduler used to rule out
+// spurious livelocks.
+type Fairness int
+
+const (
+	// FairnessNone reports every window without progress,
+	// including those caused by unfair schedules.
+	FairnessNone Fairness = iota
+
+	// FairnessWeak assumes that a thread that is continuously
+	// runnable eventually runs. A window is only reported if
+	// every thread that was runnable throughout its second half
+	// ran during its second half.
+	FairnessWeak
+
+	// FairnessStrong assumes that a thread that is repeatedly
+	// runnable eventually runs. A window is only reported if
+	// every thread that was runnable at some point in its second
+	// half ran during its second half.
+	FairnessStrong
+)
+
+// livenessWindow tracks the current window of steps without progress.
+type livenessWindow struct {
+	steps int
+	// The following track the second half of the window. ran is
+	// the set of threads that ran. always and ever are the sets
+	// of threads that were runnable throughout and at some point.
+	ran, always, ever map[int]bool
+}
+
+func (w *livenessWindow) reset() {
+	*w = livenessWindow{}
+}
+
+// livenessStep records that tid ran while runnable were runnable. It
+// returns an error if the window now constitutes a livelock.
+func (s *Scheduler) livenessStep(tid int, runnable []int) error {
+	l, w := s.Liveness, &s.window
+	if l.Progress() {
+		w.reset()
+		return nil
+	}
+
+	w.steps++
+	if w.steps <= l.Bound/2 {
+		return nil
+	}
+	if w.ran == nil {
+		// Start the second half.
+		w.ran = make(map[int]bool)
+		w.always = make(map[int]bool)
+		w.ever = make(map[int]bool)
+		for _, id := range runnable {
+			w.always[id] = true
+		}
+	}
+	w.ran[tid] = true
+	isRunnable := make(map[int]bool)
+	for _, id := range runnable {
+		isRunnable[id] = true
+		w.ever[id] = true
+	}
+	for id := range w.always {
+		if !isRunnable[id] {
+			delete(w.always, id)
+		}
+	}
+
+	if w.steps < l.Bound {
+		return nil
+	}
+	var need map[int]bool
+	switch l.Fairness {
+	case FairnessWeak:
+		need = w.always
+	case FairnessStrong:
+		need = w.ever
+	}
+	for id := range need {
+		if !w.ran[id] {
+			// This window is unfair. Start a new one.
+			w.reset()
+			return nil
+		}
+	}
+	return fmt.Errorf("livelock: no progress in %d steps", w.steps)
+}
+
+// deadlockError returns the error for an execution that ended with
+// blocked threads.
+func (s *Scheduler) deadlockError() error {
+	var buf bytes.Buffer
+	mainBlocked := false
+	for i, t := range s.blocked {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "%v (%s)", t, t.waitReason)
+		if t.id == 0 {
+			mainBlocked = true
+		}
+	}
+	if mainBlocked {
+		return fmt.Errorf("deadlock: all threads are blocked: %s", buf.String())
+	}
+	return fmt.Errorf("threads blocked forever at exit: %s", buf.String())
+}
@@ -4,27 +4,28 @@
 
 package weave
 
-import "fmt"
-
 type Mutex struct {
 	locked  bool
 	waiters []*thread
 }
 
 func (m *Mutex) Lock() {
+	globalSched.Access(m, true)
 	if !m.locked {
 		m.locked = true
 		return
 	}
 	this := globalSched.curThread
 	m.waiters = append(m.waiters, this)
-	this.block(m.reset)
+	this.block("Mutex.Lock", m.reset)
+	globalSched.Access(m, true)
 }
 
 func (m *Mutex) Unlock() {
 	if !m.locked {
 		panic("attempt to Unlock unlocked Mutex")
 	}
+	globalSched.Access(m, true)
 	if len(m.waiters) == 0 {
 		m.locked = false
 	} else {
@@ -42,29 +43,37 @@ func (m *Mutex) reset() {
 	*m = Mutex{}
 }
 
+// RWMutex is a reader/writer mutual exclusion lock with the semantics
+// of sync.RWMutex. In particular, once a writer is blocked in Lock,
+// new readers block until that writer has acquired and released the
+// lock.
 type RWMutex struct {
 	r, w             int
 	readers, writers []*thread
 }
 
 func (rw *RWMutex) Lock() {
+	globalSched.Access(rw, true)
 	if rw.r == 0 && rw.w == 0 {
 		rw.w++
 		return
 	}
 	this := globalSched.curThread
 	rw.writers = append(rw.writers, this)
-	this.block(rw.reset)
+	this.block("RWMutex.Lock", rw.reset)
+	globalSched.Access(rw, true)
 }
 
 func (rw *RWMutex) RLock() {
-	if rw.w == 0 {
+	globalSched.Access(rw, true)
+	if rw.w == 0 && len(rw.writers) == 0 {
 		rw.r++
 		return
 	}
 	this := globalSched.curThread
 	rw.readers = append(rw.readers, this)
-	this.block(rw.reset)
+	this.block("RWMutex.RLock", rw.reset)
+	globalSched.Access(rw, true)
 }
 
 func (rw *RWMutex) reset() {
@@ -72,19 +81,11 @@ func (rw *RWMutex) reset() {
 }
 
 func (rw *RWMutex) Unlock() {
-	rw.w--
-	rw.release()
-}
-
-func (rw *RWMutex) RUnlock() {
-	rw.r--
-	rw.release()
-}
-
-func (rw *RWMutex) release() {
-	if rw.w != 0 {
-		panic(fmt.Sprintf("bad RWMutex writer count: %d", rw.w))
+	globalSched.Access(rw, true)
+	if rw.w != 1 {
+		panic("attempt to Unlock unlocked RWMutex")
 	}
+	rw.w--
 	if len(rw.readers) > 0 {
 		// Wake all readers.
 		rw.r += len(rw.readers)
@@ -92,14 +93,33 @@ func (rw *RWMutex) release() {
 			t.unblock()
 		}
 		rw.readers = rw.readers[:0]
-	} else if rw.r == 0 && len(rw.writers) > 0 {
-		// Wake one writer.
-		rw.w++
-		next := globalSched.Amb(len(rw.writers))
-		t := rw.writers[next]
-		rw.writers[next] = rw.writers[len(rw.writers)-1]
-		rw.writers = rw.writers[:len(rw.writers)-1]
-		t.unblock()
+	} else {
+		rw.wakeWriter()
 	}
 	globalSched.Sched()
 }
+
+func (rw *RWMutex) RUnlock() {
+	globalSched.Access(rw, true)
+	if rw.r <= 0 {
+		panic("attempt to RUnlock unlocked RWMutex")
+	}
+	rw.r--
+	if rw.r == 0 {
+		rw.wakeWriter()
+	}
+	globalSched.Sched()
+}
+
+// wakeWriter wakes one blocked writer, if any.
+func (rw *RWMutex) wakeWriter() {
+	if len(rw.writers) == 0 {
+		return
+	}
+	rw.w++
+	next := globalSched.Amb(len(rw.writers))
+	t := rw.writers[next]
+	rw.writers[next] = rw.writers[len(rw.writers)-1]
+	rw.writers = rw.writers[:len(rw.writers)-1]
+	t.unblock()
+}
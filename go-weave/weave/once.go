@@ -0,0 +1,24 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package weave
+
+// Once performs exactly one action, with the semantics of sync.Once.
+// Calls to Do block until the first call's f has returned.
+type Once struct {
+	m    Mutex
+	done bool
+}
+
+func (o *Once) Do(f func()) {
+	// Like the other primitives, this doesn't use defer since a
+	// deferred Unlock would run while a thread is being aborted.
+	globalSched.Access(o, true)
+	o.m.Lock()
+	if !o.done {
+		f()
+		o.done = true
+	}
+	o.m.Unlock()
+}
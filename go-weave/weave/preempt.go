@@ -0,0 +1,168 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package weave
+
+import (
+	"fmt"
+
+	"github.com/aclements/go-misc/go-weave/amb"
+)
+
+// DefaultMaxPreemptions is the default preemption bound of
+// StrategyPreemptionBounded.
+var DefaultMaxPreemptions = 2
+
+// StrategyPreemptionBounded explores schedules in increasing order of
+// the number of preemptions, in the style of iterative context
+// bounding (Musuvathi and Qadeer, "Iterative context bounding for
+// systematic testing of multithreaded programs", PLDI 2007). A
+// preemption is a scheduling decision that switches away from a thread
+// that could have kept running.
+//
+// It first explores every schedule with no preemptions, then every
+// schedule with at most one, and so on up to MaxPreemptions. Many
+// concurrency bugs need only a few preemptions, so this finds them
+// quickly even in models that are too large to explore exhaustively.
+// Schedules with fewer preemptions than the current bound are
+// re-executed at each bound.
+//
+// StrategyPreemptionBounded can only be used with a weave.Scheduler.
+// Calls to Scheduler.Amb are explored exhaustively.
+type StrategyPreemptionBounded struct {
+	// MaxPreemptions is the maximum number of preemptions in a
+	// schedule. If this is 0, it defaults to
+	// DefaultMaxPreemptions.
+	MaxPreemptions int
+
+	// MaxDepth specifies the maximum depth of the tree. If this
+	// is 0, it defaults to amb.DefaultMaxDepth.
+	MaxDepth int
+
+	// bound is the preemption bound currently being explored.
+	bound int
+
+	path []*preemptNode
+	step int
+
+	// sched and prev are the arguments to beginSched if the next
+	// call to Amb is a scheduling decision.
+	sched []int
+	prev  int
+}
+
+type preemptNode struct {
+	width, choice int
+
+	// ids and prev are the runnable threads and the previously
+	// running thread for a scheduling decision. ids is nil for
+	// other decisions.
+	ids  []int
+	prev int
+
+	// base is the number of preemptions before this decision.
+	base int
+}
+
+// cost returns the number of preemptions choice i at node incurs.
+func (node *preemptNode) cost(i int) int {
+	if node.ids != nil && node.prev >= 0 && node.ids[i] != node.prev {
+		return 1
+	}
+	return 0
+}
+
+// nextChoice returns the first choice at or after i that stays within
+// bound.
+func (node *preemptNode) nextChoice(i, bound int) (int, bool) {
+	for ; i < node.width; i++ {
+		if node.base+node.cost(i) <= bound {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (p *StrategyPreemptionBounded) maxPreemptions() int {
+	if p.MaxPreemptions == 0 {
+		return DefaultMaxPreemptions
+	}
+	return p.MaxPreemptions
+}
+
+func (p *StrategyPreemptionBounded) maxDepth() int {
+	if p.MaxDepth == 0 {
+		return amb.DefaultMaxDepth
+	}
+	return p.MaxDepth
+}
+
+func (p *StrategyPreemptionBounded) Reset() {
+	p.bound = 0
+	p.path = nil
+	p.step = 0
+	p.sched = nil
+}
+
+func (p *StrategyPreemptionBounded) beginSched(ids []int, prev int) {
+	p.sched, p.prev = ids, prev
+}
+
+func (p *StrategyPreemptionBounded) Amb(n int) (int, bool) {
+	sched := p.sched
+	p.sched = nil
+
+	if p.step < len(p.path) {
+		// We're in replay mode.
+		node := p.path[p.step]
+		if n != node.width || (node.ids != nil) != (sched != nil) {
+			panic(&amb.ErrNondeterminism{Detail: fmt.Sprintf("Amb(%d) during replay, but previous call was Amb(%d)", n, node.width)})
+		}
+		p.step++
+		return node.choice, true
+	}
+
+	if len(p.path) == p.maxDepth() {
+		return 0, false
+	}
+
+	// We're in exploration mode.
+	node := &preemptNode{width: n, ids: sched, prev: p.prev}
+	if len(p.path) > 0 {
+		last := p.path[len(p.path)-1]
+		node.base = last.base + last.cost(last.choice)
+	}
+	choice, ok := node.nextChoice(0, p.bound)
+	if !ok {
+		// Can't happen: continuing the previous thread
+		// is always free.
+		panic("no choice within preemption bound")
+	}
+	node.choice = choice
+	p.path = append(p.path, node)
+	p.step++
+	return node.choice, true
+}
+
+func (p *StrategyPreemptionBounded) Next() bool {
+	p.step = 0
+	p.sched = nil
+
+	// Construct the next path prefix to explore.
+	for len(p.path) > 0 {
+		node := p.path[len(p.path)-1]
+		if choice, ok := node.nextChoice(node.choice+1, p.bound); ok {
+			node.choice = choice
+			return true
+		}
+		p.path = p.path[:len(p.path)-1]
+	}
+
+	// We've explored every schedule within this bound.
+	if p.bound == p.maxPreemptions() {
+		return false
+	}
+	p.bound++
+	return true
+}
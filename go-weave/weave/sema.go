@@ -18,6 +18,7 @@ type semwait struct {
 }
 
 func (s *Semaphore) Acquire(n int) {
+	globalSched.Access(s, true)
 	if s.avail >= n {
 		s.avail -= n
 		return
@@ -30,10 +31,12 @@ func (s *Semaphore) Acquire(n int) {
 		s.wait = w
 	}
 	s.waitEnd = w
-	this.block(s.reset)
+	this.block("Semaphore.Acquire", s.reset)
+	globalSched.Access(s, true)
 }
 
 func (s *Semaphore) Release(n int) {
+	globalSched.Access(s, true)
 	s.avail += n
 	any := false
 	for s.wait != nil && s.avail >= s.wait.n {
@@ -0,0 +1,141 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package weave
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+)
+
+// This file implements visited-state pruning.
+//
+// After every scheduling step, the scheduler computes a fingerprint of
+// the model's state from Scheduler.StateHash, the state of every
+// thread, and the state of every primitive from this package the
+// execution has used. If an execution reaches a state that was already
+// reached by a different sequence of decisions, it is cut off, since
+// its continuations are explored from the earlier visit. (Reaching a
+// state by the same decisions is just the replay of a path prefix.)
+//
+// The scheduler can't see threads' stacks, so two executions that
+// reach the same fingerprint with threads at different points in
+// their code are treated as the same state. StateHash must capture
+// enough of the model's state, such as a per-thread program counter,
+// to distinguish these, or the exploration will miss interleavings.
+//
+// Visited-state pruning is also unsound in combination with the sleep
+// sets used by StrategyDPOR, so it's best used with StrategyDFS.
+
+// stateHasher is implemented by primitives whose state is included in
+// the state fingerprint.
+type stateHasher interface {
+	hashState(w io.Writer)
+}
+
+// resetState clears per-execution state tracking.
+func (s *Scheduler) resetState() {
+	s.prims = s.prims[:0]
+	s.primSeen = nil
+	s.pruned = false
+	s.pathHash = 0
+}
+
+// trackPrim adds obj to the set of primitives included in the state
+// fingerprint if it's a primitive.
+func (s *Scheduler) trackPrim(obj interface{}) {
+	h, ok := obj.(stateHasher)
+	if !ok || s.primSeen[h] {
+		return
+	}
+	if s.primSeen == nil {
+		s.primSeen = make(map[stateHasher]bool)
+	}
+	s.primSeen[h] = true
+	s.prims = append(s.prims, h)
+}
+
+// stateHash returns the fingerprint of the current state.
+func (s *Scheduler) stateHash() uint64 {
+	h := fnv.New64a()
+	s.StateHash(h)
+
+	threads := append(append([]*thread(nil), s.runnable...), s.blocked...)
+	sort.Slice(threads, func(i, j int) bool { return threads[i].id < threads[j].id })
+	for _, t := range threads {
+		fmt.Fprintf(h, "T%d %v %s;", t.id, t.blocked, t.waitReason)
+	}
+
+	// Primitives are identified by the order they were first
+	// used in.
+	for i, p := range s.prims {
+		fmt.Fprintf(h, "P%d ", i)
+		p.hashState(h)
+	}
+	return h.Sum64()
+}
+
+// visit records the current state. It reports whether the state was
+// already visited by a different path.
+func (s *Scheduler) visit() bool {
+	hash := s.stateHash()
+	if path, ok := s.visited[hash]; ok {
+		return path != s.pathHash
+	}
+	s.visited[hash] = s.pathHash
+	return false
+}
+
+func hashThreads(w io.Writer, ts []*thread) {
+	for _, t := range ts {
+		fmt.Fprintf(w, " T%d", t.id)
+	}
+	fmt.Fprintf(w, ";")
+}
+
+func (m *Mutex) hashState(w io.Writer) {
+	fmt.Fprintf(w, "Mutex %v", m.locked)
+	hashThreads(w, m.waiters)
+}
+
+func (rw *RWMutex) hashState(w io.Writer) {
+	fmt.Fprintf(w, "RWMutex %d %d", rw.r, rw.w)
+	hashThreads(w, rw.readers)
+	hashThreads(w, rw.writers)
+}
+
+func (s *Semaphore) hashState(w io.Writer) {
+	fmt.Fprintf(w, "Semaphore %d", s.avail)
+	for sw := s.wait; sw != nil; sw = sw.next {
+		fmt.Fprintf(w, " T%d:%d", sw.thr.id, sw.n)
+	}
+	fmt.Fprintf(w, ";")
+}
+
+func (g *WaitGroup) hashState(w io.Writer) {
+	fmt.Fprintf(w, "WaitGroup %d", g.n)
+	hashThreads(w, g.waiters)
+}
+
+func (a *AtomicInt32) hashState(w io.Writer) {
+	fmt.Fprintf(w, "AtomicInt32 %d;", a.v)
+}
+
+func (o *Once) hashState(w io.Writer) {
+	fmt.Fprintf(w, "Once %v;", o.done)
+}
+
+func (c *Chan) hashState(w io.Writer) {
+	fmt.Fprintf(w, "Chan %d %v %v", c.cap, c.closed, c.buf)
+	for _, q := range [][]*chanWaiter{c.recvq, c.sendq} {
+		for _, cw := range q {
+			if !cw.sel.fired {
+				fmt.Fprintf(w, " T%d:%d", cw.sel.thr.id, cw.cas)
+			}
+		}
+		fmt.Fprintf(w, ";")
+	}
+}
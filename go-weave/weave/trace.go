@@ -10,16 +10,44 @@ import (
 )
 
 type traceEntry struct {
-	tid int
-	msg string
+	kind traceKind
+	tid  int
+	// other is the thread woken or created by a traceUnblock or
+	// traceGo entry.
+	other int
+	msg   string
 }
 
+// traceKind is the kind of a traceEntry. Only traceMsg entries are
+// printed in failure messages. The other kinds record the schedule
+// and are only recorded if Scheduler.DiagramFile is set.
+type traceKind uint8
+
+const (
+	traceMsg     traceKind = iota // Call to Trace
+	traceRun                      // tid was scheduled
+	traceBlock                    // tid blocked in msg
+	traceUnblock                  // tid unblocked other
+	traceGo                       // tid created other
+	traceExit                     // tid exited
+	traceFail                     // tid failed with msg, or -1 for the whole execution
+)
+
 func (s *Scheduler) Trace(msg string) {
-	s.trace = append(s.trace, traceEntry{s.curThread.id, msg})
+	s.trace = append(s.trace, traceEntry{traceMsg, s.curThread.id, -1, msg})
 }
 
 func (s *Scheduler) Tracef(msg string, args ...interface{}) {
-	s.trace = append(s.trace, traceEntry{s.curThread.id, fmt.Sprintf(msg, args...)})
+	s.trace = append(s.trace, traceEntry{traceMsg, s.curThread.id, -1, fmt.Sprintf(msg, args...)})
+}
+
+// event records a schedule event for the diagram. Nothing is recorded
+// once the execution is shutting down.
+func (s *Scheduler) event(kind traceKind, tid, other int, msg string) {
+	if s.DiagramFile == "" || s.goErr != nil {
+		return
+	}
+	s.trace = append(s.trace, traceEntry{kind, tid, other, msg})
 }
 
 type errorWithTrace struct {
@@ -28,14 +56,17 @@ type errorWithTrace struct {
 }
 
 func (e errorWithTrace) Error() string {
-	if len(e.trace) == 0 {
-		return fmt.Sprint(e.err)
-	}
-
 	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "%v\n", e.err)
-	fmt.Fprintf(&buf, "trace:")
+	fmt.Fprintf(&buf, "%v", e.err)
+	header := false
 	for _, ent := range e.trace {
+		if ent.kind != traceMsg {
+			continue
+		}
+		if !header {
+			fmt.Fprintf(&buf, "\ntrace:")
+			header = true
+		}
 		fmt.Fprintf(&buf, "\n  T%d %s", ent.tid, ent.msg)
 	}
 	return buf.String()
@@ -10,7 +10,11 @@ type WaitGroup struct {
 }
 
 func (g *WaitGroup) Add(delta int) {
+	globalSched.Access(g, true)
 	g.n += delta
+	if g.n < 0 {
+		panic("negative WaitGroup counter")
+	}
 	if g.n == 0 {
 		waiters := g.waiters
 		g.waiters = nil
@@ -25,13 +29,15 @@ func (g *WaitGroup) Done() {
 }
 
 func (g *WaitGroup) Wait() {
+	globalSched.Access(g, false)
 	if g.n == 0 {
 		globalSched.Sched()
 		return
 	}
 	this := globalSched.curThread
 	g.waiters = append(g.waiters, this)
-	this.block(g.reset)
+	this.block("WaitGroup.Wait", g.reset)
+	globalSched.Access(g, false)
 }
 
 func (g *WaitGroup) reset() {
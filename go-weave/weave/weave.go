@@ -7,20 +7,42 @@ package weave
 import (
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/aclements/go-misc/go-weave/amb"
 )
 
-// TODO: Implement simple partial order reduction. If the next actions
-// on T1 and T2 commute, then we know that [T1,T2,...] and [T2,T1,...]
-// are equivalent (however, we can't just cut off T2, since we still
-// need [T2,T2,...]).
-
 // TODO: Implement a PCT scheduler (https://www.microsoft.com/en-us/research/publication/a-randomized-scheduler-with-probabilistic-guarantees-of-finding-bugs/)
 
 type Scheduler struct {
 	Strategy amb.Strategy
 
+	// TraceFile, if non-empty, is the file to write the schedule
+	// of the first failing execution to. To reproduce the failure,
+	// run the model with a Strategy returned by
+	// amb.ReadTraceFile.
+	TraceFile string
+
+	// Liveness, if non-nil, enables livelock detection.
+	Liveness *Liveness
+
+	// StateHash, if non-nil, enables visited-state pruning. It
+	// is called after every scheduling step and must write a
+	// fingerprint of the model's state to w. The state of the
+	// primitives in this package and of the threads is added to
+	// this automatically. See state.go for the caveats. With
+	// amb.StrategyParallel, each worker process only prunes the
+	// states it visited itself.
+	StateHash func(w io.Writer)
+
+	// DiagramFile, if non-empty, is the file to write an HTML
+	// sequence diagram of the first failing execution to. The
+	// diagram shows each thread's lane, the messages passed to
+	// Trace, where threads block and which threads wake them, and
+	// the failure. Set this together with TraceFile to draw the
+	// diagram of a replayed trace.
+	DiagramFile string
+
 	as amb.Scheduler
 
 	nextid    int
@@ -35,10 +57,56 @@ type Scheduler struct {
 	wakeSched chan void
 
 	trace []traceEntry
+
+	// replay indicates Strategy is an *amb.StrategyReplay.
+	replay bool
+
+	// dpor is Strategy if it is a *StrategyDPOR, or nil.
+	dpor *StrategyDPOR
+	// schedStrategy is Strategy if it needs to know about
+	// scheduling decisions, or nil.
+	schedStrategy schedStrategy
+	// stepAcc and stepSpawn record the objects accessed and the
+	// threads created by the current thread since it was last
+	// scheduled.
+	stepAcc   map[interface{}]bool
+	stepSpawn []int
+
+	// window is the current liveness window.
+	window livenessWindow
+
+	// visited maps from each state hash reached in this Run to
+	// the hash of the decisions that first reached it.
+	visited map[uint64]uint64
+	// pathHash is the hash of the decisions made so far in the
+	// current execution.
+	pathHash uint64
+	// prims is the primitives used by the current execution, in
+	// the order they were first used, and primSeen is the set of
+	// these.
+	prims    []stateHasher
+	primSeen map[stateHasher]bool
+	// pruned indicates the current execution was cut off because
+	// it reached a visited state.
+	pruned bool
+
+	// diagrammed indicates a failure has been written to
+	// DiagramFile.
+	diagrammed bool
 }
 
 var globalSched *Scheduler
 
+// schedStrategy is implemented by strategies that need to know which
+// threads they are choosing between.
+type schedStrategy interface {
+	// beginSched indicates that the next call to Amb chooses
+	// which of the threads in ids to run next. prev is the ID of
+	// the thread that ran the previous step if it's still
+	// runnable, or -1.
+	beginSched(ids []int, prev int)
+}
+
 type void struct{}
 
 type thread struct {
@@ -46,6 +114,9 @@ type thread struct {
 	id      int
 	index   int // Index in Scheduler.runnable or .blocked
 	blocked bool
+	// waitReason describes the operation this thread is blocked
+	// in, if blocked.
+	waitReason string
 
 	tls map[*TLS]interface{}
 
@@ -59,8 +130,12 @@ func (t *thread) String() string {
 const debug = false
 
 func (s *Scheduler) newThread() *thread {
-	thr := &thread{s, s.nextid, -1, false, nil, make(chan void)}
+	thr := &thread{s, s.nextid, -1, false, "", nil, make(chan void)}
 	s.nextid++
+	if s.curThread != nil {
+		s.stepSpawn = append(s.stepSpawn, thr.id)
+		s.event(traceGo, s.curThread.id, thr.id, "")
+	}
 	if thr.id != -1 {
 		thr.index = len(s.runnable)
 		s.runnable = append(s.runnable, thr)
@@ -75,7 +150,12 @@ func (s *Scheduler) Run(main func()) {
 	globalSched = s
 	defer func() { globalSched = nil }()
 
-	s.as = amb.Scheduler{Strategy: s.Strategy}
+	s.as = amb.Scheduler{Strategy: s.Strategy, TraceFile: s.TraceFile}
+	s.dpor, _ = s.Strategy.(*StrategyDPOR)
+	s.schedStrategy, _ = s.Strategy.(schedStrategy)
+	_, s.replay = s.Strategy.(*amb.StrategyReplay)
+	s.visited = make(map[uint64]uint64)
+	s.diagrammed = false
 
 	s.as.Run(func() {
 		// Initialize state.
@@ -86,13 +166,24 @@ func (s *Scheduler) Run(main func()) {
 		s.goErr = nil
 		s.wakeSched = make(chan void)
 		s.trace = nil
+		s.window.reset()
+		s.resetState()
 		s.goNoSched(main)
 		s.scheduler()
+		if s.goErr == amb.PathTerminated && (s.pruned || s.dpor != nil && s.dpor.sleepBlocked) {
+			// This execution was pruned because it's
+			// equivalent to one already explored.
+			return
+		}
 		if s.goErr != nil {
+			s.writeDiagram(s.goErr)
 			panic(errorWithTrace{s.goErr, s.trace})
 		}
 		if len(s.blocked) != 0 {
-			panic(errorWithTrace{fmt.Sprintf("threads asleep: %s", s.blocked), s.trace})
+			err := s.deadlockError()
+			s.event(traceFail, -1, -1, err.Error())
+			s.writeDiagram(err)
+			panic(errorWithTrace{err, s.trace})
 		}
 		if debug {
 			fmt.Println("run done")
@@ -129,12 +220,21 @@ func (s *Scheduler) goNoSched(f func()) {
 				return
 			}
 
+			// If we're replaying a failure, crash on this
+			// thread so the stack trace shows the failure.
+			if goErr != nil && s.replay {
+				s.event(traceFail, thr.id, -1, fmt.Sprint(goErr))
+				s.writeDiagram(goErr)
+				panic(goErr)
+			}
+
 			// If we're panicking, report the error so the
 			// scheduler can shut down this execution.
 			//
 			// TODO: Capture the stack trace.
 			if goErr != nil {
 				if s.goErr == nil {
+					s.event(traceFail, thr.id, -1, fmt.Sprint(goErr))
 					s.goErr = goErr
 				}
 				s.wakeSched <- void{}
@@ -142,6 +242,7 @@ func (s *Scheduler) goNoSched(f func()) {
 			}
 
 			// Otherwise, this is a regular thread exit.
+			s.event(traceExit, thr.id, -1, "")
 			close(thr.wake)
 			s.wakeSched <- void{}
 		}()
@@ -169,6 +270,12 @@ func (s *Scheduler) scheduler() {
 		// this, and we might be aborting because amb
 		// terminated this path anyway.
 		var tid int
+		var ids []int
+		if s.schedStrategy != nil || s.Liveness != nil {
+			for _, t := range s.runnable {
+				ids = append(ids, t.id)
+			}
+		}
 		if s.goErr == nil {
 			// Amb may panic with PathTerminated.
 			func() {
@@ -180,9 +287,21 @@ func (s *Scheduler) scheduler() {
 						panic(err)
 					}
 				}()
-				tid = s.as.Amb(len(s.runnable))
+				if s.schedStrategy != nil {
+					prev := -1
+					for _, id := range ids {
+						if s.curThread != nil && id == s.curThread.id {
+							prev = id
+						}
+					}
+					s.schedStrategy.beginSched(ids, prev)
+				}
+				tid = s.amb(len(s.runnable))
 			}()
 		}
+		if s.runnable[tid] != s.curThread {
+			s.event(traceRun, s.runnable[tid].id, -1, "")
+		}
 		s.curThread = s.runnable[tid]
 
 		if debug {
@@ -190,10 +309,24 @@ func (s *Scheduler) scheduler() {
 		}
 
 		// Switch to that thread.
+		s.stepAcc, s.stepSpawn = nil, nil
 		s.curThread.wake <- void{}
 
 		// Wait for thread to deschedule.
 		<-s.wakeSched
+		if s.dpor != nil && s.goErr == nil {
+			s.dpor.endStep(s.curThread.id, s.stepAcc, s.stepSpawn)
+		}
+		if s.Liveness != nil && s.goErr == nil {
+			if err := s.livenessStep(s.curThread.id, ids); err != nil {
+				s.event(traceFail, -1, -1, err.Error())
+				s.goErr = err
+			}
+		}
+		if s.StateHash != nil && s.goErr == nil && len(s.runnable) > 0 && s.visit() {
+			s.goErr = amb.PathTerminated
+			s.pruned = true
+		}
 		if s.goErr != nil {
 			// This state will signal all threads to exit,
 			// but we have to wake blocked threads so they
@@ -219,14 +352,48 @@ func (t *thread) desched() {
 }
 
 func (s *Scheduler) Amb(n int) int {
-	return s.as.Amb(n)
+	return s.amb(n)
+}
+
+// amb calls s.as.Amb and records its result in s.pathHash.
+func (s *Scheduler) amb(n int) int {
+	x := s.as.Amb(n)
+	if s.StateHash != nil {
+		s.pathHash = (s.pathHash^uint64(x))*1099511628211 ^ uint64(n)
+	}
+	return x
+}
+
+// Access records that the current thread accessed obj, which must be
+// comparable. If write is true, the access modified obj.
+//
+// Access is only needed by strategies that reduce the set of explored
+// interleavings using the accesses made by each thread, such as
+// StrategyDPOR. Accesses made by the synchronization primitives in
+// this package are recorded automatically.
+func (s *Scheduler) Access(obj interface{}, write bool) {
+	if s.StateHash != nil {
+		s.trackPrim(obj)
+	}
+	if s.dpor == nil {
+		return
+	}
+	if s.stepAcc == nil {
+		s.stepAcc = make(map[interface{}]bool)
+	}
+	s.stepAcc[obj] = s.stepAcc[obj] || write
 }
 
-func (t *thread) block(abortf func()) {
+// block blocks t until another thread unblocks it. what describes the
+// operation t is blocked in. abortf is called if the execution is
+// aborted while t is blocked.
+func (t *thread) block(what string, abortf func()) {
 	if t.blocked {
 		panic("thread blocked multiple times")
 	}
 	t.blocked = true
+	t.waitReason = what
+	t.sched.event(traceBlock, t.id, -1, what)
 
 	s := t.sched
 	s.runnable[t.index] = s.runnable[len(s.runnable)-1]
@@ -252,6 +419,8 @@ func (t *thread) unblock() {
 		panic("thread unblocked while not blocked")
 	}
 	t.blocked = false
+	t.waitReason = ""
+	t.sched.event(traceUnblock, t.sched.curThread.id, t.id, "")
 
 	s := t.sched
 	s.blocked[t.index] = s.blocked[len(s.blocked)-1]
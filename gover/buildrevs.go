@@ -0,0 +1,183 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aclements/go-misc/internal/gitutil"
+)
+
+// doBuildRevs builds and saves each of revs, building up to jobs
+// revisions concurrently. Each revision is built in its own temporary
+// git worktree of gitRepo(), or a shallow clone if gitRepo() is
+// remote, so this doesn't disturb the current tree.
+func doBuildRevs(revs []string, jobs int) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	// Resolve revisions up front so we fail early. Several revs
+	// may name the same commit, so build each hash once.
+	var hashes []string
+	names := make(map[string][]string)
+	for _, rev := range revs {
+		hash := resolveRev(rev)
+		if names[hash] == nil {
+			hashes = append(hashes, hash)
+		}
+		names[hash] = append(names[hash], rev)
+	}
+
+	var out lockedWriter
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	errs := make([]error, len(hashes))
+	for i, hash := range hashes {
+		if _, ok := resolveName(hash); ok {
+			fmt.Fprintf(os.Stderr, "saved build `%s' already exists\n", hash)
+			continue
+		}
+
+		prefix := fmt.Sprintf("[%s] ", strings.Join(names[hash], ", "))
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, hash string) {
+			defer func() { <-sem; wg.Done() }()
+			pw := &prefixWriter{w: &out, prefix: prefix}
+			if err := buildRev(hash, pw); err != nil {
+				fmt.Fprintf(pw, "%s\n", err)
+				errs[i] = err
+			} else {
+				fmt.Fprintf(pw, "saved build as `%s'\n", hash)
+			}
+			pw.Flush()
+		}(i, hash)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", strings.Join(names[hashes[i]], ", "), err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d build(s) failed", failed)
+	}
+}
+
+// resolveRev returns the build key for revision rev in the current
+// build configuration.
+func resolveRev(rev string) string {
+	var hash string
+	var err error
+	if gitutil.IsRemote(gitRepo()) {
+		hash, err = gitutil.ResolveRemote(gitRepo(), rev)
+	} else {
+		hash, err = repoAt(gitRepo()).Resolve(rev)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return variantKey(hash)
+}
+
+// buildRev builds and saves build key hash in a temporary worktree,
+// writing build output to w.
+func buildRev(hash string, w io.Writer) error {
+	if *fetch && currentVariant() == "" {
+		if ok, err := fetchPrebuilt(hash, w); err != nil || ok {
+			return err
+		}
+	}
+
+	tmp, err := ioutil.TempDir("", "gover-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "go")
+	commit := hashPlusRe.FindStringSubmatch(hash)[1]
+
+	if gitutil.IsRemote(gitRepo()) {
+		// Fetch just this commit.
+		fmt.Fprintf(w, "fetching %s from %s\n", commit, gitRepo())
+		if _, err := gitutil.Clone(gitRepo(), dir, gitutil.CloneOptions{Depth: 1, Rev: commit}); err != nil {
+			return fmt.Errorf("error cloning %s: %v", commit, err)
+		}
+	} else {
+		// Create the worktree.
+		repo, err := gitutil.Open(gitRepo())
+		if err != nil {
+			return err
+		}
+		if _, err := repo.AddWorktree(dir, commit); err != nil {
+			return fmt.Errorf("error creating worktree: %v", err)
+		}
+		defer func() {
+			if err := repo.RemoveWorktree(dir); err != nil {
+				fmt.Fprintln(w, err)
+			}
+		}()
+	}
+
+	if err := makeBash(dir, w, w); err != nil {
+		return err
+	}
+	return saveTree(dir, hash, nil)
+}
+
+// lockedWriter serializes writes to os.Stdout.
+type lockedWriter struct {
+	mu sync.Mutex
+}
+
+func (l *lockedWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return os.Stdout.Write(b)
+}
+
+// prefixWriter prefixes each line written to it with prefix. It
+// writes whole lines at a time, so output from several prefixWriters
+// sharing a lockedWriter is interleaved by line.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := append([]byte(p.prefix), p.buf[:i+1]...)
+		if _, err := p.w.Write(line); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes any incomplete final line.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.Write([]byte("\n"))
+	}
+}
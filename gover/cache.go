@@ -15,15 +15,27 @@ import (
 	"time"
 )
 
-var hashNameRe = regexp.MustCompile(`^[0-9a-f]{7,40}(\+[0-9a-f]{1,10})?$`)
+// Saved builds are named commit[+delta][@variant], where commit is
+// the commit hash, delta is the hash of any uncommitted changes, and
+// variant is the hash of the build configuration (see variant.go).
+var hashNameRe = regexp.MustCompile(`^([0-9a-f]{7,40})(?:\+([0-9a-f]{1,10}))?(?:@([0-9a-f]{1,10}))?$`)
 var fullHashRe = regexp.MustCompile("^[0-9a-f]{40}$")
-var hashPlusRe = regexp.MustCompile(`^[0-9a-f]{40}(\+[0-9a-f]{10})?$`)
+var hashPlusRe = regexp.MustCompile(`^([0-9a-f]{40})(?:\+([0-9a-f]{10}))?(?:@([0-9a-f]{10}))?$`)
 
 // resolveName returns the path to the root of the named build and
 // whether or not that path exists. It will log an error and exit if
 // name is ambiguous. If the path does not exist, the returned path is
 // where this build should be saved.
+//
+// If name is a hash that doesn't specify a variant, it resolves to
+// the variant for the build configuration in the environment.
 func resolveName(name string) (path string, ok bool) {
+	parts := hashNameRe.FindStringSubmatch(name)
+	if parts != nil && parts[3] == "" {
+		name = variantKey(name)
+		parts = hashNameRe.FindStringSubmatch(name)
+	}
+
 	// If the name exactly matches a saved version, return it.
 	savePath := filepath.Join(*verDir, name)
 	st, err := os.Stat(savePath)
@@ -32,8 +44,7 @@ func resolveName(name string) (path string, ok bool) {
 	}
 
 	// Otherwise, try to resolve it as an unambiguous hash prefix.
-	if hashNameRe.MatchString(name) {
-		nameParts := strings.SplitN(name, "+", 2)
+	if parts != nil {
 		builds, err := listBuilds(0)
 		if err != nil {
 			log.Fatal(err)
@@ -41,13 +52,19 @@ func resolveName(name string) (path string, ok bool) {
 
 		var fullName string
 		for _, b := range builds {
-			if !strings.HasPrefix(b.commitHash, nameParts[0]) {
+			if !strings.HasPrefix(b.commitHash, parts[1]) {
+				continue
+			}
+			if (parts[2] == "") != (b.deltaHash == "") {
+				continue
+			}
+			if !strings.HasPrefix(b.deltaHash, parts[2]) {
 				continue
 			}
-			if (len(nameParts) == 1) != (b.deltaHash == "") {
+			if (parts[3] == "") != (b.variant == "") {
 				continue
 			}
-			if len(nameParts) > 1 && !strings.HasPrefix(b.deltaHash, nameParts[1]) {
+			if !strings.HasPrefix(b.variant, parts[3]) {
 				continue
 			}
 
@@ -69,23 +86,29 @@ type buildInfo struct {
 	path       string
 	commitHash string
 	deltaHash  string
+	variant    string
 	names      []string
 	commit     *commit
 }
 
 func (i buildInfo) fullName() string {
-	if i.deltaHash == "" {
-		return i.commitHash
-	}
-	return i.commitHash + "+" + i.deltaHash
+	return i.name(i.commitHash)
 }
 
 func (i buildInfo) shortName() string {
 	// TODO: Print more than 7 characters if necessary.
-	if i.deltaHash == "" {
-		return i.commitHash[:7]
+	return i.name(i.commitHash[:7])
+}
+
+func (i buildInfo) name(commit string) string {
+	name := commit
+	if i.deltaHash != "" {
+		name += "+" + i.deltaHash
+	}
+	if i.variant != "" {
+		name += "@" + i.variant
 	}
-	return i.commitHash[:7] + "+" + i.deltaHash
+	return name
 }
 
 type listFlags int
@@ -110,13 +133,15 @@ func listBuilds(flags listFlags) ([]*buildInfo, error) {
 		baseMap = make(map[string]*buildInfo)
 	}
 	for _, file := range files {
-		if !file.IsDir() || !hashPlusRe.MatchString(file.Name()) {
+		parts := hashPlusRe.FindStringSubmatch(file.Name())
+		if !file.IsDir() || parts == nil {
 			continue
 		}
-		nameParts := strings.SplitN(file.Name(), "+", 2)
-		info := &buildInfo{path: filepath.Join(*verDir, file.Name()), commitHash: nameParts[0]}
-		if len(nameParts) > 1 {
-			info.deltaHash = nameParts[1]
+		info := &buildInfo{
+			path:       filepath.Join(*verDir, file.Name()),
+			commitHash: parts[1],
+			deltaHash:  parts[2],
+			variant:    parts[3],
 		}
 
 		builds = append(builds, info)
@@ -0,0 +1,378 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// This file implements fetching prebuilt toolchains. Release builds
+// are fetched from the archives published on go.dev, and other
+// commits are fetched from the snapshots saved by the Go builders.
+
+var releaseTagRe = regexp.MustCompile(`^go[0-9]+(\.[0-9]+)*((beta|rc)[0-9]+)?$`)
+
+const (
+	releaseListURL = "https://go.dev/dl/?mode=json&include=all"
+	releaseURL     = "https://go.dev/dl/"
+	snapshotURL    = "https://storage.googleapis.com/go-build-snap/go/"
+)
+
+// fetchPrebuilt downloads a prebuilt toolchain for commit and saves
+// it as build commit. It returns false if there is no prebuilt
+// toolchain for commit, in which case the caller should build it from
+// source.
+func fetchPrebuilt(commit string, w io.Writer) (bool, error) {
+	goos, goarch := targetOSArch()
+
+	// Find an archive for this commit. verify checks the
+	// downloaded archive's hash, h.
+	var url string
+	var h hash.Hash
+	var verify func(resp *http.Response) error
+	tags, err := tagsAt(commit)
+	if err != nil {
+		return false, err
+	}
+	for _, tag := range tags {
+		if !releaseTagRe.MatchString(tag) {
+			continue
+		}
+		file, err := findRelease(tag, goos, goarch)
+		if err != nil {
+			return false, err
+		}
+		if file != nil {
+			url, h = releaseURL+file.Filename, sha256.New()
+			verify = func(resp *http.Response) error {
+				if got := fmt.Sprintf("%x", h.Sum(nil)); got != file.SHA256 {
+					return fmt.Errorf("%s: SHA256 is %s, want %s", url, got, file.SHA256)
+				}
+				return nil
+			}
+			break
+		}
+	}
+	if url == "" {
+		// Try a builder snapshot. These don't publish a hash,
+		// but GCS reports the MD5 of each object. Since this
+		// comes from the same server as the snapshot, it only
+		// detects corrupted downloads, not tampering.
+		url, h = snapshotURL+goos+"-"+goarch+"/"+commit+".tar.gz", md5.New()
+		verify = func(resp *http.Response) error {
+			for _, gh := range resp.Header["X-Goog-Hash"] {
+				for _, part := range strings.Split(gh, ",") {
+					part = strings.TrimSpace(part)
+					if !strings.HasPrefix(part, "md5=") {
+						continue
+					}
+					want := part[len("md5="):]
+					if got := base64.StdEncoding.EncodeToString(h.Sum(nil)); got != want {
+						return fmt.Errorf("%s: MD5 is %s, want %s", url, got, want)
+					}
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: no MD5 to verify download", url)
+		}
+	}
+
+	fmt.Fprintf(w, "fetching %s\n", url)
+	resp, err := http.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		// GCS returns 403 for missing objects in some buckets.
+		fmt.Fprintf(w, "no prebuilt toolchain for %s\n", commit)
+		return false, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	// Download to a temporary file so we can verify it before
+	// unpacking it.
+	tmp, err := ioutil.TempFile("", "gover-fetch-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		return false, err
+	}
+	if err := verify(resp); err != nil {
+		return false, err
+	}
+
+	// Unpack the archive.
+	dir, err := ioutil.TempDir("", "gover-fetch-")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if strings.HasSuffix(url, ".zip") {
+		// Windows releases are zip files.
+		err = unzip(tmp, dir)
+	} else {
+		err = untar(tmp, dir)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", url, err)
+	}
+
+	// Release archives contain a top-level "go" directory.
+	root := dir
+	if isGoroot(filepath.Join(dir, "go")) {
+		root = filepath.Join(dir, "go")
+	}
+	if !isGoroot(root) {
+		return false, fmt.Errorf("%s: archive does not contain a Go tree", url)
+	}
+
+	if err := saveTree(root, commit, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// targetOSArch returns the GOOS and GOARCH to save builds for.
+func targetOSArch() (goos, goarch string) {
+	goos, goarch = runtime.GOOS, runtime.GOARCH
+	if x := os.Getenv("GOOS"); x != "" {
+		goos = x
+	}
+	if x := os.Getenv("GOARCH"); x != "" {
+		goarch = x
+	}
+	return
+}
+
+// releaseFile is a file in the go.dev release list.
+type releaseFile struct {
+	Filename string `json:"filename"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	SHA256   string `json:"sha256"`
+	Kind     string `json:"kind"`
+}
+
+// findRelease returns the binary archive for release tag on goos and
+// goarch, or nil if there is no such archive.
+func findRelease(tag, goos, goarch string) (*releaseFile, error) {
+	resp, err := http.Get(releaseListURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", releaseListURL, resp.Status)
+	}
+	var releases []struct {
+		Version string        `json:"version"`
+		Files   []releaseFile `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("%s: %v", releaseListURL, err)
+	}
+	for _, rel := range releases {
+		if rel.Version != tag {
+			continue
+		}
+		for i, f := range rel.Files {
+			if f.OS == goos && f.Arch == goarch && f.Kind == "archive" && (strings.HasSuffix(f.Filename, ".tar.gz") || strings.HasSuffix(f.Filename, ".zip")) {
+				return &rel.Files[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// archivePath returns the path in dir to unpack archive entry name
+// to. It rejects names that would escape dir, including through a
+// symlink unpacked by an earlier entry.
+func archivePath(dir, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if escapes(clean) {
+		return "", fmt.Errorf("bad path in archive: %s", name)
+	}
+	// Refuse to write through an existing symlink, either in a
+	// parent directory or at the path itself.
+	path := dir
+	for _, elem := range strings.Split(clean, string(filepath.Separator)) {
+		path = filepath.Join(path, elem)
+		st, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if st.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("path in archive goes through a symlink: %s", name)
+		}
+	}
+	return filepath.Join(dir, clean), nil
+}
+
+// escapes reports whether the cleaned relative path clean is
+// absolute or refers outside the directory it is relative to.
+func escapes(clean string) bool {
+	return filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
+
+// unzip unpacks the zip archive f into dir.
+func unzip(f *os.File, dir string) error {
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(f, st.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		path, err := archivePath(dir, zf.Name)
+		if err != nil {
+			return err
+		}
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0777); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return err
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, zf.Mode()&0777|0200)
+		if err != nil {
+			r.Close()
+			return err
+		}
+		_, err = io.Copy(w, r)
+		r.Close()
+		if err1 := w.Close(); err == nil {
+			err = err1
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(path, zf.Modified, zf.Modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// untar unpacks the gzipped tar archive r into dir.
+func untar(r io.Reader, dir string) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	var links []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return checkLinks(dir, links)
+		} else if err != nil {
+			return err
+		}
+
+		path, err := archivePath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0777); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if err1 := f.Close(); err == nil {
+				err = err1
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// The link must point within dir. It is
+			// relative to the directory containing it.
+			rel, err := filepath.Rel(dir, filepath.Join(filepath.Dir(path), filepath.FromSlash(hdr.Linkname)))
+			if err != nil || filepath.IsAbs(filepath.FromSlash(hdr.Linkname)) || escapes(rel) {
+				return fmt.Errorf("bad symlink in archive: %s -> %s", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+			links = append(links, path)
+		}
+	}
+}
+
+// checkLinks checks that each symlink in links resolves to a path in
+// dir. Targets are checked lexically as they are unpacked, but a
+// target can still escape dir by going through another symlink.
+func checkLinks(dir string, links []string) error {
+	if len(links) == 0 {
+		return nil
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		name, _ := filepath.Rel(dir, link)
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			return fmt.Errorf("bad symlink in archive: %s does not resolve", name)
+		}
+		rel, err := filepath.Rel(realDir, target)
+		if err != nil || escapes(rel) {
+			return fmt.Errorf("bad symlink in archive: %s points outside the archive", name)
+		}
+	}
+	return nil
+}
@@ -28,30 +28,110 @@
 //
 //     gover [flags] build [name]
 //
-// Like "save", but first run make.bash in the current tree.
+// Like "save", but first run make.bash (make.bat on Windows) in the
+// current tree.
+//
+//     gover [flags] -rev <rev> build [name]
+//
+// Build and save revision <rev> and, optionally, name it "name". The
+// revision is checked out and built in a temporary git worktree, so
+// this doesn't modify the current tree.
+//
+//     gover [flags] -rev <rev1> -rev <rev2>... build
+//
+// Build and save each revision. Up to -j revisions are built in
+// parallel and their output is interleaved by line, with each line
+// prefixed by its revision.
+//
+// By default, revisions are checked out from the current tree's
+// repository. With -repo, they are instead checked out from another
+// repository, which may be a bare mirror such as one created by "git
+// clone --mirror". This way, gover doesn't need a Go checkout at all
+// and never requires the current tree to be clean. -repo may also be
+// the URL of a remote repository, such as
+// https://go.googlesource.com/go, in which case gover resolves
+// revisions with "git ls-remote" and fetches each commit it builds
+// into a temporary shallow clone. Remote repositories can only
+// resolve branches, tags, and full commit hashes.
+//
+// With the -fetch flag, build first tries to download a prebuilt
+// toolchain for each commit rather than building it. Tagged releases
+// are fetched from the release archives on go.dev and other commits
+// are fetched from the Go builders' snapshots. Release archives are
+// verified against the SHA256 hashes in go.dev's release list.
+// Builder snapshots don't have published hashes, so they are only
+// verified against the MD5 reported by the storage server that serves
+// them. This detects corrupted downloads, but not a snapshot that was
+// tampered with on the server. If there is no prebuilt toolchain for
+// a commit, it is built from source.
 //
 //     gover [flags] <name> <args>...
 //
 // Run "go <args>..." using saved build <name>. <name> may be an
 // unambiguous commit hash or an explicit build name.
 //
+// Builds share a Go build cache in the gover directory (see the
+// -gocache flag), so building a series of nearby revisions only
+// recompiles the packages that changed. With -ccache, C code in the
+// tree is also compiled through ccache. Because make.bash records the
+// C compiler in the toolchain it builds, the saved toolchain will
+// then use ccache by default, too.
+//
+// Build variants
+//
+// Builds of the same commit with different configurations are saved
+// as separate variants. The configuration is given by the
+// GOEXPERIMENT, GO_GCFLAGS, GO_LDFLAGS, and GOROOT_BOOTSTRAP
+// environment variables. A variant is saved as commit@variant, where
+// variant is a hash of its configuration, and "gover list" shows the
+// variant of each build. When <name> is a commit hash without a
+// variant, it refers to the variant for the configuration in the
+// current environment. For example,
+//
+//     GOEXPERIMENT=foo gover build
+//     GOEXPERIMENT=foo gover <hash> test
+//
+// builds and tests the GOEXPERIMENT=foo variant of <hash>.
+//
+//     gover [flags] run <name> <command>...
 //     gover [flags] with <name> <command>...
 //
-// Run <command> with PATH and GOROOT for build <name>.
+// Run <command> with PATH, GOROOT, and GOTOOLCHAIN set to use build
+// <name>. GOTOOLCHAIN is set to "local" so the go command doesn't
+// switch to a different toolchain.
 //
 //     gover [flags] env <name>
 //
 // Print the environment for running commands in build <name>. This is
-// printed as shell code appropriate for eval.
+// printed as shell code appropriate for eval or, on Windows, as
+// cmd.exe "set" commands.
+//
+//     gover [flags] list [-json] [-size] [-sort key]
+//
+// List saved builds with their commit date, names, the branches and
+// tags in the current tree that point to them, and when they were last
+// used. -size also shows the disk space used by each build, which is
+// slower. -sort orders the builds by "date" (the default), "used",
+// "size", or "name". -json prints a JSON object for each build, for
+// use by other tools.
 //
-//     gover [flags] list
+//     gover [flags] du
 //
-// List saved builds.
+// Print the disk usage of each saved build, including the space used
+// only by that build, which would be freed by removing it.
 //
-//     gover [flags] gc
+//     gover [flags] gc [-rm-unlabeled] [-keep n] [-max-size size]
 //
 // Clean the deduplication cache. This is useful after removing saved
-// builds to free up space.
+// builds to free up space. -rm-unlabeled first removes all saved
+// builds that don't have a name. -keep and -max-size first remove
+// unnamed builds, least recently used first, until at most n builds
+// remain and the saved builds use at most size bytes (such as
+// "100GB"). Named builds are never removed by -keep or -max-size.
+//
+// Defaults for these flags, such as -dir and -repo, can be set in the
+// go-misc configuration file. See
+// https://godoc.org/github.com/aclements/go-misc/internal/config.
 //
 //
 // Recipies
@@ -72,6 +152,7 @@ import (
 	"crypto/sha1"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -80,9 +161,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
-	"sort"
 	"strings"
-	"syscall"
+
+	"github.com/aclements/go-misc/internal/config"
+	"github.com/aclements/go-misc/internal/gitutil"
 )
 
 // TODO: Consider also accepting a path for name, which could let this
@@ -90,26 +172,64 @@ import (
 
 // TODO: Half of these global flags only apply to save and build.
 
-// TODO: The hash and diff hash aren't everything. Environment
-// variables like GOEXPERIMENT also affect the build, but right now
-// goenv save will complain that the hash already exists.
-
 var (
 	verbose    = flag.Bool("v", false, "print commands being run")
 	verDir     = flag.String("dir", defaultVerDir(), "`directory` of saved Go roots")
 	noDedup    = flag.Bool("no-dedup", false, "disable deduplication of saved trees")
 	gorootFlag = flag.String("C", defaultGoroot(), "use `dir` as the root of the Go tree for save and build")
+	repoFlag   = flag.String("repo", "", "for build, check out revisions from the git repository in `dir`, which may be a bare clone or a remote URL (default the tree given by -C)")
+	fetch      = flag.Bool("fetch", false, "for build, download prebuilt toolchains when available instead of building")
+	jobs       = flag.Int("j", runtime.NumCPU()/4+1, "build up to `n` revisions in parallel")
+	goCache    = flag.String("gocache", "", "for build, use `dir` as the shared GOCACHE, or \"off\" to use the default GOCACHE (default \"<dir>/_gocache\")")
+	useCcache  = flag.Bool("ccache", false, "for build, compile C code through ccache")
 )
 
+// revFlags is the list of -rev flags.
+var revFlags revList
+
+func init() {
+	flag.Var(&revFlags, "rev", "for build, build `revision` in a temporary worktree instead of building the current tree; may be repeated to build several revisions in parallel")
+}
+
+// revList is a flag.Value that accumulates revisions.
+type revList []string
+
+func (x *revList) String() string {
+	return strings.Join(*x, ",")
+}
+
+func (x *revList) Set(s string) error {
+	*x = append(*x, s)
+	return nil
+}
+
+var cfg = config.NewFlags(flag.CommandLine, "gover", map[string]string{
+	"repo":      "repo",
+	"gover-dir": "dir",
+})
+
 var binTools = []string{"go", "godoc", "gofmt"}
 
+// makeScript and exeSuffix are the name of the script that builds
+// the Go tree and the suffix of executables on this OS.
+var makeScript, exeSuffix = "make.bash", ""
+
+func init() {
+	if runtime.GOOS == "windows" {
+		makeScript, exeSuffix = "make.bat", ".exe"
+	}
+}
+
 func defaultVerDir() string {
 	cache := os.Getenv("XDG_CACHE_HOME")
+	if cache == "" && runtime.GOOS == "windows" {
+		cache = os.Getenv("LocalAppData")
+	}
 	if cache == "" {
 		home := os.Getenv("HOME")
 		if home == "" {
 			u, err := user.Current()
-			if err != nil {
+			if err == nil {
 				home = u.HomeDir
 			}
 		}
@@ -119,21 +239,11 @@ func defaultVerDir() string {
 }
 
 func defaultGoroot() string {
-	c := exec.Command("git", "rev-parse", "--show-cdup")
-	output, err := c.Output()
-	if err != nil {
+	r, err := gitutil.Open(".")
+	if err != nil || r.Bare() || !isGoroot(r.Dir()) {
 		return ""
 	}
-	goroot := strings.TrimSpace(string(output))
-	if goroot == "" {
-		// The empty string is --show-cdup's helpful way of
-		// saying "the current directory".
-		goroot = "."
-	}
-	if !isGoroot(goroot) {
-		return ""
-	}
-	return goroot
+	return r.Dir()
 }
 
 // isGoroot returns true if path is the root of a Go tree. It is
@@ -150,11 +260,14 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage:\n")
 		fmt.Fprintf(os.Stderr, "  %s [flags] save [name] - save Go build tree\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s [flags] build [name] - build and save current tree\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] -rev <rev> build [name] - build and save revision <rev>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] -rev <rev1> -rev <rev2>... build - build and save several revisions\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s [flags] <name> <args>... - run go <args> using build <name>\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s [flags] with <name> <command>... - run <command> using build <name>\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s [flags] env <name> - print the environment for build <name> as shell code\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s [flags] list - list saved builds\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s [flags] gc [-rm-unlabeled] - clean the deduplication cache", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] run|with <name> <command>... - run <command> using build <name>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] env <name> - print the environment for build <name> as shell or cmd.exe code\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] list [-json] [-size] [-sort key] - list saved builds\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] du - print disk usage of saved builds\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] gc [-rm-unlabeled] [-keep n] [-max-size size] - remove saved builds and clean the deduplication cache", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\n\n")
 		fmt.Fprintf(os.Stderr, "<name> may be an unambiguous commit hash or a string name.\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
@@ -162,16 +275,22 @@ func main() {
 	}
 
 	flag.Parse()
+	if err := cfg.Apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 	if flag.NArg() < 1 {
 		flag.Usage()
 		os.Exit(2)
 	}
 
-	// Make gorootFlag absolute.
-	if *gorootFlag != "" {
-		abs, err := filepath.Abs(*gorootFlag)
-		if err != nil {
-			*gorootFlag = abs
+	// Make gorootFlag and repoFlag absolute.
+	for _, dir := range []*string{gorootFlag, repoFlag} {
+		if *dir != "" && !gitutil.IsRemote(*dir) {
+			abs, err := filepath.Abs(*dir)
+			if err == nil {
+				*dir = abs
+			}
 		}
 	}
 
@@ -186,7 +305,23 @@ func main() {
 			flag.Usage()
 			os.Exit(2)
 		}
-		hash, diff := getHash()
+		if len(revFlags) > 0 && flag.Arg(0) != "build" {
+			log.Fatal("-rev only applies to build")
+		}
+		if len(revFlags) > 1 {
+			if flag.NArg() > 1 {
+				log.Fatal("cannot name a build of several revisions")
+			}
+			doBuildRevs(revFlags, *jobs)
+			break
+		}
+		var hash string
+		var diff []byte
+		if len(revFlags) == 1 {
+			hash = resolveRev(revFlags[0])
+		} else {
+			hash, diff = getHash()
+		}
 		name := ""
 		if flag.NArg() >= 2 {
 			name = flag.Arg(1)
@@ -222,7 +357,24 @@ func main() {
 				os.Exit(0)
 			}
 
-			doBuild()
+			if len(revFlags) == 1 {
+				if err := buildRev(hash, os.Stderr); err != nil {
+					log.Fatal(err)
+				}
+			} else {
+				fetched := false
+				if *fetch && diff == nil && currentVariant() == "" {
+					var err error
+					fetched, err = fetchPrebuilt(hash, os.Stderr)
+					if err != nil {
+						log.Fatal(err)
+					}
+				}
+				if !fetched {
+					doBuild()
+					doSave(hash, diff)
+				}
+			}
 		} else {
 			if hashExists {
 				log.Fatalf("saved build `%s' already exists", hash)
@@ -230,8 +382,8 @@ func main() {
 			if nameExists {
 				log.Fatalf("saved build `%s' already exists", name)
 			}
+			doSave(hash, diff)
 		}
-		doSave(hash, diff)
 		if namePath != "" {
 			doLink(hash, namePath)
 		}
@@ -242,13 +394,9 @@ func main() {
 		}
 
 	case "list":
-		if flag.NArg() > 1 {
-			flag.Usage()
-			os.Exit(2)
-		}
-		doList()
+		doListCmd(flag.Args()[1:])
 
-	case "with":
+	case "run", "with":
 		if flag.NArg() < 3 {
 			flag.Usage()
 			os.Exit(2)
@@ -263,13 +411,14 @@ func main() {
 		doEnv(flag.Arg(1))
 
 	case "gc":
-		if flag.NArg() == 2 && flag.Arg(1) == "-rm-unlabeled" {
-			doRemoveUnlabeled()
-		} else if flag.NArg() > 1 {
+		doGCCmd(flag.Args()[1:])
+
+	case "du":
+		if flag.NArg() > 1 {
 			flag.Usage()
 			os.Exit(2)
 		}
-		doGC()
+		doDU()
 
 	default:
 		if flag.NArg() < 2 {
@@ -290,123 +439,242 @@ func goroot() string {
 	return *gorootFlag
 }
 
-func gitCmd(cmd string, args ...string) string {
-	args = append([]string{"-C", goroot(), cmd}, args...)
-	c := exec.Command("git", args...)
-	c.Stderr = os.Stderr
-	output, err := c.Output()
+// gitRepo returns the git repository to check out revisions from.
+// This may be a local directory or the URL of a remote repository
+// (see gitutil.IsRemote).
+func gitRepo() string {
+	if *repoFlag != "" {
+		return *repoFlag
+	}
+	return goroot()
+}
+
+// repoAt returns the local git repository at dir.
+func repoAt(dir string) *gitutil.Repo {
+	r, err := gitutil.Open(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return r
+}
+
+// tagsAt returns the tags in gitRepo() that point to commit.
+func tagsAt(commit string) ([]string, error) {
+	if !gitutil.IsRemote(gitRepo()) {
+		return repoAt(gitRepo()).TagsAt(commit)
+	}
+	refs, err := gitutil.LsRemote(gitRepo(), "refs/tags/*")
 	if err != nil {
-		log.Fatalf("error executing git %s: %s", strings.Join(args, " "), err)
+		return nil, err
+	}
+	var tags []string
+	for _, ref := range refs {
+		if ref.Hash == commit {
+			tags = append(tags, strings.TrimPrefix(ref.Name, "refs/tags/"))
+		}
 	}
-	return string(output)
+	return tags, nil
+}
+
+// gitCmdIn runs git cmd args in the git tree at dir.
+func gitCmdIn(dir, cmd string, args ...string) string {
+	out, err := repoAt(dir).Run(append([]string{cmd}, args...)...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return out
+}
+
+// commitObject returns the git commit object of commit. goroot, the
+// tree being saved, may not be a git tree (for example, if it's a
+// fetched toolchain), so this reads the commit from gitRepo(). If
+// that's remote or doesn't have the commit (for example, gover save
+// of a local commit with -repo set), it reads the commit from goroot
+// if that's a git tree, or otherwise fetches it from the remote.
+func commitObject(goroot, commit string) (string, error) {
+	catFile := func(dir string) (string, error) {
+		r, err := gitutil.Open(dir)
+		if err != nil {
+			return "", err
+		}
+		return r.Run("cat-file", "commit", commit)
+	}
+	var err error
+	if !gitutil.IsRemote(gitRepo()) {
+		var obj string
+		if obj, err = catFile(gitRepo()); err == nil {
+			return obj, nil
+		}
+	}
+	if _, err := os.Stat(filepath.Join(goroot, ".git")); err == nil {
+		return catFile(goroot)
+	}
+	if err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir("", "gover-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	r, err := gitutil.Clone(gitRepo(), tmp, gitutil.CloneOptions{Bare: true, Depth: 1, Rev: commit})
+	if err != nil {
+		return "", err
+	}
+	return r.Run("cat-file", "commit", commit)
 }
 
 func getHash() (string, []byte) {
-	rev := strings.TrimSpace(string(gitCmd("rev-parse", "HEAD")))
+	return getHashIn(goroot())
+}
+
+// getHashIn returns the build key of the Go tree at root, including
+// any uncommitted changes and the build configuration, and the diff of
+// those changes.
+func getHashIn(root string) (string, []byte) {
+	rev := strings.TrimSpace(string(gitCmdIn(root, "rev-parse", "HEAD")))
 
-	diff := []byte(gitCmd("diff", "HEAD"))
+	diff := []byte(gitCmdIn(root, "diff", "HEAD"))
 
 	if len(bytes.TrimSpace(diff)) > 0 {
 		diffHash := fmt.Sprintf("%x", sha1.Sum(diff))
-		return rev + "+" + diffHash[:10], diff
+		return variantKey(rev + "+" + diffHash[:10]), diff
 	}
-	return rev, nil
+	return variantKey(rev), nil
 }
 
 func doBuild() {
-	c := exec.Command("./make.bash")
-	c.Dir = filepath.Join(goroot(), "src")
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
+	if err := makeBash(goroot(), os.Stdout, os.Stderr); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// makeBash runs make.bash (or make.bat on Windows) in the Go tree at
+// root.
+func makeBash(root string, stdout, stderr io.Writer) error {
+	env, err := buildEnv()
+	if err != nil {
+		return err
+	}
+	c := exec.Command(filepath.Join(root, "src", makeScript))
+	c.Dir = filepath.Join(root, "src")
+	c.Env = env
+	c.Stdout = stdout
+	c.Stderr = stderr
 	if err := c.Run(); err != nil {
-		log.Fatalf("error executing make.bash: %s", err)
-		os.Exit(1)
+		return fmt.Errorf("error executing %s: %s", makeScript, err)
+	}
+	return nil
+}
+
+// buildEnv returns the environment for running makeScript. Builds
+// share a GOCACHE so that building nearby revisions can reuse the
+// results of compiling packages that didn't change.
+func buildEnv() ([]string, error) {
+	env := os.Environ()
+
+	cache := *goCache
+	if cache == "" {
+		cache = filepath.Join(*verDir, "_gocache")
+	}
+	if cache != "off" {
+		if err := os.MkdirAll(cache, 0777); err != nil {
+			return nil, err
+		}
+		env = append(env, "GOCACHE="+cache)
+	}
+
+	if *useCcache {
+		ccache, err := exec.LookPath("ccache")
+		if err != nil {
+			return nil, err
+		}
+		cc := os.Getenv("CC")
+		if cc == "" {
+			cc = "cc"
+		}
+		// Revisions are built in temporary worktrees, so
+		// let ccache hit across different worktree paths.
+		env = append(env, "CC="+ccache+" "+cc, "CCACHE_BASEDIR="+os.TempDir())
 	}
+	return env, nil
 }
 
 func doSave(hash string, diff []byte) {
+	if err := saveTree(goroot(), hash, diff); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// saveTree saves the built Go tree at goroot as build hash. If this
+// fails, it removes the partially saved build.
+func saveTree(goroot, hash string, diff []byte) (err error) {
 	// Create a minimal GOROOT at $GOROOT/gover/hash.
 	savePath, _ := resolveName(hash)
-	goos, goarch := runtime.GOOS, runtime.GOARCH
-	if x := os.Getenv("GOOS"); x != "" {
-		goos = x
-	}
-	if x := os.Getenv("GOARCH"); x != "" {
-		goarch = x
-	}
+	goos, goarch := targetOSArch()
 	osArch := goos + "_" + goarch
+	defer func() {
+		if err != nil {
+			os.RemoveAll(savePath)
+		}
+	}()
 
-	goroot := goroot()
 	for _, binTool := range binTools {
-		src := filepath.Join(goroot, "bin", binTool)
+		src := filepath.Join(goroot, "bin", binTool+exeSuffix)
 		if _, err := os.Stat(src); err == nil {
-			cp(src, filepath.Join(savePath, "bin", binTool))
+			if err := cp(src, filepath.Join(savePath, "bin", binTool+exeSuffix)); err != nil {
+				return err
+			}
+		}
+	}
+	dirs := []string{
+		filepath.Join("pkg", osArch),
+		filepath.Join("pkg", "tool", osArch),
+		filepath.Join("pkg", "include"),
+		// TODO: Use "go list" and save only the stuff
+		// depended on? Or maybe just save the types of files
+		// go list can return, plus "testdata" directories?
+		"src",
+		// Tracer static resources.
+		filepath.Join("misc", "trace"),
+	}
+	for _, dir := range dirs {
+		if err := cpR(filepath.Join(goroot, dir), filepath.Join(savePath, dir)); err != nil {
+			return err
 		}
 	}
-	cpR(filepath.Join(goroot, "pkg", osArch), filepath.Join(savePath, "pkg", osArch))
-	cpR(filepath.Join(goroot, "pkg", "tool", osArch), filepath.Join(savePath, "pkg", "tool", osArch))
-	cpR(filepath.Join(goroot, "pkg", "include"), filepath.Join(savePath, "pkg", "include"))
-	// TODO: Use "go list" and save only the stuff depended on? Or
-	// maybe just save the types of files go list can return, plus
-	// "testdata" directories?
-	cpR(filepath.Join(goroot, "src"), filepath.Join(savePath, "src"))
-	// Copy tracer static resources.
-	cpR(filepath.Join(goroot, "misc", "trace"), filepath.Join(savePath, "misc", "trace"))
 
 	if diff != nil {
 		if err := ioutil.WriteFile(filepath.Join(savePath, "diff"), diff, 0666); err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
 
 	// Save commit object.
-	commit := gitCmd("cat-file", "commit", "HEAD")
+	commit, err := commitObject(goroot, hashPlusRe.FindStringSubmatch(hash)[1])
+	if err != nil {
+		return err
+	}
 	if err := ioutil.WriteFile(filepath.Join(savePath, "commit"), []byte(commit), 0666); err != nil {
-		log.Fatal(err)
+		return err
 	}
-}
 
-func doLink(hash, namePath string) {
-	err := os.Symlink(hash, namePath)
-	if err != nil {
-		log.Fatal(err)
+	// Save the build configuration.
+	if config := variantConfig(); config != "" {
+		if err := ioutil.WriteFile(filepath.Join(savePath, "variant"), []byte(config), 0666); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-type buildInfoSorter []*buildInfo
-
-func (s buildInfoSorter) Len() int {
-	return len(s)
-}
-
-func (s buildInfoSorter) Less(i, j int) bool {
-	return s[i].commit.authorDate.Before(s[j].commit.authorDate)
-}
-
-func (s buildInfoSorter) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-func doList() {
-	builds, err := listBuilds(listNames | listCommit)
+func doLink(hash, namePath string) {
+	err := os.Symlink(hash, namePath)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	sort.Sort(buildInfoSorter(builds))
-
-	for _, info := range builds {
-		fmt.Print(info.shortName())
-		if !info.commit.authorDate.IsZero() {
-			fmt.Printf(" %s", info.commit.authorDate.Local().Format("2006-01-02T15:04:05"))
-		}
-		if len(info.names) > 0 {
-			fmt.Printf(" %s", info.names)
+		if runtime.GOOS == "windows" {
+			log.Fatalf("%v (naming builds requires Developer Mode or administrator privileges on Windows)", err)
 		}
-		if info.commit.topLine != "" {
-			fmt.Printf(" %s", info.commit.topLine)
-		}
-		fmt.Println()
+		log.Fatal(err)
 	}
 }
 
@@ -416,6 +684,7 @@ func doWith(name string, cmd []string) {
 		log.Fatalf("unknown name `%s'", name)
 	}
 	goroot, path := getEnv(savePath)
+	markUsed(savePath)
 
 	// exec.Command looks up the command in this process' PATH.
 	// Unfortunately, this is a rather complex process and there's
@@ -426,16 +695,22 @@ func doWith(name string, cmd []string) {
 
 	// Build the rest of the command environment.
 	for _, env := range os.Environ() {
-		if strings.HasPrefix(env, "GOROOT=") {
+		if strings.HasPrefix(env, "GOROOT=") || strings.HasPrefix(env, "GOTOOLCHAIN=") {
 			continue
 		}
 		c.Env = append(c.Env, env)
 	}
-	c.Env = append(c.Env, "GOROOT="+goroot)
+	c.Env = append(c.Env, "GOROOT="+goroot, "GOTOOLCHAIN=local")
 
 	// Run command.
 	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
 	if err := c.Run(); err != nil {
+		if err, ok := err.(*exec.ExitError); ok {
+			// Pass along the command's exit status.
+			if code := err.ExitCode(); code >= 0 {
+				os.Exit(code)
+			}
+		}
 		fmt.Printf("command failed: %s\n", err)
 		os.Exit(1)
 	}
@@ -448,9 +723,18 @@ func doEnv(name string) {
 	}
 
 	goroot, path := getEnv(savePath)
+	markUsed(savePath)
+	if runtime.GOOS == "windows" {
+		// Print cmd.exe commands.
+		fmt.Printf("set PATH=%s\n", path)
+		fmt.Printf("set GOROOT=%s\n", goroot)
+		fmt.Printf("set GOTOOLCHAIN=local\n")
+		return
+	}
 	fmt.Printf("PATH=%s;\n", shellEscape(path))
 	fmt.Printf("GOROOT=%s;\n", shellEscape(goroot))
-	fmt.Printf("export GOROOT;\n")
+	fmt.Printf("GOTOOLCHAIN=local;\n")
+	fmt.Printf("export GOROOT GOTOOLCHAIN;\n")
 }
 
 // getEnv returns the GOROOT and PATH for the Go tree rooted at savePath.
@@ -493,14 +777,14 @@ var goodDedupPath = regexp.MustCompile("/[0-9a-f]{2}/[0-9a-f]{38}$")
 func doGC() {
 	removed, space := 0, int64(0)
 	filepath.Walk(filepath.Join(*verDir, "_dedup"), func(path string, info os.FileInfo, err error) error {
-		if info.IsDir() {
+		if err != nil || info.IsDir() {
 			return nil
 		}
-		st, ok := info.Sys().(*syscall.Stat_t)
-		if !ok || st.Nlink != 1 {
+		_, nlink, ok := statLinks(path, info)
+		if !ok || nlink != 1 {
 			return nil
 		}
-		if !goodDedupPath.MatchString(path) {
+		if !goodDedupPath.MatchString(filepath.ToSlash(path)) {
 			// Be paranoid about removing files.
 			log.Printf("unexpected file in dedup cache: %s\n", path)
 			return nil
@@ -516,10 +800,10 @@ func doGC() {
 	fmt.Printf("removed %d MB in %d unused file(s)\n", space>>20, removed)
 }
 
-func cp(src, dst string) {
+func cp(src, dst string) error {
 	data, err := ioutil.ReadFile(src)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	writeFile, xdst := true, dst
@@ -536,16 +820,34 @@ func cp(src, dst string) {
 		}
 		st, err := os.Stat(src)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 		if err := os.MkdirAll(filepath.Dir(xdst), 0777); err != nil {
-			log.Fatal(err)
+			return err
 		}
-		if err := ioutil.WriteFile(xdst, data, st.Mode()); err != nil {
-			log.Fatal(err)
+		// Write to a temporary file and rename it into place
+		// in case another build is saving the same file.
+		f, err := ioutil.TempFile(filepath.Dir(xdst), ".tmp")
+		if err != nil {
+			return err
 		}
-		if err := os.Chtimes(xdst, st.ModTime(), st.ModTime()); err != nil {
-			log.Fatal(err)
+		tmp := f.Name()
+		defer os.Remove(tmp) // No-op once it's renamed.
+		_, err = f.Write(data)
+		if err == nil {
+			err = f.Chmod(st.Mode())
+		}
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(tmp, st.ModTime(), st.ModTime()); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, xdst); err != nil {
+			return err
 		}
 	}
 
@@ -554,25 +856,25 @@ func cp(src, dst string) {
 			fmt.Printf("ln %s %s\n", xdst, dst)
 		}
 		if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
-			log.Fatal(err)
+			return err
 		}
 		if err := os.Link(xdst, dst); err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
+	return nil
 }
 
-func cpR(src, dst string) {
-	filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+func cpR(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() {
 			return nil
 		}
 		base := filepath.Base(path)
-		if base == "core" || strings.HasSuffix(base, ".test") {
+		if base == "core" || strings.HasSuffix(base, ".test") || strings.HasSuffix(base, ".test.exe") {
 			return nil
 		}
 
-		cp(path, dst+path[len(src):])
-		return nil
+		return cp(path, dst+path[len(src):])
 	})
 }
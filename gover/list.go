@@ -0,0 +1,192 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aclements/go-misc/internal/gitutil"
+)
+
+// listEntry is the JSON form of a saved build printed by "list -json".
+type listEntry struct {
+	// Name is the full name of the build.
+	Name string
+
+	// Commit, Delta, and Variant are the components of Name.
+	Commit  string
+	Delta   string `json:",omitempty"`
+	Variant string `json:",omitempty"`
+
+	// VariantConfig is the build configuration of this variant.
+	VariantConfig string `json:",omitempty"`
+
+	// Path is the GOROOT of this build.
+	Path string
+
+	// Names are the names given to this build by save or build.
+	Names []string `json:",omitempty"`
+
+	// Refs are the branches and tags in the current tree that
+	// point to Commit.
+	Refs []string `json:",omitempty"`
+
+	// Date is the author date of Commit and Subject is the first
+	// line of its commit message.
+	Date    time.Time
+	Subject string
+
+	// LastUsed is when this build was last used.
+	LastUsed time.Time
+
+	// Size is the disk space used by this build and UniqueSize is
+	// the disk space used only by this build. These are only set
+	// with -size.
+	Size       int64 `json:",omitempty"`
+	UniqueSize int64 `json:",omitempty"`
+
+	info *buildInfo
+}
+
+// doListCmd implements the list subcommand.
+func doListCmd(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print builds as JSON")
+	withSize := fs.Bool("size", false, "show the disk usage of each build")
+	sortKey := fs.String("sort", "date", "sort builds by `key`: date, used, size, or name")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var less func(a, b *listEntry) bool
+	switch *sortKey {
+	case "date":
+		less = func(a, b *listEntry) bool { return a.Date.Before(b.Date) }
+	case "used":
+		less = func(a, b *listEntry) bool { return a.LastUsed.Before(b.LastUsed) }
+	case "size":
+		*withSize = true
+		less = func(a, b *listEntry) bool { return a.Size < b.Size }
+	case "name":
+		less = func(a, b *listEntry) bool { return a.Name < b.Name }
+	default:
+		log.Fatalf("unknown sort key `%s'", *sortKey)
+	}
+
+	builds, err := listBuilds(listNames | listCommit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var du *diskUsage
+	if *withSize {
+		du = newDiskUsage(builds)
+	}
+	refs := refsByCommit()
+
+	var entries []*listEntry
+	for _, b := range builds {
+		e := &listEntry{
+			Name:     b.fullName(),
+			Commit:   b.commitHash,
+			Delta:    b.deltaHash,
+			Variant:  b.variant,
+			Path:     b.path,
+			Names:    b.names,
+			Refs:     refs[b.commitHash],
+			Date:     b.commit.authorDate,
+			Subject:  b.commit.topLine,
+			LastUsed: lastUsed(b.path),
+			info:     b,
+		}
+		if b.variant != "" {
+			config, _ := ioutil.ReadFile(filepath.Join(b.path, "variant"))
+			e.VariantConfig = string(config)
+		}
+		if du != nil {
+			e.Size, e.UniqueSize = du.build(b.path)
+		}
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	for _, e := range entries {
+		fmt.Print(e.info.shortName())
+		if !e.Date.IsZero() {
+			fmt.Printf(" %s", e.Date.Local().Format("2006-01-02T15:04:05"))
+		}
+		if len(e.Names) > 0 {
+			fmt.Printf(" %s", e.Names)
+		}
+		if len(e.Refs) > 0 {
+			fmt.Printf(" (%s)", strings.Join(e.Refs, ", "))
+		}
+		if !e.LastUsed.IsZero() {
+			fmt.Printf(" used %s", e.LastUsed.Local().Format("2006-01-02"))
+		}
+		if du != nil {
+			fmt.Printf(" %d MB", e.Size>>20)
+		}
+		if e.Subject != "" {
+			fmt.Printf(" %s", e.Subject)
+		}
+		fmt.Println()
+	}
+}
+
+// refsByCommit returns the branches and tags in the git repository
+// given by -repo or -C, indexed by the commit hash they point to. If
+// there is no such repository, it returns nil.
+func refsByCommit() map[string][]string {
+	repo := *repoFlag
+	if repo == "" {
+		repo = *gorootFlag
+	}
+	if repo == "" {
+		return nil
+	}
+	if gitutil.IsRemote(repo) {
+		remoteRefs, err := gitutil.LsRemote(repo, "refs/heads/*", "refs/tags/*")
+		if err != nil {
+			return nil
+		}
+		refs := make(map[string][]string)
+		for _, ref := range remoteRefs {
+			name := strings.TrimPrefix(strings.TrimPrefix(ref.Name, "refs/heads/"), "refs/tags/")
+			refs[ref.Hash] = append(refs[ref.Hash], name)
+		}
+		return refs
+	}
+	r, err := gitutil.Open(repo)
+	if err != nil {
+		return nil
+	}
+	refs, err := r.Refs()
+	if err != nil {
+		return nil
+	}
+	return refs
+}
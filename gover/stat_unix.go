@@ -0,0 +1,23 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// statLinks returns the identity and hard link count of the file at
+// path, which has FileInfo info. ok is false if these aren't
+// available.
+func statLinks(path string, info os.FileInfo) (id fileID, nlink uint64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, uint64(st.Nlink), true
+}
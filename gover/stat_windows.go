@@ -0,0 +1,30 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// statLinks returns the identity and hard link count of the file at
+// path, which has FileInfo info. ok is false if these aren't
+// available.
+//
+// Windows doesn't report these in FileInfo, so this opens the file
+// to query them.
+func statLinks(path string, info os.FileInfo) (id fileID, nlink uint64, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileID{}, 0, false
+	}
+	defer f.Close()
+	var d syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &d); err != nil {
+		return fileID{}, 0, false
+	}
+	id = fileID{uint64(d.VolumeSerialNumber), uint64(d.FileIndexHigh)<<32 | uint64(d.FileIndexLow)}
+	return id, uint64(d.NumberOfLinks), true
+}
@@ -0,0 +1,258 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lastUsedFile is the name of the file in a saved build whose
+// modification time records when the build was last used.
+const lastUsedFile = "last-used"
+
+// markUsed records that the build at savePath was just used.
+func markUsed(savePath string) {
+	path := filepath.Join(savePath, lastUsedFile)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); os.IsNotExist(err) {
+		if f, err := os.Create(path); err == nil {
+			f.Close()
+		}
+	}
+}
+
+// lastUsed returns the time the build at savePath was last used. If
+// it has never been used, this is the time it was saved.
+func lastUsed(savePath string) time.Time {
+	for _, name := range []string{lastUsedFile, "commit"} {
+		if st, err := os.Stat(filepath.Join(savePath, name)); err == nil {
+			return st.ModTime()
+		}
+	}
+	return time.Time{}
+}
+
+// diskUsage records the disk usage of a set of saved builds. Saved
+// builds share files through hard links, so it tracks the files
+// referenced by each build.
+type diskUsage struct {
+	// files maps from each build path to the files it uses.
+	files map[string][]fileID
+
+	// size is the size of each file.
+	size map[fileID]int64
+
+	// refs is the number of builds that use each file.
+	refs map[fileID]int
+}
+
+type fileID struct {
+	dev, ino uint64
+}
+
+func newDiskUsage(builds []*buildInfo) *diskUsage {
+	du := &diskUsage{
+		files: make(map[string][]fileID),
+		size:  make(map[fileID]int64),
+		refs:  make(map[fileID]int),
+	}
+	for _, b := range builds {
+		var files []fileID
+		seen := make(map[fileID]bool)
+		filepath.Walk(b.path, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			id, _, ok := statLinks(path, info)
+			if !ok {
+				return nil
+			}
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+			files = append(files, id)
+			du.size[id] = info.Size()
+			du.refs[id]++
+			return nil
+		})
+		du.files[b.path] = files
+	}
+	return du
+}
+
+// total returns the total size of the files used by all builds.
+func (du *diskUsage) total() int64 {
+	var total int64
+	for id, size := range du.size {
+		if du.refs[id] > 0 {
+			total += size
+		}
+	}
+	return total
+}
+
+// build returns the total size of the files used by the build at
+// path and the size of the files used only by that build.
+func (du *diskUsage) build(path string) (size, unique int64) {
+	for _, id := range du.files[path] {
+		size += du.size[id]
+		if du.refs[id] == 1 {
+			unique += du.size[id]
+		}
+	}
+	return
+}
+
+// remove removes the build at path from du.
+func (du *diskUsage) remove(path string) {
+	for _, id := range du.files[path] {
+		du.refs[id]--
+	}
+	delete(du.files, path)
+}
+
+// parseSize parses a size like "100GB". Units are powers of 1024.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	num, mult := strings.ToUpper(s), int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSuffix(num, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size `%s'", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+func doDU() {
+	builds, err := listBuilds(listNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	du := newDiskUsage(builds)
+
+	type row struct {
+		info         *buildInfo
+		size, unique int64
+	}
+	var rows []row
+	for _, b := range builds {
+		size, unique := du.build(b.path)
+		rows = append(rows, row{b, size, unique})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].size > rows[j].size })
+
+	for _, r := range rows {
+		fmt.Printf("%s %6d MB %6d MB unique %s", r.info.shortName(), r.size>>20, r.unique>>20, lastUsed(r.info.path).Local().Format("2006-01-02"))
+		if len(r.info.names) > 0 {
+			fmt.Printf(" %s", r.info.names)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("total %d MB\n", du.total()>>20)
+}
+
+// doGCCmd implements the gc subcommand.
+func doGCCmd(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	rmUnlabeled := fs.Bool("rm-unlabeled", false, "remove all unlabeled saved builds")
+	keep := fs.Int("keep", -1, "remove least recently used unlabeled builds until at most `n` builds remain")
+	maxSizeFlag := fs.String("max-size", "", "remove least recently used unlabeled builds until the total size is at most `size`, such as 100GB")
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *rmUnlabeled {
+		doRemoveUnlabeled()
+	}
+	if *keep >= 0 || *maxSizeFlag != "" {
+		maxSize := int64(-1)
+		if *maxSizeFlag != "" {
+			var err error
+			maxSize, err = parseSize(*maxSizeFlag)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		doRemoveLRU(*keep, maxSize)
+	}
+	doGC()
+}
+
+// doRemoveLRU removes unlabeled builds, least recently used first,
+// until at most keep builds remain and the builds use at most maxSize
+// bytes. Either limit may be negative to disable it. Labeled builds
+// are never removed.
+func doRemoveLRU(keep int, maxSize int64) {
+	builds, err := listBuilds(listNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var du *diskUsage
+	var total int64
+	if maxSize >= 0 {
+		du = newDiskUsage(builds)
+		total = du.total()
+	}
+
+	var candidates []*buildInfo
+	for _, b := range builds {
+		if len(b.names) == 0 {
+			candidates = append(candidates, b)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return lastUsed(candidates[i].path).Before(lastUsed(candidates[j].path))
+	})
+
+	remaining, rms := len(builds), 0
+	for _, b := range candidates {
+		if (keep < 0 || remaining <= keep) && (maxSize < 0 || total <= maxSize) {
+			break
+		}
+		if *verbose {
+			fmt.Printf("rm -r %s\n", b.path)
+		}
+		if err := os.RemoveAll(b.path); err != nil {
+			// Not fatal.
+			log.Println(err)
+			continue
+		}
+		remaining--
+		rms++
+		if du != nil {
+			_, unique := du.build(b.path)
+			total -= unique
+			du.remove(b.path)
+		}
+	}
+	fmt.Printf("removed %d least recently used saved build(s)\n", rms)
+	if keep >= 0 && remaining > keep || maxSize >= 0 && total > maxSize {
+		log.Printf("limits not met: remaining builds are labeled")
+	}
+}
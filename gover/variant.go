@@ -0,0 +1,62 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// variantEnv lists the environment variables that affect the result
+// of a build. Builds of the same commit with different values of
+// these variables are saved as different variants.
+var variantEnv = []string{"GOEXPERIMENT", "GO_GCFLAGS", "GO_LDFLAGS", "GOROOT_BOOTSTRAP"}
+
+// variantConfig returns a description of the build configuration
+// given by the environment, or "" for the default configuration.
+func variantConfig() string {
+	var config []string
+	for _, env := range variantEnv {
+		val := os.Getenv(env)
+		if val == "" {
+			continue
+		}
+		config = append(config, env+"="+val)
+		if env == "GOROOT_BOOTSTRAP" {
+			// Identify the bootstrap toolchain by its version,
+			// too, since the path may be reused.
+			if v, err := ioutil.ReadFile(filepath.Join(val, "VERSION")); err == nil {
+				config = append(config, "# "+strings.SplitN(string(v), "\n", 2)[0])
+			}
+		}
+	}
+	if len(config) == 0 {
+		return ""
+	}
+	return strings.Join(config, "\n") + "\n"
+}
+
+// currentVariant returns the variant hash of the build configuration
+// given by the environment, or "" for the default configuration.
+func currentVariant() string {
+	config := variantConfig()
+	if config == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(config)))[:10]
+}
+
+// variantKey returns the build key for key in the current build
+// configuration.
+func variantKey(key string) string {
+	if v := currentVariant(); v != "" {
+		return key + "@" + v
+	}
+	return key
+}
@@ -13,6 +13,10 @@ import (
 )
 
 func canColor() bool {
+	// See https://no-color.org/.
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
 	if os.Getenv("TERM") == "" || os.Getenv("TERM") == "dumb" {
 		return false
 	}
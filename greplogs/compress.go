@@ -0,0 +1,64 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// compressedExts lists the file extensions of compressed logs that
+// readLog decompresses.
+var compressedExts = []string{".gz", ".zst"}
+
+// readLog returns the contents of the log at path, decompressing it
+// if it is compressed.
+//
+// zstd-compressed logs are decompressed using the zstd command.
+func readLog(path string) ([]byte, error) {
+	switch filepath.Ext(path) {
+	case ".gz":
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+
+	case ".zst":
+		var stderr bytes.Buffer
+		cmd := exec.Command("zstd", "-d", "-c", "-q", path)
+		cmd.Stderr = &stderr
+		data, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %v\n%s", err, stderr.Bytes())
+		}
+		return data, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+// logName returns the name of the log at path without any
+// compression extension.
+func logName(path string) string {
+	base := filepath.Base(path)
+	for _, ext := range compressedExts {
+		if strings.HasSuffix(base, ext) {
+			return strings.TrimSuffix(base, ext)
+		}
+	}
+	return base
+}
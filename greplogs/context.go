@@ -0,0 +1,149 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/aclements/go-misc/internal/loganal"
+)
+
+// contextBlock is a region of a log to print.
+type contextBlock struct {
+	msg []byte
+
+	// start is the offset of msg in the log, or -1 if msg is not
+	// part of the log.
+	start int
+
+	// failure is the failure this block shows, or nil if this
+	// block is raw context lines.
+	failure *loganal.Failure
+}
+
+// failureBlocks returns a block for each failure in failures, which
+// were extracted from data.
+func failureBlocks(data []byte, failures []*loganal.Failure) []contextBlock {
+	offsets := failureOffsets(data, failures)
+	blocks := make([]contextBlock, len(failures))
+	for i, failure := range failures {
+		if failure.FullMessage != "" {
+			blocks[i].msg = []byte(failure.FullMessage)
+		} else {
+			blocks[i].msg = []byte(failure.Message)
+		}
+		blocks[i].start = offsets[i]
+		blocks[i].failure = failure
+	}
+	return blocks
+}
+
+// failureOffsets returns the offset in data of the full message of
+// each of failures, or -1 if it isn't found.
+func failureOffsets(data []byte, failures []*loganal.Failure) []int {
+	offsets := make([]int, len(failures))
+	pos := 0
+	for i, failure := range failures {
+		offsets[i] = -1
+		if failure.FullMessage == "" {
+			continue
+		}
+		msg := []byte(failure.FullMessage)
+		j := bytes.Index(data[pos:], msg)
+		if j >= 0 {
+			j += pos
+		} else if j = bytes.Index(data, msg); j < 0 {
+			// The log may have been canonicalized
+			// during extraction.
+			continue
+		}
+		offsets[i] = j
+		pos = j + len(msg)
+	}
+	return offsets
+}
+
+// matchBlocks returns a block around each search match in data. If
+// expand is true, a match that lands inside one of failures is
+// expanded to that entire failure. Otherwise, or if the match is not
+// in a failure, the block consists of the lines of the match plus
+// lines of context before and after it. Overlapping blocks are
+// merged.
+func matchBlocks(data []byte, failures []*loganal.Failure, expand bool, lines int) []contextBlock {
+	type span struct {
+		start, end int
+		failure    *loganal.Failure
+	}
+
+	// Find the extent of each failure in the log.
+	var fspans []span
+	if expand {
+		for i, off := range failureOffsets(data, failures) {
+			if off >= 0 {
+				fspans = append(fspans, span{off, off + len(failures[i].FullMessage), failures[i]})
+			}
+		}
+	}
+
+	// Compute the span around each match.
+	matches := allMatches(data)
+	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+	var spans []span
+	for _, m := range matches {
+		var sp *span
+		for i := range fspans {
+			if fspans[i].start <= m[0] && m[0] < fspans[i].end {
+				sp = &fspans[i]
+				break
+			}
+		}
+		if sp != nil {
+			spans = append(spans, *sp)
+			continue
+		}
+
+		start := bytes.LastIndexByte(data[:m[0]], '\n') + 1
+		for i := 0; i < lines && start > 0; i++ {
+			start = bytes.LastIndexByte(data[:start-1], '\n') + 1
+		}
+		end := m[1]
+		if end > m[0] && data[end-1] == '\n' {
+			end--
+		}
+		end = lineEnd(data, end)
+		for i := 0; i < lines && end < len(data); i++ {
+			end = lineEnd(data, end+1)
+		}
+		spans = append(spans, span{start, end, nil})
+	}
+
+	// Merge overlapping spans.
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var blocks []contextBlock
+	for i := 0; i < len(spans); {
+		sp := spans[i]
+		for i++; i < len(spans) && spans[i].start <= sp.end; i++ {
+			if spans[i].end > sp.end {
+				sp.end = spans[i].end
+			}
+			if spans[i].start != sp.start || spans[i].end != sp.end || spans[i].failure != sp.failure {
+				sp.failure = nil
+			}
+		}
+		blocks = append(blocks, contextBlock{data[sp.start:sp.end], sp.start, sp.failure})
+	}
+	return blocks
+}
+
+// lineEnd returns the offset of the end of the line containing
+// offset pos, not including the newline.
+func lineEnd(data []byte, pos int) int {
+	i := bytes.IndexByte(data[pos:], '\n')
+	if i < 0 {
+		return len(data)
+	}
+	return pos + i
+}
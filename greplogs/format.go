@@ -0,0 +1,130 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// logMeta describes the build that produced a log.
+type logMeta struct {
+	// Path is the path of the log. For dashboard logs, this is
+	// relative to the fetchlogs directory.
+	Path string
+
+	// LogURL, Builder, Repo, and Revision identify the build that
+	// produced this log, if known.
+	LogURL   string `json:",omitempty"`
+	Builder  string `json:",omitempty"`
+	Repo     string `json:",omitempty"`
+	Revision string `json:",omitempty"`
+}
+
+// readLogMeta returns the metadata for log f. For dashboard logs, this
+// is read from the revision metadata saved by fetchlogs.
+func readLogMeta(f *logFile, logURL string) logMeta {
+	meta := logMeta{Path: f.nicePath, LogURL: logURL}
+	if f.remote != nil {
+		meta.Builder, meta.Repo, meta.Revision = f.remote.builder, f.remote.repo, f.remote.revision
+		return meta
+	}
+
+	var rev struct {
+		Repo, Revision string
+	}
+	if r, err := os.Open(filepath.Join(filepath.Dir(f.path), ".rev.json")); err == nil {
+		if json.NewDecoder(r).Decode(&rev) == nil {
+			meta.Builder = logName(f.path)
+			meta.Repo, meta.Revision = rev.Repo, rev.Revision
+		}
+		r.Close()
+	}
+	return meta
+}
+
+// headerFormat is the template given by -format, or nil to use the
+// default header.
+var headerFormat *template.Template
+
+func parseFormat(format string) error {
+	funcs := template.FuncMap{
+		"path":    func(s string) string { return color.color(s, colorPath) },
+		"builder": func(s string) string { return color.color(s, colorBuilder) },
+		"rev":     func(s string) string { return color.color(s, colorRevision) },
+	}
+	tmpl, err := template.New("format").Funcs(funcs).Parse(format)
+	if err != nil {
+		return err
+	}
+	headerFormat = tmpl
+	return nil
+}
+
+// header returns the header line to print before the output for the
+// log described by meta. printPath is the path to show by default. If
+// colon is true, the default header is followed by a colon.
+func header(meta logMeta, printPath string, colon bool) string {
+	if headerFormat != nil {
+		var buf bytes.Buffer
+		if err := headerFormat.Execute(&buf, meta); err != nil {
+			return err.Error()
+		}
+		return buf.String()
+	}
+
+	h := colorPathFields(printPath, meta)
+	if colon {
+		h += color.color(":", colorPathColon)
+	}
+	return h
+}
+
+// colorPathFields highlights path, distinguishing the commit hash and
+// builder name within path from the rest of the path.
+func colorPathFields(path string, meta logMeta) string {
+	if !color.enabled {
+		return path
+	}
+
+	type field struct {
+		start, end int
+		flags      colorFlags
+	}
+	var fields []field
+	if meta.Revision != "" {
+		// Paths typically contain an abbreviated hash.
+		for n := len(meta.Revision); n >= 7; n-- {
+			if i := strings.Index(path, meta.Revision[:n]); i >= 0 {
+				fields = append(fields, field{i, i + n, colorRevision})
+				break
+			}
+		}
+	}
+	if meta.Builder != "" {
+		if i := strings.LastIndex(path, meta.Builder); i >= 0 {
+			if len(fields) == 0 || i >= fields[0].end {
+				fields = append(fields, field{i, i + len(meta.Builder), colorBuilder})
+			}
+		}
+	}
+
+	out, pos := "", 0
+	for _, f := range fields {
+		if pos < f.start {
+			out += color.color(path[pos:f.start], colorPath)
+		}
+		out += color.color(path[f.start:f.end], f.flags)
+		pos = f.end
+	}
+	if pos < len(path) {
+		out += color.color(path[pos:], colorPath)
+	}
+	return out
+}
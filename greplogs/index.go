@@ -0,0 +1,395 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// trigramIndex is a persistent index from each trigram to the set of
+// logs containing that trigram. This is used to quickly eliminate
+// logs that cannot match the search regexps, in the style of
+// codesearch.
+//
+// The index is updated incrementally: logs that are new or have
+// changed since they were indexed are indexed before searching.
+type trigramIndex struct {
+	path string
+
+	// Files maps from absolute log path to its index entry.
+	Files map[string]*indexedFile
+
+	// Posts maps from trigram to the sorted IDs of the files
+	// containing that trigram. This may contain IDs of files that
+	// have since been re-indexed, which must be ignored.
+	Posts map[uint32][]uint32
+
+	// NextID is the next unused file ID.
+	NextID uint32
+
+	dirty bool
+}
+
+type indexedFile struct {
+	ID      uint32
+	Size    int64
+	ModTime time.Time
+}
+
+const trigramIndexVersion = 1
+
+type trigramIndexFile struct {
+	Version int
+	Index   *trigramIndex
+}
+
+func defaultIndexPath() string {
+	return filepath.Join(xdgCacheDir(), "greplogs", "index")
+}
+
+// openTrigramIndex loads the index at path. If there is no index at
+// path or it cannot be read, it returns an empty index.
+func openTrigramIndex(path string) *trigramIndex {
+	idx := &trigramIndex{
+		path:  path,
+		Files: make(map[string]*indexedFile),
+		Posts: make(map[uint32][]uint32),
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return idx
+	}
+	defer f.Close()
+	var file trigramIndexFile
+	if err := gob.NewDecoder(f).Decode(&file); err != nil || file.Version != trigramIndexVersion || file.Index == nil {
+		return idx
+	}
+	file.Index.path = path
+	if file.Index.Files == nil {
+		file.Index.Files = make(map[string]*indexedFile)
+	}
+	if file.Index.Posts == nil {
+		file.Index.Posts = make(map[uint32][]uint32)
+	}
+	return file.Index
+}
+
+// save writes the index back to disk if it has changed.
+func (idx *trigramIndex) save() error {
+	if !idx.dirty {
+		return nil
+	}
+	idx.compact()
+	if err := xdgCreateDir(filepath.Dir(idx.path)); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(idx.path), filepath.Base(idx.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(&trigramIndexFile{trigramIndexVersion, idx}); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), idx.path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	idx.dirty = false
+	return nil
+}
+
+// compact removes stale file IDs from the posting lists if they make
+// up a significant fraction of the index.
+func (idx *trigramIndex) compact() {
+	if uint32(len(idx.Files)) > idx.NextID/2 {
+		return
+	}
+	live := make(map[uint32]bool, len(idx.Files))
+	for _, f := range idx.Files {
+		live[f.ID] = true
+	}
+	for tri, ids := range idx.Posts {
+		out := ids[:0]
+		for _, id := range ids {
+			if live[id] {
+				out = append(out, id)
+			}
+		}
+		if len(out) == 0 {
+			delete(idx.Posts, tri)
+		} else {
+			idx.Posts[tri] = out
+		}
+	}
+}
+
+// update indexes any of files that are new or have changed since
+// they were last indexed.
+func (idx *trigramIndex) update(files []logFile) {
+	type result struct {
+		path string
+		ent  *indexedFile
+		tris []uint32
+	}
+	todo := make(chan string)
+	results := make(chan result)
+	go func() {
+		for i := range files {
+			if files[i].err != nil {
+				continue
+			}
+			path, err := filepath.Abs(files[i].path)
+			if err != nil {
+				continue
+			}
+			st, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if ent := idx.Files[path]; ent != nil && ent.Size == st.Size() && ent.ModTime.Equal(st.ModTime()) {
+				continue
+			}
+			todo <- path
+		}
+		close(todo)
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range todo {
+				st, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				data, err := readLog(path)
+				if err != nil {
+					continue
+				}
+				ent := &indexedFile{Size: st.Size(), ModTime: st.ModTime()}
+				results <- result{path, ent, trigrams(data)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// IDs are assigned in increasing order, so appending keeps
+	// the posting lists sorted.
+	for r := range results {
+		r.ent.ID = idx.NextID
+		idx.NextID++
+		idx.Files[r.path] = r.ent
+		for _, tri := range r.tris {
+			idx.Posts[tri] = append(idx.Posts[tri], r.ent.ID)
+		}
+		idx.dirty = true
+	}
+}
+
+// trigrams returns the distinct trigrams in data.
+func trigrams(data []byte) []uint32 {
+	seen := make(map[uint32]bool)
+	var tris []uint32
+	for i := 0; i+3 <= len(data); i++ {
+		tri := uint32(data[i])<<16 | uint32(data[i+1])<<8 | uint32(data[i+2])
+		if !seen[tri] {
+			seen[tri] = true
+			tris = append(tris, tri)
+		}
+	}
+	return tris
+}
+
+// filter returns the subset of files that may satisfy req according
+// to the index. files must have already been indexed by update.
+func (idx *trigramIndex) filter(files []logFile, req trigramReq) []logFile {
+	out := files[:0]
+	for _, f := range files {
+		if f.err != nil {
+			out = append(out, f)
+			continue
+		}
+		path, err := filepath.Abs(f.path)
+		if err != nil {
+			out = append(out, f)
+			continue
+		}
+		ent := idx.Files[path]
+		if ent == nil || idx.mayMatch(ent.ID, req) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// mayMatch reports whether file id may satisfy req.
+func (idx *trigramIndex) mayMatch(id uint32, req trigramReq) bool {
+	for _, and := range req {
+		ok := true
+		for _, lit := range and {
+			for i := 0; i+3 <= len(lit); i++ {
+				tri := uint32(lit[i])<<16 | uint32(lit[i+1])<<8 | uint32(lit[i+2])
+				ids := idx.Posts[tri]
+				j := sort.Search(len(ids), func(j int) bool { return ids[j] >= id })
+				if j == len(ids) || ids[j] != id {
+					ok = false
+					break
+				}
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// trigramReq is a requirement on the contents of a file in
+// disjunctive normal form: a file satisfies the requirement if, for
+// any element, it contains all of the strings in that element.
+type trigramReq [][]string
+
+// matchAny is the requirement satisfied by every file.
+var matchAny = trigramReq{{}}
+
+// maxReqTerms limits the size of a trigramReq. Requirements that
+// would be larger are weakened to matchAny.
+const maxReqTerms = 64
+
+func (r trigramReq) and(s trigramReq) trigramReq {
+	if len(r)*len(s) > maxReqTerms {
+		// Weaken to whichever side is more selective.
+		if len(r) <= len(s) {
+			return r
+		}
+		return s
+	}
+	out := trigramReq{}
+	for _, a := range r {
+		for _, b := range s {
+			and := append(append([]string{}, a...), b...)
+			out = append(out, and)
+		}
+	}
+	return out
+}
+
+func (r trigramReq) or(s trigramReq) trigramReq {
+	if len(r)+len(s) > maxReqTerms {
+		return matchAny
+	}
+	return append(append(trigramReq{}, r...), s...)
+}
+
+// regexpReq returns a requirement that every file matching re must
+// satisfy.
+func regexpReq(re *regexp.Regexp) trigramReq {
+	sre, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return matchAny
+	}
+	return syntaxReq(sre.Simplify())
+}
+
+func syntaxReq(re *syntax.Regexp) trigramReq {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return matchAny
+		}
+		return trigramReq{{string(re.Rune)}}
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return syntaxReq(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min == 0 {
+			return matchAny
+		}
+		return syntaxReq(re.Sub[0])
+
+	case syntax.OpConcat:
+		// Combine adjacent literals into longer strings, since
+		// these may contain trigrams that span the literals.
+		req := matchAny
+		lit := ""
+		flush := func() {
+			if lit != "" {
+				req = req.and(trigramReq{{lit}})
+				lit = ""
+			}
+		}
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 {
+				lit += string(sub.Rune)
+				continue
+			}
+			flush()
+			req = req.and(syntaxReq(sub))
+		}
+		flush()
+		return req
+
+	case syntax.OpAlternate:
+		req := trigramReq{}
+		for _, sub := range re.Sub {
+			req = req.or(syntaxReq(sub))
+		}
+		return req
+	}
+	return matchAny
+}
+
+// queryReq returns a requirement that every file matching q must
+// satisfy.
+func queryReq(q query) trigramReq {
+	switch q := q.(type) {
+	case *queryRegexp:
+		return regexpReq(q.re)
+	case *queryAnd:
+		return queryReq(q.l).and(queryReq(q.r))
+	case *queryOr:
+		return queryReq(q.l).or(queryReq(q.r))
+	}
+	return matchAny
+}
+
+// searchReq returns the requirement for the regexps given by -e, -E,
+// and -q.
+func searchReq() trigramReq {
+	req := matchAny
+	for _, re := range fileRegexps {
+		req = req.and(regexpReq(re))
+	}
+	for _, re := range failRegexps {
+		req = req.and(regexpReq(re))
+	}
+	if fileQuery != nil {
+		req = req.and(queryReq(fileQuery))
+	}
+	return req
+}
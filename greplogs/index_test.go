@@ -0,0 +1,32 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestRegexpReq(t *testing.T) {
+	for _, test := range []struct {
+		re   string
+		want string
+	}{
+		{`fatal error`, `[[fatal error]]`},
+		{`(?m)^panic: .*runtime`, `[[panic:  runtime]]`},
+		{`a|bcd`, `[[a] [bcd]]`},
+		{`(foo|bar)baz`, `[[foo baz] [bar baz]]`},
+		{`x*`, `[[]]`},
+		{`(?i)foo`, `[[]]`},
+		{`(abc)+d`, `[[abc d]]`},
+		{`abc?`, `[[ab]]`},
+	} {
+		got := fmt.Sprint(regexpReq(regexp.MustCompile(test.re)))
+		if got != test.want {
+			t.Errorf("regexpReq(%s) = %s, want %s", test.re, got, test.want)
+		}
+	}
+}
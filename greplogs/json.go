@@ -0,0 +1,84 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/aclements/go-misc/internal/loganal"
+)
+
+// jsonRecord is the -json output for a single match.
+type jsonRecord struct {
+	logMeta
+
+	// Lines is the list of 1-based, inclusive line ranges in the
+	// log matched by the search regexps. For a failure or block
+	// of context lines, this is only the matches within it.
+	Lines [][2]int
+
+	// Failure is the extracted failure. This is omitted with -l
+	// and for blocks of context lines that aren't a failure.
+	Failure *loganal.Failure `json:",omitempty"`
+
+	// Labels are the possible categories of Failure, from most to
+	// least likely.
+	Labels []loganal.Label `json:",omitempty"`
+
+	// lineStarts is the offset of the start of each line of the
+	// log after the first.
+	lineStarts []int
+
+	// matches is the byte range of each search match in the
+	// log, sorted by start.
+	matches [][]int
+}
+
+// newJSONRecord returns a JSON record for the log described by meta
+// with contents data. Its Lines are all of the matches in data.
+func newJSONRecord(meta logMeta, data []byte) *jsonRecord {
+	rec := &jsonRecord{logMeta: meta}
+	for i, c := range data {
+		if c == '\n' {
+			rec.lineStarts = append(rec.lineStarts, i+1)
+		}
+	}
+	rec.matches = allMatches(data)
+	sort.Slice(rec.matches, func(i, j int) bool { return rec.matches[i][0] < rec.matches[j][0] })
+	rec.setBlock(0, len(data))
+	return rec
+}
+
+// setBlock sets r.Lines to the lines of the matches that start
+// within bytes [start, end) of the log.
+func (r *jsonRecord) setBlock(start, end int) {
+	lineOf := func(off int) int {
+		return sort.SearchInts(r.lineStarts, off+1) + 1
+	}
+	r.Lines = [][2]int{}
+	for _, m := range r.matches {
+		if m[0] < start || m[0] >= end {
+			continue
+		}
+		mstart, mend := lineOf(m[0]), lineOf(m[0])
+		if m[1] > m[0] {
+			// Don't count the line after a trailing newline.
+			mend = lineOf(m[1] - 1)
+		}
+		if n := len(r.Lines); n > 0 && r.Lines[n-1][1] >= mstart {
+			if mend > r.Lines[n-1][1] {
+				r.Lines[n-1][1] = mend
+			}
+			continue
+		}
+		r.Lines = append(r.Lines, [2]int{mstart, mend})
+	}
+}
+
+func (r *jsonRecord) write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
@@ -0,0 +1,200 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// This file implements searching build logs stored by LUCI directly,
+// without a local fetchlogs mirror. Builds are found using the
+// Buildbucket pRPC API and logs are fetched from LogDog.
+
+const buildbucketHost = "cr-buildbucket.appspot.com"
+
+// remoteLog identifies a log stored by LUCI.
+type remoteLog struct {
+	// rawURL is the URL of the raw log text.
+	rawURL string
+
+	// buildURL is the URL of the build's page.
+	buildURL string
+
+	builder, repo, revision string
+}
+
+// The following types are the subset of the Buildbucket v2 API used
+// to search builds.
+
+type bbSearchBuildsRequest struct {
+	Predicate bbBuildPredicate `json:"predicate"`
+	PageSize  int              `json:"pageSize,omitempty"`
+	PageToken string           `json:"pageToken,omitempty"`
+	Fields    string           `json:"fields,omitempty"`
+}
+
+type bbBuildPredicate struct {
+	Builder    bbBuilderID  `json:"builder"`
+	Status     string       `json:"status,omitempty"`
+	CreateTime *bbTimeRange `json:"createTime,omitempty"`
+}
+
+type bbTimeRange struct {
+	StartTime *time.Time `json:"startTime,omitempty"`
+	EndTime   *time.Time `json:"endTime,omitempty"`
+}
+
+type bbBuilderID struct {
+	Project string `json:"project"`
+	Bucket  string `json:"bucket,omitempty"`
+	Builder string `json:"builder,omitempty"`
+}
+
+type bbSearchBuildsResponse struct {
+	Builds        []bbBuild `json:"builds"`
+	NextPageToken string    `json:"nextPageToken"`
+}
+
+type bbBuild struct {
+	ID      string      `json:"id"`
+	Builder bbBuilderID `json:"builder"`
+	Input   struct {
+		GitilesCommit struct {
+			Host    string `json:"host"`
+			Project string `json:"project"`
+			ID      string `json:"id"`
+		} `json:"gitilesCommit"`
+	} `json:"input"`
+	Steps []struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Logs   []struct {
+			Name    string `json:"name"`
+			ViewURL string `json:"viewUrl"`
+		} `json:"logs"`
+	} `json:"steps"`
+}
+
+// luciFiles returns the stdout logs of the failed steps of up to
+// limit recent failed builds in LUCI project. If rf is non-nil, it
+// restricts the builds by creation time.
+func luciFiles(project string, limit int, rf *revFilter) ([]logFile, error) {
+	req := bbSearchBuildsRequest{
+		Predicate: bbBuildPredicate{
+			Builder: bbBuilderID{Project: project},
+			Status:  "FAILURE",
+		},
+		Fields: "builds.*.id,builds.*.builder,builds.*.input.gitilesCommit,builds.*.steps.*.name,builds.*.steps.*.status,builds.*.steps.*.logs,nextPageToken",
+	}
+	if rf != nil && (!rf.since.IsZero() || !rf.until.IsZero()) {
+		tr := new(bbTimeRange)
+		if !rf.since.IsZero() {
+			tr.StartTime = &rf.since
+		}
+		if !rf.until.IsZero() {
+			// EndTime is exclusive.
+			end := rf.until.Add(time.Nanosecond)
+			tr.EndTime = &end
+		}
+		req.Predicate.CreateTime = tr
+	}
+
+	var files []logFile
+	for nBuilds := 0; nBuilds < limit; {
+		req.PageSize = limit - nBuilds
+		if req.PageSize > 1000 {
+			req.PageSize = 1000
+		}
+		var resp bbSearchBuildsResponse
+		if err := prpc(buildbucketHost, "buildbucket.v2.Builds", "SearchBuilds", &req, &resp); err != nil {
+			return nil, err
+		}
+
+		for _, b := range resp.Builds {
+			nBuilds++
+			commit := b.Input.GitilesCommit
+			for _, step := range b.Steps {
+				if step.Status != "FAILURE" {
+					continue
+				}
+				for _, l := range step.Logs {
+					if l.Name != "stdout" {
+						continue
+					}
+					rl := &remoteLog{
+						rawURL:   l.ViewURL + "?format=raw",
+						buildURL: "https://ci.chromium.org/b/" + b.ID,
+						builder:  b.Builder.Builder,
+						repo:     commit.Project,
+						revision: commit.ID,
+					}
+					rev := commit.ID
+					if len(rev) > 7 {
+						rev = rev[:7]
+					}
+					nicePath := fmt.Sprintf("%s/%s/%s/%s", b.ID, b.Builder.Builder, rev, step.Name)
+					files = append(files, logFile{path: rl.rawURL, nicePath: nicePath, remote: rl})
+				}
+			}
+		}
+
+		if resp.NextPageToken == "" || len(resp.Builds) == 0 {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	return files, nil
+}
+
+// prpc invokes method of service on host using the pRPC protocol with
+// JSON encoding.
+func prpc(host, service, method string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("https://%s/prpc/%s/%s", host, service, method)
+	hreq, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("Accept", "application/json")
+	hresp, err := http.DefaultClient.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(hresp.Body)
+		return fmt.Errorf("%s: %s: %s", url, hresp.Status, bytes.TrimSpace(msg))
+	}
+
+	// pRPC JSON responses start with an XSSI protection prefix.
+	br := bufio.NewReader(hresp.Body)
+	if prefix, err := br.Peek(4); err == nil && string(prefix) == ")]}'" {
+		br.ReadString('\n')
+	}
+	return json.NewDecoder(br).Decode(resp)
+}
+
+// readRemoteLog fetches the text of a remote log.
+func readRemoteLog(rl *remoteLog) ([]byte, error) {
+	resp, err := http.Get(rl.rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", rl.rawURL, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -4,8 +4,9 @@
 
 // Command greplogs searches Go builder logs.
 //
-//     greplogs [flags] (-e regexp|-E regexp) paths...
-//     greplogs [flags] (-e regexp|-E regexp) -dashboard
+//     greplogs [flags] (-e regexp|-E regexp|-q query) paths...
+//     greplogs [flags] (-e regexp|-E regexp|-q query) -dashboard
+//     greplogs [flags] (-e regexp|-E regexp|-q query) -luci
 //
 // greplogs finds builder logs matching a given set of regular
 // expressions in Go syntax (godoc.org/regexp/syntax) and extracts
@@ -14,6 +15,65 @@
 // greplogs can search an arbitrary set of files just like grep.
 // Alternatively, the -dashboard flag causes it to search the logs
 // saved locally by fetchlogs (golang.org/x/build/cmd/fetchlogs).
+// Logs compressed with gzip (.gz) or zstd (.zst) are decompressed
+// transparently; the latter requires the zstd command.
+//
+// The -q flag accepts a boolean query over regular expressions that
+// is evaluated against each log, such as
+//
+//     (re:"fatal error" AND NOT re:"exit status 2") OR re:"DATA RACE"
+//
+// Queries may combine re:"regexp" terms with AND, OR, NOT, and
+// parentheses. NOT binds tightest, then AND, then OR.
+//
+// By default, greplogs prints the whole extracted failure containing
+// each match, or the lines around a match that isn't in a failure.
+// -context failure prints every failure in a matching log instead,
+// and -context lines prints only the lines around each match. -C
+// sets the number of lines of context.
+//
+// The -since, -until, and -rev flags restrict the search to logs for
+// commits in a date or commit range. These use the revision metadata
+// saved by fetchlogs, so logs without metadata are skipped. Dates may
+// be YYYY-MM-DD or RFC 3339 times, and both bounds are inclusive; a
+// date alone covers that whole day. -rev A..B includes commits after A
+// up to and including B, ordered by commit date, and either end may be
+// omitted.
+//
+// The -luci flag searches the logs of recent failed builds stored by
+// LUCI, fetching them directly rather than reading a local fetchlogs
+// mirror. This supports the same output formats, and -since and
+// -until restrict the builds by creation time.
+//
+// By default, greplogs highlights output in color when writing to a
+// terminal, with different colors for matches, builder names, and
+// commit hashes. Setting the NO_COLOR environment variable disables
+// this. The -format flag replaces the header printed before each match
+// with a Go text/template. The template is executed with a struct with
+// fields Path, LogURL, Builder, Repo, and Revision, and may use the
+// functions path, builder, and rev to highlight values, for example
+//
+//     -format '{{rev (printf "%.7s" .Revision)}} {{builder .Builder}}:'
+//
+// The -category flag shows only failures whose most likely category
+// is one of the given categories: build-error, compile-crash,
+// test-assert, timeout, runtime-throw, race-report, linker-error, or
+// infrastructure-flake. With -json, each record lists the failure's
+// possible categories, confidences, and the evidence for each.
+//
+// With -md, failures that are identical up to details like addresses
+// and times are collapsed into one entry. Failures in different
+// subtests are kept separate unless -subtests=false, in which case
+// they are collapsed with other failures of their top-level test.
+//
+// The -index flag maintains an on-disk trigram index of searched logs
+// in the user cache directory. Logs are added to the index as they
+// are first searched, and later searches use it to skip logs that
+// cannot match, which makes repeated queries much faster.
+//
+// Defaults for flags such as -dir and -luci-project can be set in the
+// go-misc configuration file. See
+// https://godoc.org/github.com/aclements/go-misc/internal/config.
 package main
 
 import (
@@ -22,9 +82,11 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 
+	"github.com/aclements/go-misc/internal/config"
 	"github.com/aclements/go-misc/internal/loganal"
 )
 
@@ -41,19 +103,44 @@ import (
 var (
 	fileRegexps regexpList
 	failRegexps regexpList
+	fileQuery   query
 
 	flagDashboard = flag.Bool("dashboard", false, "search dashboard logs from fetchlogs")
+	flagRevDir    = flag.String("dir", filepath.Join(xdgCacheDir(), "fetchlogs", "rev"), "with -dashboard, search logs under `directory`")
 	flagMD        = flag.Bool("md", false, "output in Markdown")
+	flagContext   = flag.String("context", "auto", "print failures containing matches or else lines around them (auto), failures in matching logs (failure), or lines around matches (lines)")
+	flagLines     = flag.Int("C", 3, "print `n` lines of context around matches in lines and auto -context modes")
+	flagDedup     = flag.Bool("dedup", true, "with -md, collapse identical failures into one entry")
+	flagSubtests  = flag.Bool("subtests", true, "with -md and -dedup, keep failures in different subtests of a test separate")
+	flagCategory  = flag.String("category", "", "show only failures whose most likely category is one of the comma-separated `categories`")
 	flagFilesOnly = flag.Bool("l", false, "print only names of matching files")
+	flagJSON      = flag.Bool("json", false, "output one JSON record per match")
 	flagColor     = flag.String("color", "auto", "highlight output in color: `mode` is never, always, or auto")
+	flagFormat    = flag.String("format", "", "print the header of each match using Go template `format` (see package doc)")
+	flagSince     = flag.String("since", "", "search only logs for commits on or after `date`")
+	flagUntil     = flag.String("until", "", "search only logs for commits on or before `date`")
+	flagRev       = flag.String("rev", "", "search only logs for commits in `A..B`")
+	flagIndex     = flag.Bool("index", false, "use and update a trigram index to skip logs that cannot match")
+	flagIndexFile = flag.String("index-file", defaultIndexPath(), "store the trigram index in `file`")
+	flagJobs      = flag.Int("j", runtime.GOMAXPROCS(0), "search up to `n` files in parallel")
+
+	flagLUCI        = flag.Bool("luci", false, "search recent failed build logs stored by LUCI instead of local files")
+	flagLUCIProject = flag.String("luci-project", "golang", "search builds in LUCI `project`")
+	flagLUCILimit   = flag.Int("luci-limit", 100, "search at most `n` LUCI builds")
 
 	color *colorizer
+
+	// categories is the set of categories given by -category, or
+	// nil to show failures of any category.
+	categories map[loganal.Category]bool
 )
 
 const (
 	colorPath      = colorFgMagenta
 	colorPathColon = colorFgCyan
 	colorMatch     = colorBold | colorFgRed
+	colorBuilder   = colorFgGreen
+	colorRevision  = colorFgYellow
 )
 
 func main() {
@@ -61,20 +148,81 @@ func main() {
 	// logs and have it extract the failures.
 	flag.Var(&fileRegexps, "e", "show files matching `regexp`; if provided multiple times, files must match all regexps")
 	flag.Var(&failRegexps, "E", "show only errors matching `regexp`; if provided multiple times, an error must match all regexps")
+	flagQuery := flag.String("q", "", "show files matching boolean `query` of regexps")
+	cfg := config.NewFlags(flag.CommandLine, "greplogs", map[string]string{
+		"logs":         "dir",
+		"luci-project": "luci-project",
+	})
 	flag.Parse()
+	if err := cfg.Apply(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
 	// Validate flags.
+	if *flagQuery != "" {
+		var err error
+		fileQuery, err = parseQuery(*flagQuery)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(2)
+		}
+	}
 	if *flagDashboard && flag.NArg() > 0 {
 		fmt.Fprintf(os.Stderr, "-dashboard and paths are incompatible\n")
 		os.Exit(2)
 	}
+	if *flagLUCI && (*flagDashboard || flag.NArg() > 0) {
+		fmt.Fprintf(os.Stderr, "-luci is incompatible with -dashboard and paths\n")
+		os.Exit(2)
+	}
+	if *flagLUCI && (*flagRev != "" || *flagIndex) {
+		fmt.Fprintf(os.Stderr, "-luci is incompatible with -rev and -index\n")
+		os.Exit(2)
+	}
+	if *flagJSON && *flagMD {
+		fmt.Fprintf(os.Stderr, "-json and -md are incompatible\n")
+		os.Exit(2)
+	}
+	switch *flagContext {
+	case "auto", "failure", "lines":
+	default:
+		fmt.Fprintf(os.Stderr, "-context must be one of auto, failure, or lines\n")
+		os.Exit(2)
+	}
+	rf, err := newRevFilter(*flagSince, *flagUntil, *flagRev)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(2)
+	}
+	if *flagFormat != "" {
+		if err := parseFormat(*flagFormat); err != nil {
+			fmt.Fprintf(os.Stderr, "bad -format: %s\n", err)
+			os.Exit(2)
+		}
+	}
+	if *flagCategory != "" {
+		categories = make(map[loganal.Category]bool)
+		for _, c := range strings.Split(*flagCategory, ",") {
+			cat := loganal.Category(c)
+			known := false
+			for _, c2 := range loganal.Categories {
+				known = known || cat == c2
+			}
+			if !known {
+				fmt.Fprintf(os.Stderr, "unknown -category %q; must be one of %v\n", c, loganal.Categories)
+				os.Exit(2)
+			}
+			categories[cat] = true
+		}
+	}
 	switch *flagColor {
 	case "never":
 		color = newColorizer(false)
 	case "always":
 		color = newColorizer(true)
 	case "auto":
-		color = newColorizer(canColor())
+		color = newColorizer(canColor() && !*flagJSON)
 	default:
 		fmt.Fprintf(os.Stderr, "-color must be one of never, always, or auto")
 		os.Exit(2)
@@ -84,7 +232,7 @@ func main() {
 	var paths []string
 	var stripDir string
 	if *flagDashboard {
-		revDir := filepath.Join(xdgCacheDir(), "fetchlogs", "rev")
+		revDir := *flagRevDir
 		fis, err := ioutil.ReadDir(revDir)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: %s\n", revDir, err)
@@ -102,13 +250,20 @@ func main() {
 		paths = flag.Args()
 	}
 
-	// Process files
+	// Gather files.
 	status := 1
+	var files []logFile
+	if *flagLUCI {
+		files, err = luciFiles(*flagLUCIProject, *flagLUCILimit, rf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
 	for _, path := range paths {
 		filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
-				status = 2
-				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				files = append(files, logFile{path: path, err: err})
 				return nil
 			}
 			if info.IsDir() || strings.HasPrefix(filepath.Base(path), ".") {
@@ -120,22 +275,58 @@ func main() {
 				nicePath = path[len(stripDir):]
 			}
 
-			found, err := process(path, nicePath)
-			if err != nil {
-				status = 2
-				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
-			} else if found && status == 1 {
-				status = 0
-			}
+			files = append(files, logFile{path: path, nicePath: nicePath})
 			return nil
 		})
 	}
+
+	// Filter by commit.
+	if rf != nil && !*flagLUCI {
+		files, err = rf.filter(files)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(2)
+		}
+	}
+
+	// Use the index to eliminate files that can't match.
+	if *flagIndex {
+		idx := openTrigramIndex(*flagIndexFile)
+		idx.update(files)
+		if err := idx.save(); err != nil {
+			fmt.Fprintf(os.Stderr, "saving index: %v\n", err)
+		}
+		files = idx.filter(files, searchReq())
+	}
+
+	// Process files.
+	var mdFailures mdFailureSet
+	searchFiles(files, *flagJobs, func(f *logFile) {
+		if f.err != nil {
+			status = 2
+			fmt.Fprintf(os.Stderr, "%s: %v\n", f.path, f.err)
+		} else if f.found && status == 1 {
+			status = 0
+		}
+		os.Stdout.Write(f.out.Bytes())
+		for _, mf := range f.mdFailures {
+			mdFailures.add(mf)
+		}
+	})
+	mdFailures.print(os.Stdout)
 	os.Exit(status)
 }
 
-func process(path, nicePath string) (found bool, err error) {
+func process(f *logFile) (found bool, err error) {
+	w, path, nicePath := &f.out, f.path, f.nicePath
+
 	// TODO: Use streaming if possible.
-	data, err := ioutil.ReadFile(path)
+	var data []byte
+	if f.remote != nil {
+		data, err = readRemoteLog(f.remote)
+	} else {
+		data, err = readLog(path)
+	}
 	if err != nil {
 		return false, err
 	}
@@ -144,10 +335,15 @@ func process(path, nicePath string) (found bool, err error) {
 	if !fileRegexps.AllMatch(data) || !failRegexps.AllMatch(data) {
 		return false, nil
 	}
+	if fileQuery != nil && !fileQuery.Match(data) {
+		return false, nil
+	}
 
 	// If this is from the dashboard, get the builder URL.
 	var logURL string
-	if _, err := os.Stat(filepath.Join(filepath.Dir(path), ".rev.json")); err == nil {
+	if f.remote != nil {
+		logURL = f.remote.buildURL
+	} else if _, err := os.Stat(filepath.Join(filepath.Dir(path), ".rev.json")); err == nil {
 		// TODO: Get the URL from the rev.json metadata
 		link, err := os.Readlink(path)
 		if err == nil {
@@ -161,8 +357,17 @@ func process(path, nicePath string) (found bool, err error) {
 		printPath = fmt.Sprintf("[%s](%s)", nicePath, logURL)
 	}
 
+	meta := readLogMeta(f, logURL)
+	var rec *jsonRecord
+	if *flagJSON {
+		rec = newJSONRecord(meta, data)
+	}
+
 	if *flagFilesOnly {
-		fmt.Printf("%s\n", color.color(printPath, colorPath))
+		if rec != nil {
+			return true, rec.write(w)
+		}
+		fmt.Fprintf(w, "%s\n", header(meta, printPath, false))
 		return true, nil
 	}
 
@@ -172,44 +377,86 @@ func process(path, nicePath string) (found bool, err error) {
 		return false, err
 	}
 
+	var blocks []contextBlock
+	switch *flagContext {
+	case "failure":
+		blocks = failureBlocks(data, failures)
+	case "lines":
+		blocks = matchBlocks(data, failures, false, *flagLines)
+	case "auto":
+		blocks = matchBlocks(data, failures, true, *flagLines)
+	}
+
 	// Print failures.
-	for _, failure := range failures {
-		var msg []byte
-		if failure.FullMessage != "" {
-			msg = []byte(failure.FullMessage)
-		} else {
-			msg = []byte(failure.Message)
-		}
+	for _, block := range blocks {
+		msg := block.msg
 
 		if len(failRegexps) > 0 && !failRegexps.AllMatch(msg) {
 			continue
 		}
 
-		fmt.Printf("%s%s\n", color.color(printPath, colorPath), color.color(":", colorPathColon))
+		var labels []loganal.Label
+		if block.failure != nil {
+			labels = loganal.Categorize(block.failure)
+		}
+		if categories != nil && (len(labels) == 0 || !categories[labels[0].Category]) {
+			continue
+		}
+
+		if rec != nil {
+			rec.Failure = block.failure
+			rec.Labels = labels
+			if block.start >= 0 {
+				rec.setBlock(block.start, block.start+len(msg))
+			} else {
+				rec.Lines = [][2]int{}
+			}
+			if err := rec.write(w); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		if *flagMD && *flagDedup {
+			// Print these all at the end.
+			f.mdFailures = append(f.mdFailures, mdFailure{printPath, string(msg), block.failure})
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\n", header(meta, printPath, true))
 		if *flagMD {
-			fmt.Printf("```\n")
+			fmt.Fprintf(w, "```\n")
 		}
 		if !color.enabled {
-			fmt.Printf("%s", msg)
+			fmt.Fprintf(w, "%s", msg)
 		} else {
 			// Find specific matches and highlight them.
-			matches := mergeMatches(append(fileRegexps.Matches(msg),
-				failRegexps.Matches(msg)...))
+			matches := mergeMatches(allMatches(msg))
 			printed := 0
 			for _, m := range matches {
-				fmt.Printf("%s%s", msg[printed:m[0]], color.color(string(msg[m[0]:m[1]]), colorMatch))
+				fmt.Fprintf(w, "%s%s", msg[printed:m[0]], color.color(string(msg[m[0]:m[1]]), colorMatch))
 				printed = m[1]
 			}
-			fmt.Printf("%s", msg[printed:])
+			fmt.Fprintf(w, "%s", msg[printed:])
 		}
 		if *flagMD {
-			fmt.Printf("\n```")
+			fmt.Fprintf(w, "\n```")
 		}
-		fmt.Printf("\n\n")
+		fmt.Fprintf(w, "\n\n")
 	}
 	return true, nil
 }
 
+// allMatches returns the indexes of all matches in data of the
+// regexps given by -e, -E, and -q.
+func allMatches(data []byte) [][]int {
+	matches := append(fileRegexps.Matches(data), failRegexps.Matches(data)...)
+	if fileQuery != nil {
+		matches = append(matches, fileQuery.Matches(data)...)
+	}
+	return matches
+}
+
 func mergeMatches(matches [][]int) [][]int {
 	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
 	for i := 0; i < len(matches); {
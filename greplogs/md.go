@@ -0,0 +1,105 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/aclements/go-misc/internal/loganal"
+)
+
+// mdFailure is a single failure to print in Markdown output.
+type mdFailure struct {
+	printPath string
+	msg       string
+
+	// failure is the extracted failure, or nil if msg is raw
+	// context lines.
+	failure *loganal.Failure
+}
+
+// mdFailureSet collects failures for Markdown output and groups
+// failures whose messages are identical up to addresses, timestamps,
+// and similar incidental details.
+type mdFailureSet struct {
+	groups []*mdFailureGroup
+	byKey  map[string]*mdFailureGroup
+}
+
+type mdFailureGroup struct {
+	// msg is the message of the first failure in this group.
+	msg string
+
+	// printPaths are the paths of all logs with this failure.
+	printPaths []string
+}
+
+var mdNormalize = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Addresses and PC offsets.
+	{regexp.MustCompile(`0x[0-9a-fA-F]+`), "0x?"},
+	// Dates and times.
+	{regexp.MustCompile(`\d{4}[-/]\d\d[-/]\d\d([T ]\d\d:\d\d:\d\d(\.\d+)?(Z|[-+]\d\d:?\d\d)?)?`), "DATE"},
+	{regexp.MustCompile(`\d\d:\d\d:\d\d(\.\d+)?`), "TIME"},
+	// Durations, such as test run times.
+	{regexp.MustCompile(`\d+(\.\d+)?(ns|µs|us|ms|s|m|h)\b`), "DURATION"},
+	// Goroutine IDs.
+	{regexp.MustCompile(`goroutine \d+`), "goroutine N"},
+	// Temporary directories.
+	{regexp.MustCompile(`/tmp/[^/\s]+`), "/tmp/TMP"},
+}
+
+// normalizeFailure canonicalizes msg so that failures that differ
+// only in incidental details compare equal.
+func normalizeFailure(msg string) string {
+	for _, n := range mdNormalize {
+		msg = n.re.ReplaceAllString(msg, n.repl)
+	}
+	return msg
+}
+
+func (s *mdFailureSet) add(f mdFailure) {
+	if s.byKey == nil {
+		s.byKey = make(map[string]*mdFailureGroup)
+	}
+	key := normalizeFailure(f.msg)
+	if !*flagSubtests && f.failure != nil && strings.Contains(f.failure.Test, "/") {
+		// Collapse the names of all subtests of this test.
+		top := f.failure.TestAtDepth(1)
+		key = regexp.MustCompile(regexp.QuoteMeta(top)+`/\S+`).ReplaceAllString(key, top+"/SUBTEST")
+	}
+	g := s.byKey[key]
+	if g == nil {
+		g = &mdFailureGroup{msg: f.msg}
+		s.byKey[key] = g
+		s.groups = append(s.groups, g)
+	}
+	g.printPaths = append(g.printPaths, f.printPath)
+}
+
+// print writes each failure group to w in the order each group was
+// first seen.
+func (s *mdFailureSet) print(w io.Writer) {
+	for _, g := range s.groups {
+		if len(g.printPaths) == 1 {
+			fmt.Fprintf(w, "%s:\n", g.printPaths[0])
+		} else {
+			fmt.Fprintf(w, "%d occurrences:", len(g.printPaths))
+			for i, p := range g.printPaths {
+				if i > 0 {
+					fmt.Fprintf(w, ",")
+				}
+				fmt.Fprintf(w, " %s", p)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+		fmt.Fprintf(w, "```\n%s\n```\n\n", g.msg)
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A query is a boolean combination of regexps that is evaluated
+// against an entire log.
+//
+// The query syntax is
+//
+//     query   = or
+//     or      = and { "OR" and }
+//     and     = not { "AND" not }
+//     not     = "NOT" not | primary
+//     primary = "(" query ")" | "re:" string
+//
+// where string is a double-quoted Go string or a back-quoted raw
+// string. As with -e, regexps are in multi-line mode.
+type query interface {
+	// Match reports whether data matches this query. Operands
+	// are evaluated left to right and evaluation stops as soon as
+	// the result is known.
+	Match(data []byte) bool
+
+	// Matches returns the indexes of all matches in data of the
+	// regexps that appear positively in this query.
+	Matches(data []byte) [][]int
+
+	String() string
+}
+
+type queryRegexp struct {
+	re *regexp.Regexp
+}
+
+type queryNot struct {
+	q query
+}
+
+type queryAnd struct {
+	l, r query
+}
+
+type queryOr struct {
+	l, r query
+}
+
+func (q *queryRegexp) Match(data []byte) bool { return q.re.Match(data) }
+func (q *queryNot) Match(data []byte) bool    { return !q.q.Match(data) }
+func (q *queryAnd) Match(data []byte) bool    { return q.l.Match(data) && q.r.Match(data) }
+func (q *queryOr) Match(data []byte) bool     { return q.l.Match(data) || q.r.Match(data) }
+
+func (q *queryRegexp) Matches(data []byte) [][]int {
+	return q.re.FindAllIndex(data, -1)
+}
+
+func (q *queryNot) Matches(data []byte) [][]int {
+	// Matches of negated regexps aren't interesting.
+	return nil
+}
+
+func (q *queryAnd) Matches(data []byte) [][]int {
+	return append(q.l.Matches(data), q.r.Matches(data)...)
+}
+
+func (q *queryOr) Matches(data []byte) [][]int {
+	return append(q.l.Matches(data), q.r.Matches(data)...)
+}
+
+func (q *queryRegexp) String() string {
+	return "re:" + strconv.Quote(strings.TrimPrefix(q.re.String(), "(?m)"))
+}
+func (q *queryNot) String() string { return "NOT " + q.q.String() }
+func (q *queryAnd) String() string { return "(" + q.l.String() + " AND " + q.r.String() + ")" }
+func (q *queryOr) String() string  { return "(" + q.l.String() + " OR " + q.r.String() + ")" }
+
+// parseQuery parses a query string.
+func parseQuery(s string) (query, error) {
+	p := &queryParser{s: s}
+	q, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, p.errorf("unexpected %q", tok)
+	}
+	return q, nil
+}
+
+type queryParser struct {
+	s   string
+	pos int
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("query at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) skipSpace() {
+	p.pos += len(p.s[p.pos:]) - len(strings.TrimLeftFunc(p.s[p.pos:], unicode.IsSpace))
+}
+
+// peek returns the next token without consuming it, or "" at the end
+// of the query. Strings are returned as just their opening quote.
+func (p *queryParser) peek() string {
+	p.skipSpace()
+	rest := p.s[p.pos:]
+	if rest == "" {
+		return ""
+	}
+	switch rest[0] {
+	case '(', ')', '"', '`':
+		return rest[:1]
+	}
+	if strings.HasPrefix(rest, "re:") {
+		return "re:"
+	}
+	end := strings.IndexFunc(rest, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '(' || r == ')'
+	})
+	if end < 0 {
+		end = len(rest)
+	}
+	return rest[:end]
+}
+
+func (p *queryParser) next() string {
+	tok := p.peek()
+	p.pos += len(tok)
+	return tok
+}
+
+func (p *queryParser) or() (query, error) {
+	q, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "OR" {
+		p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		q = &queryOr{q, r}
+	}
+	return q, nil
+}
+
+func (p *queryParser) and() (query, error) {
+	q, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "AND" {
+		p.next()
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		q = &queryAnd{q, r}
+	}
+	return q, nil
+}
+
+func (p *queryParser) not() (query, error) {
+	if p.peek() == "NOT" {
+		p.next()
+		q, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &queryNot{q}, nil
+	}
+	return p.primary()
+}
+
+func (p *queryParser) primary() (query, error) {
+	switch tok := p.next(); tok {
+	case "(":
+		q, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, p.errorf("missing )")
+		}
+		return q, nil
+
+	case "re:":
+		str, err := p.str()
+		if err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile("(?m)" + str)
+		if err != nil {
+			return nil, err
+		}
+		return &queryRegexp{re}, nil
+
+	case "":
+		return nil, p.errorf("unexpected end of query")
+
+	default:
+		return nil, p.errorf("unexpected %q", tok)
+	}
+}
+
+// str consumes a quoted string and returns its unquoted value.
+func (p *queryParser) str() (string, error) {
+	rest := p.s[p.pos:]
+	if rest == "" || (rest[0] != '"' && rest[0] != '`') {
+		return "", p.errorf("expected quoted string after re:")
+	}
+	quoted, err := strconv.QuotedPrefix(rest)
+	if err != nil {
+		return "", p.errorf("bad quoted string")
+	}
+	p.pos += len(quoted)
+	return strconv.Unquote(quoted)
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestParseQuery(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{`re:"a"`, `re:"a"`},
+		{"re:`a\\b`", `re:"a\\b"`},
+		{`re:"a" AND re:"b" OR re:"c"`, `((re:"a" AND re:"b") OR re:"c")`},
+		{`re:"a" OR re:"b" AND re:"c"`, `(re:"a" OR (re:"b" AND re:"c"))`},
+		{`NOT re:"a" AND re:"b"`, `(NOT re:"a" AND re:"b")`},
+		{`(re:"fatal error" AND NOT re:"exit status 2") OR re:"DATA RACE"`, `((re:"fatal error" AND NOT re:"exit status 2") OR re:"DATA RACE")`},
+		{`NOT (re:"a" OR re:"b")`, `NOT (re:"a" OR re:"b")`},
+	} {
+		q, err := parseQuery(test.in)
+		if err != nil {
+			t.Errorf("parseQuery(%s): unexpected error %v", test.in, err)
+			continue
+		}
+		if got := q.String(); got != test.want {
+			t.Errorf("parseQuery(%s) = %s, want %s", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, in := range []string{
+		``,
+		`re:`,
+		`re:a`,
+		`re:"a" AND`,
+		`(re:"a"`,
+		`re:"a")`,
+		`re:"a" re:"b"`,
+		`re:"("`,
+		`foo`,
+	} {
+		if _, err := parseQuery(in); err == nil {
+			t.Errorf("parseQuery(%s): expected error", in)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	q, err := parseQuery(`(re:"fatal error" AND NOT re:"exit status 2") OR re:"^WARNING: DATA RACE"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		data string
+		want bool
+	}{
+		{"fatal error: x\n", true},
+		{"fatal error: x\nexit status 2\n", false},
+		{"ok\nWARNING: DATA RACE\nexit status 2\n", true},
+		{"ok\n", false},
+	} {
+		if got := q.Match([]byte(test.data)); got != test.want {
+			t.Errorf("Match(%q) = %v, want %v", test.data, got, test.want)
+		}
+	}
+}
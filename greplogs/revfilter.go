@@ -0,0 +1,174 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// revFilter restricts the search to logs whose commit falls in a
+// date or commit range. Commits are identified using the .rev.json
+// metadata written by fetchlogs alongside each revision's logs.
+type revFilter struct {
+	// since and until, if non-zero, bound the commit date.
+	// Both are inclusive.
+	since, until time.Time
+
+	// revFrom and revTo are the endpoints of a commit range
+	// "from..to". Either may be "".
+	revFrom, revTo string
+
+	// metas caches the revision metadata for each directory. A
+	// nil value indicates the directory has no metadata.
+	metas map[string]*revMeta
+}
+
+// revMeta is the subset of a .rev.json file used for filtering.
+type revMeta struct {
+	Revision string
+	Date     string
+
+	date time.Time
+}
+
+// parseDate parses the -since and -until flags. If s is just a date,
+// the result is the beginning of that day if end is false and the
+// last instant of that day if end is true.
+func parseDate(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date %q: want YYYY-MM-DD or RFC 3339 time", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// newRevFilter returns a revFilter for the -since, -until, and -rev
+// flags, or nil if none of these flags are set.
+func newRevFilter(since, until, revRange string) (*revFilter, error) {
+	if since == "" && until == "" && revRange == "" {
+		return nil, nil
+	}
+	rf := &revFilter{metas: make(map[string]*revMeta)}
+	var err error
+	if since != "" {
+		if rf.since, err = parseDate(since, false); err != nil {
+			return nil, err
+		}
+	}
+	if until != "" {
+		if rf.until, err = parseDate(until, true); err != nil {
+			return nil, err
+		}
+	}
+	if revRange != "" {
+		i := strings.Index(revRange, "..")
+		if i < 0 {
+			return nil, fmt.Errorf("bad revision range %q: want A..B", revRange)
+		}
+		rf.revFrom, rf.revTo = revRange[:i], revRange[i+2:]
+	}
+	return rf, nil
+}
+
+// meta returns the revision metadata for logs in dir, or nil if dir
+// has no metadata.
+func (rf *revFilter) meta(dir string) *revMeta {
+	if m, ok := rf.metas[dir]; ok {
+		return m
+	}
+	var m *revMeta
+	if f, err := os.Open(filepath.Join(dir, ".rev.json")); err == nil {
+		m = new(revMeta)
+		err := json.NewDecoder(f).Decode(m)
+		f.Close()
+		if err == nil {
+			m.date, err = time.Parse(time.RFC3339, m.Date)
+		}
+		if err != nil {
+			m = nil
+		}
+	}
+	rf.metas[dir] = m
+	return m
+}
+
+// resolve returns the commit date of the unique revision with the
+// given hash prefix among the revisions in rf.metas.
+func (rf *revFilter) resolve(hash string) (time.Time, error) {
+	var found *revMeta
+	for _, m := range rf.metas {
+		if m == nil || !strings.HasPrefix(m.Revision, hash) {
+			continue
+		}
+		if found != nil && found.Revision != m.Revision {
+			return time.Time{}, fmt.Errorf("ambiguous revision %q", hash)
+		}
+		found = m
+	}
+	if found == nil {
+		return time.Time{}, fmt.Errorf("unknown revision %q", hash)
+	}
+	return found.date, nil
+}
+
+// filter returns the subset of files whose commit is in the filter's
+// range. Files without revision metadata are dropped. Files with
+// errors are always kept so the errors are reported.
+func (rf *revFilter) filter(files []logFile) ([]logFile, error) {
+	for i := range files {
+		rf.meta(filepath.Dir(files[i].path))
+	}
+
+	// Resolve the commit range to a date range. Like git, A..B
+	// excludes A and includes B.
+	var after, through time.Time
+	if rf.revFrom != "" {
+		var err error
+		if after, err = rf.resolve(rf.revFrom); err != nil {
+			return nil, err
+		}
+	}
+	if rf.revTo != "" {
+		var err error
+		if through, err = rf.resolve(rf.revTo); err != nil {
+			return nil, err
+		}
+	}
+
+	out := files[:0]
+	for _, f := range files {
+		if f.err == nil {
+			m := rf.meta(filepath.Dir(f.path))
+			if m == nil {
+				continue
+			}
+			if !rf.since.IsZero() && m.date.Before(rf.since) {
+				continue
+			}
+			if !rf.until.IsZero() && m.date.After(rf.until) {
+				continue
+			}
+			if !after.IsZero() && !m.date.After(after) {
+				continue
+			}
+			if !through.IsZero() && m.date.After(through) {
+				continue
+			}
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRevFilterDates(t *testing.T) {
+	// Write a revision directory with one log for each commit
+	// date.
+	dir, err := ioutil.TempDir("", "greplogs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var files []logFile
+	for i, date := range []string{
+		"2018-03-01T23:59:59Z",
+		"2018-03-02T00:00:00Z",
+		"2018-03-02T12:00:00Z",
+		"2018-03-02T12:00:01Z",
+		"2018-03-02T23:59:59Z",
+		"2018-03-03T00:00:00Z",
+	} {
+		revDir := filepath.Join(dir, fmt.Sprint(i))
+		if err := os.Mkdir(revDir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		meta := fmt.Sprintf(`{"Revision":"%040d","Date":%q}`, i, date)
+		if err := ioutil.WriteFile(filepath.Join(revDir, ".rev.json"), []byte(meta), 0666); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, logFile{path: filepath.Join(revDir, "log"), nicePath: date})
+	}
+
+	for _, test := range []struct {
+		since, until string
+		want         []string
+	}{
+		// Bounds are inclusive.
+		{"2018-03-02T12:00:00Z", "", []string{"2018-03-02T12:00:00Z", "2018-03-02T12:00:01Z", "2018-03-02T23:59:59Z", "2018-03-03T00:00:00Z"}},
+		{"", "2018-03-02T12:00:00Z", []string{"2018-03-01T23:59:59Z", "2018-03-02T00:00:00Z", "2018-03-02T12:00:00Z"}},
+		{"2018-03-02T12:00:00Z", "2018-03-02T12:00:00Z", []string{"2018-03-02T12:00:00Z"}},
+		// A date covers the whole day.
+		{"2018-03-02", "2018-03-02", []string{"2018-03-02T00:00:00Z", "2018-03-02T12:00:00Z", "2018-03-02T12:00:01Z", "2018-03-02T23:59:59Z"}},
+	} {
+		rf, err := newRevFilter(test.since, test.until, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := rf.filter(append([]logFile(nil), files...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range out {
+			got = append(got, f.nicePath)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("-since %q -until %q: got %v, want %v", test.since, test.until, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// logFile is a single file to search and the results of searching
+// it.
+type logFile struct {
+	path, nicePath string
+
+	// remote, if non-nil, indicates this is a remote log. In this
+	// case, path is its URL.
+	remote *remoteLog
+
+	// done is closed when the search of this file is complete.
+	done chan struct{}
+
+	found bool
+	out   bytes.Buffer
+	err   error
+
+	// mdFailures is the list of failures to print in
+	// deduplicated Markdown output.
+	mdFailures []mdFailure
+}
+
+// searchFiles searches files using up to jobs concurrent workers and
+// calls report for each file in the order of files. Files are
+// searched out of order, but their output is buffered so the final
+// output is deterministic.
+func searchFiles(files []logFile, jobs int, report func(f *logFile)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	for i := range files {
+		files[i].done = make(chan struct{})
+	}
+
+	// Bound the amount of buffered output by only letting
+	// workers run a limited distance ahead of the reporter.
+	ahead := make(chan struct{}, 4*jobs)
+	todo := make(chan *logFile)
+	go func() {
+		for i := range files {
+			ahead <- struct{}{}
+			todo <- &files[i]
+		}
+		close(todo)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range todo {
+				if f.err == nil {
+					f.found, f.err = process(f)
+				}
+				close(f.done)
+			}
+		}()
+	}
+
+	for i := range files {
+		f := &files[i]
+		<-f.done
+		report(f)
+		// Release the buffer.
+		f.out = bytes.Buffer{}
+		f.mdFailures = nil
+		<-ahead
+	}
+	wg.Wait()
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package benchfmt
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// FuzzParse checks that Parse accepts arbitrary input and that
+// printing what it parsed and parsing that again produces the same
+// benchmarks.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"BenchmarkX\t1\t2 ns/op 3 MB/s",
+		"BenchmarkX-4\t1\t2 ns/op",
+		"BenchmarkX/a:20/b:abc\t1\t2 ns/op",
+		"BenchmarkX/gomaxprocs:abc\t1\t2 ns/op",
+		"BenchmarkX-4/sub\t1\t2 ns/op",
+		"BenchmarkX\t1\tNaN ns/op",
+		"BenchmarkX\t1\tfoo bar",
+		"commit: 123456\ndate: Jan 1\nblank:\n\nBenchmarkX/commit:abcdef\t1\t2 ns/op",
+		"commit: 123456\nBenchmarkX\t1\t2 ns/op\ncommit: abcdef\nBenchmarkX\t1\t3 ns/op",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		bs, err := Parse(strings.NewReader(input))
+		if err != nil {
+			// The only errors are from reading.
+			t.Skip(err)
+		}
+		var buf bytes.Buffer
+		if err := Fprint(&buf, bs); err != nil {
+			t.Fatal(err)
+		}
+		printed := buf.String()
+		bs2, err := Parse(&buf)
+		if err != nil {
+			t.Fatalf("parsing printed benchmarks: %v", err)
+		}
+		if len(bs) != len(bs2) {
+			t.Fatalf("printed %d benchmarks, parsed %d:\n%s", len(bs), len(bs2), printed)
+		}
+		for i := range bs {
+			if !sameBenchmark(bs[i], bs2[i]) {
+				t.Fatalf("benchmark %d changed when printed:\nwant %s\ngot  %s\nprinted:\n%s", i, fmtBenchmark(bs[i]), fmtBenchmark(bs2[i]), printed)
+			}
+		}
+	})
+}
+
+func sameBenchmark(a, b *Benchmark) bool {
+	if a.Name != b.Name || a.Iterations != b.Iterations || len(a.Config) != len(b.Config) || len(a.Result) != len(b.Result) {
+		return false
+	}
+	for k, c := range a.Config {
+		c2, ok := b.Config[k]
+		if !ok || c.RawValue != c2.RawValue || c.InBlock != c2.InBlock {
+			return false
+		}
+	}
+	for k, v := range a.Result {
+		v2, ok := b.Result[k]
+		if !ok || v != v2 && !(math.IsNaN(v) && math.IsNaN(v2)) {
+			return false
+		}
+	}
+	return true
+}
+
+func fmtBenchmark(b *Benchmark) string {
+	config := make(map[string]Config)
+	for k, c := range b.Config {
+		config[k] = *c
+	}
+	return fmt.Sprintf("%q %d %+v %v", b.Name, b.Iterations, config, b.Result)
+}
@@ -2,11 +2,11 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package bench reads and writes Go benchmarks results files.
+// Package benchfmt reads and writes Go benchmarks results files.
 //
 // This format is specified at:
 // https://github.com/golang/proposal/blob/master/design/14313-benchmark-format.md
-package bench
+package benchfmt
 
 import (
 	"bufio"
@@ -79,18 +79,14 @@ func Parse(r io.Reader) ([]*Benchmark, error) {
 		}
 
 		// Configuration lines.
-		m := configRe.FindStringSubmatch(line)
-		if m != nil {
-			config[m[1]] = &Config{RawValue: m[2], InBlock: true}
+		if k, v, ok := ParseConfigLine(line); ok {
+			config[k] = &Config{RawValue: v, InBlock: true}
 			continue
 		}
 
 		// Benchmark lines.
-		if strings.HasPrefix(line, "Benchmark") {
-			b := parseBenchmark(line, config)
-			if b != nil {
-				benchmarks = append(benchmarks, b)
-			}
+		if b := ParseBenchmarkLine(line, config); b != nil {
+			benchmarks = append(benchmarks, b)
 		}
 	}
 	if err := scanner.Err(); err != nil {
@@ -100,6 +96,31 @@ func Parse(r io.Reader) ([]*Benchmark, error) {
 	return benchmarks, nil
 }
 
+// ParseConfigLine parses a single configuration line of the form
+// "key: value". If line is not a configuration line, it returns
+// false.
+func ParseConfigLine(line string) (key, value string, ok bool) {
+	m := configRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// ParseBenchmarkLine parses a single benchmark result line. config
+// is the block configuration in effect at line and is copied into
+// the returned Benchmark. If line is not a benchmark result line, it
+// returns nil.
+//
+// Parse is built on ParseConfigLine and ParseBenchmarkLine. These
+// are useful for processing a results file line by line.
+func ParseBenchmarkLine(line string, config map[string]*Config) *Benchmark {
+	if !strings.HasPrefix(line, "Benchmark") {
+		return nil
+	}
+	return parseBenchmark(line, config)
+}
+
 func parseBenchmark(line string, gconfig map[string]*Config) *Benchmark {
 	// TODO: Consider using scanner to avoid the slice allocation.
 	f := strings.Fields(line)
@@ -164,6 +185,9 @@ func parseBenchmark(line string, gconfig map[string]*Config) *Benchmark {
 		}
 		b.Result[f[i+1]] = val
 	}
+	if len(b.Result) == 0 {
+		return nil
+	}
 
 	return b
 }
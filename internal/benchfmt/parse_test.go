@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package bench
+package benchfmt
 
 import (
 	"bytes"
@@ -52,7 +52,7 @@ BenchmarkX	1	2`,
 BenchmarkX-4	1	2 ns/op`,
 			[]*Benchmark{
 				{"X", 1, map[string]*Config{
-					"gomaxprocs": &Config{"gomaxprocs", "4", "4", false},
+					"gomaxprocs": &Config{RawValue: "4"},
 				}, map[string]float64{"ns/op": 2}},
 			},
 		},
@@ -63,11 +63,11 @@ BenchmarkX/a:20/b:abc	1	2 ns/op
 BenchmarkY/c:123	2	4 ns/op`,
 			[]*Benchmark{
 				{"X", 1, map[string]*Config{
-					"a": &Config{"a", "20", "20", false},
-					"b": &Config{"b", "abc", "abc", false},
+					"a": &Config{RawValue: "20"},
+					"b": &Config{RawValue: "abc"},
 				}, map[string]float64{"ns/op": 2}},
 				{"Y", 2, map[string]*Config{
-					"c": &Config{"c", "123", "123", false},
+					"c": &Config{RawValue: "123"},
 				}, map[string]float64{"ns/op": 4}},
 			},
 		},
@@ -85,10 +85,10 @@ Not-config: x
 BenchmarkX	1	2 ns/op`,
 			[]*Benchmark{
 				{"X", 1, map[string]*Config{
-					"commit":      &Config{"commit", "123456", "123456", true},
-					"date":        &Config{"date", "Jan 1", "Jan 1", true},
-					"colon:colon": &Config{"colon:colon", "42", "42", true},
-					"blank":       &Config{"blank", "", "", true},
+					"commit":      &Config{RawValue: "123456", InBlock: true},
+					"date":        &Config{RawValue: "Jan 1", InBlock: true},
+					"colon:colon": &Config{RawValue: "42", InBlock: true},
+					"blank":       &Config{RawValue: "", InBlock: true},
 				}, map[string]float64{"ns/op": 2}},
 			},
 		},
@@ -100,8 +100,8 @@ date: Jan 1
 BenchmarkX/commit:abcdef	1	2 ns/op`,
 			[]*Benchmark{
 				{"X", 1, map[string]*Config{
-					"commit": &Config{"commit", "abcdef", "abcdef", false},
-					"date":   &Config{"date", "Jan 1", "Jan 1", true},
+					"commit": &Config{RawValue: "abcdef"},
+					"date":   &Config{RawValue: "Jan 1", InBlock: true},
 				}, map[string]float64{"ns/op": 2}},
 			},
 		},
@@ -114,12 +114,19 @@ date: Jan 1
 BenchmarkX	1	2 ns/op`,
 			[]*Benchmark{
 				{"X", 1, map[string]*Config{
-					"commit": &Config{"commit", "abcdef", "abcdef", true},
-					"date":   &Config{"date", "Jan 1", "Jan 1", true},
+					"commit": &Config{RawValue: "abcdef", InBlock: true},
+					"date":   &Config{RawValue: "Jan 1", InBlock: true},
 				}, map[string]float64{"ns/op": 2}},
 			},
 		},
 	} {
+		// Parse always sets gomaxprocs.
+		for _, b := range test.want {
+			if b.Config["gomaxprocs"] == nil {
+				b.Config["gomaxprocs"] = &Config{RawValue: "1"}
+			}
+		}
+
 		r := bytes.NewBufferString(test.input)
 		bs, err := Parse(r)
 		if err != nil {
@@ -139,3 +146,23 @@ BenchmarkX	1	2 ns/op`,
 		}
 	}
 }
+
+func TestParseConfigLine(t *testing.T) {
+	for _, test := range []struct {
+		line   string
+		ok     bool
+		key, v string
+	}{
+		{"commit: abcdef", true, "commit", "abcdef"},
+		{"date:\tJan 1", true, "date", "Jan 1"},
+		{"blank:", true, "blank", ""},
+		{"Commit: abcdef", false, "", ""},
+		{"commit:abcdef", false, "", ""},
+		{"BenchmarkX	1	2 ns/op", false, "", ""},
+	} {
+		k, v, ok := ParseConfigLine(test.line)
+		if ok != test.ok || k != test.key || v != test.v {
+			t.Errorf("ParseConfigLine(%q) = %q, %q, %v; want %q, %q, %v", test.line, k, v, ok, test.key, test.v, test.ok)
+		}
+	}
+}
@@ -2,13 +2,14 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package bench
+package benchfmt
 
 import (
 	"fmt"
 	"io"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -23,6 +24,10 @@ func Fprint(w io.Writer, bs []*Benchmark) error {
 	type block struct {
 		config []kv
 		bs     []*Benchmark
+
+		// blockGMP indicates that gomaxprocs was set by
+		// this or an earlier configuration block.
+		blockGMP bool
 	}
 
 	configKeys := func(b *Benchmark, inBlock bool) []string {
@@ -55,7 +60,8 @@ func Fprint(w io.Writer, bs []*Benchmark) error {
 
 		if len(blocks) == 0 || changed != nil {
 			// Start a new configuration block.
-			blocks = append(blocks, block{changed, nil})
+			_, blockGMP := lastConfig["gomaxprocs"]
+			blocks = append(blocks, block{changed, nil, blockGMP})
 		}
 
 		// Add benchmark to latest block.
@@ -84,7 +90,7 @@ func Fprint(w io.Writer, bs []*Benchmark) error {
 		}
 
 		// Construct benchmark lines.
-		lines := make([][]string, len(block.bs))
+		lines := make([][]string, 0, len(block.bs))
 		for _, b := range block.bs {
 			// Construct benchmark name.
 			name := []string{"Benchmark" + b.Name}
@@ -99,13 +105,20 @@ func Fprint(w io.Writer, bs []*Benchmark) error {
 				// TODO: Syntax check.
 				name = append(name, fmt.Sprintf("%s:%s", k, config.RawValue))
 			}
-			if haveGMP && gomaxprocs != "1" {
-				if len(name) == 1 {
+			// Parse defaults gomaxprocs to 1, so omit it if
+			// that's its value, unless that would inherit a
+			// different value from the configuration block.
+			if haveGMP && (gomaxprocs != "1" || block.blockGMP) {
+				if len(name) == 1 && isGomaxprocs(gomaxprocs) {
 					// Use short form.
 					name[0] = fmt.Sprintf("%s-%s", name[0], gomaxprocs)
 				} else {
 					name = append(name, fmt.Sprintf("gomaxprocs:%s", gomaxprocs))
 				}
+			} else if i := strings.LastIndex(b.Name, "-"); len(name) == 1 && i >= 0 && isGomaxprocs(b.Name[i+1:]) {
+				// Force the long form so Parse doesn't take
+				// the end of the name for gomaxprocs.
+				name = append(name, "")
 			}
 
 			// Construct results.
@@ -164,6 +177,13 @@ func Fprint(w io.Writer, bs []*Benchmark) error {
 	return nil
 }
 
+// isGomaxprocs returns whether s can follow the last "-" in a
+// benchmark name as a gomaxprocs value.
+func isGomaxprocs(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil && !strings.Contains(s, "-")
+}
+
 var fixedKeys = map[string]int{
 	"ns/op": -2,
 	"MB/s":  -1,
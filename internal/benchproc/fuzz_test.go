@@ -0,0 +1,62 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package benchproc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aclements/go-misc/internal/benchfmt"
+)
+
+// FuzzParseUnit checks that tidying a unit is idempotent.
+func FuzzParseUnit(f *testing.F) {
+	for _, seed := range []string{"ns/op", "MB/s", "B", "allocs/op", "/", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, raw string) {
+		u := ParseUnit(raw)
+		if u.Factor <= 0 {
+			t.Fatalf("ParseUnit(%q) has factor %v", raw, u.Factor)
+		}
+		if u2 := ParseUnit(u.Tidy); u2.Tidy != u.Tidy || u2.Factor != 1 || u2.Class != u.Class {
+			t.Fatalf("ParseUnit(%q) = %+v, but tidy unit parses as %+v", raw, u, u2)
+		}
+	})
+}
+
+// FuzzProject checks that projections recover any configuration
+// values and that different values have different keys.
+func FuzzProject(f *testing.F) {
+	f.Add("X", "a", "b", "X", "a", "b")
+	f.Add("X", "1:a", "", "X", "1", ":a")
+	f.Fuzz(func(t *testing.T, name1, a1, b1, name2, a2, b2 string) {
+		p := MustParseProjection(".name,a,b")
+		bench := func(name, a, b string) *benchfmt.Benchmark {
+			return &benchfmt.Benchmark{
+				Name: name,
+				Config: map[string]*benchfmt.Config{
+					"a": {RawValue: a},
+					"b": {RawValue: b},
+				},
+			}
+		}
+		k1, ok1 := p.Project(bench(name1, a1, b1))
+		k2, ok2 := p.Project(bench(name2, a2, b2))
+		if !ok1 || !ok2 {
+			t.Fatal("benchmark missing projected keys")
+		}
+		if got, want := k1.Values(), []string{name1, a1, b1}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got values %q, want %q", got, want)
+		}
+		same := name1 == name2 && a1 == a2 && b1 == b2
+		if (k1 == k2) != same {
+			t.Fatalf("keys %s and %s: equal is %v, want %v", k1, k2, k1 == k2, same)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package benchproc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aclements/go-misc/internal/benchfmt"
+)
+
+// NameKey is the projection key for the benchmark name.
+const NameKey = ".name"
+
+// A Projection extracts the values of a set of configuration keys
+// from benchmarks. Benchmarks with the same values for all of these
+// keys have the same Key, so a Projection groups benchmarks by
+// configuration.
+type Projection struct {
+	keys []string
+}
+
+// ParseProjection parses a comma-separated list of configuration
+// keys, such as "commit,.name". The key NameKey projects the
+// benchmark name.
+func ParseProjection(spec string) (*Projection, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, key := range strings.Split(spec, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("empty key in projection %q", spec)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate key %q in projection %q", key, spec)
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return &Projection{keys}, nil
+}
+
+// MustParseProjection is like ParseProjection, but panics if spec
+// can't be parsed. It's meant for projections fixed by a program.
+func MustParseProjection(spec string) *Projection {
+	p, err := ParseProjection(spec)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Keys returns the configuration keys p projects.
+func (p *Projection) Keys() []string {
+	return append([]string(nil), p.keys...)
+}
+
+// Project returns the values of p's keys in b. If b doesn't have
+// one of p's configuration keys, it returns false.
+func (p *Projection) Project(b *benchfmt.Benchmark) (Key, bool) {
+	// Length-prefix each value so any values can be packed into
+	// a comparable string.
+	var vals []byte
+	for _, key := range p.keys {
+		var val string
+		if key == NameKey {
+			val = b.Name
+		} else if c, ok := b.Config[key]; ok {
+			val = c.RawValue
+		} else {
+			return Key{}, false
+		}
+		vals = strconv.AppendInt(vals, int64(len(val)), 10)
+		vals = append(vals, ':')
+		vals = append(vals, val...)
+	}
+	return Key{p, string(vals)}, true
+}
+
+// Group groups bs by their Key under p. It returns the distinct keys
+// in the order they first appear in bs and the benchmarks with each
+// key. It omits benchmarks that don't have all of p's keys.
+func (p *Projection) Group(bs []*benchfmt.Benchmark) ([]Key, map[Key][]*benchfmt.Benchmark) {
+	var keys []Key
+	groups := make(map[Key][]*benchfmt.Benchmark)
+	for _, b := range bs {
+		k, ok := p.Project(b)
+		if !ok {
+			continue
+		}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], b)
+	}
+	return keys, groups
+}
+
+// A Key is the values of a Projection's keys in some benchmark. Keys
+// are comparable and can be used as map keys.
+type Key struct {
+	p    *Projection
+	vals string
+}
+
+// Values returns the value of each of the projection's keys, in
+// the order of Projection.Keys.
+func (k Key) Values() []string {
+	var vals []string
+	for s := k.vals; s != ""; {
+		i := strings.IndexByte(s, ':')
+		n, _ := strconv.Atoi(s[:i])
+		vals = append(vals, s[i+1:i+1+n])
+		s = s[i+1+n:]
+	}
+	return vals
+}
+
+// Get returns the value of configuration key key, or "" if key
+// isn't one of the projection's keys.
+func (k Key) Get(key string) string {
+	for i, val := range k.Values() {
+		if k.p.keys[i] == key {
+			return val
+		}
+	}
+	return ""
+}
+
+// String returns k in the form "key:value key:value".
+func (k Key) String() string {
+	if k.p == nil {
+		return ""
+	}
+	var parts []string
+	for i, val := range k.Values() {
+		parts = append(parts, k.p.keys[i]+":"+val)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package benchproc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aclements/go-misc/internal/benchfmt"
+)
+
+func TestParseProjection(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		want []string
+		err  bool
+	}{
+		{"commit", []string{"commit"}, false},
+		{"commit, .name", []string{"commit", ".name"}, false},
+		{"", nil, true},
+		{"commit,", nil, true},
+		{"commit,commit", nil, true},
+	} {
+		p, err := ParseProjection(test.spec)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseProjection(%q): want error", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseProjection(%q): %v", test.spec, err)
+			continue
+		}
+		if got := p.Keys(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseProjection(%q).Keys() = %q, want %q", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	bs, err := benchfmt.Parse(strings.NewReader(`
+commit: a
+BenchmarkX	1	1 ns/op
+BenchmarkY	1	2 ns/op
+BenchmarkX	1	3 ns/op
+commit: b
+BenchmarkX/commit:c	1	4 ns/op
+BenchmarkX	1	5 ns/op
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs = append(bs, &benchfmt.Benchmark{Name: "Z"})
+
+	keys, groups := MustParseProjection("commit,.name").Group(bs)
+	var got []string
+	for _, k := range keys {
+		var ns []float64
+		for _, b := range groups[k] {
+			ns = append(ns, b.Result["ns/op"])
+		}
+		got = append(got, fmt.Sprint(k, " ", ns))
+	}
+	want := []string{
+		"commit:a .name:X [1 3]",
+		"commit:a .name:Y [2]",
+		"commit:c .name:X [4]",
+		"commit:b .name:X [5]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got groups %q, want %q", got, want)
+	}
+
+	if k := keys[2]; k.Get("commit") != "c" || k.Get(".name") != "X" || k.Get("date") != "" {
+		t.Errorf("bad Get results for key %s", k)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package benchproc processes parsed benchmark results. It
+// normalizes metric units and groups benchmarks by their
+// configuration.
+package benchproc
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A Unit is a parsed benchmark metric unit, such as "ns/op" or
+// "MB/s".
+type Unit struct {
+	// Raw is the unit as written in the benchmark results.
+	Raw string
+
+	// Tidy is the unit in unprefixed base units, such as
+	// "sec/op" or "B/s". Values in Raw units multiplied by
+	// Factor are in Tidy units.
+	Tidy   string
+	Factor float64
+
+	// Class is the kind of quantity the unit's numerator
+	// measures.
+	Class UnitClass
+}
+
+// A UnitClass is a kind of quantity measured by a unit.
+type UnitClass int
+
+const (
+	// UnitOther is any quantity not otherwise classified, such
+	// as "allocs/op".
+	UnitOther UnitClass = iota
+
+	// UnitTime is a duration, in seconds once tidied.
+	UnitTime
+
+	// UnitBytes is an amount of data, in bytes once tidied.
+	UnitBytes
+)
+
+// baseUnits maps the numerators of units the testing package and
+// common tools produce to their base unit, scale factor, and class.
+var baseUnits = map[string]struct {
+	base   string
+	factor float64
+	class  UnitClass
+}{
+	"ns":  {"sec", 1e-9, UnitTime},
+	"us":  {"sec", 1e-6, UnitTime},
+	"µs":  {"sec", 1e-6, UnitTime},
+	"ms":  {"sec", 1e-3, UnitTime},
+	"s":   {"sec", 1, UnitTime},
+	"sec": {"sec", 1, UnitTime},
+
+	// The testing package uses decimal megabytes for MB/s.
+	"B":   {"B", 1, UnitBytes},
+	"KB":  {"B", 1e3, UnitBytes},
+	"MB":  {"B", 1e6, UnitBytes},
+	"GB":  {"B", 1e9, UnitBytes},
+	"KiB": {"B", 1 << 10, UnitBytes},
+	"MiB": {"B", 1 << 20, UnitBytes},
+	"GiB": {"B", 1 << 30, UnitBytes},
+}
+
+// ParseUnit parses a metric unit. The numerator is the unit up to
+// the first "/" or, if there is none, the whole unit. If the
+// numerator is a known time or data unit, such as "ns" or "MB", the
+// unit is converted to base units. Otherwise, the unit is left as
+// is with a Factor of 1.
+func ParseUnit(raw string) Unit {
+	num, rest := raw, ""
+	if i := strings.Index(raw, "/"); i >= 0 {
+		num, rest = raw[:i], raw[i:]
+	}
+	if b, ok := baseUnits[num]; ok {
+		return Unit{raw, b.base + rest, b.factor, b.class}
+	}
+	return Unit{raw, raw, 1, UnitOther}
+}
+
+// Tidy converts value in unit to the unit's tidy form. See Unit.
+func Tidy(unit string, value float64) (string, float64) {
+	u := ParseUnit(unit)
+	return u.Tidy, value * u.Factor
+}
+
+// siPrefixes are the prefixes Format uses for time and data units,
+// from largest to smallest.
+var siPrefixes = []struct {
+	prefix string
+	scale  float64
+}{
+	{"G", 1e9}, {"M", 1e6}, {"k", 1e3}, {"", 1}, {"m", 1e-3}, {"µ", 1e-6}, {"n", 1e-9},
+}
+
+// Format formats value, given in u's raw units, for display. Time
+// and data values are tidied and given an SI prefix, such as
+// "1.5ms/op" or "12MB/s".
+func (u Unit) Format(value float64) string {
+	if u.Class == UnitOther {
+		return strconv.FormatFloat(value, 'g', 4, 64) + " " + u.Raw
+	}
+	value *= u.Factor
+	base, rest := "B", strings.TrimPrefix(u.Tidy, "B")
+	if u.Class == UnitTime {
+		base, rest = "s", strings.TrimPrefix(u.Tidy, "sec")
+	}
+	p := siPrefixes[len(siPrefixes)-1]
+	for _, p2 := range siPrefixes {
+		if math.Abs(value) >= p2.scale {
+			p = p2
+			break
+		}
+	}
+	if u.Class == UnitBytes && p.scale < 1 {
+		// There are no fractional bytes.
+		p = siPrefixes[3]
+	}
+	return fmt.Sprintf("%.3g%s%s%s", value/p.scale, p.prefix, base, rest)
+}
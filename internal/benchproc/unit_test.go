@@ -0,0 +1,48 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package benchproc
+
+import "testing"
+
+func TestParseUnit(t *testing.T) {
+	for _, test := range []struct {
+		raw  string
+		want Unit
+	}{
+		{"ns/op", Unit{"ns/op", "sec/op", 1e-9, UnitTime}},
+		{"ns/GC", Unit{"ns/GC", "sec/GC", 1e-9, UnitTime}},
+		{"MB/s", Unit{"MB/s", "B/s", 1e6, UnitBytes}},
+		{"B/op", Unit{"B/op", "B/op", 1, UnitBytes}},
+		{"MiB", Unit{"MiB", "B", 1 << 20, UnitBytes}},
+		{"allocs/op", Unit{"allocs/op", "allocs/op", 1, UnitOther}},
+		{"max RSS bytes", Unit{"max RSS bytes", "max RSS bytes", 1, UnitOther}},
+		{"", Unit{"", "", 1, UnitOther}},
+	} {
+		if got := ParseUnit(test.raw); got != test.want {
+			t.Errorf("ParseUnit(%q) = %+v, want %+v", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		unit  string
+		value float64
+		want  string
+	}{
+		{"ns/op", 1500, "1.5µs/op"},
+		{"ns/op", 2.5e9, "2.5s/op"},
+		{"ns/op", 0.5, "0.5ns/op"},
+		{"ns/op", 0, "0ns/op"},
+		{"MB/s", 12, "12MB/s"},
+		{"B/op", 0.25, "0.25B/op"},
+		{"B/op", 2048, "2.05kB/op"},
+		{"allocs/op", 3, "3 allocs/op"},
+	} {
+		if got := ParseUnit(test.unit).Format(test.value); got != test.want {
+			t.Errorf("Format(%v %s) = %s, want %s", test.value, test.unit, got, test.want)
+		}
+	}
+}
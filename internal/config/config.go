@@ -0,0 +1,183 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package config reads the configuration file shared by the tools in
+// this repository, so common settings don't have to be repeated on
+// every command line.
+//
+// The configuration file is $GOMISC_CONFIG if set, or otherwise
+// go-misc/config.toml in the XDG configuration directory (usually
+// ~/.config/go-misc/config.toml). It's written in a subset of TOML:
+// tables, keys, and string, integer, float, boolean, and array
+// values. For example:
+//
+//	# Settings for all tools.
+//	repo = "~/go"
+//	logs = "~/.cache/fetchlogs/rev"
+//
+//	# Settings for one tool, named by its flags.
+//	[benchmany]
+//	n = 10
+//
+//	# Named profiles, selected with -profile or $GOMISC_PROFILE.
+//	[profiles.release]
+//	repo = "~/go-release"
+//	[profiles.release.gover]
+//	dir = "/scratch/gover-release"
+//
+// Top-level keys are common settings, which each tool maps to its own
+// flags:
+//
+//	repo       the Go git repository (benchmany, benchplot, and benchserve -C; gover -repo)
+//	gover-dir  the gover directory of saved Go trees (benchmany, gover, and objdiff)
+//	logs       the fetchlogs directory of dashboard logs (findflakes and greplogs -dir)
+//	luci-project  the LUCI project to search (greplogs)
+//	alert-url  the URL to POST alerts to (benchserve and findflakes)
+//	fetch-cmd  the command to fetch new logs (findflakes)
+//
+// Values starting with "~/" are relative to the home directory.
+//
+// A tool's settings come from, in increasing order of precedence, the
+// common settings, the tool's table, the selected profile's common
+// settings, the profile's table for the tool, and finally flags given
+// on the command line.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Path returns the path of the configuration file.
+func Path() string {
+	if path := os.Getenv("GOMISC_CONFIG"); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		// Not XDG but standard for OS X.
+		if runtime.GOOS == "darwin" {
+			dir = filepath.Join(homeDir(), "Library/Application Support")
+		} else {
+			dir = filepath.Join(homeDir(), ".config")
+		}
+	}
+	return filepath.Join(dir, "go-misc", "config.toml")
+}
+
+func homeDir() string {
+	if home := os.Getenv("HOME"); home != "" {
+		return home
+	}
+	if u, err := user.Current(); err == nil {
+		return u.HomeDir
+	}
+	return ""
+}
+
+// Flags applies the configuration file to a tool's flags.
+type Flags struct {
+	fs      *flag.FlagSet
+	tool    string
+	common  map[string]string
+	profile *string
+}
+
+// NewFlags prepares to apply the configuration file to the flags of
+// tool in fs. It registers a -profile flag in fs to select a profile.
+// common maps the names of common settings (see the package
+// documentation) to the names of the flags in fs they set.
+//
+// Call Apply after parsing fs.
+func NewFlags(fs *flag.FlagSet, tool string, common map[string]string) *Flags {
+	f := &Flags{fs: fs, tool: tool, common: common}
+	f.profile = fs.String("profile", os.Getenv("GOMISC_PROFILE"), "use settings from configuration `profile` (see "+Path()+")")
+	return f
+}
+
+// Apply sets each flag that wasn't given on the command line from the
+// configuration file. It's not an error for the file not to exist,
+// unless a profile was selected.
+func (f *Flags) Apply() error {
+	path := Path()
+	file, err := Load(path)
+	if os.IsNotExist(err) && *f.profile == "" {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	f.fs.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+
+	// Gather settings from lowest to highest precedence.
+	var tables []Table
+	tables = append(tables, f.commonTable(file.Root), file.Root.Table(f.tool))
+	if *f.profile != "" {
+		profile := file.Root.Table("profiles").Table(*f.profile)
+		if profile == nil {
+			return fmt.Errorf("%s: no profile %q", path, *f.profile)
+		}
+		tables = append(tables, f.commonTable(profile), profile.Table(f.tool))
+	}
+	vals := make(map[string]Value)
+	for _, t := range tables {
+		for name, v := range t {
+			if _, ok := v.(Table); ok {
+				continue
+			}
+			if f.fs.Lookup(name) == nil {
+				return fmt.Errorf("%s: %s has no flag -%s", path, f.tool, name)
+			}
+			vals[name] = v
+		}
+	}
+
+	for name, v := range vals {
+		if set[name] {
+			continue
+		}
+		fl := f.fs.Lookup(name)
+		strs := []Value{v}
+		if list, ok := v.([]Value); ok {
+			// Set flags from each element, in case it's a
+			// repeatable flag.
+			strs = list
+		}
+		for _, s := range strs {
+			if err := fl.Value.Set(expandHome(fmt.Sprint(s))); err != nil {
+				return fmt.Errorf("%s: setting -%s: %v", path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// commonTable returns the flag settings for f's tool from the common
+// settings in t.
+func (f *Flags) commonTable(t Table) Table {
+	out := make(Table)
+	for key, v := range t {
+		if name, ok := f.common[key]; ok {
+			if _, ok := v.(Table); !ok {
+				out[name] = v
+			}
+		}
+	}
+	return out
+}
+
+func expandHome(s string) string {
+	if strings.HasPrefix(s, "~/") {
+		return filepath.Join(homeDir(), s[2:])
+	}
+	return s
+}
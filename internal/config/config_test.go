@@ -0,0 +1,154 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const input = `
+# Comment
+repo = "~/go" # trailing comment
+n = 1_000
+x = 1.5
+ok = true
+list = ["a", 'b\c', 3]
+"quoted key" = "tab\there"
+
+[gover]
+dir = '/tmp/gover'
+
+[profiles . release]
+repo = "/release"
+gover.dir = "/tmp/release"
+`
+	f, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Table{
+		"repo":       "~/go",
+		"n":          int64(1000),
+		"x":          1.5,
+		"ok":         true,
+		"list":       []Value{"a", `b\c`, int64(3)},
+		"quoted key": "tab\there",
+		"gover":      Table{"dir": "/tmp/gover"},
+		"profiles": Table{
+			"release": Table{
+				"repo":  "/release",
+				"gover": Table{"dir": "/tmp/release"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(f.Root, want) {
+		t.Errorf("got %#v\nwant %#v", f.Root, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		input, err string
+	}{
+		{"a = ", "1: expected value"},
+		{"a = b", `1: bad value "b"`},
+		{"a = 1 2", `1: unexpected "2"`},
+		{"a = 'x", "1: unterminated string"},
+		{"a = [1 2]", "1: expected ',' or ']' in array"},
+		{"a = 1\na = 2", "2: duplicate key a"},
+		{"a = 1\n[a]", "2: a is not a table"},
+		{"[a", "1: expected ']'"},
+		{"[[a]]", "1: expected key"},
+		{"= 1", "1: expected key"},
+	} {
+		_, err := Parse(strings.NewReader(test.input))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Parse(%q): got error %v, want %s", test.input, err, test.err)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.toml")
+	err = ioutil.WriteFile(path, []byte(`
+repo = "/go"
+logs = "/logs"
+
+[tool]
+n = 5
+v = true
+
+[profiles.p]
+repo = "/go-p"
+[profiles.p.tool]
+n = 6
+`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("GOMISC_CONFIG", os.Getenv("GOMISC_CONFIG"))
+	os.Setenv("GOMISC_CONFIG", path)
+	defer os.Setenv("GOMISC_PROFILE", os.Getenv("GOMISC_PROFILE"))
+	os.Setenv("GOMISC_PROFILE", "")
+
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "/go 5 true"},
+		{[]string{"-C", "/x", "-n", "1"}, "/x 1 true"},
+		{[]string{"-profile", "p"}, "/go-p 6 true"},
+		{[]string{"-profile", "p", "-C", "/x"}, "/x 6 true"},
+	} {
+		fs := flag.NewFlagSet("tool", flag.ContinueOnError)
+		repo := fs.String("C", "", "")
+		n := fs.Int("n", 0, "")
+		v := fs.Bool("v", false, "")
+		cfg := NewFlags(fs, "tool", map[string]string{"repo": "C"})
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.Apply(); err != nil {
+			t.Errorf("%q: %v", test.args, err)
+			continue
+		}
+		got := strings.Join([]string{*repo, fmt.Sprint(*n), fmt.Sprint(*v)}, " ")
+		if got != test.want {
+			t.Errorf("%q: got %s, want %s", test.args, got, test.want)
+		}
+	}
+
+	// Unknown profile.
+	fs := flag.NewFlagSet("tool", flag.ContinueOnError)
+	fs.String("C", "", "")
+	fs.Int("n", 0, "")
+	fs.Bool("v", false, "")
+	cfg := NewFlags(fs, "tool", map[string]string{"repo": "C"})
+	fs.Parse([]string{"-profile", "q"})
+	if err := cfg.Apply(); err == nil {
+		t.Errorf("want error for unknown profile")
+	}
+
+	// Tool settings for a flag that doesn't exist.
+	fs = flag.NewFlagSet("tool", flag.ContinueOnError)
+	cfg = NewFlags(fs, "tool", nil)
+	fs.Parse(nil)
+	if err := cfg.Apply(); err == nil || !strings.Contains(err.Error(), "has no flag") {
+		t.Errorf("want error for unknown flag, got %v", err)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A File is a parsed configuration file.
+type File struct {
+	Root Table
+}
+
+// A Table is a TOML table. Each value is a string, int64, float64,
+// bool, []Value, or Table.
+type Table map[string]Value
+
+// A Value is a TOML value.
+type Value interface{}
+
+// Table returns the sub-table name of t, or nil if there is none.
+func (t Table) Table(name string) Table {
+	sub, _ := t[name].(Table)
+	return sub
+}
+
+// Load reads and parses the configuration file at path.
+func Load(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file, err := Parse(f)
+	if _, ok := err.(*SyntaxError); ok {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return file, nil
+}
+
+// A SyntaxError is an error in the syntax of a configuration file.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Line, e.Msg)
+}
+
+// Parse parses a configuration file from r. It supports the subset
+// of TOML described in the package documentation: single-line keys
+// and values, and no inline tables, arrays of tables, multi-line
+// strings, or dates.
+func Parse(r io.Reader) (*File, error) {
+	root := make(Table)
+	cur := root
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		p := &parser{s: scanner.Text(), line: lineno}
+		p.space()
+		if p.eol() {
+			continue
+		}
+		if p.s[0] == '[' {
+			// Table header.
+			p.s = p.s[1:]
+			keys := p.keys()
+			p.expect(']')
+			p.end()
+			if p.err != nil {
+				return nil, p.err
+			}
+			cur = root
+			for i, key := range keys {
+				switch sub := cur[key].(type) {
+				case nil:
+					t := make(Table)
+					cur[key] = t
+					cur = t
+				case Table:
+					cur = sub
+				default:
+					return nil, &SyntaxError{lineno, fmt.Sprintf("%s is not a table", strings.Join(keys[:i+1], "."))}
+				}
+			}
+			continue
+		}
+
+		// Key/value pair.
+		keys := p.keys()
+		p.expect('=')
+		val := p.value()
+		p.end()
+		if p.err != nil {
+			return nil, p.err
+		}
+		t := cur
+		for _, key := range keys[:len(keys)-1] {
+			sub, ok := t[key].(Table)
+			if !ok {
+				if t[key] != nil {
+					return nil, &SyntaxError{lineno, fmt.Sprintf("%s is not a table", key)}
+				}
+				sub = make(Table)
+				t[key] = sub
+			}
+			t = sub
+		}
+		key := keys[len(keys)-1]
+		if _, ok := t[key]; ok {
+			return nil, &SyntaxError{lineno, fmt.Sprintf("duplicate key %s", key)}
+		}
+		t[key] = val
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &File{root}, nil
+}
+
+// parser parses a single line of a configuration file. Once it
+// encounters an error, it records it in err and further parsing does
+// nothing.
+type parser struct {
+	s    string
+	line int
+	err  error
+}
+
+func (p *parser) errorf(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = &SyntaxError{p.line, fmt.Sprintf(format, args...)}
+	}
+	p.s = ""
+}
+
+// space consumes white space.
+func (p *parser) space() {
+	p.s = strings.TrimLeft(p.s, " \t")
+}
+
+// eol returns whether the rest of the line is empty or a comment.
+func (p *parser) eol() bool {
+	return p.s == "" || p.s[0] == '#'
+}
+
+// end checks that nothing but a comment follows.
+func (p *parser) end() {
+	p.space()
+	if !p.eol() {
+		p.errorf("unexpected %q", p.s)
+	}
+}
+
+// expect consumes c, surrounded by optional white space.
+func (p *parser) expect(c byte) {
+	p.space()
+	if p.s == "" || p.s[0] != c {
+		p.errorf("expected %q", c)
+		return
+	}
+	p.s = p.s[1:]
+	p.space()
+}
+
+// keys parses a dotted key.
+func (p *parser) keys() []string {
+	var keys []string
+	for {
+		p.space()
+		var key string
+		if p.s != "" && (p.s[0] == '"' || p.s[0] == '\'') {
+			key = p.str()
+		} else {
+			i := 0
+			for i < len(p.s) && isBareKeyChar(p.s[i]) {
+				i++
+			}
+			if i == 0 {
+				p.errorf("expected key")
+				return nil
+			}
+			key, p.s = p.s[:i], p.s[i:]
+		}
+		keys = append(keys, key)
+		p.space()
+		if p.s == "" || p.s[0] != '.' {
+			return keys
+		}
+		p.s = p.s[1:]
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-'
+}
+
+// str parses a basic ("...") or literal ('...') string.
+func (p *parser) str() string {
+	if p.s[0] == '\'' {
+		i := strings.IndexByte(p.s[1:], '\'')
+		if i < 0 {
+			p.errorf("unterminated string")
+			return ""
+		}
+		s := p.s[1 : i+1]
+		p.s = p.s[i+2:]
+		return s
+	}
+	// Find the closing quote, skipping escapes.
+	for i := 1; i < len(p.s); i++ {
+		switch p.s[i] {
+		case '\\':
+			i++
+		case '"':
+			s, err := strconv.Unquote(p.s[:i+1])
+			if err != nil {
+				p.errorf("bad string %s", p.s[:i+1])
+				return ""
+			}
+			p.s = p.s[i+1:]
+			return s
+		}
+	}
+	p.errorf("unterminated string")
+	return ""
+}
+
+// value parses a value.
+func (p *parser) value() Value {
+	p.space()
+	if p.s == "" {
+		p.errorf("expected value")
+		return nil
+	}
+	switch p.s[0] {
+	case '"', '\'':
+		return p.str()
+	case '[':
+		p.s = p.s[1:]
+		var vals []Value
+		for {
+			p.space()
+			if p.s != "" && p.s[0] == ']' {
+				p.s = p.s[1:]
+				return vals
+			}
+			vals = append(vals, p.value())
+			p.space()
+			if p.s != "" && p.s[0] == ',' {
+				p.s = p.s[1:]
+			} else if p.s == "" || p.s[0] != ']' {
+				p.errorf("expected ',' or ']' in array")
+				return nil
+			}
+		}
+	}
+
+	// Bare value: boolean or number.
+	i := strings.IndexAny(p.s, " \t,]#")
+	if i < 0 {
+		i = len(p.s)
+	}
+	tok := p.s[:i]
+	p.s = p.s[i:]
+	switch tok {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	num := strings.Replace(tok, "_", "", -1)
+	if n, err := strconv.ParseInt(num, 0, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(num, 64); err == nil {
+		return f
+	}
+	p.errorf("bad value %q", tok)
+	return nil
+}
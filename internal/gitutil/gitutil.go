@@ -0,0 +1,156 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gitutil is the git access layer shared by the tools in this
+// repository.
+//
+// A Repo is a local repository, which may be a working tree or a bare
+// clone. Most operations, such as listing commits and resolving
+// revisions, work on both, so tools can run against a bare mirror.
+// Operations that need files checked out use a temporary worktree of
+// the repository (see Repo.AddWorktree).
+//
+// Remote repositories can be queried without a local clone using
+// LsRemote and ResolveRemote, and cloned with Clone.
+//
+// All operations run the git command.
+package gitutil
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// An Error is a failed git command.
+type Error struct {
+	// Args is the arguments to git.
+	Args []string
+
+	// Err is the error from running git, usually an
+	// *exec.ExitError.
+	Err error
+
+	// Stderr is git's standard error.
+	Stderr []byte
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("git %s: %v", strings.Join(e.Args, " "), e.Err)
+	if stderr := bytes.TrimSpace(e.Stderr); len(stderr) > 0 {
+		msg += "\n" + string(stderr)
+	}
+	return msg
+}
+
+// A Repo is a local git repository.
+type Repo struct {
+	dir  string
+	bare bool
+}
+
+// Open returns the repository containing dir. If dir is in a working
+// tree, the Repo is the top level of that tree.
+func Open(dir string) (*Repo, error) {
+	out, err := run("", "-C", dir, "rev-parse", "--is-bare-repository", "--absolute-git-dir")
+	if err != nil {
+		return nil, err
+	}
+	fs := strings.Fields(out)
+	if len(fs) != 2 {
+		return nil, fmt.Errorf("%s: unexpected rev-parse output %q", dir, out)
+	}
+	if fs[0] == "true" {
+		return &Repo{dir: fs[1], bare: true}, nil
+	}
+	top, err := run("", "-C", dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	return &Repo{dir: strings.TrimSpace(top)}, nil
+}
+
+// Dir returns the top level directory of r's working tree, or its git
+// directory if r is bare.
+func (r *Repo) Dir() string {
+	return r.dir
+}
+
+// Bare returns whether r is a bare repository, with no working tree.
+func (r *Repo) Bare() bool {
+	return r.bare
+}
+
+// Command returns a command that runs git with args in r. This is
+// useful for commands whose output should be streamed.
+func (r *Repo) Command(args ...string) *exec.Cmd {
+	return exec.Command("git", append([]string{"-C", r.dir}, args...)...)
+}
+
+// Run runs git with args in r and returns its standard output.
+func (r *Repo) Run(args ...string) (string, error) {
+	return run(r.dir, args...)
+}
+
+// Lines is like Run, but splits the output into lines, omitting a
+// final empty line.
+func (r *Repo) Lines(args ...string) ([]string, error) {
+	out, err := r.Run(args...)
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(out, "\n"), "\n"), nil
+}
+
+// Resolve returns the full hash of the commit named by rev.
+func (r *Repo) Resolve(rev string) (string, error) {
+	out, err := r.Run("rev-parse", "--verify", rev+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Refs returns the branches and tags in r, indexed by the hash of the
+// commit they point to.
+func (r *Repo) Refs() (map[string][]string, error) {
+	// %(*objectname) is the commit pointed to by an annotated tag.
+	lines, err := r.Lines("for-each-ref", "--format=%(objectname) %(*objectname) %(refname:short)", "refs/heads", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string][]string)
+	for _, line := range lines {
+		fs := strings.Fields(line)
+		switch len(fs) {
+		case 2:
+			refs[fs[0]] = append(refs[fs[0]], fs[1])
+		case 3:
+			refs[fs[1]] = append(refs[fs[1]], fs[2])
+		}
+	}
+	return refs, nil
+}
+
+// TagsAt returns the tags that point to commit.
+func (r *Repo) TagsAt(commit string) ([]string, error) {
+	return r.Lines("tag", "--points-at", commit)
+}
+
+// run runs git with args in dir, or the current directory if dir is
+// "", and returns its standard output.
+func run(dir string, args ...string) (string, error) {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", &Error{Args: args, Err: err, Stderr: stderr.Bytes()}
+	}
+	return string(out), nil
+}
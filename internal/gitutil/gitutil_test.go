@@ -0,0 +1,225 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testRepo creates a repository in a temporary directory with commits
+// a, b, and c on master and a merge of branch "side" (commit s),
+// tagged "v1" and "v2" (annotated), and returns its directory and the
+// hash of each commit.
+func testRepo(t *testing.T) (dir string, hashes map[string]string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "gitutil-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgit(t, dir, "init", "-q")
+	tgit(t, dir, "checkout", "-q", "-b", "master")
+	hashes = make(map[string]string)
+	commit := func(name string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0666); err != nil {
+			t.Fatal(err)
+		}
+		tgit(t, dir, "add", name)
+		tgit(t, dir, "commit", "-q", "-m", "commit "+name)
+		hashes[name] = strings.TrimSpace(tgit(t, dir, "rev-parse", "HEAD"))
+	}
+	commit("a")
+	tgit(t, dir, "checkout", "-q", "-b", "side")
+	commit("s")
+	tgit(t, dir, "checkout", "-q", "master")
+	commit("b")
+	tgit(t, dir, "merge", "-q", "--no-ff", "-m", "merge", "side")
+	hashes["merge"] = strings.TrimSpace(tgit(t, dir, "rev-parse", "HEAD"))
+	commit("c")
+	tgit(t, dir, "tag", "v1", hashes["a"])
+	tgit(t, dir, "tag", "-a", "-m", "v2", "v2", hashes["b"])
+	return dir, hashes
+}
+
+func tgit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func hashesOf(commits []*Commit) []string {
+	var hs []string
+	for _, c := range commits {
+		hs = append(hs, c.Hash)
+	}
+	return hs
+}
+
+func TestLog(t *testing.T) {
+	dir, h := testRepo(t)
+	defer os.RemoveAll(dir)
+	r, err := Open(filepath.Join(dir, "."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Bare() {
+		t.Errorf("working tree is bare")
+	}
+
+	commits, err := r.Log(LogOptions{}, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 5 {
+		t.Fatalf("want 5 commits, got %v", hashesOf(commits))
+	}
+	if c := commits[0]; c.Hash != h["c"] || c.Subject != "commit c" || !reflect.DeepEqual(c.Parents, []string{h["merge"]}) {
+		t.Errorf("want commit c with parent %s, got %+v", h["merge"], c)
+	}
+	children := Children(commits)
+	if want := []string{h["merge"]}; !reflect.DeepEqual(children[h["s"]], want) {
+		t.Errorf("children of s: want %v, got %v", want, children[h["s"]])
+	}
+
+	commits, err = r.Log(LogOptions{FirstParent: true}, h["a"]+"..master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hashesOf(commits), []string{h["c"], h["merge"], h["b"]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first-parent log: want %v, got %v", want, got)
+	}
+
+	commits, err = r.Log(LogOptions{NoWalk: true}, "v1", "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The commits may have the same date, so ignore the order.
+	got, want := hashesOf(commits), []string{h["a"], h["c"]}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("no-walk log: want %v, got %v", want, got)
+	}
+}
+
+func TestRefs(t *testing.T) {
+	dir, h := testRepo(t)
+	defer os.RemoveAll(dir)
+	r, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := r.Resolve("v2"); err != nil || got != h["b"] {
+		t.Errorf("Resolve(v2) = %s, %v; want %s", got, err, h["b"])
+	}
+	if _, err := r.Resolve("nonexistent"); err == nil {
+		t.Errorf("Resolve(nonexistent) succeeded")
+	}
+
+	refs, err := r.Refs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"v2"}; !reflect.DeepEqual(refs[h["b"]], want) {
+		t.Errorf("refs at b: want %v, got %v", want, refs[h["b"]])
+	}
+	if tags, err := r.TagsAt(h["a"]); err != nil || !reflect.DeepEqual(tags, []string{"v1"}) {
+		t.Errorf("TagsAt(a) = %v, %v; want [v1]", tags, err)
+	}
+
+	// Resolve the same refs remotely.
+	for rev, want := range map[string]string{"v1": h["a"], "v2": h["b"], "master": h["c"], "refs/heads/side": h["s"], h["b"]: h["b"]} {
+		if got, err := ResolveRemote(dir, rev); err != nil || got != want {
+			t.Errorf("ResolveRemote(%s) = %s, %v; want %s", rev, got, err, want)
+		}
+	}
+	if _, err := ResolveRemote(dir, "nonexistent"); err == nil {
+		t.Errorf("ResolveRemote(nonexistent) succeeded")
+	}
+}
+
+func TestIsRemote(t *testing.T) {
+	for repo, want := range map[string]bool{
+		"https://go.googlesource.com/go": true,
+		"git@github.com:golang/go.git":   true,
+		"/home/user/go":                  false,
+		"go":                             false,
+		"./a:b":                          false,
+		`C:\go`:                          false,
+	} {
+		if got := IsRemote(repo); got != want {
+			t.Errorf("IsRemote(%q) = %v, want %v", repo, got, want)
+		}
+	}
+}
+
+func TestWorktree(t *testing.T) {
+	dir, h := testRepo(t)
+	defer os.RemoveAll(dir)
+
+	tmp, err := ioutil.TempDir("", "gitutil-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Check out a worktree from a bare mirror.
+	mirror, err := Clone(dir, filepath.Join(tmp, "mirror"), CloneOptions{Mirror: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mirror.Bare() {
+		t.Fatalf("mirror is not bare")
+	}
+	wtDir := filepath.Join(tmp, "wt")
+	wt, err := mirror.AddWorktree(wtDir, "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := wt.Resolve("HEAD"); err != nil || got != h["b"] {
+		t.Errorf("worktree HEAD = %s, %v; want %s", got, err, h["b"])
+	}
+	if err := wt.Checkout("v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(wtDir, "b")); !os.IsNotExist(err) {
+		t.Errorf("file b exists after checking out v1")
+	}
+	if err := mirror.RemoveWorktree(wtDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(wtDir); !os.IsNotExist(err) {
+		t.Errorf("worktree exists after RemoveWorktree")
+	}
+
+	// Shallow clone of a single commit.
+	shallow, err := Clone("file://"+filepath.ToSlash(dir), filepath.Join(tmp, "shallow"), CloneOptions{Depth: 1, Rev: h["merge"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, err := shallow.Log(LogOptions{}, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hashesOf(commits); !reflect.DeepEqual(got, []string{h["merge"]}) {
+		t.Errorf("shallow clone has commits %v, want only %s", got, h["merge"])
+	}
+}
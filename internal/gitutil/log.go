@@ -0,0 +1,100 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Commit is a node in the commit DAG.
+type Commit struct {
+	Hash    string
+	Parents []string
+	Subject string
+
+	AuthorDate, CommitDate time.Time
+}
+
+// LogOptions control which commits Log returns.
+type LogOptions struct {
+	// FirstParent follows only the first parent of merge
+	// commits, so a range on a branch is the commits made
+	// directly on that branch.
+	FirstParent bool
+
+	// NoWalk returns only the commits named by the revisions,
+	// without their ancestors. Ranges, such as A..B, are still
+	// expanded.
+	NoWalk bool
+}
+
+// logFormat is the log format parsed by Log. Records are separated
+// by NUL, since subjects can contain anything else.
+const logFormat = "--format=format:%H%n%P%n%aI%n%cI%n%s%x00"
+
+// Log returns the commits in revs, which are revisions and ranges
+// spelled as documented in gitrevisions(7). If revs is empty, it
+// returns all commits reachable from any ref. Commits are in reverse
+// chronological order, most recent first, like git-rev-list(1).
+func (r *Repo) Log(opts LogOptions, revs ...string) ([]*Commit, error) {
+	args := []string{"log", "-s", logFormat}
+	if opts.FirstParent {
+		args = append(args, "--first-parent")
+	}
+	if opts.NoWalk {
+		args = append(args, "--no-walk=sorted")
+	}
+	if len(revs) == 0 {
+		args = append(args, "--all")
+	} else {
+		args = append(args, revs...)
+	}
+	args = append(args, "--")
+	out, err := r.Run(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []*Commit
+	for _, rec := range strings.Split(out, "\x00") {
+		rec = strings.TrimPrefix(rec, "\n")
+		if rec == "" {
+			continue
+		}
+		fs := strings.SplitN(rec, "\n", 5)
+		if len(fs) != 5 {
+			return nil, fmt.Errorf("malformed git log record %q", rec)
+		}
+		c := &Commit{Hash: fs[0], Parents: strings.Fields(fs[1]), Subject: fs[4]}
+		if c.AuthorDate, err = time.Parse(time.RFC3339, fs[2]); err != nil {
+			return nil, fmt.Errorf("commit %s: bad author date: %v", c.Hash, err)
+		}
+		if c.CommitDate, err = time.Parse(time.RFC3339, fs[3]); err != nil {
+			return nil, fmt.Errorf("commit %s: bad commit date: %v", c.Hash, err)
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// Children returns the children of each commit in commits, indexed
+// by hash. It only includes edges between commits in commits.
+func Children(commits []*Commit) map[string][]string {
+	in := make(map[string]bool, len(commits))
+	for _, c := range commits {
+		in[c.Hash] = true
+	}
+	children := make(map[string][]string)
+	for _, c := range commits {
+		for _, p := range c.Parents {
+			if in[p] {
+				children[p] = append(children[p], c.Hash)
+			}
+		}
+	}
+	return children
+}
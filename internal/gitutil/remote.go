@@ -0,0 +1,169 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A Ref is a named reference in a remote repository.
+type Ref struct {
+	// Name is the full name of the ref, such as
+	// "refs/heads/master".
+	Name string
+
+	// Hash is the object the ref points to. For an annotated
+	// tag, this is the commit the tag points to.
+	Hash string
+}
+
+// IsRemote returns whether repo names a remote repository, rather
+// than a local directory.
+func IsRemote(repo string) bool {
+	if strings.Contains(repo, "://") {
+		return true
+	}
+	// scp-like syntax: [user@]host:path. Like git, a colon
+	// after a slash is part of a local path.
+	i := strings.Index(repo, ":")
+	return i > 1 && !strings.Contains(repo[:i], "/")
+}
+
+// LsRemote returns the refs in remote repository remote matching
+// patterns, without cloning it. If there are no patterns, it returns
+// all refs.
+func LsRemote(remote string, patterns ...string) ([]Ref, error) {
+	args := append([]string{"ls-remote", remote}, patterns...)
+	out, err := run("", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Annotated tags are listed twice: once as the tag object and
+	// once peeled, with a ^{} suffix. Report the peeled commit.
+	var refs []Ref
+	index := make(map[string]int)
+	for _, line := range strings.Split(out, "\n") {
+		fs := strings.Fields(line)
+		if len(fs) != 2 {
+			continue
+		}
+		name := fs[1]
+		if peeled := strings.TrimSuffix(name, "^{}"); peeled != name {
+			if i, ok := index[peeled]; ok {
+				refs[i].Hash = fs[0]
+			}
+			continue
+		}
+		index[name] = len(refs)
+		refs = append(refs, Ref{Name: name, Hash: fs[0]})
+	}
+	return refs, nil
+}
+
+var hashRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ResolveRemote returns the commit hash of rev in remote repository
+// remote without cloning it. rev may be a full commit hash, a branch,
+// a tag, or a full ref name. Remote repositories generally can't
+// resolve abbreviated hashes or revision expressions.
+func ResolveRemote(remote, rev string) (string, error) {
+	if hashRe.MatchString(rev) {
+		return rev, nil
+	}
+	// Patterns must match the peeled ^{} form of annotated tags
+	// separately.
+	refs, err := LsRemote(remote, rev, rev+"^{}")
+	if err != nil {
+		return "", err
+	}
+	// ls-remote matches any trailing path components, so look
+	// for exact matches in the same order as git rev-parse.
+	for _, name := range []string{rev, "refs/" + rev, "refs/tags/" + rev, "refs/heads/" + rev} {
+		for _, ref := range refs {
+			if ref.Name == name {
+				return ref.Hash, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%s: unknown revision %s", remote, rev)
+}
+
+// CloneOptions control how Clone clones a repository.
+type CloneOptions struct {
+	// Bare creates a bare clone, with no working tree.
+	Bare bool
+
+	// Mirror creates a bare clone that mirrors all of the
+	// remote's refs, suitable for updating with "git fetch".
+	Mirror bool
+
+	// Depth, if positive, creates a shallow clone with history
+	// truncated to Depth commits.
+	Depth int
+
+	// Rev, if not "", clones only revision rev, which may be a
+	// commit hash, and checks it out. This requires a server that
+	// allows fetching any commit, which most hosts do.
+	Rev string
+}
+
+// Clone clones the repository at url into dir, which must not exist
+// or be empty.
+func Clone(url, dir string, opts CloneOptions) (*Repo, error) {
+	if opts.Rev == "" {
+		args := []string{"clone", "-q"}
+		if opts.Mirror {
+			args = append(args, "--mirror")
+		} else if opts.Bare {
+			args = append(args, "--bare")
+		}
+		if opts.Depth > 0 {
+			args = append(args, "--depth", fmt.Sprint(opts.Depth))
+		}
+		args = append(args, "--", url, dir)
+		if _, err := run("", args...); err != nil {
+			return nil, err
+		}
+		return Open(dir)
+	}
+
+	// git clone can't clone a specific commit, so fetch it
+	// into a new repository.
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	initArgs := []string{"init", "-q"}
+	if opts.Bare || opts.Mirror {
+		initArgs = append(initArgs, "--bare")
+	}
+	if _, err := run(dir, initArgs...); err != nil {
+		return nil, err
+	}
+	r, err := Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	fetch := []string{"fetch", "-q"}
+	if opts.Depth > 0 {
+		fetch = append(fetch, "--depth", fmt.Sprint(opts.Depth))
+	}
+	fetch = append(fetch, "--", url, opts.Rev)
+	if _, err := r.Run(fetch...); err != nil {
+		return nil, err
+	}
+	if r.bare {
+		_, err = r.Run("update-ref", "--no-deref", "HEAD", "FETCH_HEAD")
+	} else {
+		_, err = r.Run("checkout", "-q", "--detach", "FETCH_HEAD")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gitutil
+
+import (
+	"os"
+)
+
+// AddWorktree checks out rev in a new worktree of r at dir, with a
+// detached HEAD, and returns the worktree's Repo. This works for bare
+// repositories, so a bare mirror can be used to check out many
+// revisions at once without disturbing any working tree.
+//
+// The caller should remove the worktree with RemoveWorktree when
+// it's done with it.
+func (r *Repo) AddWorktree(dir, rev string) (*Repo, error) {
+	if _, err := r.Run("worktree", "add", "-q", "--detach", dir, rev); err != nil {
+		return nil, err
+	}
+	return Open(dir)
+}
+
+// RemoveWorktree removes the worktree of r at dir, discarding any
+// changes in it.
+func (r *Repo) RemoveWorktree(dir string) error {
+	_, err := r.Run("worktree", "remove", "--force", dir)
+	if err != nil {
+		// Fall back to removing it by hand and letting
+		// git clean up its metadata.
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		_, err = r.Run("worktree", "prune")
+	}
+	return err
+}
+
+// Checkout checks out rev in r's working tree with a detached HEAD.
+func (r *Repo) Checkout(rev string) error {
+	_, err := r.Run("checkout", "-q", "--detach", rev)
+	return err
+}
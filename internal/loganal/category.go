@@ -0,0 +1,180 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loganal
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Category is a broad kind of failure.
+type Category string
+
+const (
+	CategoryBuildError   Category = "build-error"
+	CategoryCompileCrash Category = "compile-crash"
+	CategoryTestAssert   Category = "test-assert"
+	CategoryTimeout      Category = "timeout"
+	CategoryRuntimeThrow Category = "runtime-throw"
+	CategoryRaceReport   Category = "race-report"
+	CategoryLinkerError  Category = "linker-error"
+	CategoryInfraFlake   Category = "infrastructure-flake"
+)
+
+// Categories lists all failure categories.
+var Categories = []Category{
+	CategoryBuildError, CategoryCompileCrash, CategoryTestAssert,
+	CategoryTimeout, CategoryRuntimeThrow, CategoryRaceReport,
+	CategoryLinkerError, CategoryInfraFlake,
+}
+
+// A Label assigns a failure to a category.
+type Label struct {
+	Category Category
+
+	// Confidence is the confidence that the failure belongs to
+	// Category, between 0 and 1.
+	Confidence float64
+
+	// Evidence lists what supports this label.
+	Evidence []Evidence
+}
+
+// Evidence is a reason for a Label.
+type Evidence struct {
+	// Reason describes the evidence.
+	Reason string
+
+	// Start and End are the byte offsets of the matched text in
+	// the failure's FullMessage, or in its Message if FullMessage
+	// is empty. They are -1 for evidence from the failure's other
+	// fields.
+	Start, End int
+}
+
+// A categoryRule is evidence that a failure belongs to a category.
+// Exactly one of re and match is set.
+type categoryRule struct {
+	cat    Category
+	weight float64
+	reason string
+	re     *regexp.Regexp
+	match  func(f *Failure) bool
+}
+
+func textRule(cat Category, weight float64, reason, re string) categoryRule {
+	return categoryRule{cat: cat, weight: weight, reason: reason, re: regexp.MustCompile(re)}
+}
+
+func fieldRule(cat Category, weight float64, reason string, match func(f *Failure) bool) categoryRule {
+	return categoryRule{cat: cat, weight: weight, reason: reason, match: match}
+}
+
+// categoryRules are weighted by how strongly they indicate their
+// category on their own.
+var categoryRules = []categoryRule{
+	textRule(CategoryRaceReport, 0.95, "race detector report", `(?m)^WARNING: DATA RACE$`),
+	textRule(CategoryRaceReport, 0.9, "race detected during test", `race detected during execution of test|Found [0-9]+ data race`),
+
+	textRule(CategoryTimeout, 0.95, "test timeout panic", `panic: test timed out after`),
+	textRule(CategoryTimeout, 0.9, "test killed", `\*\*\* Test killed.*ran too long`),
+	textRule(CategoryTimeout, 0.7, "coordinator timeout", `(?m)Result: error: timed out|^Test "[^"]+" ran over [0-9a-z]+ limit`),
+	fieldRule(CategoryTimeout, 0.9, "timed out", func(f *Failure) bool {
+		return strings.HasPrefix(f.Message, "test timed out") || f.Message == "build failed (timed out)"
+	}),
+	fieldRule(CategoryTimeout, 0.5, "goroutine dump of a hang", func(f *Failure) bool {
+		return f.Dump != nil && f.Dump.Kind != DumpCrash
+	}),
+
+	textRule(CategoryCompileCrash, 0.95, "internal compiler error", `internal compiler error`),
+	textRule(CategoryCompileCrash, 0.8, "compiler panic", `(?m)^\s*cmd/compile/internal/\S+\(`),
+
+	textRule(CategoryLinkerError, 0.9, "external linker failed", `collect2: error: ld returned|running (?:gcc|clang) failed|/usr/bin/ld: `),
+	textRule(CategoryLinkerError, 0.85, "undefined symbol", `undefined reference to|relocation target \S+ not defined|undefined symbols? for architecture`),
+	textRule(CategoryLinkerError, 0.6, "linker message", `(?m)^(?:cmd/)?link: `),
+
+	textRule(CategoryRuntimeThrow, 0.9, "runtime throw", `(?m)^fatal error: `),
+	textRule(CategoryRuntimeThrow, 0.9, "unexpected signal", `unexpected signal during runtime execution|(?m)^unexpected fault address`),
+	textRule(CategoryRuntimeThrow, 0.6, "runtime error panic", `panic: runtime error: `),
+	textRule(CategoryRuntimeThrow, 0.5, "fatal signal", `(?m)^\[signal SIG[A-Z]+`),
+	textRule(CategoryRuntimeThrow, 0.4, "panic", `(?m)^panic: `),
+	fieldRule(CategoryRuntimeThrow, 0.4, "goroutine dump of a crash", func(f *Failure) bool {
+		return f.Dump != nil && f.Dump.Kind == DumpCrash
+	}),
+	fieldRule(CategoryRuntimeThrow, 0.5, "failure in runtime", func(f *Failure) bool {
+		return f.Package == "runtime" && f.Test == ""
+	}),
+
+	textRule(CategoryBuildError, 0.9, "package build failed", `\[build failed\]`),
+	textRule(CategoryBuildError, 0.7, "compile error", `(?m)^\S+\.go:[0-9]+(?::[0-9]+)?: `),
+	textRule(CategoryBuildError, 0.8, "missing package", `cannot find package|no required module provides package|package \S+ is not in (?:GOROOT|std)`),
+	fieldRule(CategoryBuildError, 0.8, "build failed", func(f *Failure) bool {
+		return f.Message == "build failed" || f.Message == "toolchain build failed"
+	}),
+
+	fieldRule(CategoryTestAssert, 0.9, "error in test file", func(f *Failure) bool {
+		return f.Test != "" && strings.HasSuffix(f.File, "_test.go") && f.Function == ""
+	}),
+	fieldRule(CategoryTestAssert, 0.5, "test failure", func(f *Failure) bool {
+		return f.Test != ""
+	}),
+
+	textRule(CategoryInfraFlake, 0.9, "disk full", `no space left on device`),
+	textRule(CategoryInfraFlake, 0.85, "scheduling failed", `Failed to schedule`),
+	textRule(CategoryInfraFlake, 0.7, "out of resources", `cannot allocate memory|resource temporarily unavailable|too many open files`),
+	textRule(CategoryInfraFlake, 0.6, "network error", `connection reset by peer|connection refused|TLS handshake timeout|i/o timeout|dial tcp: lookup`),
+	textRule(CategoryInfraFlake, 0.5, "buildlet error", `buildlet|signal: killed`),
+}
+
+// Categorize labels failure f with the categories it may belong to,
+// ordered from most to least confident. Each category's confidence
+// combines the weights of the evidence for it, so more independent
+// evidence gives higher confidence. It returns nil if there's no
+// evidence for any category.
+func Categorize(f *Failure) []Label {
+	text := f.FullMessage
+	if text == "" {
+		text = f.Message
+	}
+
+	labels := map[Category]*Label{}
+	for _, rule := range categoryRules {
+		var ev []Evidence
+		if rule.re != nil {
+			for _, loc := range rule.re.FindAllStringIndex(text, -1) {
+				ev = append(ev, Evidence{rule.reason, loc[0], loc[1]})
+			}
+		} else if rule.match(f) {
+			ev = append(ev, Evidence{rule.reason, -1, -1})
+		}
+		if ev == nil {
+			continue
+		}
+
+		l := labels[rule.cat]
+		if l == nil {
+			l = &Label{Category: rule.cat}
+			labels[rule.cat] = l
+		}
+		// Combine evidence as independent: the label is
+		// wrong only if every rule is wrong. Repeated matches
+		// of one rule count once.
+		l.Confidence = 1 - (1-l.Confidence)*(1-rule.weight)
+		l.Evidence = append(l.Evidence, ev...)
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+
+	out := make([]Label, 0, len(labels))
+	for _, cat := range Categories {
+		if l := labels[cat]; l != nil {
+			out = append(out, *l)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Confidence > out[j].Confidence })
+	return out
+}
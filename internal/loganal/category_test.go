@@ -0,0 +1,151 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loganal
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCategorize(t *testing.T) {
+	for _, test := range []struct {
+		f    Failure
+		want Category
+	}{
+		{Failure{
+			Package: "p",
+			Message: "build failed",
+			FullMessage: `# p
+p/p.go:3:1: syntax error: non-declaration statement outside function body
+FAIL	p [build failed]`,
+		}, CategoryBuildError},
+
+		{Failure{
+			Package: "cmd/compile",
+			Test:    "TestStdlib",
+			Message: "unknown testing.T failure",
+			FullMessage: `--- FAIL: TestStdlib (1.20s)
+    stdlib_test.go:61: compiling bytes: exit status 2
+        bytes/buffer.go:80:6: internal compiler error: panic: runtime error: index out of range [1] with length 1
+
+        goroutine 1 [running]:
+        cmd/compile/internal/ssa.(*Func).newValue(...)`,
+		}, CategoryCompileCrash},
+
+		{Failure{
+			Package:     "strings",
+			Test:        "TestReplace",
+			Message:     `Replace("a", "b") = "a", want "b"`,
+			File:        "replace_test.go",
+			Line:        42,
+			FullMessage: "--- FAIL: TestReplace (0.00s)\n    replace_test.go:42: Replace(\"a\", \"b\") = \"a\", want \"b\"",
+		}, CategoryTestAssert},
+
+		{Failure{
+			Package: "net/http",
+			Test:    "TestServer",
+			Message: "test timed out",
+			FullMessage: `panic: test timed out after 10m0s
+
+goroutine 7 [chan receive, 9 minutes]:
+net/http.TestServer(0xc000007a00)
+	/go/src/net/http/serve_test.go:18 +0x4a`,
+		}, CategoryTimeout},
+
+		{Failure{
+			Package:  "runtime",
+			Message:  "unexpected signal during runtime execution",
+			Function: "runtime.scanobject",
+			FullMessage: `fatal error: unexpected signal during runtime execution
+[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x42f0c1]
+
+runtime stack:
+runtime.throw({0x8a1b2c, 0x2a})
+	/go/src/runtime/panic.go:992 +0x71`,
+		}, CategoryRuntimeThrow},
+
+		{Failure{
+			Package: "sync",
+			Test:    "TestPool",
+			Message: "race detected during execution of test",
+			FullMessage: `==================
+WARNING: DATA RACE
+Write at 0x00c0000a4010 by goroutine 8:
+  sync.TestPool.func1()
+      /go/src/sync/pool_test.go:30 +0x44
+==================
+--- FAIL: TestPool (0.01s)
+    testing.go:1312: race detected during execution of test`,
+		}, CategoryRaceReport},
+
+		{Failure{
+			Package: "misc/cgo/test",
+			Message: "build failed",
+			FullMessage: `# misc/cgo/test
+/usr/bin/ld: $WORK/b001/_x002.o: in function ` + "`" + `_cgo_f` + "'" + `:
+undefined reference to ` + "`" + `f` + "'" + `
+collect2: error: ld returned 1 exit status`,
+		}, CategoryLinkerError},
+
+		{Failure{
+			Message:     "build failed (no space left on device)",
+			FullMessage: "write /tmp/go-build123/b001/exe/a.out: no space left on device",
+		}, CategoryInfraFlake},
+	} {
+		t.Run(string(test.want), func(t *testing.T) {
+			f := test.f
+			f.Dump = parseGoroutineDump(f.FullMessage)
+			labels := Categorize(&f)
+			if len(labels) == 0 {
+				t.Fatalf("no labels, want %s", test.want)
+			}
+			if labels[0].Category != test.want {
+				t.Errorf("top category is %s, want %s; labels %+v", labels[0].Category, test.want, labels)
+			}
+			for i, l := range labels {
+				if !(0 < l.Confidence && l.Confidence <= 1) {
+					t.Errorf("%s confidence %v not in (0, 1]", l.Category, l.Confidence)
+				}
+				if i > 0 && l.Confidence > labels[i-1].Confidence {
+					t.Errorf("labels not ordered by confidence: %+v", labels)
+				}
+				for _, ev := range l.Evidence {
+					if ev.Start < 0 {
+						continue
+					}
+					if ev.Start > ev.End || ev.End > len(f.FullMessage) {
+						t.Errorf("%s evidence %q has bad range [%d, %d)", l.Category, ev.Reason, ev.Start, ev.End)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCategorizeConfidence(t *testing.T) {
+	// Independent evidence combines, but repeated matches of one
+	// rule count once.
+	f := &Failure{FullMessage: "no space left on device\nno space left on device"}
+	labels := Categorize(f)
+	if len(labels) != 1 || labels[0].Category != CategoryInfraFlake {
+		t.Fatalf("got %+v, want one %s label", labels, CategoryInfraFlake)
+	}
+	if got, want := labels[0].Confidence, 0.9; math.Abs(got-want) > 1e-9 {
+		t.Errorf("confidence = %v, want %v", got, want)
+	}
+	if got := len(labels[0].Evidence); got != 2 {
+		t.Errorf("got %d pieces of evidence, want 2", got)
+	}
+
+	f = &Failure{FullMessage: "no space left on device\nFailed to schedule"}
+	labels = Categorize(f)
+	if got, want := labels[0].Confidence, 1-(1-0.9)*(1-0.85); math.Abs(got-want) > 1e-9 {
+		t.Errorf("confidence = %v, want %v", got, want)
+	}
+
+	if labels := Categorize(&Failure{Message: "something odd"}); labels != nil {
+		t.Errorf("got %+v, want no labels", labels)
+	}
+}
@@ -19,7 +19,9 @@ type Failure struct {
 	Package string
 
 	// Test identifies the failed test function. If this is not a
-	// testing.T failure, this will be "".
+	// testing.T failure, this will be "". For a subtest, this is
+	// the full path of the innermost failed subtest, such as
+	// "TestFoo/case=3/variant". See TestPath and TestAtDepth.
 	Test string
 
 	// Message is the summarized failure message. This will be one
@@ -46,6 +48,11 @@ type Failure struct {
 
 	// OS and Arch are the GOOS and GOARCH of this failure.
 	OS, Arch string
+
+	// Dump is the goroutine dump printed with this failure, if
+	// any. It classifies crashes and hangs and identifies the
+	// goroutines responsible.
+	Dump *GoroutineDump
 }
 
 func (f Failure) String() string {
@@ -73,6 +80,31 @@ func (f Failure) String() string {
 	return s
 }
 
+// TestPath returns the components of f.Test: the top-level test
+// followed by the name of each nested subtest. It returns nil if f is
+// not a testing.T failure.
+func (f *Failure) TestPath() []string {
+	if f.Test == "" {
+		return nil
+	}
+	return strings.Split(f.Test, "/")
+}
+
+// TestAtDepth returns f.Test truncated to at most depth components.
+// For example, TestAtDepth(1) returns the top-level test, which can
+// be used to aggregate failures of subtests with their parent.
+func (f *Failure) TestAtDepth(depth int) string {
+	test := f.Test
+	for i := 0; i < len(test); i++ {
+		if test[i] == '/' {
+			if depth--; depth <= 0 {
+				return test[:i]
+			}
+		}
+	}
+	return test
+}
+
 var (
 	linesStar = `(?:.*\n)*?`
 	linesPlus = `(?:.*\n)+?`
@@ -95,12 +127,21 @@ var (
 	testingFailed = regexp.MustCompile(`^--- FAIL: ([^-\s]+).*\n(` + linesStar + `)` + failPkg)
 
 	// testingError matches the file name and message of the last
-	// T.Error in a testingFailed log.
-	testingError = regexp.MustCompile(`(?:.*\n)*\t([^:]+):([0-9]+): (.*)\n`)
+	// T.Error in a testingFailed log. Depending on the Go version
+	// and subtest depth, these are indented by tabs or spaces.
+	testingError = regexp.MustCompile(`(?:.*\n)*[ \t]+([^:\s]+):([0-9]+): (.*)\n`)
+
+	// testingTestFailed matches the header of each top-level test
+	// in a testingFailed log.
+	testingTestFailed = regexp.MustCompile(`(?m)^--- FAIL: ([^-\s]+).*\n`)
+
+	// testingSubtestFailed matches the header of a failed subtest
+	// in a testingFailed log.
+	testingSubtestFailed = regexp.MustCompile(`(?m)^[ \t]+--- FAIL: (\S+)`)
 
 	// testingPanic matches a recovered panic in a testingFailed
 	// log.
-	testingPanic = regexp.MustCompile(`panic: (.*?)(?: \[recovered\])`)
+	testingPanic = regexp.MustCompile(`panic: (.*?)(?: \[recovered.*?\])`)
 
 	// gotestFailed matches a $GOROOT/test failure.
 	gotestFailed = regexp.MustCompile(`^# go run run\.go.*\n(` + linesPlus + `)` + failPkg)
@@ -122,7 +163,7 @@ var (
 	// function/line number entry in a traceback. Group 1 matches
 	// the fully qualified function name. Groups 2 and 3 match the
 	// file name and line number.
-	tbEntry = `(\S+)\(.*\)\n\t(.*):([0-9]+) .*\n`
+	tbEntry = `(\S+)\(.*\)\n\t(.*):([0-9]+)(?: .*)?\n`
 
 	// runtimeFailed matches a runtime throw or testing package
 	// panic. Matching the panic is fairly loose because in some
@@ -151,6 +192,10 @@ var (
 	miscFailed = regexp.MustCompile(`^.*Failed: (?:exit status|test failed)`)
 )
 
+// unknownTestFailure is the message of a testing.T failure without a
+// recognized error or panic.
+const unknownTestFailure = "unknown testing.T failure"
+
 // An extractCache speeds up failure extraction from multiple logs by
 // caching known lines. It is *not* thread-safe, so we track it in a
 // sync.Pool.
@@ -158,6 +203,11 @@ type extractCache struct {
 	boringLines map[string]bool
 }
 
+// maxBoringLines limits the size of extractCache.boringLines. Logs
+// have many unique lines, such as the result line of each package, so
+// the cache would otherwise grow without bound.
+const maxBoringLines = 1 << 14
+
 var extractCachePool sync.Pool
 
 func init() {
@@ -166,19 +216,139 @@ func init() {
 	}
 }
 
-// Extract parses the failures from all.bash log m.
+// Extract parses the failures from all.bash log m. m may be either a
+// plain text log or a stream of test2json events, such as the output
+// of go test -json or go tool dist test -json.
 func Extract(m string, os, arch string) ([]*Failure, error) {
+	// Canonicalize line endings. Note that some logs have a mix
+	// of line endings and some somehow have multiple \r's.
+	m = canonLine.ReplaceAllString(m, "\n")
+
+	var fs []*Failure
+	if isTest2JSON(m) {
+		fs = extractTest2JSON(m)
+	} else {
+		var testingStarted bool
+		fs, testingStarted = extractText(m)
+
+		// Check if we even got as far as testing. Note that
+		// there was a period when we didn't print the
+		// "testing" header, so as long as we found failures,
+		// we don't care if we found the header.
+		if !testingStarted && len(fs) == 0 {
+			fs = append(fs, &Failure{
+				Message: "toolchain build failed",
+			})
+		}
+	}
+	return finishFailures(fs, os, arch), nil
+}
+
+// finishFailures cleans up the failures fs extracted from a log and
+// sets their OS and Arch.
+func finishFailures(fs []*Failure, os, arch string) []*Failure {
+	// If the same (message, where) shows up in more than five
+	// packages, it's probably a systemic issue, so collapse it
+	// down to one failure with no package.
+	type dedup struct {
+		packages map[string]bool
+		kept     bool
+	}
+	msgDedup := map[Failure]*dedup{}
+	failureMap := map[*Failure]*dedup{}
+	maxCount := 0
+	for _, f := range fs {
+		key := Failure{
+			Message:  f.canonicalMessage(),
+			Function: f.Function,
+			File:     f.File,
+			Line:     f.Line,
+		}
+
+		d := msgDedup[key]
+		if d == nil {
+			d = &dedup{packages: map[string]bool{}}
+			msgDedup[key] = d
+		}
+		d.packages[f.Package] = true
+		if len(d.packages) > maxCount {
+			maxCount = len(d.packages)
+		}
+		failureMap[f] = d
+	}
+	if maxCount >= 5 {
+		fsn := []*Failure{}
+		for _, f := range fs {
+			d := failureMap[f]
+			if len(d.packages) < 5 {
+				fsn = append(fsn, f)
+			} else if !d.kept {
+				d.kept = true
+				f.Test, f.Package = "", ""
+				fsn = append(fsn, f)
+			}
+		}
+		fs = fsn
+	}
+
+	for _, f := range fs {
+		f.OS, f.Arch = os, arch
+
+		// Clean up package. For misc/cgo tests, this will be
+		// something like
+		// _/tmp/buildlet-scatch825855615/go/misc/cgo/test.
+		if strings.HasPrefix(f.Package, "_/tmp/") {
+			f.Package = strings.SplitN(f.Package, "/", 4)[3]
+		}
+
+		// Trim trailing newlines from FullMessage.
+		f.FullMessage = strings.TrimRight(f.FullMessage, "\n")
+
+		f.Dump = parseGoroutineDump(f.FullMessage)
+	}
+	return fs
+}
+
+// extractText parses the failures from plain text all.bash log m. It
+// also reports whether m reached the testing phase of all.bash.
+func extractText(m string) (fs []*Failure, testingStarted bool) {
+	var st textState
+	fs = st.extract(m)
+	if len(fs) == 0 {
+		if i := textFallback(m); i >= 0 {
+			fs = append(fs, &Failure{Message: textFallbacks[i].message})
+		}
+	}
+	return fs, st.testingStarted
+}
+
+// textState is the state of text log extraction that carries over
+// from one part of a log to the next.
+type textState struct {
+	// section is the current go tool dist test section.
+	section string
+
+	// sectionFailed is whether earlier parts of the log found
+	// failures in section.
+	sectionFailed bool
+
+	// testingStarted is whether the log reached the testing
+	// phase of all.bash.
+	testingStarted bool
+}
+
+// extract parses the failures from m, which is the next part of a
+// plain text log. It doesn't apply textFallbacks.
+func (st *textState) extract(m string) []*Failure {
 	fs := []*Failure{}
-	testingStarted := false
-	section := ""
+	section := st.section
 	sectionHeaderFailures := 0 // # failures at section start
 	unknown := []string{}
 	cache := extractCachePool.Get().(*extractCache)
 	defer extractCachePool.Put(cache)
-
-	// Canonicalize line endings. Note that some logs have a mix
-	// of line endings and some somehow have multiple \r's.
-	m = canonLine.ReplaceAllString(m, "\n")
+	if len(cache.boringLines) > maxBoringLines {
+		cache.boringLines = make(map[string]bool)
+	}
 
 	var s []string
 	matcher := newMatcher(m)
@@ -218,34 +388,28 @@ func Extract(m string, os, arch string) ([]*Failure, error) {
 			}
 
 		case consume(testingHeader):
-			testingStarted = true
+			st.testingStarted = true
 
 		case consume(sectionHeader):
 			section = s[1]
 			sectionHeaderFailures = len(fs)
+			st.sectionFailed = false
 
 		case consume(testingFailed):
-			f := &Failure{
-				Test:        s[1],
-				Package:     s[3],
-				FullMessage: s[0],
-				Message:     "unknown testing.T failure",
-			}
-
-			// TODO: Can have multiple errors per FAIL:
-			// ../fetchlogs/rev/2015-03-24T19:51:21-41f9c43/linux-arm64-canonical
-
-			sError := testingError.FindStringSubmatch(s[2])
-			sPanic := testingPanic.FindStringSubmatch(s[2])
-			if sError != nil {
-				f.File, f.Line, f.Message = sError[1], atoi(sError[2]), sError[3]
-			} else if sPanic != nil {
-				f.Function, f.File, f.Line = panicWhere(s[2])
-				f.Message = sPanic[1]
+			// Several tests may fail before the package's
+			// FAIL line. Split these into a failure per
+			// top-level test.
+			blocks := testingTestFailed.FindAllStringSubmatchIndex(s[0], -1)
+			for i, b := range blocks {
+				end := len(s[0])
+				if i+1 < len(blocks) {
+					end = blocks[i+1][0]
+				}
+				f := testingFailure(s[0][b[2]:b[3]], s[0][b[1]:end])
+				f.Package, f.FullMessage = s[3], s[0][b[0]:end]
+				fs = append(fs, f)
 			}
 
-			fs = append(fs, f)
-
 		case consume(gotestFailed):
 			fs = append(fs, &Failure{
 				Package:     "test/" + s[2],
@@ -317,7 +481,7 @@ func Extract(m string, os, arch string) ([]*Failure, error) {
 				Message:     "unknown failure: " + firstBadLine(),
 			})
 
-		case len(fs) == sectionHeaderFailures && consume(miscFailed):
+		case len(fs) == sectionHeaderFailures && !st.sectionFailed && consume(miscFailed):
 			fs = append(fs, &Failure{
 				Package:     section,
 				FullMessage: s[0],
@@ -337,98 +501,72 @@ func Extract(m string, os, arch string) ([]*Failure, error) {
 		}
 	}
 
-	// TODO: FullMessages for these.
-	if len(fs) == 0 && strings.Contains(m, "no space left on device") {
-		fs = append(fs, &Failure{
-			Message: "build failed (no space left on device)",
-		})
-	}
-	if len(fs) == 0 && coordinatorTimeout.MatchString(m) {
-		// all.bash was killed by coordinator.
-		fs = append(fs, &Failure{
-			Message: "build failed (timed out)",
-		})
-	}
-	if len(fs) == 0 && strings.Contains(m, "Failed to schedule") {
-		// Test sharding failed.
-		fs = append(fs, &Failure{
-			Message: "build failed (failed to schedule)",
-		})
-	}
-	if len(fs) == 0 && strings.Contains(m, "nosplit stack overflow") {
-		fs = append(fs, &Failure{
-			Message: "build failed (nosplit stack overflow)",
-		})
+	st.section = section
+	if len(fs) > sectionHeaderFailures {
+		st.sectionFailed = true
 	}
+	return fs
+}
 
-	// If the same (message, where) shows up in more than five
-	// packages, it's probably a systemic issue, so collapse it
-	// down to one failure with no package.
-	type dedup struct {
-		packages map[string]bool
-		kept     bool
-	}
-	msgDedup := map[Failure]*dedup{}
-	failureMap := map[*Failure]*dedup{}
-	maxCount := 0
-	for _, f := range fs {
-		key := Failure{
-			Message:  f.canonicalMessage(),
-			Function: f.Function,
-			File:     f.File,
-			Line:     f.Line,
-		}
+// textFallbacks are the failures of a text log in which no other
+// failures were found, in order of precedence.
+//
+// TODO: FullMessages for these.
+var textFallbacks = []struct {
+	match   func(m string) bool
+	message string
+}{
+	{func(m string) bool { return strings.Contains(m, "no space left on device") },
+		"build failed (no space left on device)"},
+	// all.bash was killed by coordinator.
+	{coordinatorTimeout.MatchString,
+		"build failed (timed out)"},
+	// Test sharding failed.
+	{func(m string) bool { return strings.Contains(m, "Failed to schedule") },
+		"build failed (failed to schedule)"},
+	{func(m string) bool { return strings.Contains(m, "nosplit stack overflow") },
+		"build failed (nosplit stack overflow)"},
+}
 
-		d := msgDedup[key]
-		if d == nil {
-			d = &dedup{packages: map[string]bool{}}
-			msgDedup[key] = d
-		}
-		d.packages[f.Package] = true
-		if len(d.packages) > maxCount {
-			maxCount = len(d.packages)
+// textFallback returns the index of the first of textFallbacks that
+// matches log m, or -1 if none match.
+func textFallback(m string) int {
+	for i, fb := range textFallbacks {
+		if fb.match(m) {
+			return i
 		}
-		failureMap[f] = d
-	}
-	if maxCount >= 5 {
-		fsn := []*Failure{}
-		for _, f := range fs {
-			d := failureMap[f]
-			if len(d.packages) < 5 {
-				fsn = append(fsn, f)
-			} else if !d.kept {
-				d.kept = true
-				f.Test, f.Package = "", ""
-				fsn = append(fsn, f)
-			}
-		}
-		fs = fsn
 	}
+	return -1
+}
 
-	// Check if we even got as far as testing. Note that there was
-	// a period when we didn't print the "testing" header, so as
-	// long as we found failures, we don't care if we found the
-	// header.
-	if !testingStarted && len(fs) == 0 {
-		fs = append(fs, &Failure{
-			Message: "toolchain build failed",
-		})
+// testingFailure returns the failure of test, given the log of its
+// failure following its "--- FAIL" line.
+func testingFailure(test, log string) *Failure {
+	f := &Failure{
+		Test:    test,
+		Message: unknownTestFailure,
 	}
 
-	for _, f := range fs {
-		f.OS, f.Arch = os, arch
+	sError := testingError.FindStringSubmatchIndex(log)
+	sPanic := testingPanic.FindStringSubmatch(log)
+	end := len(log)
+	if sError != nil {
+		f.File, f.Line, f.Message = log[sError[2]:sError[3]], atoi(log[sError[4]:sError[5]]), log[sError[6]:sError[7]]
+		end = sError[2]
+	} else if sPanic != nil {
+		f.Function, f.File, f.Line = panicWhere(log)
+		f.Message = sPanic[1]
+	}
 
-		// Clean up package. For misc/cgo tests, this will be
-		// something like
-		// _/tmp/buildlet-scatch825855615/go/misc/cgo/test.
-		if strings.HasPrefix(f.Package, "_/tmp/") {
-			f.Package = strings.SplitN(f.Package, "/", 4)[3]
+	// Attribute the failure to the last subtest that failed
+	// before the message. This may be a sibling of an earlier
+	// failed subtest.
+	for _, sub := range testingSubtestFailed.FindAllStringSubmatchIndex(log[:end], -1) {
+		if name := log[sub[2]:sub[3]]; strings.HasPrefix(name, test+"/") {
+			f.Test = name
 		}
-
-		// Trim trailing newlines from FullMessage.
-		f.FullMessage = strings.TrimRight(f.FullMessage, "\n")
 	}
-	return fs, nil
+	return f
 }
 
 func atoi(s string) int {
@@ -506,7 +644,7 @@ func panicWhere(tb string) (fn string, file string, line int) {
 		fn := m.groups[1]
 
 		// Ignore functions involved in panic handling.
-		if strings.HasPrefix(fn, "runtime.panic") || fn == "runtime.throw" || fn == "runtime.sigpanic" {
+		if strings.HasPrefix(fn, "runtime.panic") || fn == "runtime.throw" || fn == "runtime.sigpanic" || fn == "panic" || strings.HasPrefix(fn, "testing.tRunner.func") {
 			continue
 		}
 		return fn, m.groups[2], atoi(m.groups[3])
@@ -0,0 +1,116 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loganal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractText(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		input string
+		want  []failureSummary
+	}{
+		{"nested subtests", `##### Testing packages.
+ok  	p/ok	0.010s
+--- FAIL: TestOuter (0.00s)
+    --- FAIL: TestOuter/mid (0.00s)
+        --- PASS: TestOuter/mid/fine (0.00s)
+        --- FAIL: TestOuter/mid/leaf (0.00s)
+            leaf_test.go:42: want 1, got 2
+FAIL
+FAIL	p/leaf	0.020s
+`,
+			[]failureSummary{
+				{Package: "p/leaf", Test: "TestOuter/mid/leaf", Message: "want 1, got 2", File: "leaf_test.go", Line: 42},
+			},
+		},
+
+		{"several tests in one package", `##### Testing packages.
+--- FAIL: TestA (0.00s)
+    a_test.go:5: a failed
+--- FAIL: TestB (0.00s)
+    --- FAIL: TestB/x (0.00s)
+        b_test.go:9: x failed
+    --- FAIL: TestB/y (0.00s)
+        b_test.go:9: y failed
+FAIL
+FAIL	p	0.020s
+`,
+			[]failureSummary{
+				{Package: "p", Test: "TestA", Message: "a failed", File: "a_test.go", Line: 5},
+				{Package: "p", Test: "TestB/y", Message: "y failed", File: "b_test.go", Line: 9},
+			},
+		},
+
+		{"recovered panic in subtest", `##### Testing packages.
+--- FAIL: TestP (0.00s)
+    --- FAIL: TestP/sub (0.00s)
+panic: bad thing [recovered]
+	panic: bad thing
+
+goroutine 6 [running]:
+testing.tRunner.func1.2({0x4f1a20, 0x55e8a8})
+	/go/src/testing/testing.go:1389 +0x24e
+panic({0x4f1a20, 0x55e8a8})
+	/go/src/runtime/panic.go:838 +0x207
+p.do(...)
+	/src/p/p.go:4
+p.TestP.func1(0x0)
+	/src/p/p_test.go:8 +0x27
+FAIL	p	0.010s
+`,
+			[]failureSummary{
+				{Package: "p", Test: "TestP/sub", Message: "bad thing", Function: "p.do", File: "/src/p/p.go", Line: 4},
+			},
+		},
+
+		{"build failure", `##### Testing packages.
+# p
+p/p.go:3:1: syntax error: non-declaration statement outside function body
+FAIL	p [build failed]
+`,
+			[]failureSummary{
+				{Package: "p", Message: "build failed"},
+			},
+		},
+
+		{"toolchain build", `Building Go cmd/dist using /usr/lib/go.
+cmd/dist: bad
+`,
+			[]failureSummary{
+				{Message: "toolchain build failed"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fs, err := Extract(test.input, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := summarize(fs)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got:\n%+v\nwant:\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTestAtDepth(t *testing.T) {
+	f := &Failure{Test: "TestA/b/c"}
+	if got, want := f.TestPath(), []string{"TestA", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TestPath() = %v, want %v", got, want)
+	}
+	for depth, want := range []string{"TestA", "TestA", "TestA/b", "TestA/b/c", "TestA/b/c"} {
+		if got := f.TestAtDepth(depth); got != want {
+			t.Errorf("TestAtDepth(%d) = %q, want %q", depth, got, want)
+		}
+	}
+	if got := (&Failure{}).TestPath(); got != nil {
+		t.Errorf("TestPath() of non-test failure = %v, want nil", got)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loganal
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A GoroutineDump is the goroutine dump printed with a failure, such
+// as the traceback of a crash or of a test timeout.
+type GoroutineDump struct {
+	// Kind classifies the failure based on the dump.
+	Kind DumpKind
+
+	// Goroutines lists the goroutines in the dump, in the order
+	// they were printed.
+	Goroutines []*Goroutine
+
+	// Culprits lists the goroutines that most likely caused the
+	// failure. For a crash, this is the crashing goroutine. For a
+	// hang, these are the goroutines of the code under test that
+	// were blocked.
+	Culprits []*Goroutine
+}
+
+// DumpKind classifies a failure with a goroutine dump.
+type DumpKind string
+
+const (
+	// DumpCrash is a panic or runtime throw.
+	DumpCrash DumpKind = "runtime crash"
+
+	// DumpDeadlock is a hang where goroutines were blocked on
+	// locks or wait groups, or the runtime detected that all
+	// goroutines were asleep.
+	DumpDeadlock DumpKind = "deadlock"
+
+	// DumpChannel is a hang where goroutines were blocked on
+	// channel operations.
+	DumpChannel DumpKind = "stuck on channel"
+
+	// DumpHang is any other hang, such as goroutines that are
+	// running or sleeping.
+	DumpHang DumpKind = "hang"
+)
+
+// A Goroutine is a single goroutine from a goroutine dump.
+type Goroutine struct {
+	// ID is the goroutine ID.
+	ID int
+
+	// State is the goroutine's status or wait reason, such as
+	// "running" or "chan receive".
+	State string
+
+	// Waiting is how long the goroutine has been blocked, such as
+	// "5 minutes", if printed.
+	Waiting string
+
+	// Frames is the goroutine's stack, starting with the
+	// innermost frame.
+	Frames []Frame
+
+	// CreatedBy is the frame of the go statement that created
+	// this goroutine, if printed.
+	CreatedBy *Frame
+}
+
+// A Frame is a single stack frame in a goroutine dump.
+type Frame struct {
+	// Function is the fully qualified function name.
+	Function string
+
+	// File and Line are the source position of the frame.
+	File string
+	Line int
+}
+
+var (
+	// goroutineHeader matches the header of each goroutine in a
+	// dump. Group 1 is the goroutine ID and group 2 is its state.
+	goroutineHeader = regexp.MustCompile(`(?m)^goroutine ([0-9]+)(?: [^[\n]*)? \[([^\]]*)\]:$`)
+
+	// goroutineFrame matches a frame in a goroutine dump. Group 1
+	// is the function name if this is the frame of the go
+	// statement that created the goroutine. Otherwise, group 2 is
+	// the function name. Groups 3 and 4 are the file and line.
+	goroutineFrame = regexp.MustCompile(`^(?:created by (\S+)(?: in goroutine [0-9]+)?|(\S+)\(.*\))\n\t(.*):([0-9]+)(?: .*)?\n`)
+
+	// goroutineWaiting matches the blocked duration in a goroutine
+	// state.
+	goroutineWaiting = regexp.MustCompile(`^[0-9]+ minutes?$`)
+)
+
+// parseGoroutineDump parses the goroutine dump in message m, if any.
+// It returns nil if m doesn't contain a goroutine dump.
+func parseGoroutineDump(m string) *GoroutineDump {
+	// Frames are terminated by newlines, but m may have been
+	// trimmed.
+	if !strings.HasSuffix(m, "\n") {
+		m += "\n"
+	}
+
+	var gs []*Goroutine
+	for _, loc := range goroutineHeader.FindAllStringSubmatchIndex(m, -1) {
+		g := &Goroutine{ID: atoi(m[loc[2]:loc[3]])}
+		for i, field := range strings.Split(m[loc[4]:loc[5]], ", ") {
+			if i == 0 {
+				g.State = field
+			} else if goroutineWaiting.MatchString(field) {
+				g.Waiting = field
+			}
+		}
+
+		// Parse the stack.
+		rest := strings.TrimPrefix(m[loc[1]:], "\n")
+		for {
+			fm := goroutineFrame.FindStringSubmatch(rest)
+			if fm == nil {
+				break
+			}
+			rest = rest[len(fm[0]):]
+			if fm[1] != "" {
+				g.CreatedBy = &Frame{fm[1], fm[3], atoi(fm[4])}
+				break
+			}
+			g.Frames = append(g.Frames, Frame{fm[2], fm[3], atoi(fm[4])})
+		}
+		gs = append(gs, g)
+	}
+	if len(gs) == 0 {
+		return nil
+	}
+	return classifyDump(m, gs)
+}
+
+// classifyDump classifies the failure described by message m with
+// goroutines gs.
+func classifyDump(m string, gs []*Goroutine) *GoroutineDump {
+	d := &GoroutineDump{Goroutines: gs}
+
+	// Find the goroutines running the code under test, ignoring
+	// the runtime and the testing framework.
+	var user []*Goroutine
+	for _, g := range gs {
+		if g.isUser() {
+			user = append(user, g)
+		}
+	}
+
+	switch {
+	case strings.Contains(m, "all goroutines are asleep - deadlock!"):
+		d.Kind = DumpDeadlock
+		d.Culprits = user
+
+	case strings.Contains(m, "test timed out") || strings.Contains(m, "Test killed"):
+		var locks, chans []*Goroutine
+		for _, g := range user {
+			switch {
+			case g.isLockWait():
+				locks = append(locks, g)
+			case g.isChanWait():
+				chans = append(chans, g)
+			}
+		}
+		switch {
+		case len(locks) > 0:
+			d.Kind, d.Culprits = DumpDeadlock, locks
+		case len(chans) > 0:
+			d.Kind, d.Culprits = DumpChannel, chans
+		default:
+			d.Kind, d.Culprits = DumpHang, user
+		}
+
+	default:
+		// The first goroutine of a crash's dump is the one
+		// that crashed.
+		d.Kind = DumpCrash
+		d.Culprits = gs[:1]
+	}
+	return d
+}
+
+// isUser returns whether g is running code other than the runtime and
+// the testing framework.
+func (g *Goroutine) isUser() bool {
+	for _, f := range g.Frames {
+		fn := f.Function
+		if !(strings.HasPrefix(fn, "runtime.") || strings.HasPrefix(fn, "testing.") ||
+			strings.HasPrefix(fn, "time.") || strings.HasPrefix(fn, "os/signal.") ||
+			fn == "main.main" || fn == "panic") {
+			return true
+		}
+	}
+	return false
+}
+
+// isLockWait returns whether g is blocked on a sync primitive.
+func (g *Goroutine) isLockWait() bool {
+	return strings.HasPrefix(g.State, "semacquire") || strings.HasPrefix(g.State, "sync.")
+}
+
+// isChanWait returns whether g is blocked on a channel operation.
+func (g *Goroutine) isChanWait() bool {
+	return strings.HasPrefix(g.State, "chan ") || strings.HasPrefix(g.State, "select")
+}
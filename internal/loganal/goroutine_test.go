@@ -0,0 +1,192 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loganal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGoroutineDump(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		input string
+		kind  DumpKind
+		// goroutines and culprits are the IDs of the parsed
+		// goroutines and the culprits.
+		goroutines, culprits []int
+	}{
+		{"crash", `panic: runtime error: invalid memory address or nil pointer dereference
+[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4f1a2b]
+
+goroutine 18 [running]:
+p.(*T).m(...)
+	/src/p/p.go:10
+p.TestM(0xc0000a2000)
+	/src/p/p_test.go:22 +0x2b
+testing.tRunner(0xc0000a2000, 0x5a6b78)
+	/go/src/testing/testing.go:1439 +0x102
+created by testing.(*T).Run
+	/go/src/testing/testing.go:1486 +0x35f
+`,
+			DumpCrash, []int{18}, []int{18}},
+
+		{"deadlock", `fatal error: all goroutines are asleep - deadlock!
+
+goroutine 1 [chan receive]:
+testing.(*T).Run(0xc000007860, {0x5410d4, 0x8}, 0x5482a8)
+	/go/src/testing/testing.go:1487 +0x37a
+main.main()
+	_testmain.go:47 +0x14b
+
+goroutine 6 [semacquire]:
+sync.runtime_Semacquire(0x0)
+	/go/src/runtime/sema.go:56 +0x25
+sync.(*WaitGroup).Wait(0xc000012098)
+	/go/src/sync/waitgroup.go:136 +0x52
+p.TestWait(0x0)
+	/src/p/p_test.go:14 +0x65
+`,
+			DumpDeadlock, []int{1, 6}, []int{6}},
+
+		{"timeout on lock", `panic: test timed out after 10m0s
+
+goroutine 35 [running]:
+testing.(*M).startAlarm.func1()
+	/go/src/testing/testing.go:2036 +0x8e
+created by time.goFunc
+	/go/src/time/sleep.go:176 +0x32
+
+goroutine 7 [sync.Mutex.Lock, 9 minutes]:
+sync.runtime_SemacquireMutex(0xc0000b6004, 0x0, 0x1)
+	/go/src/runtime/sema.go:77 +0x25
+sync.(*Mutex).lockSlow(0xc0000b6000)
+	/go/src/sync/mutex.go:171 +0x165
+p.(*Cache).Get(0xc0000b6000)
+	/src/p/cache.go:40 +0x33
+p.TestCache(0xc000007a00)
+	/src/p/cache_test.go:18 +0x4a
+
+goroutine 8 [chan receive, 9 minutes]:
+p.worker(0xc000020120)
+	/src/p/worker.go:12 +0x2e
+created by p.TestCache in goroutine 7
+	/src/p/cache_test.go:15 +0x3c
+`,
+			DumpDeadlock, []int{35, 7, 8}, []int{7}},
+
+		{"timeout on channel", `panic: test timed out after 2m0s
+
+goroutine 9 [select, 2 minutes]:
+p.loop(0xc000020120, 0xc000020180)
+	/src/p/loop.go:20 +0x8c
+p.TestLoop(0xc000007a00)
+	/src/p/loop_test.go:9 +0x4a
+`,
+			DumpChannel, []int{9}, []int{9}},
+
+		{"timeout running", `*** Test killed with quit: ran too long (11m0s).
+
+goroutine 4 [running]:
+p.spin()
+	/src/p/spin.go:5 +0x12
+p.TestSpin(0xc000007a00)
+	/src/p/spin_test.go:9 +0x4a
+`,
+			DumpHang, []int{4}, []int{4}},
+
+		{"truncated", `panic: test timed out after 10m0s
+
+goroutine 12 [chan send, 10 minutes]:
+p.produce(0xc000020120)
+	/src/p/produce.go:31 +0x4f
+p.TestProduce.func1()
+	/src/p/produce_test.go:12 +0x2a
+created by p.TestProduce in goroutine 11
+	/src/p/produce_test.go:10 +0x7c
+
+goroutine 13 [chan receive, 10 minutes]:
+p.consume(0xc00`,
+			// Goroutine 13 lost its frames, so it isn't
+			// known to be running user code.
+			DumpChannel, []int{12, 13}, []int{12}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			d := parseGoroutineDump(test.input)
+			if d == nil {
+				t.Fatal("no goroutine dump found")
+			}
+			if d.Kind != test.kind {
+				t.Errorf("kind = %q, want %q", d.Kind, test.kind)
+			}
+			ids := func(gs []*Goroutine) []int {
+				var out []int
+				for _, g := range gs {
+					out = append(out, g.ID)
+				}
+				return out
+			}
+			if got := ids(d.Goroutines); !reflect.DeepEqual(got, test.goroutines) {
+				t.Errorf("goroutines = %v, want %v", got, test.goroutines)
+			}
+			if got := ids(d.Culprits); !reflect.DeepEqual(got, test.culprits) {
+				t.Errorf("culprits = %v, want %v", got, test.culprits)
+			}
+		})
+	}
+}
+
+func TestParseGoroutineDumpFrames(t *testing.T) {
+	// A dump cut off in the middle of a frame keeps the frames
+	// before the cut.
+	d := parseGoroutineDump(`panic: boom
+
+goroutine 5 [running, locked to thread]:
+p.f(0x1)
+	/src/p/p.go:3 +0x1d
+p.g()
+	/src/p/p.go:7 +0x2a
+created by p.start in goroutine 1
+	/src/p/p.go:11 +0x3c
+
+goroutine 6 [IO wait, 3 minutes]:
+internal/poll.runtime_pollWait(0x7f0, 0x72)
+	/go/src/runtime/netpoll.go:302 +0x89
+internal/poll.(*pollDesc).wait(0xc0001`)
+	if d == nil || len(d.Goroutines) != 2 {
+		t.Fatalf("got %+v, want 2 goroutines", d)
+	}
+
+	g := d.Goroutines[0]
+	want := &Goroutine{
+		ID:    5,
+		State: "running",
+		Frames: []Frame{
+			{"p.f", "/src/p/p.go", 3},
+			{"p.g", "/src/p/p.go", 7},
+		},
+		CreatedBy: &Frame{"p.start", "/src/p/p.go", 11},
+	}
+	if !reflect.DeepEqual(g, want) {
+		t.Errorf("goroutine 5 = %+v, want %+v", g, want)
+	}
+
+	g = d.Goroutines[1]
+	want = &Goroutine{
+		ID:      6,
+		State:   "IO wait",
+		Waiting: "3 minutes",
+		Frames: []Frame{
+			{"internal/poll.runtime_pollWait", "/go/src/runtime/netpoll.go", 302},
+		},
+	}
+	if !reflect.DeepEqual(g, want) {
+		t.Errorf("goroutine 6 = %+v, want %+v", g, want)
+	}
+
+	if d := parseGoroutineDump("panic: boom\n\ngoroutine 1 [runn"); d != nil {
+		t.Errorf("dump cut off in a header: got %+v, want nil", d)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loganal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	// streamWindow is the amount of context ExtractReader retains
+	// for each failure and for the output of each running test.
+	streamWindow = 512 << 10
+
+	// streamMaxLine is the longest line ExtractReader reads.
+	// Longer lines are truncated.
+	streamMaxLine = 1 << 20
+)
+
+var (
+	// streamBoundary matches the last line of a part of a text log
+	// that can be parsed independently of the following lines.
+	// These are the result lines of each package.
+	streamBoundary = regexp.MustCompile(`^(?:ok|\?|FAIL)[ \t]+\S`)
+
+	// streamBoring matches lines that ExtractReader drops if
+	// they begin a part of a text log, since they can't affect
+	// the failures found in it.
+	streamBoring = regexp.MustCompile(`^(?:=== |--- PASS|--- SKIP|PASS\n)`)
+)
+
+// ExtractReader is like Extract, but reads the log incrementally from
+// r. Its memory use is bounded regardless of the size of the log: it
+// retains only a window of each part of the log that may contain a
+// failure and of the output of each running test. If a failure's
+// context exceeds this window, its FullMessage keeps the beginning
+// and end of the context and elides the middle, so the failures
+// found may differ from Extract's for very long failure messages.
+func ExtractReader(r io.Reader, os, arch string) ([]*Failure, error) {
+	br := bufio.NewReaderSize(r, streamMaxLine)
+	text := textStream{chunk: window{max: streamWindow}, fallback: -1}
+	jx := newTest2JSONExtractor(streamWindow)
+	isJSON := false
+	for {
+		line, err := readLine(br)
+		if line != "" {
+			if ev, ok := parseTest2JSON(strings.TrimSuffix(line, "\n")); ok {
+				isJSON = true
+				jx.event(ev)
+			} else {
+				text.line(line)
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	text.flush()
+
+	if len(text.fs) == 0 && text.fallback >= 0 {
+		text.fs = append(text.fs, &Failure{Message: textFallbacks[text.fallback].message})
+	}
+	fs := append(text.fs, jx.fs...)
+	if !isJSON && !text.st.testingStarted && len(fs) == 0 {
+		// See Extract.
+		fs = append(fs, &Failure{
+			Message: "toolchain build failed",
+		})
+	}
+	return finishFailures(fs, os, arch), nil
+}
+
+// readLine reads a line from br, including its "\n", and
+// canonicalizes its line ending. If the line is longer than br's
+// buffer, it truncates the line.
+func readLine(br *bufio.Reader) (string, error) {
+	buf, err := br.ReadSlice('\n')
+	line := string(buf)
+	if err == bufio.ErrBufferFull {
+		// Discard the rest of the line.
+		for err == bufio.ErrBufferFull {
+			_, err = br.ReadSlice('\n')
+		}
+		if err == nil {
+			line += "\n"
+		}
+	}
+	if strings.HasSuffix(line, "\r\n") {
+		line = strings.TrimRight(line[:len(line)-1], "\r") + "\n"
+	}
+	return line, err
+}
+
+// A textStream incrementally extracts failures from a plain text log.
+// It splits the log into parts at the result line of each package and
+// extracts the failures from each part separately.
+type textStream struct {
+	st    textState
+	chunk window
+
+	// fs is the failures found so far.
+	fs []*Failure
+
+	// fallback is the index of the first of textFallbacks that
+	// matched any part of the log, or -1.
+	fallback int
+}
+
+// line processes the next line of the log.
+func (t *textStream) line(line string) {
+	if sectionHeader.MatchString(line) {
+		t.flush()
+	} else if t.chunk.len() == 0 && streamBoring.MatchString(line) {
+		return
+	}
+	t.chunk.WriteString(line)
+	if streamBoundary.MatchString(line) {
+		t.flush()
+	}
+}
+
+// flush extracts the failures from the current part of the log.
+func (t *textStream) flush() {
+	m := t.chunk.String()
+	t.chunk.reset()
+	if m == "" {
+		return
+	}
+	t.fs = append(t.fs, t.st.extract(m)...)
+	if i := textFallback(m); i >= 0 && (t.fallback < 0 || i < t.fallback) {
+		t.fallback = i
+	}
+}
+
+// A window accumulates text, retaining about max bytes of it. Once the
+// text exceeds max bytes, it keeps the first and last max/2 bytes and
+// elides the middle, at line boundaries where possible. If max is 0,
+// it retains all text.
+type window struct {
+	max int
+
+	head     []byte
+	headDone bool
+
+	// tail[tailStart:] is the retained end of the text.
+	tail      []byte
+	tailStart int
+
+	// elided is the number of bytes dropped between head and
+	// tail.
+	elided int
+}
+
+// WriteString appends s to the window.
+func (w *window) WriteString(s string) {
+	if !w.headDone {
+		if w.max == 0 || len(w.head)+len(s) <= w.max/2 {
+			w.head = append(w.head, s...)
+			return
+		}
+		w.headDone = true
+	}
+
+	w.tail = append(w.tail, s...)
+	for len(w.tail)-w.tailStart > w.max/2 {
+		n := bytes.IndexByte(w.tail[w.tailStart:], '\n') + 1
+		if n == 0 {
+			n = len(w.tail) - w.tailStart
+		}
+		w.tailStart += n
+		w.elided += n
+	}
+	if w.tailStart > len(w.tail)/2 {
+		w.tail = append(w.tail[:0], w.tail[w.tailStart:]...)
+		w.tailStart = 0
+	}
+}
+
+// len returns the number of bytes retained in the window.
+func (w *window) len() int {
+	return len(w.head) + len(w.tail) - w.tailStart
+}
+
+// reset empties the window.
+func (w *window) reset() {
+	*w = window{max: w.max, head: w.head[:0], tail: w.tail[:0]}
+}
+
+// String returns the retained text. If text was elided, this replaces
+// it with a line saying so. Log parsers ignore this line.
+func (w *window) String() string {
+	tail := w.tail[w.tailStart:]
+	if w.elided == 0 {
+		return string(w.head) + string(tail)
+	}
+	sep := ""
+	if len(w.head) > 0 && w.head[len(w.head)-1] != '\n' {
+		sep = "\n"
+	}
+	return fmt.Sprintf("%s%s# ... %d bytes elided ...\n%s", w.head, sep, w.elided, tail)
+}
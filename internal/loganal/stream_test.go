@@ -0,0 +1,146 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loganal
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// synthLog is an io.Reader that generates a go test log of a given
+// size without holding it in memory. Like a real log, it has a few
+// failures, each a test that panics with a goroutine dump, followed by
+// an arbitrary amount of passing test output.
+type synthLog struct {
+	size int64 // bytes left to generate
+	json bool  // generate test2json events
+	pkg  int
+	buf  bytes.Buffer
+}
+
+func (l *synthLog) Read(p []byte) (int, error) {
+	if l.size <= 0 {
+		return 0, io.EOF
+	}
+	if l.buf.Len() == 0 {
+		l.nextPackage()
+	}
+	if int64(len(p)) > l.size {
+		p = p[:l.size]
+	}
+	n, _ := l.buf.Read(p)
+	l.size -= int64(n)
+	return n, nil
+}
+
+// nextPackage generates the log of the next package into l.buf.
+func (l *synthLog) nextPackage() {
+	pkg := fmt.Sprintf("example.com/pkg%d", l.pkg)
+	failed := l.pkg < 100 && l.pkg%10 == 9
+	l.pkg++
+
+	var out bytes.Buffer
+	emit := func(action, test, output string) {
+		if l.json {
+			fmt.Fprintf(&l.buf, `{"Action":%q,"Package":%q,"Test":%q,"Output":%q}`+"\n", action, pkg, test, output)
+		} else if action == "output" {
+			l.buf.WriteString(output)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		test := fmt.Sprintf("TestCase%d", i)
+		emit("run", test, "")
+		emit("output", test, fmt.Sprintf("=== RUN   %s\n", test))
+		emit("output", test, fmt.Sprintf("--- PASS: %s (0.01s)\n", test))
+		emit("pass", test, "")
+	}
+	if !failed {
+		emit("output", "", fmt.Sprintf("ok  \t%s\t1.000s\n", pkg))
+		emit("pass", "", "")
+		return
+	}
+
+	emit("run", "TestCrash", "")
+	out.WriteString("=== RUN   TestCrash\n")
+	out.WriteString("--- FAIL: TestCrash (0.00s)\n")
+	out.WriteString("panic: runtime error: index out of range [recovered]\n\n")
+	out.WriteString("goroutine 7 [running]:\n")
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&out, "%s.frame%d(0x0)\n\t/src/%s/crash_test.go:%d +0x20\n", pkg, i, pkg, i+10)
+	}
+	out.WriteString("created by testing.(*T).Run\n\t/goroot/src/testing/testing.go:1000 +0x300\n")
+	for _, line := range bytes.SplitAfter(out.Bytes(), []byte("\n")) {
+		if len(line) > 0 {
+			emit("output", "TestCrash", string(line))
+		}
+	}
+	emit("output", "", fmt.Sprintf("FAIL\t%s\t0.010s\n", pkg))
+	emit("fail", "", "")
+}
+
+var benchLarge = flag.Bool("bench-large", false, "run ExtractReader benchmarks on multi-gigabyte logs")
+
+func BenchmarkExtractReader(b *testing.B) {
+	for _, json := range []bool{false, true} {
+		for _, size := range []int64{16 << 20, 256 << 20, 4 << 30} {
+			name := fmt.Sprintf("text/%dMB", size>>20)
+			if json {
+				name = fmt.Sprintf("json/%dMB", size>>20)
+			}
+			b.Run(name, func(b *testing.B) {
+				if size > 256<<20 && (!*benchLarge || testing.Short()) {
+					b.Skip("skipping large log; use -bench-large")
+				}
+				benchmarkExtractReader(b, size, json)
+			})
+		}
+	}
+}
+
+func benchmarkExtractReader(b *testing.B, size int64, json bool) {
+	// Sample the heap while extracting to show that memory use
+	// doesn't grow with the size of the log. The log has a fixed
+	// number of failures, so the result doesn't either.
+	var peak uint64
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > peak {
+				peak = ms.HeapInuse
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		fs, err := ExtractReader(&synthLog{size: size, json: json}, "", "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(fs) == 0 {
+			b.Fatal("no failures found")
+		}
+	}
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+	b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+}
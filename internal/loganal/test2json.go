@@ -0,0 +1,283 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loganal
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// test2jsonEvent is an event in a test2json stream. See "go doc
+// test2json".
+type test2jsonEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+
+	// ImportPath identifies the package of build-output and
+	// build-fail events. It may be followed by a bracketed test
+	// variant, like "p [p.test]".
+	ImportPath string
+}
+
+var (
+	// test2jsonLine matches the first line that may be a test2json
+	// event.
+	test2jsonLine = regexp.MustCompile(`(?m)^\{"`)
+
+	// jsonTestError matches a T.Error in the output of a test.
+	// Depending on the Go version, these are indented by a tab or
+	// by spaces.
+	jsonTestError = regexp.MustCompile(`(?m)^[ \t]+([^:\s]+\.go):([0-9]+): (.*)$`)
+
+	// jsonTestPanic matches a panic or runtime throw in the output
+	// of a test.
+	jsonTestPanic = regexp.MustCompile(`(?m)^(?:panic: |fatal error: )(.*?)(?: \[recovered.*\])?$`)
+)
+
+// isTest2JSON returns whether log m is a test2json event stream. Such
+// a stream may be preceded or interrupted by plain text lines.
+func isTest2JSON(m string) bool {
+	loc := test2jsonLine.FindStringIndex(m)
+	if loc == nil {
+		return false
+	}
+	_, ok := parseTest2JSON(firstLine(m[loc[0]:]))
+	return ok
+}
+
+// parseTest2JSON parses a line of a test2json stream.
+func parseTest2JSON(line string) (test2jsonEvent, bool) {
+	var ev test2jsonEvent
+	if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &ev) != nil || ev.Action == "" {
+		return ev, false
+	}
+	return ev, true
+}
+
+// extractTest2JSON parses the failures from test2json event stream m.
+// Lines of m that aren't events are parsed as a text log.
+func extractTest2JSON(m string) []*Failure {
+	x := newTest2JSONExtractor(0)
+	var text strings.Builder
+	for _, line := range strings.SplitAfter(m, "\n") {
+		ev, ok := parseTest2JSON(strings.TrimSuffix(line, "\n"))
+		if !ok {
+			text.WriteString(line)
+			continue
+		}
+		x.event(ev)
+	}
+
+	fs := x.fs
+	if text.Len() > 0 {
+		tfs, _ := extractText(text.String())
+		fs = append(tfs, fs...)
+	}
+	return fs
+}
+
+// testKey identifies a test, or a package if test is "".
+type testKey struct {
+	pkg, test string
+}
+
+// A test2jsonExtractor incrementally extracts failures from test2json
+// events.
+type test2jsonExtractor struct {
+	// fs is the failures found so far.
+	fs []*Failure
+
+	// maxOutput limits the output retained for each test. See
+	// window.
+	maxOutput int
+
+	output      map[testKey]*window
+	buildOutput map[string]*window
+
+	// failed maps packages with a build failure and tests with a
+	// failed subtest to the failure that accounts for them.
+	failed map[testKey]*Failure
+
+	// running records the tests that have started but not
+	// finished.
+	running map[testKey]bool
+}
+
+// newTest2JSONExtractor returns a test2jsonExtractor that retains
+// about maxOutput bytes of the output of each test, or all output if
+// maxOutput is 0.
+func newTest2JSONExtractor(maxOutput int) *test2jsonExtractor {
+	return &test2jsonExtractor{
+		maxOutput:   maxOutput,
+		output:      map[testKey]*window{},
+		buildOutput: map[string]*window{},
+		failed:      map[testKey]*Failure{},
+		running:     map[testKey]bool{},
+	}
+}
+
+// event processes the next event in the stream.
+func (x *test2jsonExtractor) event(ev test2jsonEvent) {
+	key := testKey{ev.Package, ev.Test}
+	switch ev.Action {
+	case "run":
+		x.running[key] = true
+
+	case "pass", "skip":
+		// The output of a passing test is no longer needed.
+		delete(x.running, key)
+		delete(x.output, key)
+
+	case "output":
+		b := x.output[key]
+		if b == nil {
+			b = &window{max: x.maxOutput}
+			x.output[key] = b
+		}
+		b.WriteString(ev.Output)
+
+	case "build-output":
+		b := x.buildOutput[ev.ImportPath]
+		if b == nil {
+			b = &window{max: x.maxOutput}
+			x.buildOutput[ev.ImportPath] = b
+		}
+		b.WriteString(ev.Output)
+
+	case "build-fail":
+		pkg := ev.ImportPath
+		if i := strings.Index(pkg, " ["); i >= 0 {
+			pkg = pkg[:i]
+		}
+		f := &Failure{
+			Package: pkg,
+			Message: "build failed",
+		}
+		if b := x.buildOutput[ev.ImportPath]; b != nil {
+			f.FullMessage = b.String()
+			delete(x.buildOutput, ev.ImportPath)
+		}
+		x.failed[testKey{pkg, ""}] = f
+		x.fs = append(x.fs, f)
+
+	case "fail":
+		x.fail(key)
+	}
+}
+
+// fail processes a "fail" event for key.
+func (x *test2jsonExtractor) fail(key testKey) {
+	delete(x.running, key)
+	var out string
+	if b := x.output[key]; b != nil {
+		out = b.String()
+		delete(x.output, key)
+	}
+	if key.test == "" {
+		// If the test binary crashed or timed out, the tests
+		// that were running never finish. Blame the innermost
+		// of these.
+		var stuck []string
+		for k := range x.running {
+			if k.pkg == key.pkg {
+				stuck = append(stuck, k.test)
+				delete(x.running, k)
+			}
+		}
+		sort.Strings(stuck)
+		for i, test := range stuck {
+			if i+1 < len(stuck) && strings.HasPrefix(stuck[i+1], test+"/") {
+				continue
+			}
+			var sout string
+			if b := x.output[testKey{key.pkg, test}]; b != nil {
+				sout = b.String()
+			}
+			f := testFailure(key.pkg, test, sout)
+			x.fs = append(x.fs, f)
+			x.failed[key] = f
+		}
+		for k := range x.output {
+			if k.pkg == key.pkg {
+				delete(x.output, k)
+			}
+		}
+	}
+	if f := x.failed[key]; f != nil {
+		// A subtest failure or build failure already accounts
+		// for this failure. A panic in a subtest is printed by
+		// its parent, so add the parent's output to a subtest
+		// failure that lacks a message.
+		if key.test != "" && f.Message == unknownTestFailure {
+			*f = *testFailure(f.Package, f.Test, f.FullMessage+out)
+		}
+		return
+	}
+
+	var f *Failure
+	if key.test != "" {
+		f = testFailure(key.pkg, key.test, out)
+		x.fs = append(x.fs, f)
+	}
+	// Mark the parents of this test as accounted for. All tests
+	// also mark the package.
+	test := key.test
+	for test != "" {
+		if i := strings.LastIndex(test, "/"); i >= 0 {
+			test = test[:i]
+		} else {
+			test = ""
+		}
+		x.failed[testKey{key.pkg, test}] = f
+	}
+	if f != nil {
+		return
+	}
+
+	// The package failed outside of any test, for example, in
+	// TestMain or in a test binary that didn't start. Parse the
+	// package's output as a text log.
+	pfs, _ := extractText(out)
+	if len(pfs) == 0 {
+		pfs = append(pfs, &Failure{
+			FullMessage: out,
+			Message:     "unknown failure: " + firstLine(strings.TrimLeft(out, "\n")),
+		})
+	}
+	for _, f := range pfs {
+		f.Package = key.pkg
+	}
+	x.fs = append(x.fs, pfs...)
+}
+
+// testFailure returns the failure of test in pkg, given the test's
+// output.
+func testFailure(pkg, test, out string) *Failure {
+	f := &Failure{
+		Package:     pkg,
+		Test:        test,
+		FullMessage: out,
+		Message:     unknownTestFailure,
+	}
+	// A panic ends the test, so it takes precedence over any
+	// earlier errors.
+	if sPanic := jsonTestPanic.FindStringSubmatch(out); sPanic != nil {
+		f.Function, f.File, f.Line = panicWhere(out)
+		f.Message = sPanic[1]
+		if strings.HasPrefix(f.Message, "test timed out") {
+			f.Function, f.File, f.Line = "", "", 0
+			f.Message = "test timed out"
+		}
+	} else if sErrors := jsonTestError.FindAllStringSubmatch(out, -1); sErrors != nil {
+		// Like the text log parser, use the last error.
+		sError := sErrors[len(sErrors)-1]
+		f.File, f.Line, f.Message = sError[1], atoi(sError[2]), sError[3]
+	}
+	return f
+}
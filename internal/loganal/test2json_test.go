@@ -0,0 +1,158 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loganal
+
+import (
+	"reflect"
+	"testing"
+)
+
+// failureSummary is the part of a Failure compared by tests.
+type failureSummary struct {
+	Package, Test, Message string
+	Function, File         string
+	Line                   int
+}
+
+func summarize(fs []*Failure) []failureSummary {
+	out := []failureSummary{}
+	for _, f := range fs {
+		out = append(out, failureSummary{f.Package, f.Test, f.Message, f.Function, f.File, f.Line})
+	}
+	return out
+}
+
+func TestExtractTest2JSON(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		input string
+		want  []failureSummary
+	}{
+		{"interleaved subtests", `
+{"Action":"run","Package":"p","Test":"TestP"}
+{"Action":"output","Package":"p","Test":"TestP","Output":"=== RUN   TestP\n"}
+{"Action":"run","Package":"p","Test":"TestP/a"}
+{"Action":"output","Package":"p","Test":"TestP/a","Output":"=== RUN   TestP/a\n"}
+{"Action":"output","Package":"p","Test":"TestP/a","Output":"=== PAUSE TestP/a\n"}
+{"Action":"pause","Package":"p","Test":"TestP/a"}
+{"Action":"run","Package":"p","Test":"TestP/b"}
+{"Action":"output","Package":"p","Test":"TestP/b","Output":"=== RUN   TestP/b\n"}
+{"Action":"output","Package":"p","Test":"TestP/b","Output":"=== PAUSE TestP/b\n"}
+{"Action":"pause","Package":"p","Test":"TestP/b"}
+{"Action":"cont","Package":"p","Test":"TestP/a"}
+{"Action":"output","Package":"p","Test":"TestP/a","Output":"=== CONT  TestP/a\n"}
+{"Action":"cont","Package":"p","Test":"TestP/b"}
+{"Action":"output","Package":"p","Test":"TestP/b","Output":"=== CONT  TestP/b\n"}
+{"Action":"output","Package":"p","Test":"TestP/b","Output":"    p_test.go:20: b is fine\n"}
+{"Action":"output","Package":"p","Test":"TestP/a","Output":"    p_test.go:15: a is broken\n"}
+{"Action":"output","Package":"p","Test":"TestP/b","Output":"--- PASS: TestP/b (0.00s)\n"}
+{"Action":"pass","Package":"p","Test":"TestP/b"}
+{"Action":"output","Package":"p","Test":"TestP/a","Output":"--- FAIL: TestP/a (0.00s)\n"}
+{"Action":"fail","Package":"p","Test":"TestP/a"}
+{"Action":"output","Package":"p","Test":"TestP","Output":"--- FAIL: TestP (0.00s)\n"}
+{"Action":"fail","Package":"p","Test":"TestP"}
+{"Action":"output","Package":"p","Output":"FAIL\n"}
+{"Action":"fail","Package":"p"}
+`,
+			[]failureSummary{
+				{Package: "p", Test: "TestP/a", Message: "a is broken", File: "p_test.go", Line: 15},
+			},
+		},
+
+		{"output split across events", `
+{"Action":"run","Package":"p","Test":"TestSplit"}
+{"Action":"output","Package":"p","Test":"TestSplit","Output":"=== RUN   TestSplit\n"}
+{"Action":"output","Package":"p","Test":"TestSplit","Output":"    split_test.go:7: got 1, "}
+{"Action":"output","Package":"p","Test":"TestSplit","Output":"want 2\n"}
+{"Action":"output","Package":"p","Test":"TestSplit","Output":"--- FAIL: TestSplit (0.00s)\n"}
+{"Action":"fail","Package":"p","Test":"TestSplit"}
+{"Action":"fail","Package":"p"}
+`,
+			[]failureSummary{
+				{Package: "p", Test: "TestSplit", Message: "got 1, want 2", File: "split_test.go", Line: 7},
+			},
+		},
+
+		{"crash blames running test", `
+{"Action":"run","Package":"p","Test":"TestOK"}
+{"Action":"pass","Package":"p","Test":"TestOK"}
+{"Action":"run","Package":"p","Test":"TestCrash"}
+{"Action":"run","Package":"p","Test":"TestCrash/sub"}
+{"Action":"output","Package":"p","Test":"TestCrash/sub","Output":"panic: runtime error: index out of range [3] with length 3\n"}
+{"Action":"output","Package":"p","Test":"TestCrash/sub","Output":"\ngoroutine 7 [running]:\n"}
+{"Action":"output","Package":"p","Test":"TestCrash/sub","Output":"p.index(...)\n\t/src/p/p.go:12\n"}
+{"Action":"output","Package":"p","Test":"TestCrash/sub","Output":"p.TestCrash.func1(0xc000082b60)\n\t/src/p/p_test.go:30 +0x1d\n"}
+{"Action":"output","Package":"p","Output":"FAIL\tp\t0.012s\n"}
+{"Action":"fail","Package":"p"}
+`,
+			[]failureSummary{
+				{Package: "p", Test: "TestCrash/sub", Message: "runtime error: index out of range [3] with length 3", Function: "p.index", File: "/src/p/p.go", Line: 12},
+			},
+		},
+
+		{"build failure", `
+{"ImportPath":"p [p.test]","Action":"build-output","Output":"# p [p.test]\n"}
+{"ImportPath":"p [p.test]","Action":"build-output","Output":"./p_test.go:5:2: undefined: x\n"}
+{"ImportPath":"p [p.test]","Action":"build-fail"}
+{"Action":"output","Package":"p","Output":"FAIL\tp [build failed]\n"}
+{"Action":"fail","Package":"p"}
+`,
+			[]failureSummary{
+				{Package: "p", Message: "build failed"},
+			},
+		},
+
+		{"failure outside tests", `
+{"Action":"output","Package":"p","Output":"TestMain setup failed\n"}
+{"Action":"output","Package":"p","Output":"FAIL\tp\t0.003s\n"}
+{"Action":"fail","Package":"p"}
+`,
+			[]failureSummary{
+				{Package: "p", Message: "unknown failure: TestMain setup failed"},
+			},
+		},
+
+		{"interrupting text", `
+##### Testing packages.
+{"Action":"run","Package":"p","Test":"TestX"}
+{"Action":"output","Package":"p","Test":"TestX","Output":"    x_test.go:3: bad\n"}
+{"Action":"fail","Package":"p","Test":"TestX"}
+{"Action":"fail","Package":"p"}
+##### API check
+Error running API checker: exit status 1
+`,
+			[]failureSummary{
+				{Package: "API checker", Message: "exit status 1"},
+				{Package: "p", Test: "TestX", Message: "bad", File: "x_test.go", Line: 3},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if !isTest2JSON(test.input) {
+				t.Fatalf("isTest2JSON = false, want true")
+			}
+			got := summarize(extractTest2JSON(test.input))
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got:\n%+v\nwant:\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsTest2JSON(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  bool
+	}{
+		{"ok  \tp\t0.01s\n", false},
+		{"{\"Action\":\"run\",\"Package\":\"p\",\"Test\":\"TestX\"}\n", true},
+		{"# p\n{\"Action\":\"start\",\"Package\":\"p\"}\n", true},
+		{"{\"not\":\"an event\"}\n", false},
+	} {
+		if got := isTest2JSON(test.input); got != test.want {
+			t.Errorf("isTest2JSON(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
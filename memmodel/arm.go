@@ -0,0 +1,69 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// ARMModel models all loads and stores as ARMv8 operations. ARMv8 is
+// other-multi-copy atomic: a store becomes visible to all other
+// threads at once. Each thread may execute its loads and stores out
+// of order, except for loads and stores of the same variable.
+//
+// Go's sync/atomic on arm64 implements atomic loads with LDAR and
+// atomic stores with STLR. These are modeled by the Acquire and
+// Release options.
+type ARMModel struct {
+	// Acquire, if true, makes all loads load-acquires (LDAR).
+	// Later loads and stores cannot execute before a
+	// load-acquire.
+	Acquire bool
+
+	// Release, if true, makes all stores store-releases (STLR).
+	// A store-release cannot execute before earlier loads and
+	// stores. If both Acquire and Release are set, a
+	// load-acquire also cannot execute before an earlier
+	// store-release.
+	Release bool
+}
+
+func (m ARMModel) String() string {
+	s := "ARMv8"
+	if m.Acquire {
+		s += "+LDAR"
+	}
+	if m.Release {
+		s += "+STLR"
+	}
+	return s
+}
+
+func (m ARMModel) Eval(p *Prog, outcomes *OutcomeSet) {
+	evalRelaxed(m, p, outcomes)
+}
+
+func (m ARMModel) Witness(p *Prog, cond, mask Outcome) []Event {
+	return witnessRelaxed(m, p, cond, mask)
+}
+
+func (m ARMModel) ordered(earlier, later Op) bool {
+	switch {
+	case earlier.Var == later.Var:
+		// Accesses to the same variable are coherent.
+		return true
+	case m.Acquire && earlier.Type == OpLoad:
+		return true
+	case m.Release && later.Type == OpStore:
+		return true
+	case m.Acquire && m.Release && earlier.Type == OpStore && later.Type == OpLoad:
+		return true
+	}
+	return false
+}
+
+func (ARMModel) multiCopyAtomic() bool {
+	return true
+}
+
+func (ARMModel) barrier() (cumulative, full bool) {
+	return false, false
+}
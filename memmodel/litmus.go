@@ -0,0 +1,510 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A Litmus is a litmus test: a program and a condition on its
+// outcome. Litmus tests can be read from and written to the .litmus
+// format used by herd7 and the other diy tools.
+//
+// Only the subset of the format that corresponds to a Prog is
+// supported: X86, AArch64, and PPC tests whose threads perform plain
+// loads and stores of 1, with each variable stored at most once, and
+// whose condition is a conjunction of the results of loads.
+type Litmus struct {
+	Name string
+	Prog Prog
+
+	// Exists is true if the condition is "exists" and false if it
+	// is "~exists".
+	Exists bool
+
+	// Cond and Mask are the condition on the outcome. An outcome
+	// o satisfies the condition if o&Mask == Cond.
+	Cond, Mask Outcome
+}
+
+// LitmusArchs lists the supported litmus architectures.
+var LitmusArchs = []string{"X86", "AArch64", "PPC"}
+
+// Observed returns whether any outcome in s satisfies l's condition.
+func (l *Litmus) Observed(s *OutcomeSet) bool {
+	observed := false
+	for o := range s.OutcomeIter() {
+		// Consume all outcomes so the iterator exits.
+		observed = observed || o&l.Mask == l.Cond
+	}
+	return observed
+}
+
+// Ok returns whether the outcomes in s validate l's condition. This
+// is what herd7 reports as "Ok" or "No".
+func (l *Litmus) Ok(s *OutcomeSet) bool {
+	return l.Observed(s) == l.Exists
+}
+
+// CondString returns l's condition in terms of l.Prog's loads.
+func (l *Litmus) CondString() string {
+	var conds []string
+	for id := 0; id < l.Prog.NumLoads; id++ {
+		if l.Mask&(1<<uint(id)) != 0 {
+			conds = append(conds, fmt.Sprintf("%c=%d", 'a'+id, (l.Cond>>uint(id))&1))
+		}
+	}
+	q := "exists"
+	if !l.Exists {
+		q = "~exists"
+	}
+	return q + " (" + strings.Join(conds, " /\\ ") + ")"
+}
+
+// litmusVarNames are the names of variables in written litmus tests.
+var litmusVarNames = [MaxVar]string{"x", "y", "z", "w"}
+
+// WriteLitmus writes l to w as a litmus test for arch, which must be
+// one of LitmusArchs.
+func (l *Litmus) WriteLitmus(w io.Writer, arch string) error {
+	p := &l.Prog
+	var insns [MaxThreads][]string
+	var init []string
+	var conds []string
+	nthr := 0
+	for tid := range p.Threads {
+		if p.Threads[tid].Ops[0].Type == OpExit {
+			break
+		}
+		nthr++
+		nload := 0
+		for i, op := range p.Threads[tid].Ops[:MaxOps] {
+			if op.Type == OpExit {
+				break
+			}
+			v := litmusVarNames[op.Var]
+			var reg string // Register holding the load result.
+			switch arch {
+			case "X86":
+				reg = []string{"EAX", "EBX", "ECX", "EDX"}[nload]
+				if op.Type == OpStore {
+					insns[tid] = append(insns[tid], fmt.Sprintf("MOV [%s],$1", v))
+				} else {
+					insns[tid] = append(insns[tid], fmt.Sprintf("MOV %s,[%s]", reg, v))
+				}
+
+			case "AArch64":
+				reg = fmt.Sprintf("X%d", 2*i)
+				init = append(init, fmt.Sprintf("%d:X%d=%s;", tid, 2*i+1, v))
+				if op.Type == OpStore {
+					insns[tid] = append(insns[tid], fmt.Sprintf("MOV W%d,#1", 2*i), fmt.Sprintf("STR W%d,[X%d]", 2*i, 2*i+1))
+				} else {
+					insns[tid] = append(insns[tid], fmt.Sprintf("LDR W%d,[X%d]", 2*i, 2*i+1))
+				}
+
+			case "PPC":
+				// r0 reads as 0 in addressing, so avoid
+				// it.
+				reg = fmt.Sprintf("r%d", 2*i+1)
+				init = append(init, fmt.Sprintf("%d:r%d=%s;", tid, 2*i+2, v))
+				if op.Type == OpStore {
+					insns[tid] = append(insns[tid], fmt.Sprintf("li r%d,1", 2*i+1), fmt.Sprintf("stw r%d,0(r%d)", 2*i+1, 2*i+2))
+				} else {
+					insns[tid] = append(insns[tid], fmt.Sprintf("lwz r%d,0(r%d)", 2*i+1, 2*i+2))
+				}
+
+			default:
+				return fmt.Errorf("unknown litmus architecture %q", arch)
+			}
+			if op.Type == OpLoad {
+				nload++
+				if l.Mask&(1<<op.ID) != 0 {
+					conds = append(conds, fmt.Sprintf("%d:%s=%d", tid, reg, (l.Cond>>op.ID)&1))
+				}
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %s\n", arch, l.Name)
+	fmt.Fprintf(bw, "\"Written by memmodel\"\n")
+	fmt.Fprintf(bw, "{\n")
+	for v := 0; v < MaxVar; v++ {
+		if usesVar(p, byte(v)) {
+			fmt.Fprintf(bw, "%s=0;\n", litmusVarNames[v])
+		}
+	}
+	for _, s := range init {
+		fmt.Fprintf(bw, "%s\n", s)
+	}
+	fmt.Fprintf(bw, "}\n")
+
+	// Print the program as a table with a column per thread.
+	rows := 0
+	widths := make([]int, nthr)
+	for tid := range insns[:nthr] {
+		if len(insns[tid]) > rows {
+			rows = len(insns[tid])
+		}
+		widths[tid] = len(fmt.Sprintf("P%d", tid))
+		for _, insn := range insns[tid] {
+			if len(insn) > widths[tid] {
+				widths[tid] = len(insn)
+			}
+		}
+	}
+	for row := -1; row < rows; row++ {
+		for tid := range insns[:nthr] {
+			cell := ""
+			if row < 0 {
+				cell = fmt.Sprintf("P%d", tid)
+			} else if row < len(insns[tid]) {
+				cell = insns[tid][row]
+			}
+			sep := " |"
+			if tid == nthr-1 {
+				sep = " ;"
+			}
+			fmt.Fprintf(bw, " %-*s%s", widths[tid], cell, sep)
+		}
+		fmt.Fprintf(bw, "\n")
+	}
+
+	q := "exists"
+	if !l.Exists {
+		q = "~exists"
+	}
+	fmt.Fprintf(bw, "%s (%s)\n", q, strings.Join(conds, " /\\ "))
+	return bw.Flush()
+}
+
+// usesVar returns whether p loads or stores variable v.
+func usesVar(p *Prog, v byte) bool {
+	for tid := range p.Threads {
+		for _, op := range p.Threads[tid].Ops {
+			if op.Type != OpExit && op.Var == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var (
+	litmusComment = regexp.MustCompile(`(?s)\(\*.*?\*\)`)
+	litmusHeader  = regexp.MustCompile(`^\s*(\S+)\s+(\S+)`)
+	litmusCond    = regexp.MustCompile(`^\s*(~?exists|forall)\s*\((.*)\)\s*$`)
+	litmusCondEq  = regexp.MustCompile(`^([0-9]+):(\w+)=([0-9]+)$`)
+	litmusThread  = regexp.MustCompile(`^P([0-9]+)$`)
+)
+
+// ParseLitmus parses a litmus test from r.
+func ParseLitmus(r io.Reader) (*Litmus, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text := litmusComment.ReplaceAllString(string(data), "")
+
+	// Parse the header line.
+	m := litmusHeader.FindStringSubmatch(text)
+	if m == nil {
+		return nil, fmt.Errorf("missing litmus header")
+	}
+	arch, name := m[1], m[2]
+	known := false
+	for _, a := range LitmusArchs {
+		known = known || arch == a
+	}
+	if !known {
+		return nil, fmt.Errorf("unsupported litmus architecture %q", arch)
+	}
+
+	// Parse the initial state.
+	lbrace, rbrace := strings.Index(text, "{"), strings.Index(text, "}")
+	if lbrace < 0 || rbrace < lbrace {
+		return nil, fmt.Errorf("missing initial state")
+	}
+	// regs maps "tid:reg" to the variable whose address it
+	// holds or the value it holds.
+	regs := map[string]string{}
+	for _, ent := range strings.Split(text[lbrace+1:rbrace], ";") {
+		ent = strings.TrimSpace(ent)
+		if ent == "" {
+			continue
+		}
+		i := strings.Index(ent, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("bad initial state %q", ent)
+		}
+		lhs, rhs := strings.TrimSpace(ent[:i]), strings.TrimSpace(ent[i+1:])
+		// Drop any type, as in "int x=0".
+		if f := strings.Fields(lhs); len(f) > 0 {
+			lhs = f[len(f)-1]
+		}
+		if strings.Contains(lhs, ":") {
+			regs[canonLitmusReg(arch, lhs)] = rhs
+		} else if rhs != "0" {
+			return nil, fmt.Errorf("initial value of %s must be 0", lhs)
+		}
+	}
+
+	// Split the rest into the program table and the condition.
+	var rows [][]string
+	var cond []string
+	for _, line := range strings.Split(text[rbrace+1:], "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case cond != nil:
+			cond = append(cond, line)
+		case strings.HasPrefix(line, "locations"):
+		case strings.HasPrefix(line, "exists") || strings.HasPrefix(line, "~exists") || strings.HasPrefix(line, "forall"):
+			cond = append(cond, line)
+		case strings.HasPrefix(line, "filter"):
+			return nil, fmt.Errorf("filter conditions are not supported")
+		default:
+			line = strings.TrimSuffix(line, ";")
+			var cells []string
+			for _, cell := range strings.Split(line, "|") {
+				cells = append(cells, strings.TrimSpace(cell))
+			}
+			rows = append(rows, cells)
+		}
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("missing program")
+	}
+	nthr := len(rows[0])
+	if nthr > MaxThreads {
+		return nil, fmt.Errorf("too many threads (%d > %d)", nthr, MaxThreads)
+	}
+	for tid, cell := range rows[0] {
+		if m := litmusThread.FindStringSubmatch(cell); m == nil || m[1] != strconv.Itoa(tid) {
+			return nil, fmt.Errorf("bad thread header %q", cell)
+		}
+	}
+
+	// Translate each thread's instructions into ops. Variables and
+	// loads are numbered in program order, like GenerateProgs
+	// does.
+	l := &Litmus{Name: name}
+	p := &l.Prog
+	vars := map[string]byte{}
+	stored := map[string]bool{}
+	// loadReg maps "tid:reg" to the ID of the load that wrote it.
+	loadReg := map[string]byte{}
+	varOf := func(name string) (byte, error) {
+		v, ok := vars[name]
+		if !ok {
+			if len(vars) == MaxVar {
+				return 0, fmt.Errorf("too many variables (> %d)", MaxVar)
+			}
+			v = byte(len(vars))
+			vars[name] = v
+		}
+		return v, nil
+	}
+	for tid := 0; tid < nthr; tid++ {
+		nops := 0
+		for _, row := range rows[1:] {
+			if len(row) != nthr {
+				return nil, fmt.Errorf("row %q has %d columns, want %d", strings.Join(row, " | "), len(row), nthr)
+			}
+			if row[tid] == "" {
+				continue
+			}
+			in, err := parseLitmusInsn(arch, row[tid])
+			if err != nil {
+				return nil, err
+			}
+			reg := func(r string) string {
+				return canonLitmusReg(arch, fmt.Sprintf("%d:%s", tid, r))
+			}
+			if in.kind == litmusInsnMove {
+				regs[reg(in.reg)] = in.val
+				continue
+			}
+			// Resolve the address and value.
+			loc := in.loc
+			if in.addrReg != "" {
+				loc = regs[reg(in.addrReg)]
+				if loc == "" {
+					return nil, fmt.Errorf("P%d: %q: register %s does not hold an address", tid, row[tid], in.addrReg)
+				}
+			}
+			if nops == MaxOps {
+				return nil, fmt.Errorf("P%d: too many operations (> %d)", tid, MaxOps)
+			}
+			op := &p.Threads[tid].Ops[nops]
+			nops++
+			if in.kind == litmusInsnStore {
+				val := in.val
+				if in.valReg != "" {
+					val = regs[reg(in.valReg)]
+				}
+				if val != "1" {
+					return nil, fmt.Errorf("P%d: %q: only stores of 1 are supported", tid, row[tid])
+				}
+				if stored[loc] {
+					return nil, fmt.Errorf("%s is stored more than once", loc)
+				}
+				stored[loc] = true
+				op.Type = OpStore
+			} else {
+				if p.NumLoads == MaxTotalOps {
+					return nil, fmt.Errorf("too many loads")
+				}
+				op.Type = OpLoad
+				op.ID = byte(p.NumLoads)
+				p.NumLoads++
+				loadReg[reg(in.reg)] = op.ID
+				// The register no longer holds an
+				// address or constant.
+				regs[reg(in.reg)] = ""
+			}
+			if op.Var, err = varOf(loc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.Threads[0].Ops[0].Type == OpExit {
+		return nil, fmt.Errorf("empty program")
+	}
+
+	// Parse the condition.
+	if cond == nil {
+		return nil, fmt.Errorf("missing condition")
+	}
+	m = litmusCond.FindStringSubmatch(strings.Join(cond, " "))
+	if m == nil {
+		return nil, fmt.Errorf("bad condition %q", strings.Join(cond, " "))
+	}
+	switch m[1] {
+	case "exists":
+		l.Exists = true
+	case "~exists":
+		l.Exists = false
+	default:
+		return nil, fmt.Errorf("%s conditions are not supported", m[1])
+	}
+	if strings.Contains(m[2], `\/`) {
+		return nil, fmt.Errorf("disjunctive conditions are not supported")
+	}
+	for _, term := range strings.Split(m[2], `/\`) {
+		term = strings.Join(strings.Fields(strings.Trim(strings.TrimSpace(term), "()")), "")
+		if term == "" || term == "true" {
+			continue
+		}
+		cm := litmusCondEq.FindStringSubmatch(term)
+		if cm == nil {
+			return nil, fmt.Errorf("unsupported condition %q", term)
+		}
+		id, ok := loadReg[canonLitmusReg(arch, cm[1]+":"+cm[2])]
+		if !ok {
+			return nil, fmt.Errorf("condition %q is not on the result of a load", term)
+		}
+		switch cm[3] {
+		case "0":
+		case "1":
+			l.Cond |= 1 << id
+		default:
+			return nil, fmt.Errorf("condition %q: loads can only return 0 or 1", term)
+		}
+		l.Mask |= 1 << id
+	}
+	return l, nil
+}
+
+// canonLitmusReg canonicalizes a "tid:reg" register name. On AArch64,
+// Wn is the low half of Xn, so both are named Xn.
+func canonLitmusReg(arch, reg string) string {
+	if arch == "AArch64" {
+		if i := strings.Index(reg, ":"); i >= 0 && strings.HasPrefix(reg[i+1:], "W") {
+			reg = reg[:i+1] + "X" + reg[i+2:]
+		}
+	}
+	return reg
+}
+
+type litmusInsnKind int
+
+const (
+	litmusInsnMove litmusInsnKind = iota
+	litmusInsnLoad
+	litmusInsnStore
+)
+
+// A litmusInsn is a parsed litmus test instruction.
+type litmusInsn struct {
+	kind litmusInsnKind
+
+	// reg is the destination register of a move or load.
+	reg string
+
+	// The location accessed by a load or store is either loc or
+	// the address in register addrReg.
+	loc, addrReg string
+
+	// The value of a move or store is either val or the value in
+	// register valReg.
+	val, valReg string
+}
+
+var (
+	x86Store = regexp.MustCompile(`^(?i:MOV)\s*\[(\w+)\]\s*,\s*\$([0-9]+)$`)
+	x86Load  = regexp.MustCompile(`^(?i:MOV)\s*(\w+)\s*,\s*\[(\w+)\]$`)
+
+	arm64Move  = regexp.MustCompile(`^MOV\s+([WX][0-9]+)\s*,\s*#([0-9]+)$`)
+	arm64Load  = regexp.MustCompile(`^LDR\s+([WX][0-9]+)\s*,\s*\[(X[0-9]+)\]$`)
+	arm64Store = regexp.MustCompile(`^STR\s+([WX][0-9]+)\s*,\s*\[(X[0-9]+)\]$`)
+
+	ppcMove  = regexp.MustCompile(`^li\s+(r[0-9]+)\s*,\s*([0-9]+)$`)
+	ppcLoad  = regexp.MustCompile(`^lwz\s+(r[0-9]+)\s*,\s*0\((r[0-9]+)\)$`)
+	ppcStore = regexp.MustCompile(`^stw\s+(r[0-9]+)\s*,\s*0\((r[0-9]+)\)$`)
+)
+
+// parseLitmusInsn parses a single instruction of a litmus test for
+// arch.
+func parseLitmusInsn(arch, insn string) (litmusInsn, error) {
+	insn = strings.TrimSpace(insn)
+	var m []string
+	switch arch {
+	case "X86":
+		if m = x86Store.FindStringSubmatch(insn); m != nil {
+			return litmusInsn{kind: litmusInsnStore, loc: m[1], val: m[2]}, nil
+		}
+		if m = x86Load.FindStringSubmatch(insn); m != nil {
+			return litmusInsn{kind: litmusInsnLoad, reg: m[1], loc: m[2]}, nil
+		}
+	case "AArch64":
+		if m = arm64Move.FindStringSubmatch(insn); m != nil {
+			return litmusInsn{kind: litmusInsnMove, reg: m[1], val: m[2]}, nil
+		}
+		if m = arm64Load.FindStringSubmatch(insn); m != nil {
+			return litmusInsn{kind: litmusInsnLoad, reg: m[1], addrReg: m[2]}, nil
+		}
+		if m = arm64Store.FindStringSubmatch(insn); m != nil {
+			return litmusInsn{kind: litmusInsnStore, valReg: m[1], addrReg: m[2]}, nil
+		}
+	case "PPC":
+		if m = ppcMove.FindStringSubmatch(insn); m != nil {
+			return litmusInsn{kind: litmusInsnMove, reg: m[1], val: m[2]}, nil
+		}
+		if m = ppcLoad.FindStringSubmatch(insn); m != nil {
+			return litmusInsn{kind: litmusInsnLoad, reg: m[1], addrReg: m[2]}, nil
+		}
+		if m = ppcStore.FindStringSubmatch(insn); m != nil {
+			return litmusInsn{kind: litmusInsnStore, valReg: m[1], addrReg: m[2]}, nil
+		}
+	}
+	return litmusInsn{}, fmt.Errorf("unsupported %s instruction %q", arch, insn)
+}
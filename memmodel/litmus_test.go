@@ -0,0 +1,210 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func st(v byte) Op     { return Op{Type: OpStore, Var: v} }
+func ld(v, id byte) Op { return Op{Type: OpLoad, Var: v, ID: id} }
+
+func mkProg(threads ...[]Op) Prog {
+	var p Prog
+	for tid, ops := range threads {
+		for i, op := range ops {
+			p.Threads[tid].Ops[i] = op
+			if op.Type == OpLoad {
+				p.NumLoads++
+			}
+		}
+	}
+	return p
+}
+
+// These tests are in the form herd7 and diy7 write them.
+var litmusTests = []struct {
+	src  string
+	want Litmus
+}{
+	{`X86 SB
+"Fre PodWR Fre PodWR"
+{ x=0; y=0; }
+ P0          | P1          ;
+ MOV [x],$1  | MOV [y],$1  ;
+ MOV EAX,[y] | MOV EAX,[x] ;
+exists (0:EAX=0 /\ 1:EAX=0)
+`, Litmus{
+		Name:   "SB",
+		Prog:   mkProg([]Op{st(0), ld(1, 0)}, []Op{st(1), ld(0, 1)}),
+		Exists: true, Cond: 0, Mask: 3,
+	}},
+
+	{`AArch64 MP
+"PodWW Rfe PodRR Fre"
+Generator=diyone7 (version 7.47+3)
+Prefetch=0:x=F,0:y=W,1:y=F,1:x=T
+Com=Rf Fr
+Orig=PodWW Rfe PodRR Fre
+{
+0:X1=x; 0:X3=y;
+1:X1=y; 1:X3=x;
+}
+ P0          | P1          ;
+ MOV W0,#1   | LDR W0,[X1] ;
+ STR W0,[X1] | LDR W2,[X3] ;
+ MOV W2,#1   |             ;
+ STR W2,[X3] |             ;
+exists (1:X0=1 /\ 1:X2=0)
+`, Litmus{
+		Name:   "MP",
+		Prog:   mkProg([]Op{st(0), st(1)}, []Op{ld(1, 0), ld(0, 1)}),
+		Exists: true, Cond: 1, Mask: 3,
+	}},
+
+	{`PPC LB
+"PodRW Rfe PodRW Rfe"
+Cycle=Rfe PodRW Rfe PodRW
+(* A comment. *)
+{
+0:r2=x; 0:r4=y;
+1:r2=y; 1:r4=x;
+}
+ P0           | P1           ;
+ lwz r1,0(r2) | lwz r1,0(r2) ;
+ li r3,1      | li r3,1      ;
+ stw r3,0(r4) | stw r3,0(r4) ;
+exists (0:r1=1 /\ 1:r1=1)
+`, Litmus{
+		Name:   "LB",
+		Prog:   mkProg([]Op{ld(0, 0), st(1)}, []Op{ld(1, 1), st(0)}),
+		Exists: true, Cond: 3, Mask: 3,
+	}},
+
+	// IRIW needs four threads, so use WRC to test multi-copy
+	// atomicity.
+	{`AArch64 WRC
+"Rfe PodRW Rfe PodRR Fre"
+{
+0:X1=x;
+1:X1=x; 1:X3=y;
+2:X1=y; 2:X3=x;
+}
+ P0          | P1          | P2          ;
+ MOV W0,#1   | LDR W0,[X1] | LDR W0,[X1] ;
+ STR W0,[X1] | MOV W2,#1   | LDR W2,[X3] ;
+             | STR W2,[X3] |             ;
+~exists (1:X0=1 /\ 2:X0=1 /\ 2:X2=0)
+`, Litmus{
+		Name:   "WRC",
+		Prog:   mkProg([]Op{st(0)}, []Op{ld(0, 0), st(1)}, []Op{ld(1, 1), ld(0, 2)}),
+		Exists: false, Cond: 3, Mask: 7,
+	}},
+}
+
+func TestParseLitmus(t *testing.T) {
+	for _, test := range litmusTests {
+		l, err := ParseLitmus(strings.NewReader(test.src))
+		if err != nil {
+			t.Errorf("%s: %v", test.want.Name, err)
+			continue
+		}
+		if *l != test.want {
+			t.Errorf("%s: got\n%s\n%s\nwant\n%s\n%s", test.want.Name, &l.Prog, l.CondString(), &test.want.Prog, test.want.CondString())
+		}
+	}
+}
+
+func TestLitmusRoundTrip(t *testing.T) {
+	for _, test := range litmusTests {
+		for _, arch := range LitmusArchs {
+			var buf bytes.Buffer
+			if err := test.want.WriteLitmus(&buf, arch); err != nil {
+				t.Errorf("%s/%s: %v", test.want.Name, arch, err)
+				continue
+			}
+			l, err := ParseLitmus(&buf)
+			if err != nil {
+				t.Errorf("%s/%s: %v", test.want.Name, arch, err)
+				continue
+			}
+			if *l != test.want {
+				t.Errorf("%s/%s: got\n%s\n%s\nwant\n%s\n%s", test.want.Name, arch, &l.Prog, l.CondString(), &test.want.Prog, test.want.CondString())
+			}
+		}
+	}
+}
+
+func TestLitmusOk(t *testing.T) {
+	sb := &litmusTests[0].want
+	var outcomes OutcomeSet
+	SCModel{}.Eval(&sb.Prog, &outcomes)
+	if sb.Ok(&outcomes) {
+		t.Errorf("SB is Ok under SC")
+	}
+	TSOModel{}.Eval(&sb.Prog, &outcomes)
+	if !sb.Ok(&outcomes) {
+		t.Errorf("SB is not Ok under TSO")
+	}
+}
+
+func TestParseLitmusErrors(t *testing.T) {
+	const sb = `X86 SB
+{ x=0; y=0; }
+ P0          | P1          ;
+ MOV [x],$1  | MOV [y],$1  ;
+ MOV EAX,[y] | MOV EAX,[x] ;
+exists (0:EAX=0 /\ 1:EAX=0)
+`
+	for _, test := range []struct {
+		name, src, err string
+	}{
+		{"empty", "", "missing litmus header"},
+		{"arch", strings.Replace(sb, "X86", "RISCV", 1), `unsupported litmus architecture "RISCV"`},
+		{"no init", strings.Replace(sb, "{ x=0; y=0; }", "", 1), "missing initial state"},
+		{"bad init", strings.Replace(sb, "y=0;", "y;", 1), `bad initial state "y"`},
+		{"init", strings.Replace(sb, "y=0", "y=1", 1), "initial value of y must be 0"},
+		{"no prog", "X86 SB\n{ }\nexists (0:EAX=0)\n", "missing program"},
+		{"header", strings.Replace(sb, "P1 ", "P2 ", 1), `bad thread header "P2"`},
+		{"columns", strings.Replace(sb, " | MOV EAX,[x] ;", " ;", 1), "has 1 columns, want 2"},
+		{"insn", strings.Replace(sb, "MOV EAX,[y]", "MFENCE", 1), `unsupported X86 instruction "MFENCE"`},
+		{"store 2", strings.Replace(sb, "MOV [x],$1", "MOV [x],$2", 1), "only stores of 1 are supported"},
+		{"store twice", strings.Replace(sb, "MOV [y],$1", "MOV [x],$1", 1), "x is stored more than once"},
+		{"no cond", strings.Replace(sb, "exists (0:EAX=0 /\\ 1:EAX=0)\n", "", 1), "missing condition"},
+		{"filter", strings.Replace(sb, "exists", "filter (0:EAX=0)\nexists", 1), "filter conditions are not supported"},
+		{"forall", strings.Replace(sb, "exists", "forall", 1), "forall conditions are not supported"},
+		{"disjunction", strings.Replace(sb, `/\`, `\/`, 1), "disjunctive conditions are not supported"},
+		{"cond mem", strings.Replace(sb, "1:EAX=0", "x=1", 1), `unsupported condition "x=1"`},
+		{"cond reg", strings.Replace(sb, "1:EAX=0", "1:EBX=0", 1), `condition "1:EBX=0" is not on the result of a load`},
+		{"cond value", strings.Replace(sb, "1:EAX=0", "1:EAX=2", 1), "loads can only return 0 or 1"},
+		{"addr", `AArch64 A
+{ 0:X1=x; }
+ P0          ;
+ LDR W0,[X3] ;
+exists (0:X0=0)
+`, "register X3 does not hold an address"},
+		{"IRIW", `AArch64 IRIW
+{
+0:X1=x;
+1:X1=y;
+2:X1=x; 2:X3=y;
+3:X1=y; 3:X3=x;
+}
+ P0          | P1          | P2          | P3          ;
+ MOV W0,#1   | MOV W0,#1   | LDR W0,[X1] | LDR W0,[X1] ;
+ STR W0,[X1] | STR W0,[X1] | LDR W2,[X3] | LDR W2,[X3] ;
+exists (2:X0=1 /\ 2:X2=0 /\ 3:X0=1 /\ 3:X2=0)
+`, "too many threads (4 > 3)"},
+	} {
+		_, err := ParseLitmus(strings.NewReader(test.src))
+		if err == nil {
+			t.Errorf("%s: want error containing %q, got success", test.name, test.err)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: want error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -28,11 +28,45 @@
 // the outcomes allowed by all of the models. This is mostly useful
 // for debugging.
 //
+// With -minimize, it reduces each example to a program from which no
+// operation can be removed without the models agreeing. With
+// -witness, it also shows how the weaker model produces the outcome
+// the stronger model forbids: an execution of the weaker model's
+// abstract machine, with each thread's events in a column and each
+// event that relies on a relaxation of sequential consistency
+// annotated with that relaxation, such as a load passing a buffered
+// store or a store that is visible to some threads but not others.
+// The witness relies on as few relaxations as possible and omits
+// events that don't affect the outcome. Only operational models
+// produce witnesses.
+//
+// With -export-litmus, it writes each example where models differ as
+// a litmus test in the .litmus format of the herd7 tool, so the
+// models can be cross-checked against herd7's. The condition of each
+// test is an outcome the weaker model permits and the stronger model
+// does not. -litmus-arch selects the architecture of the written
+// tests: X86, AArch64, or PPC.
+//
+//
+// Litmus tests
+//
+// With -litmus, memmodel runs the .litmus tests named on the command
+// line under each model rather than generating programs, and reports
+// whether each model validates each test's condition, like herd7's
+// "Ok" and "No". With -witness, it also shows the execution of each
+// operational model that satisfies the condition, if any. This
+// supports the subset of the .litmus format that memmodel's programs
+// can express: X86, AArch64, and PPC tests of up to three threads of
+// plain loads and stores, where each variable is stored at most once
+// and only the value 1 is stored, and whose condition is a
+// conjunction of the results of loads.
+//
 //
 // Supported memory models
 //
 // memmodel supports strict consistency (SC), x86-style total store
-// order (TSO), acquire/release, and unordered memory models.
+// order (TSO), ARMv8, POWER, acquire/release, and unordered memory
+// models.
 //
 // Some of these memory models have two different, but equivalent
 // specification strategies. Any model followed by "(HB)" is specified
@@ -46,6 +80,20 @@
 //
 // Likewise, some models have options. The operational implementation
 // of TSO supports optional memory fences around loads and stores.
+// ARMv8 supports making loads and stores acquires and releases, as
+// Go's sync/atomic does on arm64. POWER supports lwsync or sync
+// barriers between all operations.
+//
+// ARMv8 and POWER are implemented by a shared operational machine in
+// which each thread executes its operations out of order and stores
+// propagate to each thread separately. ARMv8 stores propagate to all
+// threads at once (it is multi-copy atomic), while POWER stores may
+// become visible to different threads at different times. Since
+// programs have no dependencies or barriers other than those added by
+// these options, plain ARMv8 and POWER turn out to be equivalent.
+// Likewise, with at most three threads, POWER with lwsync is
+// equivalent to TSO; telling them apart takes a fourth thread, as in
+// the IRIW litmus test.
 //
 //
 // How it works
@@ -62,7 +110,10 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 )
 
@@ -76,6 +127,11 @@ var models = []Model{
 	TSOModel{},
 	TSOModel{StoreMFence: true},
 	TSOModel{MFenceLoad: true},
+	ARMModel{},
+	ARMModel{Acquire: true, Release: true},
+	POWERModel{},
+	POWERModel{LwSync: true},
+	POWERModel{Sync: true},
 	HBModel{HBSC{}},
 	HBModel{HBTSO{}},
 	HBModel{HBAcqRel{}},
@@ -93,6 +149,35 @@ func (c *Counterexample) Print(w io.Writer) {
 	printOutcomeTable(w, []string{c.weaker.String(), c.stronger.String()}, []OutcomeSet{c.wset, c.sset})
 }
 
+// Litmus returns a litmus test whose condition is an outcome that
+// c.weaker permits and c.stronger does not.
+func (c *Counterexample) Litmus() *Litmus {
+	name := litmusName.ReplaceAllString(c.weaker.String()+"-not-"+c.stronger.String(), "_")
+	l := &Litmus{Name: name, Prog: c.p, Exists: true, Mask: 1<<uint(c.p.NumLoads) - 1}
+	found := false
+	for o := range c.wset.OutcomeIter() {
+		if !found && !c.sset.Has(o) {
+			l.Cond, found = o, true
+		}
+	}
+	return l
+}
+
+var litmusName = regexp.MustCompile(`[^A-Za-z0-9+.-]+`)
+
+// PrintWitness prints an execution of c's program under c.weaker that
+// produces an outcome c.stronger does not permit.
+func (c *Counterexample) PrintWitness(w io.Writer) {
+	l := c.Litmus()
+	wm, ok := c.weaker.(Witnesser)
+	if !ok {
+		fmt.Fprintf(w, "no witness: %s is not an operational model\n", c.weaker)
+		return
+	}
+	fmt.Fprintf(w, "witness of %s under %s:\n", l.CondString(), c.weaker)
+	printWitness(w, &c.p, wm.Witness(&c.p, l.Cond, l.Mask))
+}
+
 func main() {
 	flagGraph := flag.String("graph", "", "write model graph to `output` dot file")
 	flagNoSimplify := flag.Bool("no-simplify", false, "disable graph simplification")
@@ -105,11 +190,37 @@ func main() {
 	// disagree, order the columns from stronger to weaker,
 	// collapse equivalent models).
 	flagAllProgs := flag.Bool("all-progs", false, "show all programs and outcomes")
+	flagLitmus := flag.Bool("litmus", false, "run the .litmus tests given as arguments instead of generating programs")
+	flagExport := flag.String("export-litmus", "", "write a litmus test for each example where models differ to `dir`")
+	flagArch := flag.String("litmus-arch", "AArch64", "write litmus tests for `arch` (one of "+strings.Join(LitmusArchs, ", ")+")")
+	flagMinimize := flag.Bool("minimize", false, "minimize examples where models differ")
+	flagWitness := flag.Bool("witness", false, "show an execution witnessing each example or litmus test outcome")
 	flag.Parse()
+	if *flagLitmus {
+		if flag.NArg() == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if !runLitmus(flag.Args(), *flagWitness) {
+			os.Exit(1)
+		}
+		return
+	}
 	if flag.NArg() > 0 {
 		flag.Usage()
 		os.Exit(2)
 	}
+	if *flagExport != "" {
+		// Check the architecture before doing any work.
+		if err := new(Litmus).WriteLitmus(ioutil.Discard, *flagArch); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if err := os.MkdirAll(*flagExport, 0777); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 
 	// counterexamples[i][j] gives an example program where model
 	// i permits outcomes that model j does not.
@@ -160,11 +271,23 @@ func main() {
 						p, models[i], models[j],
 						outcomes[i], outcomes[j],
 					}
+					if *flagMinimize {
+						c = c.Minimize()
+					}
 					counterexamples[i][j] = c
 					if *flagExamples {
 						c.Print(os.Stdout)
+						if *flagWitness {
+							c.PrintWitness(os.Stdout)
+						}
 						fmt.Println()
 					}
+					if *flagExport != "" {
+						if err := exportLitmus(*flagExport, *flagArch, c.Litmus()); err != nil {
+							fmt.Fprintln(os.Stderr, err)
+							os.Exit(1)
+						}
+					}
 				}
 				// TODO: Prefer smaller
 				// counterexamples.
@@ -198,6 +321,75 @@ func main() {
 	}
 }
 
+// runLitmus runs the litmus tests in paths under each model and prints
+// whether each model validates each test's condition. If witness is
+// set, it also prints how each operational model produces an outcome
+// satisfying the condition. It returns false if any test could not be
+// read.
+func runLitmus(paths []string, witness bool) bool {
+	ok := true
+	outcomes := make([]OutcomeSet, len(models))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			ok = false
+			continue
+		}
+		l, err := ParseLitmus(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			ok = false
+			continue
+		}
+
+		fmt.Printf("%s: %s\n%s\n%s\n", path, l.Name, &l.Prog, l.CondString())
+		width := 0
+		for _, model := range models {
+			if len(model.String()) > width {
+				width = len(model.String())
+			}
+		}
+		for i, model := range models {
+			model.Eval(&l.Prog, &outcomes[i])
+			result := "No"
+			if l.Ok(&outcomes[i]) {
+				result = "Ok"
+			}
+			fmt.Printf("%-*s  %s\n", width, model, result)
+		}
+		fmt.Println()
+
+		if !witness {
+			continue
+		}
+		for i, model := range models {
+			wm, ok := model.(Witnesser)
+			if !ok || !l.Observed(&outcomes[i]) {
+				continue
+			}
+			fmt.Printf("witness under %s:\n", model)
+			printWitness(os.Stdout, &l.Prog, wm.Witness(&l.Prog, l.Cond, l.Mask))
+			fmt.Println()
+		}
+	}
+	return ok
+}
+
+// exportLitmus writes l to dir as a litmus test for arch.
+func exportLitmus(dir, arch string, l *Litmus) error {
+	f, err := os.Create(filepath.Join(dir, l.Name+".litmus"))
+	if err != nil {
+		return err
+	}
+	if err := l.WriteLitmus(f, arch); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func writeModelGraph(w io.Writer, counterexamples [][]*Counterexample, simplify bool) {
 	fmt.Fprintln(w, "digraph memmodel {")
 	if simplify {
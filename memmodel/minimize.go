@@ -0,0 +1,75 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// Minimize returns a counterexample with the same models as c, but
+// with as few operations as possible. It repeatedly removes single
+// operations from c's program as long as the weaker model still
+// permits an outcome of the remaining loads that the stronger model
+// does not. The result is 1-minimal: removing any one operation makes
+// the models agree.
+func (c *Counterexample) Minimize() *Counterexample {
+	l := c.Litmus()
+	var wset, sset OutcomeSet
+	distinguishes := func(l *Litmus) bool {
+		c.weaker.Eval(&l.Prog, &wset)
+		c.stronger.Eval(&l.Prog, &sset)
+		return l.Observed(&wset) && !l.Observed(&sset)
+	}
+
+retry:
+	for tid := range l.Prog.Threads {
+		for i, op := range l.Prog.Threads[tid].Ops[:MaxOps] {
+			if op.Type == OpExit {
+				break
+			}
+			if l2 := l.without(tid, i); distinguishes(l2) {
+				l = l2
+				goto retry
+			}
+		}
+	}
+
+	c2 := &Counterexample{p: l.Prog, weaker: c.weaker, stronger: c.stronger}
+	c.weaker.Eval(&c2.p, &c2.wset)
+	c.stronger.Eval(&c2.p, &c2.sset)
+	return c2
+}
+
+// without returns a copy of l with operation i of thread tid removed.
+// If this leaves the thread empty, later threads move down. Loads are
+// renumbered in program order and the condition on the removed load,
+// if any, is dropped.
+func (l *Litmus) without(tid, i int) *Litmus {
+	l2 := &Litmus{Name: l.Name, Exists: l.Exists}
+	p, p2 := &l.Prog, &l2.Prog
+	tid2 := 0
+	for t := range p.Threads {
+		i2 := 0
+		for j, op := range p.Threads[t].Ops[:MaxOps] {
+			if op.Type == OpExit {
+				break
+			}
+			if t == tid && j == i {
+				continue
+			}
+			if op.Type == OpLoad {
+				id := byte(p2.NumLoads)
+				p2.NumLoads++
+				if l.Mask&(1<<op.ID) != 0 {
+					l2.Mask |= 1 << id
+					l2.Cond |= (l.Cond >> op.ID & 1) << id
+				}
+				op.ID = id
+			}
+			p2.Threads[tid2].Ops[i2] = op
+			i2++
+		}
+		if i2 > 0 {
+			tid2++
+		}
+	}
+	return l2
+}
@@ -0,0 +1,55 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// POWERModel models all loads and stores as POWER operations, possibly
+// with barriers between them. Unlike TSO and ARMv8, POWER is not
+// multi-copy atomic: a store may become visible to different threads
+// at different times. Each thread may execute its loads and stores
+// out of order, except for loads and stores of the same variable.
+type POWERModel struct {
+	// LwSync, if true, adds an lwsync between all operations.
+	// This orders all operations except a store followed by a
+	// load.
+	LwSync bool
+
+	// Sync, if true, adds a sync between all operations.
+	Sync bool
+}
+
+func (m POWERModel) String() string {
+	s := "POWER"
+	if m.LwSync {
+		s += "+lwsync"
+	}
+	if m.Sync {
+		s += "+sync"
+	}
+	return s
+}
+
+func (m POWERModel) Eval(p *Prog, outcomes *OutcomeSet) {
+	evalRelaxed(m, p, outcomes)
+}
+
+func (m POWERModel) Witness(p *Prog, cond, mask Outcome) []Event {
+	return witnessRelaxed(m, p, cond, mask)
+}
+
+func (m POWERModel) ordered(earlier, later Op) bool {
+	// Both barriers order execution. lwsync allows a load to
+	// be satisfied before an earlier store has propagated to
+	// other threads, but this is modeled by propagation, not
+	// by execution order.
+	return earlier.Var == later.Var || m.LwSync || m.Sync
+}
+
+func (POWERModel) multiCopyAtomic() bool {
+	return false
+}
+
+func (m POWERModel) barrier() (cumulative, full bool) {
+	return m.LwSync || m.Sync, m.Sync
+}
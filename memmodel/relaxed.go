@@ -0,0 +1,320 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// relaxedMachine is an operational machine for memory models that
+// execute each thread's instructions out of order and that may not be
+// multi-copy atomic, such as ARMv8 and POWER. It is loosely based on
+// the abstract machine of Sarkar, et al., "Understanding POWER
+// Multiprocessors", PLDI 2011.
+//
+// Each thread executes its instructions in any order allowed by the
+// model's ordering constraints. Each thread has its own view of
+// memory. A load reads from its thread's view. A store updates its
+// thread's view when it executes, and is then propagated to the views
+// of other threads one at a time, unless the model is multi-copy
+// atomic, in which case it updates all views at once.
+//
+// Barriers are modeled as if there were one between every pair of
+// instructions in a thread. A barrier commits once the instruction
+// before it executes, and the stores visible to the thread at that
+// point are the barrier's "group A". Because barriers are cumulative,
+// a store after a barrier cannot propagate to a thread until the
+// barrier's group A has propagated to that thread.
+type relaxedMachine interface {
+	// ordered returns whether instruction later must wait for
+	// instruction earlier to execute, where earlier precedes
+	// later in program order.
+	ordered(earlier, later Op) bool
+
+	// multiCopyAtomic returns whether stores become visible to
+	// all threads at once.
+	multiCopyAtomic() bool
+
+	// barrier returns whether there are cumulative barriers
+	// between instructions, and whether they are full barriers.
+	// An instruction after a full barrier cannot execute until
+	// the barrier's group A has propagated to all threads.
+	barrier() (cumulative, full bool)
+}
+
+// evalRelaxed evaluates p on machine m.
+func evalRelaxed(m relaxedMachine, p *Prog, outcomes *OutcomeSet) {
+	outcomes.Reset(p)
+	newRelaxedGlobal(m, p, outcomes).rec(relaxedState{})
+}
+
+// witnessRelaxed returns the best witness on machine m of an outcome
+// of p satisfying cond and mask. See Witnesser.
+func witnessRelaxed(m relaxedMachine, p *Prog, cond, mask Outcome) []Event {
+	var outcomes OutcomeSet
+	outcomes.Reset(p)
+	g := newRelaxedGlobal(m, p, &outcomes)
+	g.w = &witnessSearch{cond: cond, mask: mask}
+	g.witnessVisited = make(map[relaxedState]witnessCost)
+	g.rec(relaxedState{})
+	return g.w.witness()
+}
+
+func newRelaxedGlobal(m relaxedMachine, p *Prog, outcomes *OutcomeSet) *relaxedGlobal {
+	g := &relaxedGlobal{p: p, outcomes: outcomes, m: m, visited: make(map[relaxedState]bool)}
+	g.mca = m.multiCopyAtomic()
+	g.cumulative, g.full = m.barrier()
+	for tid := range p.Threads {
+		for i, op := range p.Threads[tid].Ops[:MaxOps] {
+			if op.Type == OpExit {
+				break
+			}
+			g.done[tid] |= 1 << uint(i)
+			g.nthreads = tid + 1
+			if op.Type == OpStore {
+				g.writer[op.Var] = tid
+			}
+		}
+	}
+	return g
+}
+
+// relaxedGlobal stores state that is global to a relaxed evaluation.
+type relaxedGlobal struct {
+	p        *Prog
+	outcomes *OutcomeSet
+	m        relaxedMachine
+
+	mca, cumulative, full bool
+
+	// done is the executed mask of each thread once all of its
+	// instructions have executed.
+	done     [MaxThreads]uint8
+	nthreads int
+
+	// writer is the thread that stores each variable.
+	writer [MaxVar]int
+
+	// visited records the states already explored. Many orders
+	// of propagation lead to the same state, so this prunes the
+	// search enormously.
+	visited map[relaxedState]bool
+
+	// w, if non-nil, records the best witness of an outcome. In
+	// this case, a state reached again may lead to a better
+	// witness, so witnessVisited records the cost of the best
+	// execution that reached each state instead of visited.
+	w              *witnessSearch
+	witnessVisited map[relaxedState]witnessCost
+}
+
+// relaxedState stores the state of a program at a single point during
+// execution.
+type relaxedState struct {
+	// executed is a bit mask of the executed instructions of
+	// each thread.
+	executed [MaxThreads]uint8
+
+	// view is each thread's view of memory.
+	view [MaxThreads]MemState
+
+	// fence is the group A of the most recent barrier committed
+	// by each thread.
+	fence [MaxThreads]MemState
+
+	// Since each variable is stored exactly once, store state is
+	// indexed by variable.
+	//
+	// propagated is the bit mask of threads each store has
+	// propagated to. groupA is the set of stores that must
+	// propagate to a thread before each store can.
+	propagated [MaxVar]uint8
+	groupA     [MaxVar]MemState
+
+	outcome Outcome
+}
+
+func (g *relaxedGlobal) rec(s relaxedState) {
+	if g.w != nil {
+		cost := g.w.cost()
+		if prev, ok := g.witnessVisited[s]; ok && !cost.less(prev) {
+			return
+		}
+		g.witnessVisited[s] = cost
+	} else {
+		if g.visited[s] {
+			return
+		}
+		g.visited[s] = true
+	}
+
+	// Pick an instruction to execute next.
+	any := false
+	for tid := 0; tid < g.nthreads; tid++ {
+		if s.executed[tid] == g.done[tid] {
+			continue
+		}
+		any = true
+		for i := 0; i < MaxOps; i++ {
+			if !g.canExec(&s, tid, i) {
+				continue
+			}
+			ns := s
+			op := g.p.Threads[tid].Ops[i]
+			var ev Event
+			switch op.Type {
+			case OpLoad:
+				_, opres := op.Exec(ns.view[tid])
+				ns.outcome |= Outcome(opres) << op.ID
+				if g.w != nil {
+					ev = g.loadEvent(&s, tid, i, opres)
+				}
+			case OpStore:
+				if g.mca {
+					for u := 0; u < g.nthreads; u++ {
+						ns.view[u], _ = op.Exec(ns.view[u])
+					}
+					ns.propagated[op.Var] = 1<<uint(g.nthreads) - 1
+				} else {
+					ns.view[tid], _ = op.Exec(ns.view[tid])
+					ns.propagated[op.Var] = 1 << uint(tid)
+				}
+				if g.cumulative {
+					ns.groupA[op.Var] = ns.fence[tid]
+				}
+				if g.w != nil {
+					ev = Event{TID: tid, Desc: op.String()}
+					if r := g.reorderRule(&s, tid, i); r != "" {
+						ev.Rules = []string{r}
+					}
+				}
+			}
+			ns.executed[tid] |= 1 << uint(i)
+			if g.cumulative {
+				// Commit the barrier after this
+				// instruction.
+				ns.fence[tid] = ns.view[tid]
+			}
+			if g.w == nil || g.w.push(ev) {
+				g.rec(ns)
+				g.w.pop()
+			}
+		}
+	}
+	if !any {
+		// This execution is done. We don't care if there are
+		// stores left to propagate.
+		g.outcomes.Add(s.outcome)
+		if g.w != nil {
+			g.w.done(s.outcome)
+		}
+		return
+	}
+
+	if g.mca {
+		return
+	}
+	// Pick a store to propagate to another thread.
+	for v := 0; v < MaxVar; v++ {
+		if s.propagated[v] == 0 {
+			// Not executed.
+			continue
+		}
+		for u := 0; u < g.nthreads; u++ {
+			if s.propagated[v]&(1<<uint(u)) != 0 || !g.propagatedTo(&s, s.groupA[v], u) {
+				continue
+			}
+			ns := s
+			ns.propagated[v] |= 1 << uint(u)
+			ns.view[u] |= 1 << uint(v)
+			if g.w == nil || g.w.push(Event{TID: u, Desc: "sees " + Op{Type: OpStore, Var: byte(v)}.String()}) {
+				g.rec(ns)
+				g.w.pop()
+			}
+		}
+	}
+}
+
+// canExec returns whether instruction i of thread tid can execute in
+// state s.
+func (g *relaxedGlobal) canExec(s *relaxedState, tid, i int) bool {
+	ops := &g.p.Threads[tid].Ops
+	if s.executed[tid]&(1<<uint(i)) != 0 || ops[i].Type == OpExit {
+		return false
+	}
+	for j := 0; j < i; j++ {
+		if s.executed[tid]&(1<<uint(j)) == 0 && g.m.ordered(ops[j], ops[i]) {
+			return false
+		}
+	}
+	if g.full {
+		// Wait for the preceding barrier's group A to
+		// propagate everywhere.
+		for u := 0; u < g.nthreads; u++ {
+			if !g.propagatedTo(s, s.fence[tid], u) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// propagatedTo returns whether all stores in set have propagated to
+// thread u in state s.
+func (g *relaxedGlobal) propagatedTo(s *relaxedState, set MemState, u int) bool {
+	for v := 0; set != 0; v, set = v+1, set>>1 {
+		if set&1 != 0 && s.propagated[v]&(1<<uint(u)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// loadEvent returns the witness event for instruction i of thread
+// tid, a load that returned res in state s.
+func (g *relaxedGlobal) loadEvent(s *relaxedState, tid, i, res int) Event {
+	op := g.p.Threads[tid].Ops[i]
+	ev := Event{TID: tid, Desc: opDesc(op, res)}
+	if r := g.reorderRule(s, tid, i); r != "" {
+		ev.Rules = append(ev.Rules, r)
+	}
+	if prop := s.propagated[op.Var]; res == 0 && prop != 0 {
+		// The store executed, but hasn't propagated to this
+		// thread.
+		st := Op{Type: OpStore, Var: op.Var}
+		if prop&^(1<<uint(g.writer[op.Var])) != 0 {
+			var seen []string
+			for u := 0; u < g.nthreads; u++ {
+				if prop&(1<<uint(u)) != 0 {
+					seen = append(seen, fmt.Sprintf("T%d", u))
+				}
+			}
+			ev.Rules = append(ev.Rules, fmt.Sprintf("not multi-copy atomic: %s is visible to %s but not T%d", st, strings.Join(seen, ", "), tid))
+		} else {
+			ev.Rules = append(ev.Rules, fmt.Sprintf("%s has not propagated from T%d", st, g.writer[op.Var]))
+		}
+	}
+	return ev
+}
+
+// reorderRule returns the relaxation that allows instruction i of
+// thread tid to execute in state s before earlier instructions, or
+// "" if all earlier instructions have executed.
+func (g *relaxedGlobal) reorderRule(s *relaxedState, tid, i int) string {
+	ops := &g.p.Threads[tid].Ops
+	kind := func(op Op) string {
+		if op.Type == OpStore {
+			return "W"
+		}
+		return "R"
+	}
+	for j := 0; j < i; j++ {
+		if s.executed[tid]&(1<<uint(j)) == 0 {
+			return fmt.Sprintf("%s->%s: executes before %s", kind(ops[j]), kind(ops[i]), ops[j])
+		}
+	}
+	return ""
+}
@@ -19,13 +19,24 @@ func (SCModel) Eval(p *Prog, outcomes *OutcomeSet) {
 	// each load instruction, and at the end of each execution
 	// record the outcome.
 	outcomes.Reset(p)
-	(&scGlobal{p, outcomes}).rec(scState{})
+	(&scGlobal{p: p, outcomes: outcomes}).rec(scState{})
+}
+
+func (SCModel) Witness(p *Prog, cond, mask Outcome) []Event {
+	var outcomes OutcomeSet
+	outcomes.Reset(p)
+	w := &witnessSearch{cond: cond, mask: mask}
+	(&scGlobal{p: p, outcomes: &outcomes, w: w}).rec(scState{})
+	return w.witness()
 }
 
 // scGlobal stores state that is global to an SC evaluation.
 type scGlobal struct {
 	p        *Prog
 	outcomes *OutcomeSet
+
+	// w, if non-nil, records the best witness of an outcome.
+	w *witnessSearch
 }
 
 // scState stores the state of a program at a single point during
@@ -50,11 +61,17 @@ func (g *scGlobal) rec(s scState) {
 				ns.outcome |= Outcome(opres) << op.ID
 			}
 			ns.pcs[tid]++
-			g.rec(ns)
+			if g.w == nil || g.w.push(Event{TID: tid, Desc: opDesc(op, opres)}) {
+				g.rec(ns)
+				g.w.pop()
+			}
 		}
 	}
 	if !any {
 		// This execution is done.
 		g.outcomes.Add(s.outcome)
+		if g.w != nil {
+			g.w.done(s.outcome)
+		}
 	}
 }
@@ -33,7 +33,15 @@ func (m TSOModel) String() string {
 
 func (m TSOModel) Eval(p *Prog, outcomes *OutcomeSet) {
 	outcomes.Reset(p)
-	(&tsoGlobal{p, outcomes, &m}).rec(tsoState{})
+	(&tsoGlobal{p: p, outcomes: outcomes, model: &m}).rec(tsoState{})
+}
+
+func (m TSOModel) Witness(p *Prog, cond, mask Outcome) []Event {
+	var outcomes OutcomeSet
+	outcomes.Reset(p)
+	w := &witnessSearch{cond: cond, mask: mask}
+	(&tsoGlobal{p: p, outcomes: &outcomes, model: &m, w: w}).rec(tsoState{})
+	return w.witness()
 }
 
 // tsoGlobal stores state that is global to a TSO evaluation.
@@ -41,6 +49,9 @@ type tsoGlobal struct {
 	p        *Prog
 	outcomes *OutcomeSet
 	model    *TSOModel
+
+	// w, if non-nil, records the best witness of an outcome.
+	w *witnessSearch
 }
 
 // tsoState stores the state of a program at a single point during
@@ -68,6 +79,7 @@ func (g *tsoGlobal) rec(s tsoState) {
 			any = true
 			ns := s
 			sb := &ns.sb[tid]
+			var ev Event
 			switch op.Type {
 			case OpLoad:
 				if g.model.MFenceLoad {
@@ -81,6 +93,17 @@ func (g *tsoGlobal) rec(s tsoState) {
 				// forwarding.
 				_, opres = op.Exec(ns.mem | sb.overlay)
 				ns.outcome |= Outcome(opres) << op.ID
+				if g.w != nil {
+					ev = Event{TID: tid, Desc: opDesc(op, opres)}
+					if g.model.MFenceLoad {
+						ev.Desc = "MFENCE; " + ev.Desc
+					}
+					if _, global := op.Exec(ns.mem); opres != global {
+						ev.Rules = []string{"store forwarding from buffered " + Op{Type: OpStore, Var: op.Var}.String()}
+					} else if sb.h < sb.t {
+						ev.Rules = []string{"W->R: load passes buffered " + Op{Type: OpStore, Var: sb.buf[sb.h]}.String()}
+					}
+				}
 			case OpStore:
 				// Write to the store buffer.
 				sb.overlay, _ = op.Exec(sb.overlay)
@@ -92,15 +115,27 @@ func (g *tsoGlobal) rec(s tsoState) {
 					ns.mem |= sb.overlay
 					sb.h, sb.t = 0, 0
 				}
+				if g.w != nil {
+					ev = Event{TID: tid, Desc: op.String() + " (buffered)"}
+					if g.model.StoreMFence {
+						ev.Desc = op.String() + "; MFENCE"
+					}
+				}
 			}
 			ns.pcs[tid]++
-			g.rec(ns)
+			if g.w == nil || g.w.push(ev) {
+				g.rec(ns)
+				g.w.pop()
+			}
 		}
 	}
 	if !any {
 		// This execution is done. We don't care if there's
 		// stuff in the store buffers.
 		g.outcomes.Add(s.outcome)
+		if g.w != nil {
+			g.w.done(s.outcome)
+		}
 		return
 	}
 
@@ -111,7 +146,10 @@ func (g *tsoGlobal) rec(s tsoState) {
 			sb := &ns.sb[tid]
 			ns.mem |= MemState(1 << sb.buf[sb.h])
 			sb.h++
-			g.rec(ns)
+			if g.w == nil || g.w.push(Event{TID: tid, Desc: Op{Type: OpStore, Var: s.sb[tid].buf[s.sb[tid].h]}.String() + " reaches memory"}) {
+				g.rec(ns)
+				g.w.pop()
+			}
 		}
 	}
 }
@@ -0,0 +1,179 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Witnesser is a Model that can explain how it permits an outcome.
+// Operational models implement this by recording the steps of their
+// abstract machine.
+type Witnesser interface {
+	Model
+
+	// Witness returns an execution of p whose outcome o satisfies
+	// o&mask == cond, or nil if the model doesn't permit any
+	// such outcome. Of all such executions, it returns one that
+	// relies on the fewest relaxations of sequential consistency
+	// and, of those, one with the fewest events.
+	Witness(p *Prog, cond, mask Outcome) []Event
+}
+
+// An Event is a single step of an execution of a program.
+type Event struct {
+	// TID is the thread that performed the event or, for the
+	// propagation of a store, the thread it became visible to.
+	TID int
+
+	// Desc describes the event.
+	Desc string
+
+	// Rules describes the relaxations of sequential consistency
+	// this event relies on, if any.
+	Rules []string
+}
+
+// witnessSearch finds the best witness of an outcome during an
+// operational model's exhaustive search of executions. The model
+// pushes an event before taking each step and pops it after
+// exploring the rest of the execution.
+type witnessSearch struct {
+	cond, mask Outcome
+
+	// trace is the current execution and relax is the number of
+	// relaxations it relies on.
+	trace []Event
+	relax int
+
+	// best is the best witness found so far, if found is set.
+	best      []Event
+	bestRelax int
+	found     bool
+}
+
+// push adds ev to the current execution. If the execution can no
+// longer be better than the best witness, it returns false and
+// doesn't add ev, in which case the model should not take the step.
+func (w *witnessSearch) push(ev Event) bool {
+	relax := w.relax + len(ev.Rules)
+	if w.found && (relax > w.bestRelax || relax == w.bestRelax && len(w.trace)+1 >= len(w.best)) {
+		return false
+	}
+	w.trace = append(w.trace, ev)
+	w.relax = relax
+	return true
+}
+
+// pop removes the last event from the current execution. It may be
+// called on a nil *witnessSearch.
+func (w *witnessSearch) pop() {
+	if w == nil {
+		return
+	}
+	w.relax -= len(w.trace[len(w.trace)-1].Rules)
+	w.trace = w.trace[:len(w.trace)-1]
+}
+
+// done records the current execution if it's a witness of outcome o.
+// The pruning in push ensures any witness is better than the best
+// found so far.
+func (w *witnessSearch) done(o Outcome) {
+	if o&w.mask != w.cond {
+		return
+	}
+	w.best = append(w.best[:0], w.trace...)
+	w.bestRelax = w.relax
+	w.found = true
+}
+
+// witness returns the best witness, or nil if there is none.
+func (w *witnessSearch) witness() []Event {
+	if !w.found {
+		return nil
+	}
+	if w.best == nil {
+		// An empty witness is still a witness.
+		return []Event{}
+	}
+	return w.best
+}
+
+// cost returns the cost of the current execution for comparing
+// executions that reach the same state.
+func (w *witnessSearch) cost() witnessCost {
+	return witnessCost{w.relax, len(w.trace)}
+}
+
+// A witnessCost orders executions by the number of relaxations and
+// then the number of events.
+type witnessCost struct {
+	relax, events int
+}
+
+func (c witnessCost) less(c2 witnessCost) bool {
+	return c.relax < c2.relax || c.relax == c2.relax && c.events < c2.events
+}
+
+// opDesc describes executing op, which returned res if it's a load.
+func opDesc(op Op, res int) string {
+	if op.Type == OpLoad {
+		return fmt.Sprintf("%s = %d", op, res)
+	}
+	return op.String()
+}
+
+// printWitness prints witness events of program p as a table with a
+// column per thread, so each thread's events read top to bottom in
+// the order they happened.
+func printWitness(w io.Writer, p *Prog, events []Event) error {
+	nthr := 0
+	for tid := range p.Threads {
+		if p.Threads[tid].Ops[0].Type == OpExit {
+			break
+		}
+		nthr++
+	}
+	widths := make([]int, nthr)
+	for tid := range widths {
+		widths[tid] = len(fmt.Sprintf("T%d", tid))
+	}
+	for _, ev := range events {
+		if len(ev.Desc) > widths[ev.TID] {
+			widths[ev.TID] = len(ev.Desc)
+		}
+	}
+
+	row := func(step string, cells []string, rules []string) error {
+		line := fmt.Sprintf("%-4s", step)
+		for tid, cell := range cells {
+			line += fmt.Sprintf("  %-*s", widths[tid], cell)
+		}
+		if len(rules) > 0 {
+			line += "  # " + strings.Join(rules, "; ")
+		}
+		_, err := fmt.Fprintln(w, strings.TrimRight(line, " "))
+		return err
+	}
+	cells := make([]string, nthr)
+	for tid := range cells {
+		cells[tid] = fmt.Sprintf("T%d", tid)
+	}
+	if err := row("", cells, nil); err != nil {
+		return err
+	}
+	for i, ev := range events {
+		for tid := range cells {
+			cells[tid] = ""
+		}
+		cells[ev.TID] = ev.Desc
+		if err := row(fmt.Sprint(i+1), cells, ev.Rules); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// An edit is one line of a diff.
+type edit struct {
+	op   byte // ' ', '-', or '+'
+	line string
+}
+
+// diff returns the shortest edit script from a to b, using Myers'
+// O(ND) algorithm.
+func diff(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] is v before round d, which is needed to backtrack.
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, off)
+			}
+		}
+	}
+	panic("not reached")
+}
+
+func backtrack(a, b []string, trace [][]int, off int) []edit {
+	var rev []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var pk int
+		if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := v[off+pk]
+		py := px - pk
+		for x > px && y > py {
+			x, y = x-1, y-1
+			rev = append(rev, edit{' ', a[x]})
+		}
+		if x == px {
+			y--
+			rev = append(rev, edit{'+', b[y]})
+		} else {
+			x--
+			rev = append(rev, edit{'-', a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		rev = append(rev, edit{' ', a[x]})
+	}
+
+	edits := make([]edit, len(rev))
+	for i, e := range rev {
+		edits[len(rev)-1-i] = e
+	}
+	return edits
+}
+
+// printHunks prints the changed lines in edits in unified diff
+// format, with context lines of context around each change.
+func printHunks(w io.Writer, edits []edit, context int) {
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk until there are more than 2*context
+		// unchanged lines.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(edits) && j-end <= 2*context; j++ {
+			if edits[j].op != ' ' {
+				end = j + 1
+			}
+		}
+		i = end
+		end += context
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		// Compute the hunk's line numbers.
+		aStart, bStart := 1, 1
+		for _, e := range edits[:start] {
+			if e.op != '+' {
+				aStart++
+			}
+			if e.op != '-' {
+				bStart++
+			}
+		}
+		aLen, bLen := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				aLen++
+			}
+			if e.op != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(w, "%c%s\n", e.op, e.line)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command objdiff compares the code generated for a package by two
+// Go toolchains.
+//
+// objdiff builds a package with two Go builds saved by gover,
+// disassembles both, and reports the change in the text size of each
+// function, largest changes first. For example,
+//
+//     gover -rev 5f4b0f8 -rev 9a1e7b3 build
+//     objdiff -d -n 5 5f4b0f8 9a1e7b3 strconv
+//
+// reports the five functions in strconv whose size changed the most
+// between commits 5f4b0f8 and 9a1e7b3 and the instruction-level diff
+// of each. This is useful for explaining a regression that benchmany
+// finds at a compiler commit.
+//
+// The package defaults to the package in the current directory. For a
+// main package, objdiff compares the linked binaries, which include
+// all of the package's dependencies; use -run to limit the functions.
+//
+// Branch targets are shown as offsets from the start of the function,
+// so they compare equal across builds.
+//
+// The default for -gover-dir can be set by "gover-dir" in the go-misc
+// configuration file. See
+// https://godoc.org/github.com/aclements/go-misc/internal/config.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/aclements/go-misc/internal/config"
+)
+
+var (
+	flagGoverDir   = flag.String("gover-dir", "", "use gover's saved Go trees in `directory` (default gover's default)")
+	flagBuildFlags = flag.String("buildflags", "", "pass `flags` to go build")
+	flagRun        = flag.String("run", "", "only compare functions matching `regexp`")
+	flagN          = flag.Int("n", 20, "report the `N` functions with the largest changes, or all if 0")
+	flagDiff       = flag.Bool("d", false, "print the instruction diff of each reported function")
+	flagLines      = flag.Bool("lines", false, "include source lines in instruction diffs")
+	flagAll        = flag.Bool("all", false, "report functions whose size didn't change")
+)
+
+func main() {
+	log.SetPrefix("objdiff: ")
+	log.SetFlags(0)
+
+	cfg := config.NewFlags(flag.CommandLine, "objdiff", map[string]string{
+		"gover-dir": "gover-dir",
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] old new [package]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := cfg.Apply(); err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() != 2 && flag.NArg() != 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	oldVer, newVer, pkg := flag.Arg(0), flag.Arg(1), "."
+	if flag.NArg() == 3 {
+		pkg = flag.Arg(2)
+	}
+
+	var re *regexp.Regexp
+	if *flagRun != "" {
+		var err error
+		re, err = regexp.Compile(*flagRun)
+		if err != nil {
+			log.Fatalf("bad -run: %v", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "objdiff")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var fns [2]map[string]*function
+	for i, ver := range []string{oldVer, newVer} {
+		out := filepath.Join(dir, fmt.Sprint(i))
+		if err := build(ver, pkg, out); err != nil {
+			os.RemoveAll(dir)
+			log.Fatal(err)
+		}
+		fns[i], err = disasm(ver, out, re)
+		if err != nil {
+			os.RemoveAll(dir)
+			log.Fatal(err)
+		}
+	}
+
+	deltas := compare(fns[0], fns[1])
+	report(deltas)
+}
+
+// A delta is the change in one function between builds. old or new
+// is nil if the function is only in one build.
+type delta struct {
+	name     string
+	old, new *function
+}
+
+func (d *delta) sizes() (old, new int) {
+	if d.old != nil {
+		old = d.old.size
+	}
+	if d.new != nil {
+		new = d.new.size
+	}
+	return
+}
+
+// abs returns the magnitude of d's size change.
+func (d *delta) abs() int {
+	o, n := d.sizes()
+	if n < o {
+		return o - n
+	}
+	return n - o
+}
+
+// compare returns the deltas between old and new, ordered by
+// decreasing magnitude of size change.
+func compare(old, new map[string]*function) []*delta {
+	var deltas []*delta
+	for name, fn := range old {
+		deltas = append(deltas, &delta{name, fn, new[name]})
+	}
+	for name, fn := range new {
+		if old[name] == nil {
+			deltas = append(deltas, &delta{name, nil, fn})
+		}
+	}
+
+	sort.Slice(deltas, func(i, j int) bool {
+		ai, aj := deltas[i].abs(), deltas[j].abs()
+		if ai != aj {
+			return ai > aj
+		}
+		return deltas[i].name < deltas[j].name
+	})
+	return deltas
+}
+
+// report prints the size table and, with -d, the diffs of deltas.
+// Unless -all is set, it omits functions whose size didn't change.
+func report(deltas []*delta) {
+	var totalOld, totalNew int
+	for _, d := range deltas {
+		o, n := d.sizes()
+		totalOld += o
+		totalNew += n
+	}
+	if !*flagAll {
+		for i, d := range deltas {
+			if d.abs() == 0 {
+				deltas = deltas[:i]
+				break
+			}
+		}
+	}
+	if *flagN > 0 && len(deltas) > *flagN {
+		deltas = deltas[:*flagN]
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "delta\told\tnew\t  function\n")
+	size := func(fn *function) string {
+		if fn == nil {
+			return "-"
+		}
+		return fmt.Sprint(fn.size)
+	}
+	for _, d := range deltas {
+		o, n := d.sizes()
+		fmt.Fprintf(tw, "%+d\t%s\t%s\t  %s\n", n-o, size(d.old), size(d.new), d.name)
+	}
+	fmt.Fprintf(tw, "%+d\t%d\t%d\t  total\n", totalNew-totalOld, totalOld, totalNew)
+	tw.Flush()
+
+	if !*flagDiff {
+		return
+	}
+	for _, d := range deltas {
+		var a, b []string
+		if d.old != nil {
+			a = d.old.insts
+		}
+		if d.new != nil {
+			b = d.new.insts
+		}
+		fmt.Printf("\n--- %s (old)\n+++ %s (new)\n", d.name, d.name)
+		printHunks(os.Stdout, diff(a, b), 3)
+	}
+}
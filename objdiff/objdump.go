@@ -0,0 +1,172 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A function is the disassembly of one function.
+type function struct {
+	name string
+
+	// size is the size of the function's code in bytes.
+	size int
+
+	// insts is the function's instructions, normalized so they
+	// can be compared across builds.
+	insts []string
+}
+
+// gover runs gover subcommand subcmd with args and returns its
+// stdout.
+func gover(subcmd string, args ...string) ([]byte, error) {
+	var gargs []string
+	if *flagGoverDir != "" {
+		gargs = append(gargs, "-dir", *flagGoverDir)
+	}
+	gargs = append(gargs, subcmd)
+	gargs = append(gargs, args...)
+	cmd := exec.Command("gover", gargs...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gover %s: %v\n%s", strings.Join(gargs, " "), err, stderr.Bytes())
+	}
+	return out, nil
+}
+
+// build builds pkg with Go build ver and writes the result to out.
+func build(ver, pkg, out string) error {
+	args := []string{ver, "go", "build", "-o", out}
+	args = append(args, strings.Fields(*flagBuildFlags)...)
+	args = append(args, pkg)
+	_, err := gover("run", args...)
+	return err
+}
+
+// disasm disassembles the functions in the binary or object file
+// path that match re using the objdump of Go build ver.
+func disasm(ver, path string, re *regexp.Regexp) (map[string]*function, error) {
+	args := []string{ver, "go", "tool", "objdump"}
+	if re != nil {
+		args = append(args, "-s", re.String())
+	}
+	args = append(args, path)
+	out, err := gover("run", args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseObjdump(out)
+}
+
+// parseObjdump parses the output of "go tool objdump". Each function
+// starts with a "TEXT name(SB) file" line, followed by a line for
+// each instruction of the form
+//
+//     file:line <tab> addr <tab> hex <tab> instruction [<tab> relocation]
+func parseObjdump(out []byte) (map[string]*function, error) {
+	fns := make(map[string]*function)
+	var fn *function
+	var lines [][]string
+	finish := func() {
+		if fn == nil {
+			return
+		}
+		fn.insts = normalize(lines)
+		fns[fn.name] = fn
+		fn, lines = nil, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "TEXT ") {
+			finish()
+			name := strings.Fields(line)[1]
+			fn = &function{name: strings.TrimSuffix(name, "(SB)")}
+			continue
+		}
+		if fn == nil || line == "" {
+			continue
+		}
+		var fields []string
+		for _, f := range strings.Split(line, "\t") {
+			if f = strings.TrimSpace(f); f != "" {
+				fields = append(fields, f)
+			}
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("malformed objdump line: %q", line)
+		}
+		fn.size += len(fields[2]) / 2
+		lines = append(lines, fields)
+	}
+	finish()
+	return fns, scanner.Err()
+}
+
+// addrRe matches bare addresses in instructions, such as branch
+// targets. It doesn't match immediates ($0x10) or memory operand
+// offsets (0x10(SP)).
+var addrRe = regexp.MustCompile(`(^|[^$\w])0x([0-9a-f]+)\b(?:[^(]|$)`)
+
+// relocRangeRe matches the byte range prefix of a relocation, such
+// as "[1:5]".
+var relocRangeRe = regexp.MustCompile(`\[\d+:\d+\]`)
+
+// normalize returns the instructions of a function's disassembly
+// lines with addresses in the function replaced by offsets from its
+// start, so they can be compared between builds.
+func normalize(lines [][]string) []string {
+	if len(lines) == 0 {
+		return nil
+	}
+	parseAddr := func(s string) uint64 {
+		a, _ := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+		return a
+	}
+	start := parseAddr(lines[0][1])
+	last := lines[len(lines)-1]
+	end := parseAddr(last[1]) + uint64(len(last[2])/2)
+
+	insts := make([]string, len(lines))
+	for i, f := range lines {
+		inst := addrRe.ReplaceAllStringFunc(f[3], func(m string) string {
+			sm := addrRe.FindStringSubmatch(m)
+			a, err := strconv.ParseUint(sm[2], 16, 64)
+			if err != nil || a < start || a > end {
+				return m
+			}
+			return strings.Replace(m, "0x"+sm[2], fmt.Sprintf("+%#x", a-start), 1)
+		})
+		if len(f) > 4 {
+			// Object files have relocations instead of
+			// symbolic targets. Drop the relocations' byte
+			// ranges within the instruction.
+			reloc := relocRangeRe.ReplaceAllString(f[4], "")
+			if sym := strings.TrimPrefix(reloc, "R_CALL:"); sym != reloc && !strings.Contains(sym, " ") {
+				// The call target is just the
+				// unrelocated address.
+				inst = strings.Fields(f[3])[0] + " " + sym
+			} else {
+				inst += "\t" + reloc
+			}
+		}
+		if *flagLines {
+			inst = f[0] + "\t" + inst
+		}
+		insts[i] = inst
+	}
+	return insts
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command profmerge summarizes the profiles benchmany collects across
+// commits.
+//
+// profmerge merges the profiles of all runs of each commit and
+// prints the share of the profile spent in each of the top -n
+// functions at each commit in Go benchmark format. This can be
+// plotted with benchplot to link a regression to the functions
+// responsible. For example,
+//
+//     benchmany -cpuprofile -d bench go1.12..master
+//     profmerge -d bench > bench/prof.log
+//     benchplot bench/prof.log > prof.svg
+//
+// Each function is reported as a benchmark named "Func:<function>"
+// with two metrics: "self-fraction", the fraction of the profile in
+// the function itself, and "cum-fraction", the fraction in the
+// function and everything it calls. The top functions are those with
+// the largest self-fraction at any commit.
+//
+// By default, profmerge summarizes CPU profiles. With -kind mem, it
+// summarizes heap profiles, using the allocated bytes sample. Use
+// -sample to summarize a different sample type.
+//
+// With -merged, profmerge also writes the merged profile of each
+// commit, which can be examined with "go tool pprof".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aclements/go-misc/internal/benchfmt"
+	"github.com/google/pprof/profile"
+)
+
+var (
+	flagDir    = flag.String("d", ".", "read profiles from benchmany's -d `directory`")
+	flagKind   = flag.String("kind", "cpu", "summarize profiles of `kind` cpu or mem")
+	flagSample = flag.String("sample", "", "summarize sample `type` (default cpu for CPU profiles and alloc_space for heap profiles)")
+	flagN      = flag.Int("n", 10, "report the top `N` functions")
+	flagMerged = flag.String("merged", "", "write each commit's merged profile to `directory`")
+)
+
+func main() {
+	log.SetPrefix("profmerge: ")
+	log.SetFlags(0)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 0 || *flagKind != "cpu" && *flagKind != "mem" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Find the profiles of each commit. benchmany names these
+	// <hash>.<run>.<kind>.prof.
+	paths, err := filepath.Glob(filepath.Join(*flagDir, "profiles", "*."+*flagKind+".prof"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(paths) == 0 {
+		log.Fatalf("no %s profiles in %s", *flagKind, filepath.Join(*flagDir, "profiles"))
+	}
+	byCommit := make(map[string][]string)
+	var commits []string
+	for _, path := range paths {
+		hash := strings.SplitN(filepath.Base(path), ".", 2)[0]
+		if byCommit[hash] == nil {
+			commits = append(commits, hash)
+		}
+		byCommit[hash] = append(byCommit[hash], path)
+	}
+	sort.Strings(commits)
+
+	if *flagMerged != "" {
+		if err := os.MkdirAll(*flagMerged, 0777); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Summarize each commit.
+	sums := make(map[string]*summary)
+	for _, hash := range commits {
+		p, err := mergeFiles(byCommit[hash])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *flagMerged != "" {
+			if err := writeProfile(filepath.Join(*flagMerged, hash+"."+*flagKind+".prof"), p); err != nil {
+				log.Fatal(err)
+			}
+		}
+		sums[hash], err = summarize(p, sampleType(p))
+		if err != nil {
+			log.Fatalf("%s: %v", hash, err)
+		}
+	}
+
+	// Report the top functions at every commit.
+	top := topFuncs(sums, *flagN)
+	var bs []*benchfmt.Benchmark
+	for _, hash := range commits {
+		sum := sums[hash]
+		for _, fn := range top {
+			bs = append(bs, &benchfmt.Benchmark{
+				Name:       "Func:" + benchName(fn),
+				Iterations: len(byCommit[hash]),
+				Config: map[string]*benchfmt.Config{
+					"commit": {RawValue: hash, InBlock: true},
+				},
+				Result: map[string]float64{
+					"self-fraction": sum.self[fn] / sum.total,
+					"cum-fraction":  sum.cum[fn] / sum.total,
+				},
+			})
+		}
+	}
+	if err := benchfmt.Print(bs); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// mergeFiles reads and merges the profiles in paths.
+func mergeFiles(paths []string) (*profile.Profile, error) {
+	var ps []*profile.Profile
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		p, err := profile.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		ps = append(ps, p)
+	}
+	p, err := profile.Merge(ps)
+	if err != nil {
+		return nil, fmt.Errorf("merging %s: %v", strings.Join(paths, " "), err)
+	}
+	return p, nil
+}
+
+func writeProfile(path string, p *profile.Profile) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := p.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sampleType returns the sample type to summarize in p.
+func sampleType(p *profile.Profile) string {
+	if *flagSample != "" {
+		return *flagSample
+	}
+	for _, st := range p.SampleType {
+		// Heap profiles default to in-use memory, but
+		// allocation is more meaningful for benchmarks.
+		if st.Type == "alloc_space" {
+			return st.Type
+		}
+	}
+	return p.SampleType[len(p.SampleType)-1].Type
+}
+
+// benchName returns function name fn in a form that can be used in a
+// benchmark name. This drops the package path, like pprof does, and
+// replaces spaces and slashes, which have special meaning in
+// benchmark names.
+func benchName(fn string) string {
+	if i := strings.LastIndex(fn, "/"); i >= 0 && !strings.ContainsAny(fn[:i], "[(") {
+		fn = fn[i+1:]
+	}
+	return strings.NewReplacer(" ", "_", "/", "_").Replace(fn)
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// A summary is the total of one sample type in a profile by function.
+type summary struct {
+	total float64
+
+	// self is the total of samples whose innermost frame is in
+	// each function and cum is the total of samples with any
+	// frame in each function.
+	self, cum map[string]float64
+}
+
+// summarize returns the summary of sample type typ in p.
+func summarize(p *profile.Profile, typ string) (*summary, error) {
+	idx := -1
+	for i, st := range p.SampleType {
+		if st.Type == typ {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("profile has no sample type %q", typ)
+	}
+
+	sum := &summary{self: make(map[string]float64), cum: make(map[string]float64)}
+	seen := make(map[string]bool)
+	for _, s := range p.Sample {
+		v := float64(s.Value[idx])
+		sum.total += v
+		for k := range seen {
+			delete(seen, k)
+		}
+		for i, loc := range s.Location {
+			// Each location's lines are ordered from the
+			// innermost inlined call out.
+			for j, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				name := line.Function.Name
+				if i == 0 && j == 0 {
+					sum.self[name] += v
+				}
+				if !seen[name] {
+					seen[name] = true
+					sum.cum[name] += v
+				}
+			}
+		}
+	}
+	if sum.total == 0 {
+		return nil, fmt.Errorf("profile has no %s samples", typ)
+	}
+	return sum, nil
+}
+
+// topFuncs returns the n functions with the largest self fraction at
+// any commit in sums, largest first.
+func topFuncs(sums map[string]*summary, n int) []string {
+	max := make(map[string]float64)
+	for _, sum := range sums {
+		for fn, v := range sum.self {
+			if f := v / sum.total; f > max[fn] {
+				max[fn] = f
+			}
+		}
+	}
+	var fns []string
+	for fn := range max {
+		fns = append(fns, fn)
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		if max[fns[i]] != max[fns[j]] {
+			return max[fns[i]] > max[fns[j]]
+		}
+		return fns[i] < fns[j]
+	})
+	if len(fns) > n {
+		fns = fns[:n]
+	}
+	return fns
+}
@@ -0,0 +1,191 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// Annotations is a set of user-supplied facts about the runtime that
+// refine the analysis. An annotations file consists of lines of the
+// form
+//
+//     ignore-edge <lock1> <lock2>
+//         Omit lock graph edges from lock1 to lock2 from all reports.
+//         This is for intentional lock orderings that the analysis
+//         reports as part of a cycle.
+//
+//     ignore-path <func>
+//         Omit code paths through func from all reports. An edge
+//         with no remaining paths is omitted entirely. This is for
+//         known false positives.
+//
+//     acquires <func> <lock>...
+//         Model a call to func as acquiring and releasing each of
+//         the given locks, rather than analyzing func. This is for
+//         functions the analysis can't see through, such as
+//         assembly functions and functions pulled in with
+//         go:linkname.
+//
+// Locks are named as they are in reports, such as "runtime.sched.lock"
+// or "runtime.hchan.lock*". Functions are named as they are in
+// reports, such as "runtime.gcStart". Blank lines and text following
+// a "#" are ignored.
+type Annotations struct {
+	// all is every annotation, in file order.
+	all []*annotation
+
+	ignoreEdges []*annotation
+	ignorePaths map[string]*annotation
+	acquires    []*annotation
+
+	// hash is the hash of the annotations file.
+	hash string
+}
+
+type annotation struct {
+	// pos is the file:line position of the annotation.
+	pos  string
+	args []string
+	// used is set if this annotation affected the analysis.
+	used bool
+}
+
+// readAnnotations reads the annotations file at path.
+func readAnnotations(path string) *Annotations {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	an := &Annotations{
+		ignorePaths: make(map[string]*annotation),
+		hash:        fmt.Sprintf("%x", sha256.Sum256(data)),
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		a := &annotation{pos: fmt.Sprintf("%s:%d", path, lineno), args: fields[1:]}
+		switch fields[0] {
+		case "ignore-edge":
+			if len(a.args) != 2 {
+				log.Fatalf("%s: usage: ignore-edge <lock1> <lock2>", a.pos)
+			}
+			an.ignoreEdges = append(an.ignoreEdges, a)
+		case "ignore-path":
+			if len(a.args) != 1 {
+				log.Fatalf("%s: usage: ignore-path <func>", a.pos)
+			}
+			an.ignorePaths[a.args[0]] = a
+		case "acquires":
+			if len(a.args) < 2 {
+				log.Fatalf("%s: usage: acquires <func> <lock>...", a.pos)
+			}
+			an.acquires = append(an.acquires, a)
+		default:
+			log.Fatalf("%s: unknown annotation %q", a.pos, fields[0])
+		}
+		an.all = append(an.all, a)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("%s: %s", path, err)
+	}
+	return an
+}
+
+// install installs call handlers for the acquires annotations in an.
+// This must be done before analysis.
+func (an *Annotations) install(s *state) {
+	for _, a := range an.acquires {
+		a := a
+		var locks []*LockClass
+		for _, name := range a.args[1:] {
+			locks = append(locks, s.lca.Named(name))
+		}
+		if _, ok := callHandlers[a.args[0]]; ok {
+			log.Fatalf("%s: %s already has special handling", a.pos, a.args[0])
+		}
+		callHandlers[a.args[0]] = func(s *state, ps PathState, instr ssa.Instruction, newps []PathState) []PathState {
+			a.used = true
+			ls := NewLockSet()
+			for _, lock := range locks {
+				ls = ls.Plus(lock, s.stack)
+			}
+			s.lockOrder.Add(ps.lockSet, ls, s.stack)
+			return append(newps, ps)
+		}
+	}
+}
+
+// Suppress removes the edges and paths suppressed by an from the
+// lock graph.
+func (lo *LockOrder) Suppress(an *Annotations) {
+	if lo.lca == nil {
+		// Empty lock graph.
+		return
+	}
+	lo.cycles, lo.sccs = nil, nil
+	matchLock := func(id int, name string) bool {
+		return strings.TrimSuffix(lo.name(id), "*") == strings.TrimSuffix(name, "*")
+	}
+	var stack []ssa.Instruction
+	ignorePath := func(sf *StackFrame) bool {
+		stack = sf.Flatten(stack)
+		for _, call := range stack {
+			if a, ok := an.ignorePaths[call.Parent().String()]; ok {
+				a.used = true
+				return true
+			}
+		}
+		return false
+	}
+edges:
+	for edge, infos := range lo.m {
+		for _, a := range an.ignoreEdges {
+			if matchLock(edge.fromId, a.args[0]) && matchLock(edge.toId, a.args[1]) {
+				a.used = true
+				delete(lo.m, edge)
+				continue edges
+			}
+		}
+		if len(an.ignorePaths) == 0 {
+			continue
+		}
+		for info := range infos {
+			if ignorePath(info.fromStack) || ignorePath(info.toStack) {
+				delete(infos, info)
+			}
+		}
+		if len(infos) == 0 {
+			delete(lo.m, edge)
+		}
+	}
+}
+
+// WriteUnused writes a warning to w for each annotation in an that
+// didn't affect the analysis. These are likely stale.
+func (an *Annotations) WriteUnused(w io.Writer) {
+	for _, a := range an.all {
+		if !a.used {
+			fmt.Fprintf(w, "%s: unused annotation\n", a.pos)
+		}
+	}
+}
@@ -0,0 +1,1050 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"go/constant"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// This file implements an incremental analysis cache.
+//
+// The cache summarizes each walk of a function from an enter path
+// state by the path states on exit, the lock graph paths it added,
+// the warnings it printed, and the roots it added, plus the walks of
+// callees it started. On the next run, a walk of the same function
+// from the same enter state replays this summary rather than walking
+// the function, provided that the function, the functions the summary
+// refers to, and the summaries of its callees are unchanged. Walks
+// are identified by the same enter state that walkFunction memoizes
+// on, plus whether they're in signal context.
+//
+// A function is unchanged if the content hash of its package is
+// unchanged or, failing that, if its SSA form is unchanged. Package
+// hashes cover the package's sources and the hashes of its imports,
+// so they capture changes to the types a function uses.
+//
+// The callees of a dynamic call come from the whole-program call
+// graph, so they can change even if the calling function doesn't.
+// Hence, a function is also only unchanged if the callees of each of
+// its dynamic call sites are unchanged. A walk that reaches a new
+// callee is re-analyzed because the function that calls it is
+// invalid.
+//
+// walkFunction cuts recursion by treating a walk that's already in
+// progress as having no exit states. If a walk's result depends on
+// cutting an enclosing walk, it depends on how that walk was reached,
+// so it's only replayed as part of replaying the enclosing walk.
+//
+// Replaying a walk reports the code paths of the walk that recorded
+// it, including its callers' stacks. Furthermore, path states compare
+// lock stacks by identity, and a summary only preserves which of its
+// own states share lock stacks, which can change which paths a walk
+// trims. Hence, an incremental run may report somewhat different
+// paths and warnings than a full run.
+
+const analysisCacheVersion = 4
+
+// analysisCache is the on-disk analysis cache.
+type analysisCache struct {
+	Version int
+
+	// Packages maps from package path to content hash.
+	Packages map[string]string
+
+	// Funcs maps from function name to its key at the time it
+	// was walked.
+	Funcs map[string]cachedFunc
+
+	// Walks maps from walk key to the summary of that walk. See
+	// walkKey.
+	Walks map[string]*walkSummary
+}
+
+type cachedFunc struct {
+	// Pkg is the path of the function's package, or "" if the
+	// package hash doesn't determine this function.
+	Pkg string
+
+	// SSA is the hash of the function's SSA form.
+	SSA string
+
+	// Callees is the hash of the callees of the function's
+	// dynamic call sites in the call graph.
+	Callees string
+}
+
+// walkSummary summarizes a walk of a function.
+type walkSummary struct {
+	// Func is the name of the walked function.
+	Func string
+
+	// Enter and Exits are the path states on entry to and exit
+	// from the function, and Stacks are the lock stacks they refer
+	// to.
+	Enter  cachedPathState
+	Exits  []cachedPathState
+	Stacks [][]cachedFrame
+
+	// Calls is the list of keys of the walks of callees that
+	// this walk started or reused.
+	Calls []string
+
+	// Deps is the list of functions this summary refers to,
+	// including Func.
+	Deps []string
+
+	// Cut indicates that the result of this walk depends on
+	// walkFunction cutting recursion into an enclosing walk, so
+	// it's only replayed as part of replaying a walk that calls
+	// it.
+	Cut bool
+
+	// AddedRoots is the list of roots added by this walk.
+	AddedRoots []string
+
+	Paths    []cachedPath
+	Messages []cachedMessage
+}
+
+// cachedMessage is a warning and its key. See state.printMessage.
+type cachedMessage struct {
+	Key, Text string
+}
+
+// cachedPathState is a PathState on entry to or exit from a function.
+// Locks and Heap are sorted so equal path states are encoded equally.
+type cachedPathState struct {
+	Locks []cachedLock
+	Heap  []cachedHeapValue
+}
+
+// cachedLock is a held lock. Stack is an index into a table of lock
+// stacks. LockSets compare stacks by identity, so the table records
+// which locks share the same stack.
+type cachedLock struct {
+	Class string
+	Stack int
+}
+
+type cachedHeapValue struct {
+	Object string
+	Value  cachedValue
+}
+
+// cachedValue is a DynValue. Kind is one of "const", "nil", "global",
+// "field", "heap", or "struct", and the fields used depend on Kind.
+type cachedValue struct {
+	Kind string
+
+	// ConstKind and Const are the kind and exact string of a
+	// constant.
+	ConstKind constant.Kind
+	Const     string
+
+	// Global is the name of a global or of the global containing
+	// a field, and Field is the field index.
+	Global string
+	Field  int
+
+	// Object is the label of a heap object pointed to.
+	Object string
+
+	// Fields maps from field name to heap object label in a
+	// struct.
+	Fields []cachedField
+}
+
+type cachedField struct {
+	Name, Object string
+}
+
+// cachedPath is a path in the lock graph. See lockOrderInfo.
+type cachedPath struct {
+	From, To           string
+	FromStack, ToStack []cachedFrame
+}
+
+// cachedFrame identifies a call instruction by its position in its
+// function. This is stable as long as the function's SSA form is.
+type cachedFrame struct {
+	Func         string
+	Block, Instr int
+}
+
+// analysisCacheState is the state of the analysis cache during a run.
+type analysisCacheState struct {
+	path string
+	old  *analysisCache
+	new  *analysisCache
+	cg   *callgraph.Graph
+
+	// byName maps from function name to function. Names that
+	// refer to more than one function map to nil.
+	byName map[string]*ssa.Function
+
+	// globals maps from global name to global.
+	globals map[string]*ssa.Global
+
+	// heap maps from label to the heap objects that path states
+	// may refer to.
+	heap map[string]*HeapObject
+
+	// frames caches the encoding of call instructions.
+	frames map[ssa.Instruction]cachedFrame
+
+	// valid caches the results of funcValid.
+	valid map[string]bool
+
+	// summaries caches the results of replayable.
+	summaries map[string]*replaySummary
+
+	// recs is the stack of walks being recorded, and active
+	// counts the walks being recorded by key.
+	recs   []*walkRecord
+	active map[string]int
+
+	// replaying indicates that a summary is being replayed, so
+	// its effects shouldn't be recorded.
+	replaying bool
+}
+
+// walkRecord records a walk of a function.
+type walkRecord struct {
+	fn *ssa.Function
+
+	// key is the key of the walk, or "" if it can't be
+	// summarized. ps is the enter PathState of the walk.
+	key string
+	ps  PathState
+
+	// depth is the index of this record in recs while the walk
+	// is in progress, or -1 once it's done.
+	depth int
+
+	// cut is the lowest depth of an in-progress walk whose
+	// recursion cut this walk's result depends on. If cut is
+	// less than depth, the walk's summary is marked Cut.
+	cut int
+
+	// summarized indicates that this walk is done and was
+	// summarized. Otherwise, it can't be summarized and its
+	// record is folded into the records of walks that use it.
+	summarized bool
+
+	calls    []string
+	deps     map[*ssa.Function]bool
+	paths    map[lockOrderEdge]map[lockOrderInfo]bool
+	messages []cachedMessage
+	msgKeys  map[string]bool
+	added    []string
+}
+
+// replaySummary is a decoded walkSummary.
+type replaySummary struct {
+	key    string
+	sum    *walkSummary
+	fn     *ssa.Function
+	stacks []*StackFrame
+	enter  PathState
+	exits  *PathStateSet
+	paths  []replayPath
+	added  []*ssa.Function
+}
+
+// stackTable assigns indexes to the lock stacks of encoded path
+// states.
+type stackTable struct {
+	index  map[*StackFrame]int
+	stacks [][]cachedFrame
+}
+
+type replayPath struct {
+	edge lockOrderEdge
+	info lockOrderInfo
+}
+
+// openAnalysisCache opens the analysis cache in dir for the analysis
+// of prog with call graph cg. Cache entries are only shared between
+// runs with the same key. pkgHashes gives the content hash of each
+// package in prog.
+func openAnalysisCache(dir, key string, prog *ssa.Program, cg *callgraph.Graph, pkgHashes map[string]string) *analysisCacheState {
+	cs := &analysisCacheState{
+		path: filepath.Join(dir, fmt.Sprintf("%x.gob", sha256.Sum256([]byte(key)))),
+		cg:   cg,
+		new: &analysisCache{
+			Version:  analysisCacheVersion,
+			Packages: pkgHashes,
+			Funcs:    make(map[string]cachedFunc),
+			Walks:    make(map[string]*walkSummary),
+		},
+		byName:    make(map[string]*ssa.Function),
+		globals:   make(map[string]*ssa.Global),
+		heap:      make(map[string]*HeapObject),
+		frames:    make(map[ssa.Instruction]cachedFrame),
+		valid:     make(map[string]bool),
+		summaries: make(map[string]*replaySummary),
+		active:    make(map[string]int),
+	}
+	for fn := range ssautil.AllFunctions(prog) {
+		name := fn.String()
+		if _, ok := cs.byName[name]; ok {
+			cs.byName[name] = nil
+		} else {
+			cs.byName[name] = fn
+		}
+	}
+	for _, pkg := range prog.AllPackages() {
+		for _, mem := range pkg.Members {
+			if g, ok := mem.(*ssa.Global); ok {
+				cs.globals[g.String()] = g
+			}
+		}
+	}
+
+	f, err := os.Open(cs.path)
+	if err != nil {
+		return cs
+	}
+	defer f.Close()
+	var old analysisCache
+	if err := gob.NewDecoder(f).Decode(&old); err != nil || old.Version != analysisCacheVersion {
+		return cs
+	}
+	cs.old = &old
+	return cs
+}
+
+// addHeap registers the heap objects that path states may refer to.
+// Their labels must be unique.
+func (cs *analysisCacheState) addHeap(objs ...*HeapObject) {
+	for _, h := range objs {
+		cs.heap[h.label] = h
+	}
+}
+
+// funcKey returns the cache key of fn.
+func (cs *analysisCacheState) funcKey(fn *ssa.Function) cachedFunc {
+	var key cachedFunc
+	// Synthetic functions and instantiations of generic
+	// functions may depend on types from other packages.
+	if fn.Pkg != nil && fn.Synthetic == "" && fn.Origin() == nil {
+		key.Pkg = fn.Pkg.Pkg.Path()
+	}
+	var buf bytes.Buffer
+	fn.WriteTo(&buf)
+	key.SSA = fmt.Sprintf("%x", sha256.Sum256(buf.Bytes()))
+	key.Callees = cs.calleesKey(fn)
+	return key
+}
+
+// calleesKey returns the hash of the callees of fn's dynamic call
+// sites in the call graph.
+func (cs *analysisCacheState) calleesKey(fn *ssa.Function) string {
+	var edges []string
+	if cnode := cs.cg.Nodes[fn]; cnode != nil {
+		for _, o := range cnode.Out {
+			if o.Site == nil || o.Site.Common().StaticCallee() != nil {
+				continue
+			}
+			b := o.Site.Block()
+			for i, instr := range b.Instrs {
+				if instr == o.Site {
+					edges = append(edges, fmt.Sprintf("%d.%d %s", b.Index, i, o.Callee.Func))
+				}
+			}
+		}
+	}
+	sort.Strings(edges)
+	h := sha256.New()
+	for _, edge := range edges {
+		fmt.Fprintf(h, "%s\n", edge)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// funcValid reports whether the function called name is unchanged
+// since the cache was written.
+func (cs *analysisCacheState) funcValid(name string) bool {
+	if valid, ok := cs.valid[name]; ok {
+		return valid
+	}
+	valid := false
+	old, ok := cs.old.Funcs[name]
+	fn := cs.byName[name]
+	if ok && fn != nil {
+		if old.Pkg != "" && cs.old.Packages[old.Pkg] != "" && cs.old.Packages[old.Pkg] == cs.new.Packages[old.Pkg] {
+			valid = true
+		} else {
+			valid = cs.funcKey(fn).SSA == old.SSA
+		}
+		// Callees can change even if the package doesn't.
+		valid = valid && cs.calleesKey(fn) == old.Callees
+	}
+	cs.valid[name] = valid
+	return valid
+}
+
+// frame returns the instruction identified by fr, or nil if there is
+// no such instruction.
+func (cs *analysisCacheState) frame(fr cachedFrame) ssa.Instruction {
+	fn := cs.byName[fr.Func]
+	if fn == nil || fr.Block < 0 || fr.Block >= len(fn.Blocks) {
+		return nil
+	}
+	b := fn.Blocks[fr.Block]
+	if fr.Instr < 0 || fr.Instr >= len(b.Instrs) {
+		return nil
+	}
+	return b.Instrs[fr.Instr]
+}
+
+// walkKey returns the key of the walk of the function called name
+// from enter, whose lock stacks are stacks. signal indicates that the
+// walk is in signal context.
+func walkKey(name string, signal bool, enter cachedPathState, stacks [][]cachedFrame) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%s %v %+v %+v", name, signal, enter, stacks))))
+}
+
+// enter starts a walk of f, whose analysis state is fInfo, from ps.
+// If the cache has a summary of this walk that can be replayed, enter
+// replays it and returns its exit states and true. Otherwise, it
+// starts recording the walk, and the caller must call exit when the
+// walk is done.
+func (cs *analysisCacheState) enter(s *state, f *ssa.Function, fInfo *funcInfo, ps PathState) (*PathStateSet, bool) {
+	rec := &walkRecord{
+		fn:    f,
+		ps:    ps,
+		depth: len(cs.recs),
+		cut:   len(cs.recs),
+		deps:  map[*ssa.Function]bool{f: true},
+	}
+	var tab stackTable
+	if enter, ok := cs.encodeState(s, ps, &tab, rec.deps); ok && cs.byName[f.String()] == f {
+		rec.key = walkKey(f.String(), s.inSignal, enter, tab.stacks)
+	}
+	if fInfo.records == nil {
+		fInfo.records = NewPathStateMap()
+	}
+
+	if rec.key != "" && cs.new.Walks[rec.key] == nil {
+		if rs := cs.replayable(s, rec.key); rs != nil && !rs.sum.Cut && !cs.conflicts(rs, make(map[string]bool)) {
+			cs.replaying = true
+			cs.replay(s, rs)
+			cs.replaying = false
+			exits := cs.exitStates(s, rs, ps)
+			rec = &walkRecord{key: rec.key, depth: -1, summarized: true}
+			fInfo.exitStates.Set(ps, exits)
+			fInfo.records.Set(ps, rec)
+			cs.use(rec)
+			return exits, true
+		}
+	}
+
+	fInfo.records.Set(ps, rec)
+	cs.recs = append(cs.recs, rec)
+	if rec.key != "" {
+		cs.active[rec.key]++
+	}
+	return nil, false
+}
+
+// exit finishes recording the walk started by the last call to enter
+// that didn't replay a summary. exits is the result of the walk.
+func (cs *analysisCacheState) exit(s *state, exits *PathStateSet) {
+	rec := cs.recs[len(cs.recs)-1]
+	cs.recs = cs.recs[:len(cs.recs)-1]
+	depth := rec.depth
+	rec.depth = -1
+	if rec.key != "" {
+		cs.active[rec.key]--
+	}
+
+	cut := rec.cut < depth
+	if cut && len(cs.recs) > 0 {
+		// If this walk depends on cutting an enclosing walk,
+		// so does the caller.
+		if top := cs.recs[len(cs.recs)-1]; rec.cut < top.cut {
+			top.cut = rec.cut
+		}
+	}
+	if rec.key != "" {
+		if old := cs.new.Walks[rec.key]; old != nil && (cut || !old.Cut) {
+			// Another walk with the same key was already
+			// summarized.
+			rec.summarized = true
+		} else if sum := cs.summarize(s, rec, exits); sum != nil {
+			sum.Cut = cut
+			cs.new.Walks[rec.key] = sum
+			rec.summarized = true
+		}
+		if rec.summarized {
+			*rec = walkRecord{key: rec.key, depth: -1, summarized: true}
+		}
+	}
+	cs.use(rec)
+}
+
+// reuse records that the walk being recorded reused the memoized walk
+// of the function whose analysis state is fInfo from ps.
+func (cs *analysisCacheState) reuse(fInfo *funcInfo, ps PathState) {
+	var rec *walkRecord
+	if fInfo.records != nil {
+		rec, _ = fInfo.records.Get(ps).(*walkRecord)
+	}
+	cs.use(rec)
+}
+
+// use records that the walk being recorded used the result of the
+// walk recorded by rec.
+func (cs *analysisCacheState) use(rec *walkRecord) {
+	if len(cs.recs) == 0 {
+		return
+	}
+	top := cs.recs[len(cs.recs)-1]
+	switch {
+	case rec == nil:
+		// We don't know what this walk did, so never
+		// replay the walks that use it.
+		top.cut = -1
+	case rec.depth >= 0:
+		// rec is in progress, so walkFunction cut recursion.
+		if rec.depth < top.cut {
+			top.cut = rec.depth
+		}
+	case rec.summarized:
+		top.calls = append(top.calls, rec.key)
+	default:
+		top.calls = append(top.calls, rec.calls...)
+		for fn := range rec.deps {
+			top.deps[fn] = true
+		}
+		for edge, infos := range rec.paths {
+			for info := range infos {
+				top.addPath(edge, info)
+			}
+		}
+		for _, msg := range rec.messages {
+			top.addMessage(msg)
+		}
+		top.added = append(top.added, rec.added...)
+	}
+}
+
+// depend records that the walk being recorded depends on fn.
+func (cs *analysisCacheState) depend(fn *ssa.Function) {
+	if len(cs.recs) > 0 && !cs.replaying {
+		cs.recs[len(cs.recs)-1].deps[fn] = true
+	}
+}
+
+// addPath records that the walk being recorded added info to the
+// lock graph edge.
+func (cs *analysisCacheState) addPath(edge lockOrderEdge, info lockOrderInfo) {
+	if len(cs.recs) > 0 && !cs.replaying {
+		cs.recs[len(cs.recs)-1].addPath(edge, info)
+	}
+}
+
+func (rec *walkRecord) addPath(edge lockOrderEdge, info lockOrderInfo) {
+	if rec.paths == nil {
+		rec.paths = make(map[lockOrderEdge]map[lockOrderInfo]bool)
+	}
+	infos := rec.paths[edge]
+	if infos == nil {
+		infos = make(map[lockOrderInfo]bool)
+		rec.paths[edge] = infos
+	}
+	infos[info] = true
+}
+
+// message records that the walk being recorded printed msg with the
+// given key. The message may refer to the current stack.
+func (cs *analysisCacheState) message(s *state, key, msg string) {
+	if len(cs.recs) == 0 || cs.replaying {
+		return
+	}
+	top := cs.recs[len(cs.recs)-1]
+	if top.msgKeys[key] {
+		return
+	}
+	top.addMessage(cachedMessage{key, msg})
+	for sf := s.stack; sf != nil; sf = sf.parent {
+		top.deps[sf.call.Parent()] = true
+	}
+}
+
+func (rec *walkRecord) addMessage(msg cachedMessage) {
+	if rec.msgKeys[msg.Key] {
+		return
+	}
+	if rec.msgKeys == nil {
+		rec.msgKeys = make(map[string]bool)
+	}
+	rec.msgKeys[msg.Key] = true
+	rec.messages = append(rec.messages, msg)
+}
+
+// addRoot records that the walk being recorded added root fn.
+func (cs *analysisCacheState) addRoot(fn *ssa.Function) {
+	if len(cs.recs) > 0 && !cs.replaying {
+		top := cs.recs[len(cs.recs)-1]
+		top.added = append(top.added, fn.String())
+	}
+}
+
+// summarize returns the summary of the walk recorded by rec, whose
+// result is exits, or nil if it can't be summarized.
+func (cs *analysisCacheState) summarize(s *state, rec *walkRecord, exits *PathStateSet) *walkSummary {
+	sum := &walkSummary{
+		Func:       rec.fn.String(),
+		Calls:      rec.calls,
+		AddedRoots: rec.added,
+		Messages:   rec.messages,
+	}
+	var tab stackTable
+	sum.Enter, _ = cs.encodeState(s, rec.ps, &tab, rec.deps)
+	ok := true
+	exits.ForEach(func(ps PathState) {
+		exit, ok1 := cs.encodeState(s, ps, &tab, rec.deps)
+		ok = ok && ok1
+		sum.Exits = append(sum.Exits, exit)
+	})
+	if !ok {
+		return nil
+	}
+	sum.Stacks = tab.stacks
+	for edge, infos := range rec.paths {
+		from, to := s.lca.Lookup(edge.fromId).String(), s.lca.Lookup(edge.toId).String()
+		for info := range infos {
+			fromStack, toStack := cs.encodeStack(info.fromStack, rec.deps), cs.encodeStack(info.toStack, rec.deps)
+			sum.Paths = append(sum.Paths, cachedPath{from, to, fromStack, toStack})
+		}
+	}
+	for fn := range rec.deps {
+		if cs.byName[fn.String()] != fn {
+			return nil
+		}
+	}
+	for fn := range rec.deps {
+		name := fn.String()
+		sum.Deps = append(sum.Deps, name)
+		if _, ok := cs.new.Funcs[name]; !ok {
+			cs.new.Funcs[name] = cs.funcKey(fn)
+		}
+	}
+	sort.Strings(sum.Deps)
+	return sum
+}
+
+// replayable returns the decoded summary of the walk with the given
+// key if it and the summaries of the walks it calls are still valid,
+// or nil otherwise.
+func (cs *analysisCacheState) replayable(s *state, key string) *replaySummary {
+	if cs.old == nil {
+		return nil
+	}
+	if rs, ok := cs.summaries[key]; ok {
+		return rs
+	}
+	// Summaries can't refer to themselves, but don't recurse
+	// forever on a corrupted cache.
+	cs.summaries[key] = nil
+	rs := cs.decode(s, key)
+	cs.summaries[key] = rs
+	return rs
+}
+
+// decode decodes the summary of the walk with the given key from the
+// old cache. It returns nil if the summary is invalid.
+func (cs *analysisCacheState) decode(s *state, key string) *replaySummary {
+	sum := cs.old.Walks[key]
+	if sum == nil {
+		return nil
+	}
+	for _, name := range sum.Deps {
+		if !cs.funcValid(name) {
+			return nil
+		}
+	}
+	rs := &replaySummary{key: key, sum: sum, fn: cs.byName[sum.Func], exits: NewPathStateSet()}
+	if rs.fn == nil {
+		return nil
+	}
+	for _, frames := range sum.Stacks {
+		sf, ok := cs.decodeStack(frames)
+		if !ok {
+			return nil
+		}
+		rs.stacks = append(rs.stacks, sf)
+	}
+	var ok bool
+	if rs.enter, ok = cs.decodeState(s, sum.Enter, rs.stacks); !ok {
+		return nil
+	}
+	for _, exit := range sum.Exits {
+		ps, ok := cs.decodeState(s, exit, rs.stacks)
+		if !ok {
+			return nil
+		}
+		rs.exits.Add(ps)
+	}
+	for _, p := range sum.Paths {
+		fromStack, ok1 := cs.decodeStack(p.FromStack)
+		toStack, ok2 := cs.decodeStack(p.ToStack)
+		if !ok1 || !ok2 {
+			return nil
+		}
+		edge := lockOrderEdge{s.lca.Named(p.From).Id(), s.lca.Named(p.To).Id()}
+		rs.paths = append(rs.paths, replayPath{edge, lockOrderInfo{fromStack.Intern(), toStack.Intern()}})
+	}
+	for _, name := range sum.AddedRoots {
+		fn := cs.byName[name]
+		if fn == nil {
+			return nil
+		}
+		rs.added = append(rs.added, fn)
+	}
+	for _, call := range sum.Calls {
+		if cs.replayable(s, call) == nil {
+			return nil
+		}
+	}
+	return rs
+}
+
+// conflicts reports whether replaying rs would replay a walk that is
+// in progress. seen is the set of walks already checked.
+func (cs *analysisCacheState) conflicts(rs *replaySummary, seen map[string]bool) bool {
+	if cs.active[rs.key] > 0 {
+		return true
+	}
+	for _, call := range rs.sum.Calls {
+		if cs.new.Walks[call] == nil && !seen[call] {
+			seen[call] = true
+			if cs.conflicts(cs.summaries[call], seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exitStates returns the exit states of rs for a walk entered in ps.
+// Locks held on entry keep their stacks from ps, and other lock stacks
+// are new, just as if the function were walked again.
+func (cs *analysisCacheState) exitStates(s *state, rs *replaySummary, ps PathState) *PathStateSet {
+	stacks := make([]*StackFrame, len(rs.stacks))
+	for i, sf := range rs.stacks {
+		if sf != nil {
+			stacks[i] = sf.parent.Extend(sf.call)
+		}
+	}
+	for _, lock := range rs.sum.Enter.Locks {
+		stacks[lock.Stack] = ps.lockSet.stacks[s.lca.Named(lock.Class).Id()]
+	}
+	exits := NewPathStateSet()
+	for _, exit := range rs.sum.Exits {
+		// decode already checked that this succeeds.
+		ps, _ := cs.decodeState(s, exit, stacks)
+		exits.Add(ps)
+	}
+	return exits
+}
+
+// replay replays rs into s, along with the summaries of the walks it
+// calls that haven't been replayed or summarized yet.
+func (cs *analysisCacheState) replay(s *state, rs *replaySummary) {
+	for _, call := range rs.sum.Calls {
+		if cs.new.Walks[call] == nil {
+			cs.replay(s, cs.summaries[call])
+		}
+	}
+
+	for _, msg := range rs.sum.Messages {
+		s.printMessage(msg.Key, msg.Text)
+	}
+	for _, p := range rs.paths {
+		s.lockOrder.addPath(&s.lca, p.edge, p.info)
+	}
+	for _, fn := range rs.added {
+		s.addRoot(fn)
+	}
+
+	// Memoize the walk so it isn't replayed again.
+	fInfo := s.info(rs.fn)
+	if fInfo.exitStates.Get(rs.enter) == nil {
+		if fInfo.records == nil {
+			fInfo.records = NewPathStateMap()
+		}
+		fInfo.exitStates.Set(rs.enter, rs.exits)
+		fInfo.records.Set(rs.enter, &walkRecord{key: rs.key, depth: -1, summarized: true})
+	}
+
+	cs.new.Walks[rs.key] = rs.sum
+	for _, name := range rs.sum.Deps {
+		cs.new.Funcs[name] = cs.old.Funcs[name]
+	}
+}
+
+// encodeState encodes ps, which must have block and mask set to nil
+// and ps.vs restricted to heap values. It adds ps's lock stacks to tab
+// and the functions the encoding refers to to deps. It reports false
+// if ps can't be encoded.
+func (cs *analysisCacheState) encodeState(s *state, ps PathState, tab *stackTable, deps map[*ssa.Function]bool) (cachedPathState, bool) {
+	var out cachedPathState
+	ls := ps.lockSet
+	var ids []int
+	for i := 0; i < ls.bits.BitLen(); i++ {
+		if ls.bits.Bit(i) != 0 {
+			ids = append(ids, i)
+		}
+	}
+	// Number the stacks in a deterministic order.
+	sort.Slice(ids, func(i, j int) bool { return ls.lca.Lookup(ids[i]).String() < ls.lca.Lookup(ids[j]).String() })
+	for _, id := range ids {
+		class := ls.lca.Lookup(id).String()
+		if len(out.Locks) > 0 && out.Locks[len(out.Locks)-1].Class == class {
+			// Lock classes are decoded by label.
+			return out, false
+		}
+		sf := ls.stacks[id]
+		index, ok := tab.index[sf]
+		if !ok {
+			if tab.index == nil {
+				tab.index = make(map[*StackFrame]int)
+			}
+			index = len(tab.stacks)
+			tab.index[sf] = index
+			tab.stacks = append(tab.stacks, cs.encodeStack(sf, deps))
+		}
+		out.Locks = append(out.Locks, cachedLock{class, index})
+	}
+
+	for h, val := range ps.vs.heap.flatten() {
+		if cs.heap[h.label] != h {
+			return out, false
+		}
+		cval, ok := cs.encodeValue(val)
+		if !ok {
+			return out, false
+		}
+		out.Heap = append(out.Heap, cachedHeapValue{h.label, cval})
+	}
+	sort.Slice(out.Heap, func(i, j int) bool { return out.Heap[i].Object < out.Heap[j].Object })
+	return out, true
+}
+
+// decodeState decodes a PathState encoded by encodeState, given its
+// decoded lock stacks.
+func (cs *analysisCacheState) decodeState(s *state, in cachedPathState, stacks []*StackFrame) (PathState, bool) {
+	ls := NewLockSet()
+	for _, lock := range in.Locks {
+		if lock.Stack < 0 || lock.Stack >= len(stacks) {
+			return PathState{}, false
+		}
+		ls = ls.Plus(s.lca.Named(lock.Class), stacks[lock.Stack])
+	}
+	var vs ValState
+	for _, hv := range in.Heap {
+		h := cs.heap[hv.Object]
+		val, ok := cs.decodeValue(hv.Value)
+		if h == nil || !ok {
+			return PathState{}, false
+		}
+		vs = vs.ExtendHeap(h, val)
+	}
+	return PathState{lockSet: ls, vs: vs}, true
+}
+
+func (cs *analysisCacheState) encodeValue(val DynValue) (cachedValue, bool) {
+	switch val := val.(type) {
+	case DynConst:
+		switch kind := val.c.Kind(); kind {
+		case constant.Bool, constant.String, constant.Int:
+			return cachedValue{Kind: "const", ConstKind: kind, Const: val.c.ExactString()}, true
+		}
+	case DynNil:
+		return cachedValue{Kind: "nil"}, true
+	case DynGlobal:
+		if name := val.global.String(); cs.globals[name] == val.global {
+			return cachedValue{Kind: "global", Global: name}, true
+		}
+	case DynFieldAddr:
+		if name := val.object.String(); cs.globals[name] == val.object {
+			return cachedValue{Kind: "field", Global: name, Field: val.field}, true
+		}
+	case DynHeapPtr:
+		if cs.heap[val.elem.label] == val.elem {
+			return cachedValue{Kind: "heap", Object: val.elem.label}, true
+		}
+	case DynStruct:
+		out := cachedValue{Kind: "struct"}
+		for name, h := range val {
+			if cs.heap[h.label] != h {
+				return cachedValue{}, false
+			}
+			out.Fields = append(out.Fields, cachedField{name, h.label})
+		}
+		sort.Slice(out.Fields, func(i, j int) bool { return out.Fields[i].Name < out.Fields[j].Name })
+		return out, true
+	}
+	return cachedValue{}, false
+}
+
+func (cs *analysisCacheState) decodeValue(val cachedValue) (DynValue, bool) {
+	switch val.Kind {
+	case "const":
+		var c constant.Value
+		switch val.ConstKind {
+		case constant.Bool:
+			c = constant.MakeBool(val.Const == "true")
+		case constant.String:
+			c = constant.MakeFromLiteral(val.Const, token.STRING, 0)
+		case constant.Int:
+			c = constant.MakeFromLiteral(val.Const, token.INT, 0)
+		}
+		if c == nil || c.Kind() != val.ConstKind {
+			return nil, false
+		}
+		return DynConst{c}, true
+	case "nil":
+		return DynNil{}, true
+	case "global":
+		if g := cs.globals[val.Global]; g != nil {
+			return DynGlobal{g}, true
+		}
+	case "field":
+		if g := cs.globals[val.Global]; g != nil {
+			return DynFieldAddr{g, val.Field}, true
+		}
+	case "heap":
+		if h := cs.heap[val.Object]; h != nil {
+			return DynHeapPtr{h}, true
+		}
+	case "struct":
+		out := make(DynStruct)
+		for _, f := range val.Fields {
+			h := cs.heap[f.Object]
+			if h == nil {
+				return nil, false
+			}
+			out[f.Name] = h
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// encodeStack encodes sf and adds the functions it refers to to deps.
+func (cs *analysisCacheState) encodeStack(sf *StackFrame, deps map[*ssa.Function]bool) []cachedFrame {
+	var frames []cachedFrame
+	for _, instr := range sf.Flatten(nil) {
+		fr, ok := cs.frames[instr]
+		if !ok {
+			b := instr.Block()
+			fr = cachedFrame{Func: instr.Parent().String(), Block: b.Index, Instr: -1}
+			for i, instr2 := range b.Instrs {
+				if instr2 == instr {
+					fr.Instr = i
+				}
+			}
+			cs.frames[instr] = fr
+		}
+		frames = append(frames, fr)
+		deps[instr.Parent()] = true
+	}
+	return frames
+}
+
+// decodeStack decodes a stack encoded by encodeStack.
+func (cs *analysisCacheState) decodeStack(frames []cachedFrame) (*StackFrame, bool) {
+	var sf *StackFrame
+	for _, fr := range frames {
+		instr := cs.frame(fr)
+		if instr == nil {
+			return nil, false
+		}
+		sf = sf.Extend(instr)
+	}
+	return sf, true
+}
+
+// save writes the new cache to disk.
+func (cs *analysisCacheState) save() {
+	if err := os.MkdirAll(filepath.Dir(cs.path), 0777); err != nil {
+		log.Print("writing analysis cache: ", err)
+		return
+	}
+	f, err := ioutil.TempFile(filepath.Dir(cs.path), filepath.Base(cs.path)+".tmp")
+	if err != nil {
+		log.Print("writing analysis cache: ", err)
+		return
+	}
+	if err := gob.NewEncoder(f).Encode(cs.new); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		log.Print("writing analysis cache: ", err)
+		return
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		log.Print("writing analysis cache: ", err)
+		return
+	}
+	if err := os.Rename(f.Name(), cs.path); err != nil {
+		os.Remove(f.Name())
+		log.Print("writing analysis cache: ", err)
+	}
+}
+
+// packageHashes returns the content hash of each package in pkgs and
+// their dependencies, taking into account overlay. Each package's hash
+// covers its compiled source files and the hashes of its imports.
+func packageHashes(pkgs []*packages.Package, overlay map[string][]byte) map[string]string {
+	hashes := make(map[string]string)
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		h := sha256.New()
+		fmt.Fprintf(h, "package %s\n", pkg.PkgPath)
+		var imports []string
+		for path := range pkg.Imports {
+			imports = append(imports, path)
+		}
+		sort.Strings(imports)
+		for _, path := range imports {
+			// Visit calls this in post-order, so
+			// dependencies have already been hashed.
+			fmt.Fprintf(h, "import %s %s\n", path, hashes[pkg.Imports[path].PkgPath])
+		}
+		for _, path := range pkg.CompiledGoFiles {
+			data, ok := overlay[path]
+			if !ok {
+				var err error
+				data, err = ioutil.ReadFile(path)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			fmt.Fprintf(h, "file %s %d\n", path, len(data))
+			h.Write(data)
+		}
+		hashes[pkg.PkgPath] = fmt.Sprintf("%x", h.Sum(nil))
+	})
+	return hashes
+}
@@ -0,0 +1,122 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// This file implements checks for operations that are unsafe in
+// restricted execution contexts.
+//
+// Nosplit functions can't grow the stack, and often run in states
+// where the runtime can't safely block or be preempted, such as
+// without a P or with inconsistent scheduler state. Signal handlers
+// may interrupt the thread at any point, including while it holds a
+// lock, so acquiring a lock in a signal handler can self-deadlock.
+//
+// In both contexts, rtcheck reports acquiring a lock (all runtime
+// locks may sleep) and calling the functions in contextUnsafeFuncs.
+
+// signalRoots are the runtime functions that are entered from signal
+// handlers. They're analyzed as additional roots.
+var signalRoots = []string{
+	"sigtrampgo",   // Unix and Windows
+	"sigprofNonGo", // Unix, from cgo traceback
+}
+
+// contextUnsafeFuncs maps from function name to a description of the
+// unbounded work calling it does.
+var contextUnsafeFuncs = map[string]string{
+	"runtime.mallocgc":     "allocates",
+	"runtime.newobject":    "allocates",
+	"runtime.gopark":       "parks",
+	"runtime.goparkunlock": "parks",
+	"runtime.notesleep":    "sleeps",
+	"runtime.notetsleepg":  "sleeps",
+	"runtime.semacquire":   "sleeps",
+	"runtime.gcStart":      "starts a GC",
+}
+
+// nosplitDecl identifies a function declared go:nosplit by the
+// position of its name.
+type nosplitDecl struct {
+	file string
+	line int
+}
+
+// nosplitDecls is the set of go:nosplit functions found by
+// rewriteSources.
+var nosplitDecls = make(map[nosplitDecl]bool)
+
+// recordNosplit records the go:nosplit declarations among decls.
+func recordNosplit(fset *token.FileSet, isNosplit map[ast.Decl]bool) {
+	for decl := range isNosplit {
+		if decl, ok := decl.(*ast.FuncDecl); ok {
+			pos := fset.Position(decl.Name.Pos())
+			nosplitDecls[nosplitDecl{pos.Filename, pos.Line}] = true
+		}
+	}
+}
+
+// isNosplit reports whether fn is a go:nosplit function.
+func (s *state) isNosplit(fn *ssa.Function) bool {
+	if fn.Parent() != nil {
+		// Closures are never nosplit.
+		return false
+	}
+	pos := s.fset.Position(fn.Pos())
+	return nosplitDecls[nosplitDecl{pos.Filename, pos.Line}]
+}
+
+// walkRoot walks root, starting in state ps. Signal roots are
+// walked in signal context.
+func (s *state) walkRoot(root *ssa.Function, ps PathState) *PathStateSet {
+	if !s.signalRoots[root] {
+		return s.walkFunction(root, ps)
+	}
+	// Walks memoized outside a signal handler didn't check for
+	// signal context, so use a separate memoization cache.
+	if s.signalFns == nil {
+		s.signalFns = make(map[*ssa.Function]*funcInfo)
+	}
+	fns := s.fns
+	s.fns, s.inSignal = s.signalFns, true
+	defer func() { s.fns, s.inSignal = fns, false }()
+	return s.walkFunction(root, ps)
+}
+
+// checkContext reports if the current call, which does what, is in a
+// nosplit or signal context. s.stack must be the call's stack. Many
+// paths can reach the same call, so this reports each call only once
+// per context, with the stack of the first path that reaches it.
+func (s *state) checkContext(what string) {
+	if !s.checkContexts || s.stack == nil {
+		return
+	}
+	call := s.stack.call
+	pos := s.fset.Position(call.Pos())
+	if s.inSignal {
+		key := fmt.Sprintf("%s: %s in signal handler", pos, what)
+		s.warnpKey(key, call.Pos(), "%s in signal handler", what)
+	}
+	if s.isNosplit(call.Parent()) {
+		// Show the chain of nosplit callers.
+		var chain []string
+		for sf := s.stack; sf != nil && s.isNosplit(sf.call.Parent()); sf = sf.parent {
+			chain = append(chain, sf.call.Parent().String())
+		}
+		for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+			chain[i], chain[j] = chain[j], chain[i]
+		}
+		key := fmt.Sprintf("%s: %s in nosplit function", pos, what)
+		s.warnpKey(key, call.Pos(), "%s in nosplit function (%s)", what, strings.Join(chain, " -> "))
+	}
+}
@@ -46,6 +46,15 @@ func init() {
 		"runtime.lock":   handleRuntimeLock,
 		"runtime.unlock": handleRuntimeUnlock,
 
+		// Since Go 1.15, lock and unlock are wrappers around
+		// these, which some code calls directly. They take
+		// the lock as their first argument, just like lock
+		// and unlock.
+		"runtime.lockWithRank":   handleRuntimeLock,
+		"runtime.unlockWithRank": handleRuntimeUnlock,
+		"runtime.lock2":          handleRuntimeLock,
+		"runtime.unlock2":        handleRuntimeUnlock,
+
 		"runtime.casgstatus":          handleRuntimeCasgstatus,
 		"runtime.castogscanstatus":    handleRuntimeCastogscanstatus,
 		"runtime.casfrom_Gscanstatus": handleRuntimeCasfrom_Gscanstatus,
@@ -73,6 +82,7 @@ func handleRuntimeLock(s *state, ps PathState, instr ssa.Instruction, newps []Pa
 	if err != nil {
 		s.warnl(instr.Pos(), "%s", err)
 	} else {
+		s.checkContext("acquires " + lock.String())
 		newls := NewLockSet().Plus(lock, s.stack)
 		s.lockOrder.Add(ps.lockSet, newls, s.stack)
 		ls2 := ps.lockSet.Plus(lock, s.stack)
@@ -0,0 +1,188 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+//go:embed static
+var staticFS embed.FS
+
+// An htmlView is one rendering of the lock graph in the HTML report.
+type htmlView struct {
+	Title string
+	SVG   template.HTML `json:"-"`
+
+	// Edges maps from SVG edge element ID to indexes of lock
+	// edges in the report.
+	Edges map[string][]int
+
+	// Groups maps from SVG node element ID to the index of the
+	// view showing that group.
+	Groups map[string]int
+}
+
+// WriteToHTML writes a self-contained, interactive HTML lock graph
+// report to w. It requires dot to be in $PATH.
+//
+// The full lock graph is often too dense to read, so the report
+// includes several views of the graph: a condensed graph where each
+// strongly connected component (a set of locks with cycles between
+// them) is collapsed into a single node, a graph of each strongly
+// connected component on its own, and the full graph.
+func (lo *LockOrder) WriteToHTML(w io.Writer) {
+	// Construct JSON for lock graph details. This is about an
+	// order of magnitude smaller than the naive renderedFrames.
+	jsonStrings := NewStringSpace()
+	// To save space, we use a struct of arrays.
+	type jsonStack struct {
+		Op     []int
+		PathID []int `json:"P"`
+		Line   []int `json:"L"`
+	}
+	xFrames := func(rs []renderedFrame) jsonStack {
+		out := jsonStack{
+			make([]int, len(rs)),
+			make([]int, len(rs)),
+			make([]int, len(rs)),
+		}
+		for i, r := range rs {
+			out.Op[i] = jsonStrings.Intern(r.Op)
+			out.PathID[i] = jsonStrings.Intern(r.Pos.Filename)
+			out.Line[i] = r.Pos.Line
+		}
+		return out
+	}
+	type jsonPath struct {
+		RootFn   int
+		From, To jsonStack
+	}
+	xPath := func(r renderedPath) jsonPath {
+		return jsonPath{jsonStrings.Intern(r.RootFn), xFrames(r.From), xFrames(r.To)}
+	}
+	type jsonEdge struct {
+		Locks [2]string
+		Cycle bool
+		Paths []jsonPath
+	}
+	jsonEdges := []jsonEdge{}
+	edgeIndex := make(map[lockOrderEdge]int)
+	for edge, infos := range lo.m {
+		var paths []jsonPath
+		for info := range infos {
+			paths = append(paths, xPath(lo.renderInfo(edge, info)))
+		}
+		edgeIndex[edge] = len(jsonEdges)
+		jsonEdges = append(jsonEdges, jsonEdge{
+			Locks: [2]string{lo.name(edge.fromId), lo.name(edge.toId)},
+			Cycle: lo.inCycle(edge),
+			Paths: paths,
+		})
+	}
+
+	// Construct the views.
+	sccs := lo.SCCs()
+	condensed := &dotView{
+		prefix:     "v0-",
+		group:      make(map[int]int),
+		groupLabel: make(map[int]string),
+	}
+	sccViews := make([]*dotView, len(sccs))
+	for i, scc := range sccs {
+		names := make([]string, len(scc))
+		inSCC := make(map[int]bool)
+		for j, id := range scc {
+			condensed.group[id] = i
+			names[j] = lo.name(id)
+			inSCC[id] = true
+		}
+		condensed.groupLabel[i] = fmt.Sprintf("cycle %d (%d locks)\n%s", i+1, len(scc), strings.Join(names, "\n"))
+		sccViews[i] = &dotView{
+			prefix:  fmt.Sprintf("v%d-", i+1),
+			include: func(id int) bool { return inSCC[id] },
+		}
+	}
+	full := &dotView{prefix: fmt.Sprintf("v%d-", len(sccs)+1)}
+
+	var views []htmlView
+	addView := func(title string, view *dotView) {
+		svg, ids := lo.renderSVG(view)
+		hv := htmlView{
+			Title:  title,
+			SVG:    template.HTML(svg),
+			Edges:  make(map[string][]int),
+			Groups: make(map[string]int),
+		}
+		for id, edges := range ids.edges {
+			for _, edge := range edges {
+				hv.Edges[id] = append(hv.Edges[id], edgeIndex[edge])
+			}
+		}
+		for id, g := range ids.groups {
+			// The view of SCC g follows the condensed
+			// view.
+			hv.Groups[id] = 1 + g
+		}
+		views = append(views, hv)
+	}
+	addView("Condensed (cycles collapsed)", condensed)
+	for i, view := range sccViews {
+		addView(fmt.Sprintf("Cycle %d (%d locks)", i+1, len(sccs[i])), view)
+	}
+	addView("Full graph", full)
+
+	// Generate HTML.
+	tmpl, err := template.ParseFS(staticFS, "static/tmpl-order.html")
+	if err != nil {
+		log.Fatal("loading HTML templates: ", err)
+	}
+	mainJS, err := staticFS.ReadFile("static/main.js")
+	if err != nil {
+		log.Fatal("loading main.js: ", err)
+	}
+	err = tmpl.Execute(w, map[string]interface{}{
+		"views":   views,
+		"strings": jsonStrings.s,
+		"edges":   jsonEdges,
+		"mainJS":  template.JS(mainJS),
+	})
+	if err != nil {
+		log.Fatal("executing HTML template: ", err)
+	}
+}
+
+// renderSVG renders view of the lock graph to SVG using dot.
+func (lo *LockOrder) renderSVG(view *dotView) ([]byte, dotIDs) {
+	cmd := exec.Command("dot", "-Tsvg")
+	dotin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Fatal("creating pipe to dot: ", err)
+	}
+	dotDone := make(chan bool)
+	var ids dotIDs
+	go func() {
+		ids = lo.writeToDot(dotin, view)
+		dotin.Close()
+		dotDone <- true
+	}()
+	svg, err := cmd.Output()
+	if err != nil {
+		log.Fatal("error running dot: ", err)
+	}
+	<-dotDone
+	// Strip stuff before the SVG tag so we can put it into HTML.
+	if i := bytes.Index(svg, []byte("<svg")); i > 0 {
+		svg = svg[i:]
+	}
+	return svg, ids
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"os"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/callgraph/vta"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// atomicPkgs are the possible import paths of the runtime's atomic
+// package. Its assembly functions are replaced with Go stubs.
+var atomicPkgs = map[string]bool{
+	"runtime/internal/atomic": true, // Pre-1.23
+	"internal/runtime/atomic": true,
+}
+
+// loadRuntime loads the runtime package and its dependencies, rewrites
+// the runtime for analysis (see rewriteSources), and builds the SSA
+// form of the whole program. roots are the runtime functions to call
+// from the rewritten runtime. It also returns the content hash of
+// each package (see packageHashes).
+//
+// TODO: Check all reasonable arch/OS combos.
+func loadRuntime(roots []string) (*ssa.Program, *ssa.Package, map[string]string) {
+	// Find the source files to rewrite.
+	conf := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps,
+	}
+	pkgs, err := packages.Load(conf, "runtime")
+	if err != nil {
+		log.Fatal("loading runtime: ", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		os.Exit(1)
+	}
+	overlay := make(map[string][]byte)
+	packages.Visit(pkgs, nil, func(pkg *packages.Package) {
+		switch {
+		case pkg.PkgPath == "runtime":
+			rewriteSources(pkg, roots, overlay)
+		case atomicPkgs[pkg.PkgPath]:
+			rewriteSources(pkg, nil, overlay)
+		}
+	})
+
+	// Load the rewritten sources. The overlay is applied both
+	// when the go command lists packages and when they are
+	// parsed.
+	conf = &packages.Config{
+		Mode:    packages.LoadAllSyntax,
+		Overlay: overlay,
+	}
+	pkgs, err = packages.Load(conf, "runtime")
+	if err != nil {
+		log.Fatal("loading runtime: ", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		os.Exit(1)
+	}
+
+	// Build SSA. Generic functions are analyzed through their
+	// instantiations.
+	prog, ssaPkgs := ssautil.AllPackages(pkgs, ssa.InstantiateGenerics)
+	prog.Build()
+	return prog, ssaPkgs[0], packageHashes(pkgs, overlay)
+}
+
+// buildCallGraph returns the call graph of prog.
+//
+// This uses variable type analysis, which refines the class hierarchy
+// analysis call graph by tracking which function values and concrete
+// types flow to each call site.
+func buildCallGraph(prog *ssa.Program) *callgraph.Graph {
+	cg := vta.CallGraph(ssautil.AllFunctions(prog), cha.CallGraph(prog))
+	cg.DeleteSyntheticNodes()
+	return cg
+}
@@ -56,6 +56,10 @@ type lockClassKey struct {
 type LockClassAnalysis struct {
 	classes map[lockClassKey]*LockClass
 	list    []*LockClass
+
+	// named is the set of classes created by Named that Get has
+	// not yet resolved a lock to, indexed by label.
+	named map[string]*LockClass
 }
 
 // Get returns the LockClass of the given ssa.Value, which must be a
@@ -104,6 +108,9 @@ loop:
 			if !ok {
 				return nil, fmt.Errorf("lock is a field of an unnamed struct")
 			}
+			// All instantiations of a generic type share
+			// a lock class.
+			styp = styp.Origin()
 			sname := styp.Obj().Name()
 			label = append(label, styp.Obj().Pkg().Name()+"."+sname)
 			key = lockClassKey{parent: key, typ: styp}
@@ -122,6 +129,12 @@ loop:
 	for i := 0; i < len(label)/2; i++ {
 		label[i], label[len(label)-i-1] = label[len(label)-i-1], label[i]
 	}
+	if lc, ok := a.named[strings.Join(label, ".")]; ok {
+		lc.isUnique = isUnique
+		delete(a.named, lc.label)
+		a.classes[key] = lc
+		return lc, nil
+	}
 	lc := &LockClass{
 		label:    strings.Join(label, "."),
 		isUnique: isUnique,
@@ -147,6 +160,29 @@ func (a *LockClassAnalysis) NewLockClass(label string, isUnique bool) *LockClass
 	return lc
 }
 
+// Named returns the lock class with the given label, creating it if
+// there is no such class. If Get later resolves a lock with this
+// label, it returns the same class. label may have the "*" suffix
+// printed by LockClass.String for non-unique classes.
+//
+// This is used to refer to lock classes by name, such as from an
+// annotations file.
+func (a *LockClassAnalysis) Named(label string) *LockClass {
+	isUnique := !strings.HasSuffix(label, "*")
+	label = strings.TrimSuffix(label, "*")
+	for _, lc := range a.list {
+		if lc.label == label {
+			return lc
+		}
+	}
+	lc := a.NewLockClass(label, isUnique)
+	if a.named == nil {
+		a.named = make(map[string]*LockClass)
+	}
+	a.named[label] = lc
+	return lc
+}
+
 // Lookup returns the *LockClass whose Id() is id.
 func (a *LockClassAnalysis) Lookup(id int) *LockClass {
 	return a.list[id]
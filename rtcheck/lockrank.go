@@ -0,0 +1,384 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// LockRanks is the runtime's own static lock ranking, as declared in
+// its lockrank.go, together with the lock classes that the runtime
+// assigns to each rank.
+//
+// The runtime checks two things when a lock is acquired (see
+// checkRanks in lockrank_on.go): that its rank is no lower than the
+// rank of the most recently acquired lock, and that the held rank
+// appears in lockPartialOrder for the new rank. lockPartialOrder is
+// transitively closed, so this is equivalent to checking every held
+// lock.
+type LockRanks struct {
+	// names maps from rank value to the name of the rank.
+	names map[int64]string
+
+	// partial maps from each rank to the set of ranks that may
+	// be held when acquiring it.
+	partial map[int64]map[int64]bool
+
+	// leaf is the value of lockRankLeafRank, or -1 if the runtime
+	// doesn't have one. Any non-leaf lock may be held when
+	// acquiring a leaf lock.
+	leaf int64
+
+	// classRanks maps from lock class ID to the ranks assigned to
+	// that class by lockInit and friends.
+	classRanks map[int][]int64
+
+	lca *LockClassAnalysis
+}
+
+// rankFuncs are the runtime functions that assign a rank to the lock
+// passed as their first argument. The rank is the second argument.
+var rankFuncs = map[string]bool{
+	"runtime.lockInit":               true,
+	"runtime.lockWithRank":           true,
+	"runtime.lockWithRankMayAcquire": true,
+}
+
+// loadLockRanks parses the lock rank declarations of runtimePkg and
+// finds the lock class of every lock that is assigned a constant rank
+// anywhere in prog.
+func loadLockRanks(prog *ssa.Program, runtimePkg *ssa.Package, lca *LockClassAnalysis) *LockRanks {
+	scope := runtimePkg.Pkg.Scope()
+	orderObj := scope.Lookup("lockPartialOrder")
+	if orderObj == nil {
+		log.Fatal("runtime does not declare lockPartialOrder; lock ranking requires Go 1.15 or later")
+	}
+	lr := &LockRanks{
+		names:      make(map[int64]string),
+		partial:    make(map[int64]map[int64]bool),
+		leaf:       -1,
+		classRanks: make(map[int][]int64),
+		lca:        lca,
+	}
+
+	// rankValue returns the value of the rank constant named by
+	// expr.
+	rankValue := func(expr ast.Expr) (int64, bool) {
+		id, ok := expr.(*ast.Ident)
+		if !ok {
+			return 0, false
+		}
+		c, ok := scope.Lookup(id.Name).(*types.Const)
+		if !ok {
+			return 0, false
+		}
+		return constant.Int64Val(c.Val())
+	}
+
+	// Collect the rank constants. Their names are the fallback if
+	// the runtime doesn't have a lockNames table.
+	for _, name := range scope.Names() {
+		if !strings.HasPrefix(name, "lockRank") {
+			continue
+		}
+		c, ok := scope.Lookup(name).(*types.Const)
+		if !ok {
+			continue
+		}
+		val, ok := constant.Int64Val(c.Val())
+		if !ok {
+			continue
+		}
+		lr.names[val] = name
+		if name == "lockRankLeafRank" {
+			lr.leaf = val
+		}
+	}
+
+	// The rank tables are initialized by composite literals, so
+	// parse them from source rather than trying to recover them
+	// from the SSA of the package initializer.
+	path := prog.Fset.Position(orderObj.Pos()).Filename
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, decl := range f.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.VAR {
+			continue
+		}
+		for _, spec := range decl.Specs {
+			spec := spec.(*ast.ValueSpec)
+			if len(spec.Names) != 1 || len(spec.Values) != 1 {
+				continue
+			}
+			lit, ok := spec.Values[0].(*ast.CompositeLit)
+			if !ok {
+				continue
+			}
+			for _, elt := range lit.Elts {
+				kv, ok := elt.(*ast.KeyValueExpr)
+				if !ok {
+					continue
+				}
+				rank, ok := rankValue(kv.Key)
+				if !ok {
+					continue
+				}
+				switch spec.Names[0].Name {
+				case "lockNames":
+					if bl, ok := kv.Value.(*ast.BasicLit); ok && bl.Kind == token.STRING {
+						if name, err := strconv.Unquote(bl.Value); err == nil {
+							lr.names[rank] = name
+						}
+					}
+				case "lockPartialOrder":
+					held := make(map[int64]bool)
+					if prevs, ok := kv.Value.(*ast.CompositeLit); ok {
+						for _, prev := range prevs.Elts {
+							if val, ok := rankValue(prev); ok {
+								held[val] = true
+							}
+						}
+					}
+					lr.partial[rank] = held
+				}
+			}
+		}
+	}
+	if len(lr.partial) == 0 {
+		log.Fatalf("%s: failed to parse lockPartialOrder", path)
+	}
+
+	// Find the ranks assigned to each lock class.
+	for fn := range ssautil.AllFunctions(prog) {
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				call, ok := instr.(*ssa.Call)
+				if !ok {
+					continue
+				}
+				callee := call.Call.StaticCallee()
+				if callee == nil || !rankFuncs[callee.String()] || len(call.Call.Args) < 2 {
+					continue
+				}
+				rc, ok := call.Call.Args[1].(*ssa.Const)
+				if !ok || rc.Value == nil {
+					// The rank is passed in from
+					// elsewhere. Its callers will
+					// be found separately if they
+					// use a constant.
+					continue
+				}
+				rank, ok := constant.Int64Val(rc.Value)
+				if !ok {
+					continue
+				}
+				lc, err := lca.Get(call.Call.Args[0])
+				if err != nil {
+					continue
+				}
+				lr.addClassRank(lc.Id(), rank)
+			}
+		}
+	}
+	return lr
+}
+
+func (lr *LockRanks) addClassRank(id int, rank int64) {
+	for _, r := range lr.classRanks[id] {
+		if r == rank {
+			return
+		}
+	}
+	lr.classRanks[id] = append(lr.classRanks[id], rank)
+	sort.Slice(lr.classRanks[id], func(i, j int) bool { return lr.classRanks[id][i] < lr.classRanks[id][j] })
+}
+
+// name returns the name of rank.
+func (lr *LockRanks) name(rank int64) string {
+	if name, ok := lr.names[rank]; ok {
+		return name
+	}
+	return fmt.Sprintf("rank%d", rank)
+}
+
+// allowed reports whether a lock of rank acquire may be acquired
+// while holding a lock of rank held.
+func (lr *LockRanks) allowed(held, acquire int64) bool {
+	if acquire < held {
+		return false
+	}
+	if acquire == lr.leaf {
+		return held < lr.leaf
+	}
+	return lr.partial[acquire][held]
+}
+
+// rankString returns the ranks of lock class id for use in reports.
+func (lr *LockRanks) rankString(id int) string {
+	var names []string
+	for _, rank := range lr.classRanks[id] {
+		names = append(names, lr.name(rank))
+	}
+	return strings.Join(names, "|")
+}
+
+// CheckRanks writes a report to w comparing the lock graph against
+// the runtime's declared lock ranks. It reports observed lock edges
+// that the ranking doesn't allow, declared rank edges that were
+// never observed, and observed locks that have no rank.
+func (lo *LockOrder) CheckRanks(w io.Writer, lr *LockRanks) {
+	// Sort the observed edges for a deterministic report.
+	var edges []lockOrderEdge
+	for edge := range lo.m {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].fromId != edges[j].fromId {
+			return edges[i].fromId < edges[j].fromId
+		}
+		return edges[i].toId < edges[j].toId
+	})
+
+	// Report classes with more than one rank. These are usually
+	// types whose instances are ranked differently, so the
+	// analysis can't tell them apart.
+	var ids []int
+	for id := range lr.classRanks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if len(lr.classRanks[id]) > 1 {
+			fmt.Fprintf(w, "lock %s has multiple ranks: %s\n", lr.lca.Lookup(id), lr.rankString(id))
+		}
+	}
+
+	// Report observed edges that violate the ranking. An edge
+	// between classes with several ranks is only reported if no
+	// combination of their ranks is allowed.
+	observed := make(map[[2]int64]bool)
+	unranked := make(map[int]bool)
+	nViolations := 0
+	for _, edge := range edges {
+		from, to := lr.classRanks[edge.fromId], lr.classRanks[edge.toId]
+		if from == nil {
+			unranked[edge.fromId] = true
+		}
+		if to == nil {
+			unranked[edge.toId] = true
+		}
+		if from == nil || to == nil {
+			continue
+		}
+		ok := false
+		for _, fr := range from {
+			for _, tr := range to {
+				observed[[2]int64{fr, tr}] = true
+				if lr.allowed(fr, tr) {
+					ok = true
+				}
+			}
+		}
+		if ok {
+			continue
+		}
+		nViolations++
+		fmt.Fprintf(w, "lock rank violation: %s (%s) -> %s (%s)\n", lo.name(edge.fromId), lr.rankString(edge.fromId), lo.name(edge.toId), lr.rankString(edge.toId))
+		lo.writePaths(w, edge)
+		fmt.Fprintf(w, "\n")
+	}
+
+	// Report declared edges that were never observed. Since
+	// lockPartialOrder is transitively closed, most of its edges
+	// are implied by others, so only report edges of its
+	// transitive reduction. Edges between ranks that have no lock
+	// class can't be observed, so they aren't reported
+	// individually.
+	hasClass := make(map[int64]bool)
+	for _, ranks := range lr.classRanks {
+		for _, rank := range ranks {
+			hasClass[rank] = true
+		}
+	}
+	var ranks []int64
+	for rank := range lr.partial {
+		ranks = append(ranks, rank)
+	}
+	sort.Slice(ranks, func(i, j int) bool { return ranks[i] < ranks[j] })
+	nUnobserved, nUncheckable, nImplied := 0, 0, 0
+	for _, rank := range ranks {
+		var held []int64
+		for prev := range lr.partial[rank] {
+			held = append(held, prev)
+		}
+		sort.Slice(held, func(i, j int) bool { return held[i] < held[j] })
+		for _, prev := range held {
+			if !hasClass[prev] || !hasClass[rank] {
+				nUncheckable++
+				continue
+			}
+			if observed[[2]int64{prev, rank}] {
+				continue
+			}
+			if lr.implied(prev, rank) {
+				nImplied++
+				continue
+			}
+			nUnobserved++
+			fmt.Fprintf(w, "lock rank edge not observed: %s -> %s\n", lr.name(prev), lr.name(rank))
+		}
+	}
+	var noClass []string
+	for _, rank := range ranks {
+		if !hasClass[rank] {
+			noClass = append(noClass, lr.name(rank))
+		}
+	}
+	if len(noClass) > 0 {
+		fmt.Fprintf(w, "ranks with no lock class: %s\n", strings.Join(noClass, " "))
+	}
+
+	// Report locks that appear in the lock graph but have no rank.
+	var unrankedNames []string
+	for id := range unranked {
+		unrankedNames = append(unrankedNames, lo.name(id))
+	}
+	sort.Strings(unrankedNames)
+	if len(unrankedNames) > 0 {
+		fmt.Fprintf(w, "locks with no rank: %s\n", strings.Join(unrankedNames, " "))
+	}
+
+	fmt.Fprintf(w, "\nlock rank violations: %d\n", nViolations)
+	fmt.Fprintf(w, "unobserved rank edges: %d (%d more are implied by other edges; %d more involve ranks with no lock class)\n", nUnobserved, nImplied, nUncheckable)
+}
+
+// implied reports whether the declared rank edge held -> acquire is
+// implied by other declared edges, that is, whether some other rank
+// may be acquired while holding held and may be held while acquiring
+// acquire.
+func (lr *LockRanks) implied(held, acquire int64) bool {
+	for mid := range lr.partial[acquire] {
+		if mid != held && mid != acquire && lr.partial[mid][held] {
+			return true
+		}
+	}
+	return false
+}
@@ -4,19 +4,12 @@
 
 // Command rtcheck performs static analysis of the Go runtime.
 //
-// Note: Currently this requires a small modification to
-// golang.org/x/tools/go/pointer:
-//
-//     --- a/go/pointer/intrinsics.go
-//     +++ b/go/pointer/intrinsics.go
-//     @@ -180,7 +180,6 @@ func (a *analysis) findIntrinsic(fn *ssa.Function) intrinsic {
-//      			// Ignore "runtime" (except SetFinalizer):
-//      			// it has few interesting effects on aliasing
-//      			// and is full of unsafe code we can't analyze.
-//     -			impl = ext۰NoEffect
-//      		}
-//
-//      		a.intrinsics[fn] = impl
+// rtcheck analyzes the runtime of the Go toolchain found by the go
+// command, for the GOOS and GOARCH in the environment. It loads the
+// runtime using golang.org/x/tools/go/packages, so it works both in
+// and out of module mode. Generic code is analyzed by instantiating
+// it, and calls through function values and interfaces are resolved
+// using variable type analysis (golang.org/x/tools/go/callgraph/vta).
 //
 // rtcheck currently implements one analysis:
 //
@@ -85,6 +78,46 @@
 // potential self-deadlock. Of course, if it requires complex dynamic
 // reasoning to show that a deadlock cannot occur at runtime, it may
 // be a good idea to simplify the code anyway.
+//
+// Lock rank cross-check
+//
+// Since Go 1.15, the runtime declares its own static lock ranking in
+// lockrank.go, which it checks dynamically when built with
+// GOEXPERIMENT=staticlockranking. With -lockrank, rtcheck assigns
+// ranks to lock classes by finding the runtime's calls to lockInit
+// and compares the lock graph against the declared ranking. It
+// reports lock graph edges that violate the ranking, ranking edges
+// that never appear in the lock graph, and locks that have no rank.
+// Violations are either runtime bugs or false positives of the
+// analysis, and unobserved ranking edges may be unnecessary or
+// indicate code the analysis can't see through.
+//
+// Annotations
+//
+// The -annotations flag reads a file of facts that refine the
+// analysis: lock orderings and code paths to omit from reports, and
+// the locks acquired by functions the analysis can't see through. See
+// Annotations for the file format. With an annotations file that
+// suppresses the known false positives, rtcheck can be run as a
+// check: it exits with status 1 if it reports any lock cycles.
+//
+// Restricted contexts
+//
+// With -contexts, rtcheck also reports acquiring locks and calling
+// functions that may block or do unbounded work (such as allocating)
+// from go:nosplit functions and from code reachable from signal
+// handlers. It analyzes the runtime's signal handler entry points as
+// additional roots for this. These reports are printed as warnings.
+//
+// Incremental analysis
+//
+// rtcheck caches a summary of each walk of a function in the user's
+// cache directory, and on later runs only re-walks the functions that
+// have changed or that reach functions that have changed. A function
+// has also changed if the call graph resolves any of its indirect
+// calls to a different set of callees. Loading the runtime and
+// building the call graph are not cached. The -cache flag sets the
+// cache directory, or disables the cache if set to "off".
 package main
 
 import (
@@ -92,7 +125,6 @@ import (
 	"flag"
 	"fmt"
 	"go/ast"
-	"go/build"
 	"go/constant"
 	"go/parser"
 	"go/printer"
@@ -102,17 +134,15 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
-	"runtime"
+	"sort"
 	"strings"
 
-	"golang.org/x/tools/go/buildutil"
 	"golang.org/x/tools/go/callgraph"
-	"golang.org/x/tools/go/loader"
-	"golang.org/x/tools/go/pointer"
+	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/ssa"
-	"golang.org/x/tools/go/ssa/ssautil"
 )
 
 // debugFunctions is a set of functions to enable extra debugging
@@ -126,10 +156,23 @@ func main() {
 		outCallGraph string
 		outHTML      string
 		debugFuncs   string
+		lockRank     bool
+		annotations  string
+		cacheDir     string
+		contexts     bool
 	)
+	if dir, err := os.UserCacheDir(); err == nil {
+		cacheDir = filepath.Join(dir, "rtcheck")
+	} else {
+		cacheDir = "off"
+	}
 	flag.StringVar(&outLockGraph, "lockgraph", "", "write lock graph in dot to `file`")
 	flag.StringVar(&outCallGraph, "callgraph", "", "write call graph in dot to `file`")
 	flag.StringVar(&outHTML, "html", "", "write HTML deadlock report to `file`")
+	flag.BoolVar(&lockRank, "lockrank", false, "cross-check the lock graph against the runtime's lock ranks")
+	flag.StringVar(&annotations, "annotations", "", "read analysis annotations from `file`")
+	flag.BoolVar(&contexts, "contexts", false, "check for locking in nosplit and signal handler contexts")
+	flag.StringVar(&cacheDir, "cache", cacheDir, "cache analysis results in `dir`, or \"off\" to disable")
 	flag.StringVar(&debugFuncs, "debugfuncs", "", "write debug graphs for `funcs` (comma-separated list)")
 	flag.Parse()
 	if flag.NArg() > 0 {
@@ -139,69 +182,25 @@ func main() {
 	for _, name := range strings.Split(debugFuncs, ",") {
 		debugFunctions[name] = true
 	}
-
-	roots := getDefaultRoots()
-
-	var conf loader.Config
-
-	// TODO: Check all reasonable arch/OS combos.
-
-	// TODO: This would be so much easier and nicer if I could
-	// just plug (path, AST)s into the loader, or at least slip in
-	// between when the loader has parsed everything and when it
-	// type-checks everything. Currently it's only possible to
-	// provide ASTs for non-importable packages to the
-	// loader.Config.
-
-	newSources := make(map[string][]byte)
-	for _, pkgName := range []string{"runtime", "runtime/internal/atomic"} {
-		buildPkg, err := build.Import(pkgName, "", 0)
-		if err != nil {
-			log.Fatal(err)
-		}
-		var pkgRoots []string
-		if pkgName == "runtime" {
-			pkgRoots = roots
-		}
-		rewriteSources(buildPkg, pkgRoots, newSources)
+	var an *Annotations
+	if annotations != "" {
+		an = readAnnotations(annotations)
 	}
 
-	ctxt := &build.Default
-	ctxt = buildutil.OverlayContext(ctxt, newSources)
-
-	conf.Build = ctxt
-	conf.Import("runtime")
+	roots := getDefaultRoots()
 
-	lprog, err := conf.Load()
-	if err != nil {
-		log.Fatal("loading runtime: ", err)
+	prog, runtimePkg, pkgHashes := loadRuntime(roots)
+	fset := prog.Fset
+	lookupMembers(runtimePkg, runtimeFns, optionalRuntimeFns)
+	if fns.mapassign == nil && fns.mapassign1 == nil {
+		log.Fatal("runtime has neither mapassign nor mapassign1")
 	}
-	fset := lprog.Fset
-
-	prog := ssautil.CreateProgram(lprog, 0)
-	prog.Build()
-	runtimePkg := prog.ImportedPackage("runtime")
-	lookupMembers(runtimePkg, runtimeFns)
 
 	// TODO: Teach it that you can jump to sigprof at any point?
 	//
 	// TODO: Teach it about implicit write barriers?
 
-	// Prepare for pointer analysis.
-	ptrConfig := pointer.Config{
-		Mains:          []*ssa.Package{runtimePkg},
-		BuildCallGraph: true,
-		//Log:            os.Stderr,
-	}
-
-	// Run pointer analysis.
-	pta, err := pointer.Analyze(&ptrConfig)
-	if err != nil {
-		log.Fatal(err)
-	}
-	cg := pta.CallGraph
-
-	cg.DeleteSyntheticNodes() // ?
+	cg := buildCallGraph(prog)
 
 	// Output call graph if requested.
 	if outCallGraph != "" {
@@ -209,7 +208,7 @@ func main() {
 			type edge struct{ a, b *callgraph.Node }
 			have := make(map[edge]struct{})
 			fmt.Fprintln(w, "digraph callgraph {")
-			callgraph.GraphVisitEdges(pta.CallGraph, func(e *callgraph.Edge) error {
+			callgraph.GraphVisitEdges(cg, func(e *callgraph.Edge) error {
 				if _, ok := have[edge{e.Caller, e.Callee}]; ok {
 					return nil
 				}
@@ -224,15 +223,34 @@ func main() {
 	s := state{
 		fset: fset,
 		cg:   cg,
-		pta:  pta,
 		fns:  make(map[*ssa.Function]*funcInfo),
 
 		lockOrder: NewLockOrder(fset),
 
 		roots:   nil,
 		rootSet: make(map[*ssa.Function]struct{}),
+
+		checkContexts: contexts,
+		signalRoots:   make(map[*ssa.Function]bool),
 	}
 	s.gscanLock = s.lca.NewLockClass("_Gscan", false)
+	if an != nil {
+		an.install(&s)
+	}
+
+	// Open the analysis cache. Debug output depends on actually
+	// walking functions, so don't use the cache when debugging.
+	if cacheDir != "off" && debugFuncs == "" {
+		key := goroot()
+		if contexts {
+			key += "\ncontexts"
+		}
+		if an != nil {
+			key += "\n" + an.hash
+		}
+		s.cache = openAnalysisCache(cacheDir, key, prog, cg, pkgHashes)
+		s.lockOrder.onAdd = s.cache.addPath
+	}
 
 	// Create heap objects we care about.
 	//
@@ -247,15 +265,27 @@ func main() {
 	curM_curg := NewHeapObject("curM.curg")
 	s.heap.curM_locks = NewHeapObject("curM.locks")
 	curM_printlock := NewHeapObject("curM.printlock")
+	if s.cache != nil {
+		s.cache.addHeap(s.heap.curG, userG, userG_m, s.heap.g0, g0_m, s.heap.curM, curM_g0, curM_curg, s.heap.curM_locks, curM_printlock)
+	}
 
 	// Add roots to state.
 	for _, name := range roots {
 		m, ok := runtimePkg.Members[name].(*ssa.Function)
 		if !ok {
-			log.Fatalf("unknown root: %s", name)
+			// rewriteSources already warned about this.
+			continue
 		}
 		s.addRoot(m)
 	}
+	if contexts {
+		for _, name := range signalRoots {
+			if m, ok := runtimePkg.Members[name].(*ssa.Function); ok {
+				s.signalRoots[m] = true
+				s.addRoot(m)
+			}
+		}
+	}
 
 	// Analyze each root. Analysis may add more roots.
 	for i := 0; i < len(s.roots); i++ {
@@ -283,7 +313,7 @@ func main() {
 		}
 
 		// Walk the function.
-		exitStates := s.walkFunction(root, ps)
+		exitStates := s.walkRoot(root, ps)
 
 		// Warn if any locks are held at return.
 		exitStates.ForEach(func(ps PathState) {
@@ -294,6 +324,9 @@ func main() {
 			s.warnl(root.Pos(), "\t(likely analysis failed to match control flow for unlock)")
 		})
 	}
+	if s.cache != nil {
+		s.cache.save()
+	}
 
 	// Dump debug trees.
 	if s.debugTree != nil {
@@ -306,6 +339,11 @@ func main() {
 		withWriter(fmt.Sprintf("debug-%s.dot", fn), fInfo.debugTree.WriteToDot)
 	}
 
+	// Remove suppressed edges before reporting.
+	if an != nil {
+		s.lockOrder.Suppress(an)
+	}
+
 	// Output lock graph.
 	if outLockGraph != "" {
 		withWriter(outLockGraph, s.lockOrder.WriteToDot)
@@ -323,8 +361,23 @@ func main() {
 		fmt.Printf(" %s", fn)
 	}
 	fmt.Print("\n")
-	fmt.Printf("number of lock cycles: %d\n\n", len(s.lockOrder.FindCycles()))
+	cycles := s.lockOrder.FindCycles()
+	fmt.Printf("number of lock cycles: %d\n\n", len(cycles))
 	s.lockOrder.Check(os.Stdout)
+
+	// Output lock rank report.
+	if lockRank {
+		lr := loadLockRanks(prog, runtimePkg, &s.lca)
+		fmt.Println()
+		s.lockOrder.CheckRanks(os.Stdout, lr)
+	}
+
+	if an != nil {
+		an.WriteUnused(os.Stdout)
+		if len(cycles) > 0 {
+			os.Exit(1)
+		}
+	}
 }
 
 // withWriter creates path and calls f with the file.
@@ -344,11 +397,24 @@ func withWriter(path string, f func(w io.Writer)) {
 // getDefaultRoots returns a list of functions in the runtime package
 // to use as roots.
 //
-// It parses $GOROOT/src/cmd/compile/internal/gc/builtin/runtime.go to
-// get this list, since these are the functions the compiler can
-// generate calls to.
+// It parses the compiler's builtin/runtime.go to get this list, since
+// these are the functions the compiler can generate calls to.
 func getDefaultRoots() []string {
-	path := filepath.Join(runtime.GOROOT(), "src/cmd/compile/internal/gc/builtin/runtime.go")
+	var path string
+	for _, p := range []string{
+		"src/cmd/compile/internal/typecheck/_builtin/runtime.go", // Go 1.20+
+		"src/cmd/compile/internal/typecheck/builtin/runtime.go",  // Go 1.17
+		"src/cmd/compile/internal/gc/builtin/runtime.go",
+	} {
+		p = filepath.Join(goroot(), p)
+		if _, err := os.Stat(p); err == nil {
+			path = p
+			break
+		}
+	}
+	if path == "" {
+		log.Fatalf("compiler builtin declarations not found in %s", goroot())
+	}
 	fset := token.NewFileSet()
 	f, err := parser.ParseFile(fset, path, nil, 0)
 	if err != nil {
@@ -381,19 +447,29 @@ func getDefaultRoots() []string {
 	return roots
 }
 
+// goroot returns the GOROOT of the Go toolchain used to load the
+// runtime.
+func goroot() string {
+	out, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		log.Fatal("go env GOROOT: ", err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
 // rewriteSources rewrites all of the Go files in pkg to eliminate
 // runtime-isms, make them easier for go/ssa to process, to add stubs
 // for internal functions, and to generate init-time calls to analysis
 // root functions. It fills rewritten with path -> new source
 // mappings.
-func rewriteSources(pkg *build.Package, roots []string, rewritten map[string][]byte) {
+func rewriteSources(pkg *packages.Package, roots []string, rewritten map[string][]byte) {
 	rootSet := make(map[string]struct{})
 	for _, root := range roots {
 		rootSet[root] = struct{}{}
 	}
 
-	for _, fname := range pkg.GoFiles {
-		path := filepath.Join(pkg.Dir, fname)
+	for _, path := range pkg.GoFiles {
+		fname := filepath.Base(path)
 
 		// Parse source.
 		fset := token.NewFileSet()
@@ -408,6 +484,7 @@ func rewriteSources(pkg *build.Package, roots []string, rewritten map[string][]b
 			addRootCalls(f, rootSet)
 			rewriteRuntime(f, isNosplit)
 		}
+		recordNosplit(fset, isNosplit)
 
 		// Back to source.
 		var buf bytes.Buffer
@@ -429,102 +506,47 @@ func rtcheck۰postsystemstack(*g) { }
 		rewritten[path] = buf.Bytes()
 	}
 
-	// Check that we found all of the roots.
+	// Report roots we didn't find. The set of functions the
+	// compiler calls that are implemented in assembly or
+	// provided by other packages changes from release to
+	// release, so this isn't fatal.
 	if len(rootSet) > 0 {
-		fmt.Fprintf(os.Stderr, "unknown roots:")
+		fmt.Fprintf(os.Stderr, "warning: roots not found in runtime:")
 		for root := range rootSet {
 			fmt.Fprintf(os.Stderr, " %s", root)
 		}
 		fmt.Fprintf(os.Stderr, "\n")
-		os.Exit(1)
 	}
 }
 
+// newStubs are bodies for assembly functions whose behavior matters
+// to the analysis, indexed by package name and function name. A stub
+// is used only if its signature matches the function's declaration;
+// other assembly functions get a body that returns zero values (see
+// rewriteStubs).
 var newStubs = make(map[string]map[string]*ast.FuncDecl)
 
+// noReturnStubs are assembly functions in the runtime that never
+// return. Their stubs loop forever.
+var noReturnStubs = map[string]bool{
+	"abort": true, "exit": true, "exit1": true, "exitThread": true,
+	"gogo": true, "jmpdefer": true, "raise": true, "raiseproc": true,
+	"sigreturn": true, "sigreturn__sigaction": true,
+}
+
 func init() {
 	// TODO: Perhaps I should do most of these as "special"
 	// functions, and do the few that affect pointers (like
 	// noescape) as call rewrites.
 
-	// Stubs provide implementations for assembly functions that
-	// are not declared in the Go source code. All of these are
-	// automatically marked go:nosplit.
+	// getg is handled specially. mcall and systemstack are
+	// eliminated during rewriting. morestack is handled
+	// specially.
 	var runtimeStubs = `
 package runtime
 
 // stubs.go
-// getg is handled specially.
-// mcall and systemstack are eliminated during rewriting.
-func memclr() { }
-func memmove() { }
-func fastrand1() uint32 { return 0 }
-func memequal() bool { return false }
 func noescape(p unsafe.Pointer) unsafe.Pointer { return p }
-func cgocallback() { }
-func gogo() { for { } }
-func gosave() { }
-func mincore() int32 { return 0 }
-func jmpdefer() { for { } }
-func exit1() { for { } }
-func setg() { }
-func breakpoint() { }
-func reflectcall() { }
-func procyield() { }
-func cgocallback_gofunc() { }
-func publicationBarrier() { }
-func setcallerpc() { }
-func getcallerpc() uintptr { return 0 }
-func getcallersp() uintptr { return 0 }
-func asmcgocall() int32 { return 0 }
-// morestack is handled specially.
-func time_now() (int64, int32) { return 0, 0 }
-
-// os_linux.go
-func futex() int32 { return 0 }
-func clone() int32 { return 0 }
-func gettid() uint32 { return 0 }
-func sigreturn() { for { } }
-func rt_sigaction() int32 { return 0 }
-func sigaltstack() { }
-func setitimer() { }
-func rtsigprocmask() { }
-func getrlimit() int32 { return 0 }
-func raise() { for { } }
-func raiseproc() { for { } }
-func sched_getaffinity() int32 { return 0 }
-func osyield() { }
-
-// stubs2.go
-func read() { return 0 }
-func closefd() { return 0 }
-func exit() { for {} }
-func nanotime() { return 0 }
-func usleep() {}
-func munmap() {}
-func write() int32 { return 0 }
-func open() int32 { return 0 }
-func madvise() {}
-
-// cputicks.go
-func cputicks() { return 0 }
-
-// cgo_mmap.go
-func sysMmap() unsafe.Pointer { return nil }
-func callCgoMmap() uintptr { return 0 }
-
-// alg.go
-func aeshash(p unsafe.Pointer, h, s uintptr) uintptr { return 0 }
-func aeshash32(p unsafe.Pointer, h uintptr) uintptr { return 0 }
-func aeshash64(p unsafe.Pointer, h uintptr) uintptr { return 0 }
-func aeshashstr(p unsafe.Pointer, h uintptr) uintptr { return 0 }
-
-// netpoll_epoll.go
-func epollcreate(size int32) int32 { return 0 }
-func epollcreate1(flags int32) int32 { return 0 }
-func epollctl(epfd, op, fd int32, ev *epollevent) int32 { return 0 }
-func epollwait(epfd int32, ev *epollevent, nev, timeout int32) int32 { return 0 }
-func closeonexec(fd int32) {}
 `
 	var atomicStubs = `
 package atomic
@@ -625,22 +647,55 @@ func StorepNoWB(ptr unsafe.Pointer, val unsafe.Pointer) {
 	}
 }
 
+// rewriteStubs gives a body to each function declaration in f that
+// doesn't have one, which are implemented in assembly or provided by
+// another package. All of these are marked go:nosplit.
+//
+// The body is the stub from newStubs if there is one with a matching
+// signature. Otherwise, it's generated from the declaration: an
+// infinite loop for noReturnStubs, or returning zero values.
 func rewriteStubs(f *ast.File, isNosplit map[ast.Decl]bool) {
-	// Replace declaration bodies.
 	for _, decl := range f.Decls {
-		switch decl := decl.(type) {
-		case *ast.FuncDecl:
-			if decl.Body != nil {
-				continue
-			}
-			newDecl, ok := newStubs[f.Name.Name][decl.Name.Name]
-			if !ok {
-				continue
-			}
+		decl, ok := decl.(*ast.FuncDecl)
+		if !ok || decl.Body != nil {
+			continue
+		}
+		newDecl, ok := newStubs[f.Name.Name][decl.Name.Name]
+		switch {
+		case ok && types.ExprString(newDecl.Type) == types.ExprString(decl.Type):
 			decl.Body = newDecl.Body
-			isNosplit[decl] = true
+		case f.Name.Name == "runtime" && noReturnStubs[decl.Name.Name]:
+			decl.Body = &ast.BlockStmt{List: []ast.Stmt{&ast.ForStmt{Body: &ast.BlockStmt{}}}}
+		default:
+			decl.Body = zeroBody(decl.Type)
+		}
+		isNosplit[decl] = true
+	}
+}
+
+// zeroBody returns a function body for signature ft that returns the
+// zero value of each result.
+func zeroBody(ft *ast.FuncType) *ast.BlockStmt {
+	body := &ast.BlockStmt{}
+	if ft.Results == nil || len(ft.Results.List) == 0 {
+		return body
+	}
+	ret := &ast.ReturnStmt{}
+	if len(ft.Results.List[0].Names) == 0 {
+		// Declare a variable for each unnamed result.
+		for i, field := range ft.Results.List {
+			name := &ast.Ident{Name: fmt.Sprintf("rtcheck۰r%d", i)}
+			body.List = append(body.List, &ast.DeclStmt{
+				&ast.GenDecl{
+					Tok:   token.VAR,
+					Specs: []ast.Spec{&ast.ValueSpec{Names: []*ast.Ident{name}, Type: field.Type}},
+				},
+			})
+			ret.Results = append(ret.Results, name)
 		}
 	}
+	body.List = append(body.List, ret)
+	return body
 }
 
 func addRootCalls(f *ast.File, rootSet map[string]struct{}) {
@@ -655,6 +710,12 @@ func addRootCalls(f *ast.File, rootSet map[string]struct{}) {
 		}
 		delete(rootSet, decl.Name.Name)
 
+		if decl.Type.TypeParams != nil {
+			// We can't call a generic function without
+			// knowing how to instantiate it.
+			continue
+		}
+
 		// Construct a valid call.
 		args := []ast.Expr{}
 		for _, aspec := range decl.Type.Params.List {
@@ -670,7 +731,10 @@ func addRootCalls(f *ast.File, rootSet map[string]struct{}) {
 					args = append(args, &ast.Ident{Name: "nil"})
 				case *ast.StructType:
 					log.Fatal("not implemented: struct args")
-				case *ast.ArrayType, *ast.Ident, *ast.SelectorExpr:
+				case *ast.Ellipsis:
+					// Pass no variadic arguments.
+				case *ast.ArrayType, *ast.Ident, *ast.SelectorExpr,
+					*ast.IndexExpr, *ast.IndexListExpr:
 					name := fmt.Sprintf("x%d", len(body))
 					adecl := &ast.DeclStmt{
 						&ast.GenDecl{
@@ -747,40 +811,46 @@ func rewriteRuntime(f *ast.File, isNosplit map[ast.Decl]bool) {
 			case "mcall":
 				// mcall(f) -> f(nil)
 				return &ast.CallExpr{Fun: node.Args[0], Args: []ast.Expr{&ast.Ident{Name: "nil"}}}
-			case "gopark":
-				if cb, ok := node.Args[0].(*ast.Ident); ok && cb.Name == "nil" {
-					break
-				}
-				// gopark(fn, arg, ...) -> fn(nil, arg)
-				return &ast.CallExpr{
-					Fun: node.Args[0],
-					Args: []ast.Expr{
-						&ast.Ident{Name: "nil"},
-						node.Args[1],
-					},
-				}
-			case "goparkunlock":
-				// goparkunlock(x, ...) -> unlock(x)
-				return &ast.CallExpr{
-					Fun:  &ast.Ident{Name: "unlock"},
-					Args: []ast.Expr{node.Args[0]},
-				}
 			}
 
 		case *ast.ExprStmt:
-			// Rewrite:
-			//   systemstack(f) -> {g := presystemstack(); f(); postsystemstack(g) }
-			//   systemstack(func() { x }) -> {g := presystemstack(); x; postsystemstack(g) }
 			expr, ok := node.X.(*ast.CallExpr)
 			if !ok {
 				break
 			}
 			fnid, ok := expr.Fun.(*ast.Ident)
-			if !ok || fnid.Name != "systemstack" {
+			if !ok {
 				break
 			}
+			switch fnid.Name {
+			case "gopark":
+				if cb, ok := expr.Args[0].(*ast.Ident); ok && cb.Name == "nil" {
+					break
+				}
+				// gopark(fn, arg, ...) -> { fn(nil, arg); _ = ... }
+				call := &ast.CallExpr{
+					Fun:  expr.Args[0],
+					Args: []ast.Expr{id("nil"), expr.Args[1]},
+				}
+				return discardArgs(call, expr.Args[2:])
+			case "goparkunlock":
+				// goparkunlock(x, ...) -> { unlock(x); _ = ... }
+				call := &ast.CallExpr{Fun: id("unlock"), Args: []ast.Expr{expr.Args[0]}}
+				return discardArgs(call, expr.Args[1:])
+			}
+			if fnid.Name != "systemstack" {
+				break
+			}
+
+			// Rewrite:
+			//   systemstack(f) -> {g := presystemstack(); f(); postsystemstack(g) }
+			//   systemstack(func() { x }) -> {g := presystemstack(); x; postsystemstack(g) }
+			//
+			// If x returns, it can't be inlined because
+			// that would return from the enclosing
+			// function, so call the function literal.
 			var x ast.Stmt
-			if arg, ok := expr.Args[0].(*ast.FuncLit); ok {
+			if arg, ok := expr.Args[0].(*ast.FuncLit); ok && !hasReturn(arg.Body) {
 				x = arg.Body
 			} else {
 				x = &ast.ExprStmt{&ast.CallExpr{Fun: expr.Args[0]}}
@@ -797,7 +867,8 @@ func rewriteRuntime(f *ast.File, isNosplit map[ast.Decl]bool) {
 			// TODO: Some functions are just too hairy for
 			// the analysis right now.
 			switch node.Name.Name {
-			case "throw":
+			case "throw",
+				"fatal", "fatalthrow", "fatalpanic": // Go 1.19
 				node.Body = &ast.BlockStmt{
 					List: []ast.Stmt{
 						&ast.ForStmt{
@@ -843,12 +914,43 @@ func rewriteRuntime(f *ast.File, isNosplit map[ast.Decl]bool) {
 	}, f)
 }
 
+// discardArgs returns a statement that calls call and then evaluates
+// and discards args. This keeps the variables used by arguments that
+// a rewrite drops from becoming unused.
+func discardArgs(call *ast.CallExpr, args []ast.Expr) ast.Stmt {
+	list := []ast.Stmt{&ast.ExprStmt{X: call}}
+	for _, arg := range args {
+		list = append(list, &ast.AssignStmt{
+			Lhs: []ast.Expr{&ast.Ident{Name: "_"}},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{arg},
+		})
+	}
+	return &ast.BlockStmt{List: list}
+}
+
+// hasReturn reports whether body contains a return statement, not
+// counting function literals in body.
+func hasReturn(body *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.ReturnStmt:
+			found = true
+		case *ast.FuncLit:
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
 var fns struct {
 	// Locking functions.
 	lock, unlock *ssa.Function
 
 	// Allocation functions.
-	newobject, newarray, makemap, makechan *ssa.Function
+	newobject, makeslice, newarray, makemap, makechan *ssa.Function
 
 	// Slice functions.
 	growslice, slicecopy, slicestringcopy *ssa.Function
@@ -865,26 +967,46 @@ var fns struct {
 
 var runtimeFns = map[string]interface{}{
 	"lock": &fns.lock, "unlock": &fns.unlock,
-	"newobject": &fns.newobject, "newarray": &fns.newarray,
-	"makemap": &fns.makemap, "makechan": &fns.makechan,
+	"newobject": &fns.newobject, "makeslice": &fns.makeslice,
+	"newarray": &fns.newarray, "makemap": &fns.makemap, "makechan": &fns.makechan,
 	"growslice": &fns.growslice, "slicecopy": &fns.slicecopy,
-	"slicestringcopy": &fns.slicestringcopy,
+	"slicestringcopy": &fns.slicestringcopy, // Pre-1.17
 	"mapaccess1":      &fns.mapaccess1, "mapaccess2": &fns.mapaccess2,
-	//"mapassign1": &fns.mapassign1, // Pre-1.8
+	"mapassign1": &fns.mapassign1, // Pre-1.8
 	"mapassign": &fns.mapassign, // Go 1.8
 	"mapdelete": &fns.mapdelete,
 	"chansend1": &fns.chansend1, "closechan": &fns.closechan,
 	"gopanic": &fns.gopanic,
 }
 
-func lookupMembers(pkg *ssa.Package, out map[string]interface{}) {
+// optionalRuntimeFns is the set of runtimeFns that exist only in
+// some versions of the runtime.
+var optionalRuntimeFns = map[string]bool{
+	"slicestringcopy": true,
+	"mapassign1":      true,
+	"mapassign":       true,
+}
+
+// lookupMembers sets each pointer in out to the member of pkg with
+// the corresponding name. Members in optional that don't exist in
+// this version of pkg are left nil. Any other missing member is a
+// fatal error.
+func lookupMembers(pkg *ssa.Package, out map[string]interface{}, optional map[string]bool) {
+	var missing []string
 	for name, ptr := range out {
 		member, ok := pkg.Members[name]
 		if !ok {
-			log.Fatalf("%s.%s not found", pkg, name)
+			if !optional[name] {
+				missing = append(missing, name)
+			}
+			continue
 		}
 		reflect.ValueOf(ptr).Elem().Set(reflect.ValueOf(member))
 	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		log.Fatalf("%s is missing required members: %s", pkg.Pkg.Path(), strings.Join(missing, " "))
+	}
 }
 
 // StringSpace interns strings into small integers.
@@ -1112,6 +1234,11 @@ type funcInfo struct {
 	// debugTree is the block trace debug tree for this function.
 	// If nil, this function is not being debug traced.
 	debugTree *DebugTree
+
+	// records maps from the enter PathState of each walk of this
+	// function to the analysis cache's record of that walk. This
+	// is only tracked if the analysis cache is enabled.
+	records *PathStateMap
 }
 
 // StackFrame is a stack of call sites. A nil *StackFrame represents
@@ -1189,7 +1316,6 @@ func (sf *StackFrame) TrimCommonPrefix(other *StackFrame, minLen int) (*StackFra
 type state struct {
 	fset  *token.FileSet
 	cg    *callgraph.Graph
-	pta   *pointer.Result
 	fns   map[*ssa.Function]*funcInfo
 	stack *StackFrame
 
@@ -1207,8 +1333,8 @@ type state struct {
 
 	lockOrder *LockOrder
 
-	// messages is the set of warning strings that have been
-	// emitted.
+	// messages is the set of keys of warnings that have been
+	// emitted. See printMessage.
 	messages map[string]struct{}
 
 	// roots is the list of root functions to visit.
@@ -1220,26 +1346,65 @@ type state struct {
 	// debugging indicates that we're debugging this subgraph of
 	// the CFG.
 	debugging bool
+
+	// cache, if non-nil, is the analysis cache.
+	cache *analysisCacheState
+
+	// checkContexts enables checking for unsafe operations in
+	// nosplit and signal contexts.
+	checkContexts bool
+	// signalRoots is the set of roots that are entered from
+	// signal handlers.
+	signalRoots map[*ssa.Function]bool
+	// signalFns is the memoization cache for signalRoots. While
+	// walking a signal root, it is swapped with fns.
+	signalFns map[*ssa.Function]*funcInfo
+	// inSignal indicates that we're walking a signal root.
+	inSignal bool
 }
 
 func (s *state) warnl(pos token.Pos, format string, args ...interface{}) {
+	s.warnlKey("", pos, format, args...)
+}
+
+// warnlKey is like warnl, but only prints the message if no message
+// with the same key has been printed. If key is "", the message is its
+// own key.
+func (s *state) warnlKey(key string, pos token.Pos, format string, args ...interface{}) {
 	// TODO: Have a different message for path terminating conditions.
 	var msg bytes.Buffer
 	if pos.IsValid() {
 		fmt.Fprintf(&msg, "%s: ", s.fset.Position(pos))
 	}
 	fmt.Fprintf(&msg, format+"\n", args...)
-	if _, ok := s.messages[msg.String()]; ok {
+	if key == "" {
+		key = msg.String()
+	}
+	s.printMessage(key, msg.String())
+}
+
+// printMessage prints msg if no message with the same key has already
+// been printed.
+func (s *state) printMessage(key, msg string) {
+	if s.cache != nil {
+		s.cache.message(s, key, msg)
+	}
+	if _, ok := s.messages[key]; ok {
 		return
 	}
 	if s.messages == nil {
 		s.messages = make(map[string]struct{})
 	}
-	s.messages[msg.String()] = struct{}{}
-	fmt.Print(msg.String())
+	s.messages[key] = struct{}{}
+	fmt.Print(msg)
 }
 
 func (s *state) warnp(pos token.Pos, format string, args ...interface{}) {
+	s.warnpKey("", pos, format, args...)
+}
+
+// warnpKey is like warnp, but deduplicates messages like warnlKey.
+func (s *state) warnpKey(key string, pos token.Pos, format string, args ...interface{}) {
 	var buf bytes.Buffer
 	for stack := s.stack; stack != nil; stack = stack.parent {
 		fmt.Fprintf(&buf, "    %s\n", stack.call.Parent().String())
@@ -1247,7 +1412,7 @@ func (s *state) warnp(pos token.Pos, format string, args ...interface{}) {
 	}
 	tb := strings.TrimSuffix(buf.String(), "\n")
 	args = append(args, tb)
-	s.warnl(pos, format+" at\n%s", args...)
+	s.warnlKey(key, pos, format+" at\n%s", args...)
 }
 
 // addRoot adds fn as a root of the control flow graph to visit.
@@ -1257,11 +1422,14 @@ func (s *state) addRoot(fn *ssa.Function) {
 	}
 	s.roots = append(s.roots, fn)
 	s.rootSet[fn] = struct{}{}
+	if s.cache != nil {
+		s.cache.addRoot(fn)
+	}
 }
 
 // callees returns the set of functions that call could possibly
-// invoke. It returns nil for built-in functions or if pointer
-// analysis failed.
+// invoke. It returns nil for built-in functions or if call isn't in
+// the call graph.
 func (s *state) callees(call ssa.CallInstruction) []*ssa.Function {
 	if builtin, ok := call.Common().Value.(*ssa.Builtin); ok {
 		// TODO: cap, len for map and channel
@@ -1272,7 +1440,7 @@ func (s *state) callees(call ssa.CallInstruction) []*ssa.Function {
 			return []*ssa.Function{fns.closechan}
 		case "copy":
 			arg0 := builtin.Type().(*types.Signature).Params().At(0).Type().Underlying()
-			if b, ok := arg0.(*types.Basic); ok && b.Kind() == types.String {
+			if b, ok := arg0.(*types.Basic); ok && b.Kind() == types.String && fns.slicestringcopy != nil {
 				return []*ssa.Function{fns.slicestringcopy}
 			}
 			return []*ssa.Function{fns.slicecopy}
@@ -1302,6 +1470,67 @@ func (s *state) callees(call ssa.CallInstruction) []*ssa.Function {
 	return nil
 }
 
+// info returns the analysis state for f, creating it on the first
+// visit of f.
+func (s *state) info(f *ssa.Function) *funcInfo {
+	if fInfo := s.fns[f]; fInfo != nil {
+		return fInfo
+	}
+
+	// Compute control-flow dependencies.
+	//
+	// TODO: Figure out which control flow decisions
+	// actually affect locking and only track those. Right
+	// now we hit a lot of simple increment loops that
+	// cause path aborts, but don't involve any locking.
+	// Find all of the branches that could lead to a
+	// lock/unlock (the may-precede set) and eliminate
+	// those where both directions will always lead to the
+	// lock/unlock anyway (where the lock/unlock is in the
+	// must-succeed set). This can be answered with the
+	// post-dominator tree. This is basically the same
+	// computation we need to propagate liveness over
+	// control flow.
+	var ifInstrs []ssa.Instruction
+	for _, b := range f.Blocks {
+		if len(b.Instrs) == 0 {
+			continue
+		}
+		instr, ok := b.Instrs[len(b.Instrs)-1].(*ssa.If)
+		if !ok {
+			continue
+		}
+		ifInstrs = append(ifInstrs, instr)
+	}
+	ifDeps := livenessFor(f, ifInstrs)
+	if debugFunctions[f.String()] {
+		f.WriteTo(os.Stderr)
+		fmt.Fprintf(os.Stderr, "if deps:\n")
+		for bid, vals := range ifDeps {
+			fmt.Fprintf(os.Stderr, "  %d: ", bid)
+			for dep := range vals {
+				fmt.Fprintf(os.Stderr, " %s", dep.(ssa.Value).Name())
+			}
+			fmt.Fprintf(os.Stderr, "\n")
+		}
+	}
+
+	fInfo := &funcInfo{
+		exitStates: NewPathStateMap(),
+		ifDeps:     ifDeps,
+	}
+	s.fns[f] = fInfo
+
+	if f.Blocks == nil {
+		s.warnl(f.Pos(), "external function %s", f)
+	}
+
+	if debugFunctions[f.String()] {
+		fInfo.debugTree = new(DebugTree)
+	}
+	return fInfo
+}
+
 // walkFunction explores f, starting at the given path state. It
 // returns the set of path states possible on exit from f.
 //
@@ -1317,70 +1546,18 @@ func (s *state) callees(call ssa.CallInstruction) []*ssa.Function {
 // correlated control flow.
 //
 // TODO: This totally fails with multi-use higher-order functions,
-// since the flow computed by the pointer analysis is not segregated
-// by PathState.
+// since the flow computed by the call graph analysis is not
+// segregated by PathState.
 //
 // TODO: A lot of call trees simply don't take locks. We could record
 // that fact and fast-path the entry locks to the exit locks.
 func (s *state) walkFunction(f *ssa.Function, ps PathState) *PathStateSet {
-	fInfo := s.fns[f]
-	if fInfo == nil {
-		// First visit of this function.
-
-		// Compute control-flow dependencies.
-		//
-		// TODO: Figure out which control flow decisions
-		// actually affect locking and only track those. Right
-		// now we hit a lot of simple increment loops that
-		// cause path aborts, but don't involve any locking.
-		// Find all of the branches that could lead to a
-		// lock/unlock (the may-precede set) and eliminate
-		// those where both directions will always lead to the
-		// lock/unlock anyway (where the lock/unlock is in the
-		// must-succeed set). This can be answered with the
-		// post-dominator tree. This is basically the same
-		// computation we need to propagate liveness over
-		// control flow.
-		var ifInstrs []ssa.Instruction
-		for _, b := range f.Blocks {
-			if len(b.Instrs) == 0 {
-				continue
-			}
-			instr, ok := b.Instrs[len(b.Instrs)-1].(*ssa.If)
-			if !ok {
-				continue
-			}
-			ifInstrs = append(ifInstrs, instr)
-		}
-		ifDeps := livenessFor(f, ifInstrs)
-		if debugFunctions[f.String()] {
-			f.WriteTo(os.Stderr)
-			fmt.Fprintf(os.Stderr, "if deps:\n")
-			for bid, vals := range ifDeps {
-				fmt.Fprintf(os.Stderr, "  %d: ", bid)
-				for dep := range vals {
-					fmt.Fprintf(os.Stderr, " %s", dep.(ssa.Value).Name())
-				}
-				fmt.Fprintf(os.Stderr, "\n")
-			}
-		}
-
-		fInfo = &funcInfo{
-			exitStates: NewPathStateMap(),
-			ifDeps:     ifDeps,
-		}
-		s.fns[f] = fInfo
-
-		if f.Blocks == nil {
-			s.warnl(f.Pos(), "external function %s", f)
-		}
-
-		if debugFunctions[f.String()] {
-			fInfo.debugTree = new(DebugTree)
-		}
-	}
+	fInfo := s.info(f)
 
 	if f.Blocks == nil {
+		if s.cache != nil {
+			s.cache.depend(f)
+		}
 		// External function. Assume it doesn't affect locks
 		// or heap state.
 		pss1 := NewPathStateSet()
@@ -1415,6 +1592,9 @@ func (s *state) walkFunction(f *ssa.Function, ps PathState) *PathStateSet {
 	// a "predicate" and a compressed "delta" for the computation
 	// and caching that.
 	if memo := fInfo.exitStates.Get(ps); memo != nil {
+		if s.cache != nil {
+			s.cache.reuse(fInfo, ps)
+		}
 		if s.debugging {
 			s.debugTree.Appendf("\n- cached exit -\n%v", memo)
 		}
@@ -1429,6 +1609,14 @@ func (s *state) walkFunction(f *ssa.Function, ps PathState) *PathStateSet {
 		defer fInfo.debugTree.Pop()
 	}
 
+	// Check the analysis cache. If it misses, this starts
+	// recording the walk.
+	if s.cache != nil {
+		if exitStates, ok := s.cache.enter(s, f, fInfo, ps); ok {
+			return exitStates
+		}
+	}
+
 	// Resolve function cycles by returning an empty set of
 	// locksets, which terminates this code path.
 	//
@@ -1444,6 +1632,9 @@ func (s *state) walkFunction(f *ssa.Function, ps PathState) *PathStateSet {
 	exitStates := NewPathStateSet()
 	s.walkBlock(blockCache, enterPathState, exitStates)
 	fInfo.exitStates.Set(ps, exitStates)
+	if s.cache != nil {
+		s.cache.exit(s, exitStates)
+	}
 	//log.Printf("%s: %s -> %s", f.Name(), locks, exitStates)
 	if s.debugging {
 		s.debugTree.Appendf("\n- exit -\n%v", exitStates)
@@ -1690,6 +1881,9 @@ func (s *state) walkBlock(blockCache *PathStateSet, enterPathState PathState, ex
 				vs:      ps.vs.LimitToHeap(),
 			}
 			for _, fn := range fns {
+				if what, ok := contextUnsafeFuncs[fn.String()]; ok {
+					s.checkContext(what)
+				}
 				if handler, ok := callHandlers[fn.String()]; ok {
 					// TODO: Instead of using
 					// FlatMap, I could just pass
@@ -1783,7 +1977,11 @@ func (s *state) walkBlock(blockCache *PathStateSet, enterPathState PathState, ex
 			doCall(instr, []*ssa.Function{fns.makemap})
 
 		case *ssa.MakeSlice:
-			doCall(instr, []*ssa.Function{fns.newarray})
+			fn := fns.makeslice
+			if fn == nil {
+				fn = fns.newarray
+			}
+			doCall(instr, []*ssa.Function{fn})
 
 		case *ssa.MapUpdate:
 			fn := fns.mapassign // Go 1.8
@@ -5,17 +5,10 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"go/token"
-	"html/template"
 	"io"
-	"io/ioutil"
-	"log"
-	"math/big"
-	"os"
-	"os/exec"
-	"path/filepath"
+	"sort"
 
 	"golang.org/x/tools/go/ssa"
 )
@@ -29,6 +22,13 @@ type LockOrder struct {
 
 	// cycles is the cached result of FindCycles, or nil.
 	cycles [][]int
+
+	// sccs is the cached result of SCCs, or nil.
+	sccs [][]int
+
+	// onAdd, if non-nil, is called for every path added to the
+	// lock graph.
+	onAdd func(edge lockOrderEdge, info lockOrderInfo)
 }
 
 type lockOrderEdge struct {
@@ -53,13 +53,6 @@ func NewLockOrder(fset *token.FileSet) *LockOrder {
 // locked are currently held and the locks in locking are being
 // acquired at stack.
 func (lo *LockOrder) Add(locked *LockSet, locking *LockSet, stack *StackFrame) {
-	lo.cycles = nil
-	if lo.lca == nil {
-		lo.lca = locked.lca
-	} else if locked.lca != nil && lo.lca != locked.lca {
-		panic("locks come from a different LockClassAnalyses")
-	}
-
 	for i := 0; i < locked.bits.BitLen(); i++ {
 		if locked.bits.Bit(i) != 0 {
 			for j := 0; j < locking.bits.BitLen(); j++ {
@@ -77,18 +70,34 @@ func (lo *LockOrder) Add(locked *LockSet, locking *LockSet, stack *StackFrame) {
 						fromStack.Intern(),
 						toStack.Intern(),
 					}
-					infos := lo.m[edge]
-					if infos == nil {
-						infos = make(map[lockOrderInfo]struct{})
-						lo.m[edge] = infos
-					}
-					infos[info] = struct{}{}
+					lo.addPath(locked.lca, edge, info)
 				}
 			}
 		}
 	}
 }
 
+// addPath adds a single path to edge of the lock graph. The IDs in
+// edge are lock classes from lca.
+func (lo *LockOrder) addPath(lca *LockClassAnalysis, edge lockOrderEdge, info lockOrderInfo) {
+	lo.cycles, lo.sccs = nil, nil
+	if lo.lca == nil {
+		lo.lca = lca
+	} else if lca != nil && lo.lca != lca {
+		panic("locks come from a different LockClassAnalyses")
+	}
+
+	infos := lo.m[edge]
+	if infos == nil {
+		infos = make(map[lockOrderInfo]struct{})
+		lo.m[edge] = infos
+	}
+	infos[info] = struct{}{}
+	if lo.onAdd != nil {
+		lo.onAdd(edge, info)
+	}
+}
+
 // FindCycles returns a list of cycles in the lock order. Each cycle
 // is a list of lock IDs from the StringSpace in cycle order (without
 // any repetition).
@@ -147,69 +156,224 @@ func (lo *LockOrder) FindCycles() [][]int {
 	return cycles
 }
 
+// SCCs returns the strongly connected components of the lock graph
+// that contain more than one lock, each as a sorted list of lock IDs.
+// Every lock cycle between distinct locks is contained in one of these
+// components.
+func (lo *LockOrder) SCCs() [][]int {
+	if lo.sccs != nil {
+		return lo.sccs
+	}
+
+	// Compute out-edge adjacency list.
+	out := map[int][]int{}
+	var nodes []int
+	seen := map[int]bool{}
+	for edge := range lo.m {
+		out[edge.fromId] = append(out[edge.fromId], edge.toId)
+		for _, id := range []int{edge.fromId, edge.toId} {
+			if !seen[id] {
+				seen[id] = true
+				nodes = append(nodes, id)
+			}
+		}
+	}
+	sort.Ints(nodes)
+
+	// Tarjan's algorithm.
+	index := map[int]int{}
+	low := map[int]int{}
+	onStack := map[int]bool{}
+	var stack []int
+	sccs := [][]int{}
+	var visit func(v int)
+	visit = func(v int) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range out[v] {
+			if _, ok := index[w]; !ok {
+				visit(w)
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+		if low[v] != index[v] {
+			return
+		}
+		var scc []int
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			scc = append(scc, w)
+			if w == v {
+				break
+			}
+		}
+		if len(scc) > 1 {
+			sort.Ints(scc)
+			sccs = append(sccs, scc)
+		}
+	}
+	for _, v := range nodes {
+		if _, ok := index[v]; !ok {
+			visit(v)
+		}
+	}
+	sort.Slice(sccs, func(i, j int) bool { return sccs[i][0] < sccs[j][0] })
+
+	lo.sccs = sccs
+	return sccs
+}
+
+// inCycle returns whether edge is part of some lock cycle.
+func (lo *LockOrder) inCycle(edge lockOrderEdge) bool {
+	if edge.fromId == edge.toId {
+		return true
+	}
+	for _, scc := range lo.SCCs() {
+		i := sort.SearchInts(scc, edge.fromId)
+		j := sort.SearchInts(scc, edge.toId)
+		if i < len(scc) && scc[i] == edge.fromId && j < len(scc) && scc[j] == edge.toId {
+			return true
+		}
+	}
+	return false
+}
+
 // WriteToDot writes the lock graph in the dot language to w, with
 // cycles highlighted.
 func (lo *LockOrder) WriteToDot(w io.Writer) {
-	lo.writeToDot(w)
+	lo.writeToDot(w, &dotView{})
 }
 
 func (lo *LockOrder) name(id int) string {
 	return lo.lca.Lookup(id).String()
 }
 
-func (lo *LockOrder) writeToDot(w io.Writer) map[lockOrderEdge]string {
+// A dotView selects and groups the locks shown by writeToDot.
+type dotView struct {
+	// prefix is prepended to the IDs of SVG elements so that
+	// several views can appear in one HTML document.
+	prefix string
+
+	// include, if non-nil, reports whether to show lock id.
+	include func(id int) bool
+
+	// group maps from lock ID to group number. All of the locks
+	// in a group are collapsed into a single node labeled
+	// groupLabel[group]. Edges between locks in the same group
+	// are omitted.
+	group      map[int]int
+	groupLabel map[int]string
+}
+
+// dotIDs records the SVG element IDs assigned by writeToDot.
+type dotIDs struct {
+	// edges maps from edge element ID to the lock edges it
+	// represents.
+	edges map[string][]lockOrderEdge
+
+	// groups maps from node element ID to group number.
+	groups map[string]int
+}
+
+func (lo *LockOrder) writeToDot(w io.Writer, view *dotView) dotIDs {
 	// TODO: Compute the transitive reduction (of the SCC
 	// condensation, I guess) to reduce noise.
 
-	// Find cycles to highlight edges.
-	cycles := lo.FindCycles()
-	cycleEdges := map[lockOrderEdge]struct{}{}
+	nid := func(lockId int) string {
+		if g, ok := view.group[lockId]; ok {
+			return fmt.Sprintf("g%d", g)
+		}
+		return fmt.Sprintf("l%d", lockId)
+	}
+
+	// Collect the edges to show, merging edges between grouped
+	// nodes.
+	type dotEdge struct{ from, to string }
+	merged := make(map[dotEdge][]lockOrderEdge)
+	var order []dotEdge
 	var maxStack int
-	for _, cycle := range cycles {
-		for i, fromId := range cycle {
-			toId := cycle[(i+1)%len(cycle)]
-			edge := lockOrderEdge{fromId, toId}
-			cycleEdges[edge] = struct{}{}
-			if len(lo.m[edge]) > maxStack {
-				maxStack = len(lo.m[edge])
-			}
+	for edge, stacks := range lo.m {
+		if view.include != nil && !(view.include(edge.fromId) && view.include(edge.toId)) {
+			continue
+		}
+		de := dotEdge{nid(edge.fromId), nid(edge.toId)}
+		if de.from == de.to && edge.fromId != edge.toId {
+			// Internal to a group.
+			continue
+		}
+		if merged[de] == nil {
+			order = append(order, de)
+		}
+		merged[de] = append(merged[de], edge)
+		if lo.inCycle(edge) && len(stacks) > maxStack {
+			maxStack = len(stacks)
 		}
 	}
+	sort.Slice(order, func(i, j int) bool {
+		if order[i].from != order[j].from {
+			return order[i].from < order[j].from
+		}
+		return order[i].to < order[j].to
+	})
 
 	fmt.Fprintf(w, "digraph locks {\n")
 	fmt.Fprintf(w, "  tooltip=\" \";\n")
-	var nodes big.Int
-	nid := func(lockId int) string {
-		return fmt.Sprintf("l%d", lockId)
-	}
+	ids := dotIDs{make(map[string][]lockOrderEdge), make(map[string]int)}
+	nodes := make(map[string]int)
 	// Write edges.
-	edgeIds := make(map[lockOrderEdge]string)
-	for edge, stacks := range lo.m {
+	for _, de := range order {
+		edges := merged[de]
 		var props string
-		if _, ok := cycleEdges[edge]; ok {
-			width := 1 + 6*float64(len(stacks))/float64(maxStack)
-			props = fmt.Sprintf(",label=%d,penwidth=%f,color=red,weight=2", len(stacks), width)
+		nstacks, cycle := 0, false
+		for _, edge := range edges {
+			nstacks += len(lo.m[edge])
+			cycle = cycle || lo.inCycle(edge)
+		}
+		if cycle {
+			width := 1 + 6*float64(nstacks)/float64(maxStack)
+			props = fmt.Sprintf(",label=%d,penwidth=%f,color=red,weight=2", nstacks, width)
+		}
+		id := fmt.Sprintf("%sedge-%s-%s", view.prefix, de.from, de.to)
+		ids.edges[id] = edges
+		tooltip := fmt.Sprintf("%s -> %s", lo.name(edges[0].fromId), lo.name(edges[0].toId))
+		if len(edges) > 1 {
+			tooltip = fmt.Sprintf("%d lock orderings", len(edges))
 		}
-		id := fmt.Sprintf("edge%d-%d", edge.fromId, edge.toId)
-		edgeIds[edge] = id
-		tooltip := fmt.Sprintf("%s -> %s", lo.name(edge.fromId), lo.name(edge.toId))
 		// We set the edge ID so Javascript can find the
 		// element in the SVG.
-		fmt.Fprintf(w, "  %s -> %s [id=%q,tooltip=%q%s];\n", nid(edge.fromId), nid(edge.toId), id, tooltip, props)
-		nodes.SetBit(&nodes, edge.fromId, 1)
-		nodes.SetBit(&nodes, edge.toId, 1)
+		fmt.Fprintf(w, "  %s -> %s [id=%q,tooltip=%q%s];\n", de.from, de.to, id, tooltip, props)
+		nodes[de.from] = edges[0].fromId
+		nodes[de.to] = edges[0].toId
 	}
 	// Write nodes. This excludes lone locks: these are only the
 	// locks that participate in some ordering
-	for i := 0; i < nodes.BitLen(); i++ {
-		if nodes.Bit(i) == 1 {
-			// We set the fill color to white so
-			// mouseovers on this node work nicely.
-			fmt.Fprintf(w, "  %s [label=%q,style=filled,fillcolor=white];\n", nid(i), lo.name(i))
+	var nodeNames []string
+	for name := range nodes {
+		nodeNames = append(nodeNames, name)
+	}
+	sort.Strings(nodeNames)
+	for _, name := range nodeNames {
+		// We set the fill color to white so mouseovers on
+		// this node work nicely.
+		lockId := nodes[name]
+		if g, ok := view.group[lockId]; ok {
+			id := fmt.Sprintf("%sgroup-%d", view.prefix, g)
+			ids.groups[id] = g
+			fmt.Fprintf(w, "  %s [id=%q,label=%q,shape=box,style=\"filled,bold\",fillcolor=white,color=red];\n", name, id, view.groupLabel[g])
+		} else {
+			fmt.Fprintf(w, "  %s [label=%q,style=filled,fillcolor=white];\n", name, lo.name(lockId))
 		}
 	}
 	fmt.Fprintf(w, "}\n")
-	return edgeIds
+	return ids
 }
 
 type renderedPath struct {
@@ -250,18 +414,6 @@ func (lo *LockOrder) Check(w io.Writer) {
 	cycles := lo.FindCycles()
 
 	// Report cycles.
-	printStack := func(stack []renderedFrame) {
-		indent := 6
-		for _, fr := range stack {
-			fmt.Fprintf(w, "%*s%s at %s\n", indent, "", fr.Op, fr.Pos)
-			indent += 2
-		}
-	}
-	printInfo := func(rinfo renderedPath) {
-		fmt.Fprintf(w, "    %s\n", rinfo.RootFn)
-		printStack(rinfo.From)
-		printStack(rinfo.To)
-	}
 	for _, cycle := range cycles {
 		cycle = append(cycle, cycle[0])
 		fmt.Fprintf(w, "lock cycle: ")
@@ -274,124 +426,31 @@ func (lo *LockOrder) Check(w io.Writer) {
 		fmt.Fprintf(w, "\n")
 
 		for i := 0; i < len(cycle)-1; i++ {
-			edge := lockOrderEdge{cycle[i], cycle[i+1]}
-			infos := lo.m[edge]
-
-			fmt.Fprintf(w, "  %d path(s) acquire %s then %s:\n", len(infos), lo.name(edge.fromId), lo.name(edge.toId))
-			for info, _ := range infos {
-				rinfo := lo.renderInfo(edge, info)
-				printInfo(rinfo)
-			}
+			lo.writePaths(w, lockOrderEdge{cycle[i], cycle[i+1]})
 			fmt.Fprintf(w, "\n")
 		}
 	}
 }
 
-// WriteToHTML writes a self-contained, interactive HTML lock graph
-// report to w. It requires dot to be in $PATH.
-func (lo *LockOrder) WriteToHTML(w io.Writer) {
-	// Generate SVG from dot graph.
-	cmd := exec.Command("dot", "-Tsvg")
-	dotin, err := cmd.StdinPipe()
-	if err != nil {
-		log.Fatal("creating pipe to dot: ", err)
-	}
-	dotDone := make(chan bool)
-	var edgeIds map[lockOrderEdge]string
-	go func() {
-		edgeIds = lo.writeToDot(dotin)
-		dotin.Close()
-		dotDone <- true
-	}()
-	svg, err := cmd.Output()
-	if err != nil {
-		log.Fatal("error running dot: ", err)
-	}
-	<-dotDone
-	// Strip stuff before the SVG tag so we can put it into HTML.
-	if i := bytes.Index(svg, []byte("<svg")); i > 0 {
-		svg = svg[i:]
-	}
-
-	// Construct JSON for lock graph details. This is about an
-	// order of magnitude smaller than the naive renderedFrames.
-	jsonStrings := NewStringSpace()
-	// To save space, we use a struct of arrays.
-	type jsonStack struct {
-		Op     []int
-		PathID []int `json:"P"`
-		Line   []int `json:"L"`
-	}
-	xFrames := func(rs []renderedFrame) jsonStack {
-		out := jsonStack{
-			make([]int, len(rs)),
-			make([]int, len(rs)),
-			make([]int, len(rs)),
-		}
-		for i, r := range rs {
-			out.Op[i] = jsonStrings.Intern(r.Op)
-			out.PathID[i] = jsonStrings.Intern(r.Pos.Filename)
-			out.Line[i] = r.Pos.Line
-		}
-		return out
-	}
-	type jsonPath struct {
-		RootFn   int
-		From, To jsonStack
-	}
-	xPath := func(r renderedPath) jsonPath {
-		return jsonPath{jsonStrings.Intern(r.RootFn), xFrames(r.From), xFrames(r.To)}
-	}
-	type jsonEdge struct {
-		EdgeID string
-		Locks  [2]string
-		Paths  []jsonPath
-	}
-	jsonEdges := []jsonEdge{}
-	for edge, infos := range lo.m {
-		var paths []jsonPath
-		for info := range infos {
-			paths = append(paths, xPath(lo.renderInfo(edge, info)))
-		}
-		jsonEdges = append(jsonEdges, jsonEdge{
-			EdgeID: edgeIds[edge],
-			Locks:  [2]string{lo.name(edge.fromId), lo.name(edge.toId)},
-			Paths:  paths,
-		})
-	}
-
-	// Find the static file path.
-	//
-	// TODO: Optionally bake these into the binary.
-	var static string
-	var found bool
-	for _, gopath := range filepath.SplitList(os.Getenv("GOPATH")) {
-		static = filepath.Join(gopath, "src/github.com/aclements/go-misc/rtcheck/static")
-		if _, err := os.Stat(static); err == nil {
-			found = true
-			break
+// writePaths writes the code paths that acquire the locks of edge
+// in order to w.
+func (lo *LockOrder) writePaths(w io.Writer, edge lockOrderEdge) {
+	printStack := func(stack []renderedFrame) {
+		indent := 6
+		for _, fr := range stack {
+			fmt.Fprintf(w, "%*s%s at %s\n", indent, "", fr.Op, fr.Pos)
+			indent += 2
 		}
 	}
-	if !found {
-		log.Fatal("unable to find HTML template in $GOPATH")
-	}
-
-	// Generate HTML.
-	tmpl, err := template.ParseFiles(filepath.Join(static, "tmpl-order.html"))
-	if err != nil {
-		log.Fatal("loading HTML templates: ", err)
-	}
-	mainJS, err := ioutil.ReadFile(filepath.Join(static, "main.js"))
-	if err != nil {
-		log.Fatal("loading main.js: ", err)
+	printInfo := func(rinfo renderedPath) {
+		fmt.Fprintf(w, "    %s\n", rinfo.RootFn)
+		printStack(rinfo.From)
+		printStack(rinfo.To)
 	}
-	err = tmpl.Execute(w, map[string]interface{}{
-		"graph":   template.HTML(svg),
-		"strings": jsonStrings.s,
-		"edges":   jsonEdges,
-		"mainJS":  template.JS(mainJS),
-	})
-	if err != nil {
-		log.Fatal("executing HTML template: ", err)
+	infos := lo.m[edge]
+	fmt.Fprintf(w, "  %d path(s) acquire %s then %s:\n", len(infos), lo.name(edge.fromId), lo.name(edge.toId))
+	for info, _ := range infos {
+		rinfo := lo.renderInfo(edge, info)
+		printInfo(rinfo)
 	}
 }
@@ -1,30 +1,127 @@
 "use strict";
 
-function initOrder(strings, edges) {
-    // Hook into the graph edges.
-    var labelRe = /^l([0-9]+)-l([0-9]+)$/;
-    $.each(edges, function(_, edge) {
-        var g = $(document.getElementById(edge.EdgeID));
-        // Increase the size of the click target by making a second,
-        // invisible, larger path element.
-        var path = $("path:first", g);
-        path.clone().attr("stroke-width", "10px").attr("stroke", "transparent").appendTo(path.parent());
-        // On click, update the info box.
-        g.
-          css({cursor: "pointer"}).
-          on("click", function(ev) {
-              showEdge(strings, edge);
-          });
+function initOrder(strings, edges, views) {
+    var details = $("#details");
+    var help = details.children().clone();
+    var select = $("#view");
+    var filter = $("#filter");
+    // highlighters maps from view index to the highlighter for
+    // views that have been set up.
+    var highlighters = {};
+    var cur = -1;
+
+    $.each(views, function(i, view) {
+        $("<option>").attr("value", i).text(view.Title).appendTo(select);
+    });
+    select.on("change", function() {
+        showView(+select.val());
+    });
+    filter.on("input", function() {
+        applyFilter();
+        listMatches();
     });
-    enableHighlighting($("#graph")[0]);
-    zoomify($("#graph")[0], $("#graphWrap")[0]);
-    $("#graph").css("visibility", "visible");
+
+    function setupView(i) {
+        var view = views[i];
+        var wrap = $("#wrap-" + i);
+        var svg = $("svg.graph", wrap);
+        // Hook into the graph edges.
+        $.each(view.Edges, function(id, idxs) {
+            var g = $(document.getElementById(id));
+            // Increase the size of the click target by making a second,
+            // invisible, larger path element.
+            var path = $("path:first", g);
+            path.clone().attr("stroke-width", "10px").attr("stroke", "transparent").appendTo(path.parent());
+            // On click, update the info box.
+            g.
+              css({cursor: "pointer"}).
+              on("click", function(ev) {
+                  showEdges(strings, $.map(idxs, function(j) { return edges[j]; }));
+              });
+        });
+        // Clicking a collapsed group switches to its view.
+        $.each(view.Groups, function(id, target) {
+            $(document.getElementById(id)).
+              css({cursor: "pointer"}).
+              on("click", function(ev) {
+                  showView(target);
+              });
+        });
+        var hl = enableHighlighting(svg[0]);
+        zoomify(svg[0], wrap[0]);
+        svg.css("visibility", "visible");
+        return hl;
+    }
+
+    function showView(i) {
+        if (cur >= 0)
+            $("#wrap-" + cur).hide();
+        cur = i;
+        select.val(i);
+        // The SVG must be visible before we can set it up.
+        $("#wrap-" + i).show();
+        if (!(i in highlighters))
+            highlighters[i] = setupView(i);
+        applyFilter();
+    }
+
+    // filterFunc returns a predicate for lock names matching the
+    // filter, or null if there is no filter.
+    function filterFunc() {
+        var text = filter.val().toLowerCase();
+        if (text === "")
+            return null;
+        return function(name) {
+            return name.toLowerCase().indexOf(text) >= 0;
+        };
+    }
+
+    function applyFilter() {
+        highlighters[cur].setFilter(filterFunc());
+    }
+
+    // listMatches lists the edges involving locks that match the
+    // filter in the info box.
+    function listMatches() {
+        var match = filterFunc();
+        details.empty().scrollTop(0);
+        if (match === null) {
+            details.append(help.clone());
+            return;
+        }
+        var matches = $.grep(edges, function(edge) {
+            return match(edge.Locks[0]) || match(edge.Locks[1]);
+        });
+        matches.sort(function(a, b) {
+            // Cycle edges first.
+            if (a.Cycle !== b.Cycle)
+                return a.Cycle ? -1 : 1;
+            return a.Locks[0] < b.Locks[0] ? -1 : a.Locks[0] > b.Locks[0] ? 1 :
+                a.Locks[1] < b.Locks[1] ? -1 : a.Locks[1] > b.Locks[1] ? 1 : 0;
+        });
+        $("<p>").appendTo(details).text(matches.length + " lock ordering(s) match:").css({fontWeight: "bold"});
+        $.each(matches, function(_, edge) {
+            $("<div>").appendTo(details).
+                text(edge.Locks[0] + " \u2192 " + edge.Locks[1] + " (" + edge.Paths.length + ")").
+                addClass("edgeLink").toggleClass("cycle", edge.Cycle).
+                on("click", function(ev) {
+                    showEdges(strings, [edge]);
+                });
+        });
+    }
+
+    showView(0);
 }
 
-function showEdge(strings, edge) {
-    var info = $("#info");
+function showEdges(strings, edges) {
+    var info = $("#details");
     info.empty().scrollTop(0);
+    $.each(edges, function(_, edge) {
+        showEdge(info, strings, edge);
+    });
+}
 
+function showEdge(info, strings, edge) {
     // Show summary information.
     $("<p>").appendTo(info).text(
         edge.Paths.length + " path(s) acquire " + edge.Locks[0] + ", then " + edge.Locks[1] + ":"
@@ -80,12 +177,22 @@ function showEdge(strings, edge) {
 // edges.
 function enableHighlighting(svg) {
     var nodes = {}, edges = {};
+    // match is the current filter predicate on lock names, or
+    // null.
+    var match = null;
+
+    function nodeOpacity(node) {
+        return match === null || node.match ? 1 : 0.15;
+    }
+    function edgeOpacity(edge) {
+        return match === null || nodes[edge.from].match || nodes[edge.to].match ? 1 : 0.15;
+    }
     function all(opacity) {
         $.each(nodes, function(_, node) {
-            $(node.dom).clearQueue().fadeTo('fast', opacity);
+            $(node.dom).clearQueue().fadeTo('fast', opacity === undefined ? nodeOpacity(node) : opacity);
         })
         $.each(edges, function(_, edge) {
-            $(edge.dom).clearQueue().fadeTo('fast', opacity);
+            $(edge.dom).clearQueue().fadeTo('fast', opacity === undefined ? edgeOpacity(edge) : opacity);
         })
     }
 
@@ -96,7 +203,9 @@ function enableHighlighting(svg) {
     // Process nodes.
     $(".node", svg).each(function(_, node) {
         var id = $("title", node).text();
-        var info = {dom: node, edges: []};
+        // Group nodes have several text elements, one per lock.
+        var names = $("text", node).map(function() { return $(this).text(); }).get();
+        var info = {dom: node, edges: [], names: names, match: false};
         nodes[id] = info;
         $(node).on("mouseenter", function() {
             all(0.25);
@@ -109,7 +218,7 @@ function enableHighlighting(svg) {
                     highlight(nodes[edge.to].dom);
             });
         }).on("mouseleave", function() {
-            all(1);
+            all();
         });
     });
 
@@ -127,9 +236,22 @@ function enableHighlighting(svg) {
             highlight(nodes[info.from].dom);
             highlight(nodes[info.to].dom);
         }).on("mouseleave", function() {
-            all(1);
+            all();
         });
     });
+
+    return {
+        // setFilter fades out nodes whose lock names don't
+        // satisfy pred and edges between such nodes. If pred is
+        // null, it clears the filter.
+        setFilter: function(pred) {
+            match = pred;
+            $.each(nodes, function(_, node) {
+                node.match = pred !== null && node.names.some(pred);
+            });
+            all();
+        },
+    };
 }
 
 // zoomify makes drags and wheel events on element fill pan and zoom
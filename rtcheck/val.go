@@ -361,6 +361,10 @@ func (x DynConst) BinOp(op token.Token, y DynValue) DynValue {
 			log.Print("division by zero")
 			return dynUnknown{}
 		}
+		if x.c.Kind() == constant.Int && yc.Kind() == constant.Int {
+			// Integer division, not exact division.
+			op = token.QUO_ASSIGN
+		}
 		fallthrough
 	default:
 		return DynConst{constant.BinaryOp(x.c, op, yc)}
@@ -0,0 +1,241 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aclements/go-moremath/stats"
+	"golang.org/x/exp/trace"
+)
+
+// traceStats is the latency data collected from a trace.
+type traceStats struct {
+	// gcPauses, schedLatency, and wakeupLatency are durations in
+	// nanoseconds.
+	gcPauses      []float64
+	schedLatency  []float64
+	wakeupLatency []float64
+
+	// gcs is the number of completed GC cycles.
+	gcs int
+
+	// util is the mutator utilization over the trace.
+	util *utilization
+}
+
+// goroutine is the state of a goroutine relevant to mutator
+// utilization and scheduling latency.
+type goroutine struct {
+	running bool
+
+	// worker indicates g is running as a dedicated or fractional
+	// GC mark worker. It's reset when g stops running.
+	worker bool
+
+	// assist indicates g is in a GC mark assist.
+	assist bool
+
+	// runnable is when g became runnable, or -1 if g isn't
+	// runnable or it's not known when it became runnable.
+	runnable trace.Time
+
+	// woken indicates g became runnable from blocking.
+	woken bool
+}
+
+// gc returns whether g is using a CPU for GC.
+func (g *goroutine) gc() bool {
+	return g.running && (g.worker || g.assist)
+}
+
+// stwPrefix is the prefix of stop-the-world range names. The rest
+// is the reason, followed by ")".
+const stwPrefix = "stop-the-world ("
+
+// readTrace reads the runtime trace at path and collects its latency
+// data.
+func readTrace(path string) (*traceStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := trace.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	st := &traceStats{util: new(utilization)}
+	gs := make(map[trace.GoID]*goroutine)
+	getG := func(id trace.GoID) *goroutine {
+		g := gs[id]
+		if g == nil {
+			g = &goroutine{runnable: -1}
+			gs[id] = g
+		}
+		return g
+	}
+	// gcProcs is the number of goroutines using a CPU for GC.
+	gcProcs := 0
+	// update applies f to g and maintains gcProcs.
+	update := func(g *goroutine, f func()) {
+		was := g.gc()
+		f()
+		if was && !g.gc() {
+			gcProcs--
+		} else if !was && g.gc() {
+			gcProcs++
+		}
+	}
+	// stw is the number of active stop-the-world ranges and
+	// stwStart is when the current GC stop-the-world began, or -1
+	// if it's not a GC stop-the-world or its start isn't known.
+	stw := 0
+	stwStart := trace.Time(-1)
+	gomaxprocs := 0
+
+	for {
+		ev, err := r.ReadEvent()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		t := ev.Time()
+
+		switch ev.Kind() {
+		case trace.EventMetric:
+			m := ev.Metric()
+			if m.Name == "/sched/gomaxprocs:threads" {
+				gomaxprocs = int(m.Value.Uint64())
+			}
+
+		case trace.EventStateTransition:
+			tr := ev.StateTransition()
+			if tr.Resource.Kind != trace.ResourceGoroutine {
+				break
+			}
+			g := getG(tr.Resource.Goroutine())
+			from, to := tr.Goroutine()
+			update(g, func() {
+				g.running = to == trace.GoRunning
+				if !g.running {
+					g.worker = false
+				}
+			})
+			switch to {
+			case trace.GoRunnable:
+				g.runnable, g.woken = -1, false
+				if from != trace.GoUndetermined {
+					g.runnable, g.woken = t, from == trace.GoWaiting
+				}
+			case trace.GoRunning:
+				if g.runnable >= 0 {
+					lat := float64(t.Sub(g.runnable))
+					st.schedLatency = append(st.schedLatency, lat)
+					if g.woken {
+						st.wakeupLatency = append(st.wakeupLatency, lat)
+					}
+				}
+				g.runnable = -1
+			case trace.GoNotExist:
+				delete(gs, tr.Resource.Goroutine())
+			default:
+				g.runnable = -1
+			}
+
+		case trace.EventLabel:
+			l := ev.Label()
+			// Background mark workers are labeled when they
+			// start running. Idle workers only run on
+			// otherwise idle Ps, so they don't take CPU from
+			// the mutator.
+			if l.Resource.Kind == trace.ResourceGoroutine && strings.HasPrefix(l.Label, "GC (") && l.Label != "GC (idle)" {
+				g := getG(l.Resource.Goroutine())
+				update(g, func() { g.worker = true })
+			}
+
+		case trace.EventRangeBegin, trace.EventRangeActive, trace.EventRangeEnd:
+			rng := ev.Range()
+			begin := ev.Kind() != trace.EventRangeEnd
+			switch {
+			case rng.Name == "GC mark assist":
+				if rng.Scope.Kind == trace.ResourceGoroutine {
+					g := getG(rng.Scope.Goroutine())
+					update(g, func() { g.assist = begin })
+				}
+
+			case rng.Name == "GC concurrent mark phase":
+				if ev.Kind() == trace.EventRangeEnd {
+					st.gcs++
+				}
+
+			case strings.HasPrefix(rng.Name, stwPrefix):
+				isGC := strings.HasPrefix(rng.Name[len(stwPrefix):], "GC ")
+				if begin {
+					stw++
+					stwStart = -1
+					if isGC && ev.Kind() == trace.EventRangeBegin {
+						stwStart = t
+					}
+				} else if stw > 0 {
+					stw--
+					if stwStart >= 0 {
+						st.gcPauses = append(st.gcPauses, float64(t.Sub(stwStart)))
+					}
+					stwStart = -1
+				}
+			}
+		}
+
+		if gomaxprocs > 0 {
+			u := 0.0
+			if stw == 0 {
+				u = 1 - float64(gcProcs)/float64(gomaxprocs)
+				if u < 0 {
+					u = 0
+				}
+			}
+			st.util.add(int64(t), u)
+		}
+	}
+	return st, nil
+}
+
+// metrics returns the benchmark metrics of st, including the minimum
+// mutator utilization at each window in windows.
+func (st *traceStats) metrics(windows []time.Duration) map[string]float64 {
+	m := make(map[string]float64)
+	dist := func(xs []float64, name string) {
+		if len(xs) == 0 {
+			return
+		}
+		s := stats.Sample{Xs: xs}
+		s.Sort()
+		// Quantiles interpolate, but sub-nanosecond precision is
+		// meaningless.
+		m["ns/p50-"+name] = math.Round(s.Quantile(0.5))
+		m["ns/p99-"+name] = math.Round(s.Quantile(0.99))
+		m["ns/max-"+name] = s.Xs[len(s.Xs)-1]
+	}
+	dist(st.gcPauses, "GC-pause")
+	dist(st.schedLatency, "sched-latency")
+	dist(st.wakeupLatency, "wakeup-latency")
+	m["GCs"] = float64(st.gcs)
+	if st.util.duration() > 0 {
+		m["mutator-util"] = st.util.mean()
+		for _, w := range windows {
+			m["MMU-"+w.String()] = st.util.mmu(int64(w))
+		}
+	}
+	return m
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command tracestat reports GC and scheduler latency metrics from Go
+// runtime execution traces in Go benchmark format.
+//
+// For example,
+//
+//     go test -run NONE -bench BenchmarkFoo -trace foo.trace
+//     tracestat Foo foo.trace
+//
+// prints a result line for BenchmarkFoo with the latency metrics of
+// foo.trace. Given several traces, tracestat prints a line for each,
+// so each trace is one run of the benchmark. The output can be
+// appended to a benchmark log and compared with benchstat or plotted
+// across commits with benchplot.
+//
+// tracestat reports the following metrics:
+//
+// GC pauses: the 50th and 99th percentile and maximum duration of
+// the stop-the-world phases of the garbage collector, and the number
+// of GC cycles that completed.
+//
+// Mutator utilization: the fraction of CPU time available to the
+// application over the whole trace, and the minimum mutator
+// utilization (MMU) at each window size in -mmu. CPU time spent in
+// stop-the-world phases, dedicated and fractional background mark
+// workers, and mark assists is not available to the application.
+// Idle mark workers only use otherwise idle CPUs, so they don't count.
+//
+// Scheduling latency: the 50th and 99th percentile and maximum time
+// goroutines spend runnable before they run.
+//
+// Wakeup latency: scheduling latency counting only goroutines that
+// were blocked, from when they are unblocked until they run.
+//
+// tracestat reads traces from Go 1.11 and later.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aclements/go-misc/internal/benchfmt"
+)
+
+func main() {
+	log.SetPrefix("tracestat: ")
+	log.SetFlags(0)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] benchname trace...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flagMMU := flag.String("mmu", "1ms,10ms,100ms", "report minimum mutator utilization at each of the comma-separated `windows`")
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	benchname := flag.Arg(0)
+
+	var windows []time.Duration
+	for _, w := range strings.Split(*flagMMU, ",") {
+		if w == "" {
+			continue
+		}
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			log.Fatalf("bad -mmu window %q", w)
+		}
+		windows = append(windows, d)
+	}
+
+	var bs []*benchfmt.Benchmark
+	for _, path := range flag.Args()[1:] {
+		st, err := readTrace(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bs = append(bs, &benchfmt.Benchmark{
+			Name:       benchname,
+			Iterations: 1,
+			Config:     map[string]*benchfmt.Config{},
+			Result:     st.metrics(windows),
+		})
+	}
+	if err := benchfmt.Print(bs); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "sort"
+
+// utilization is a step function of mutator utilization over time.
+type utilization struct {
+	// times and utils are the steps. utils[i] is the utilization
+	// from times[i] until times[i+1] or, for the last step, end.
+	times []int64
+	utils []float64
+	end   int64
+
+	// cum[i] is the integral of the function from times[0] to
+	// times[i]. It's computed lazily.
+	cum []float64
+}
+
+// add records that the utilization is u from time t on. Times must
+// be non-decreasing.
+func (f *utilization) add(t int64, u float64) {
+	f.cum = nil
+	f.end = t
+	n := len(f.times)
+	switch {
+	case n > 0 && f.utils[n-1] == u:
+		// Extend the last step.
+	case n > 0 && f.times[n-1] == t:
+		// Replace the zero-length last step.
+		f.utils[n-1] = u
+		if n > 1 && f.utils[n-2] == u {
+			f.times, f.utils = f.times[:n-1], f.utils[:n-1]
+		}
+	default:
+		f.times = append(f.times, t)
+		f.utils = append(f.utils, u)
+	}
+}
+
+// duration returns the length of time covered by f.
+func (f *utilization) duration() int64 {
+	if len(f.times) == 0 {
+		return 0
+	}
+	return f.end - f.times[0]
+}
+
+// integral returns the integral of f from its start to t, which must
+// be within f.
+func (f *utilization) integral(t int64) float64 {
+	if f.cum == nil {
+		f.cum = make([]float64, len(f.times))
+		for i := 1; i < len(f.times); i++ {
+			f.cum[i] = f.cum[i-1] + float64(f.times[i]-f.times[i-1])*f.utils[i-1]
+		}
+	}
+	// Find the step containing t.
+	i := sort.Search(len(f.times), func(i int) bool { return f.times[i] > t }) - 1
+	if i < 0 {
+		return 0
+	}
+	return f.cum[i] + float64(t-f.times[i])*f.utils[i]
+}
+
+// mean returns the mean utilization over all of f.
+func (f *utilization) mean() float64 {
+	return f.integral(f.end) / float64(f.duration())
+}
+
+// mmu returns the minimum mean utilization of f over any window of
+// length w. If w is longer than f, it returns the mean of all of f.
+func (f *utilization) mmu(w int64) float64 {
+	start, end := f.times[0], f.end
+	if w >= end-start {
+		return f.mean()
+	}
+	// The mean over a window is piecewise linear in the window's
+	// start, with breakpoints where either edge of the window
+	// crosses a step, so its minimum is at one of those.
+	min := 1.0
+	try := func(lo int64) {
+		if lo < start || lo+w > end {
+			return
+		}
+		if m := (f.integral(lo+w) - f.integral(lo)) / float64(w); m < min {
+			min = m
+		}
+	}
+	for _, t := range f.times {
+		try(t)
+		try(t - w)
+	}
+	try(end - w)
+	return min
+}